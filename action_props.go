@@ -0,0 +1,142 @@
+/*
+This file contains typed properties. A property in the action-schema may be given a value-type in
+ActionSchema.PropertySchema, which describes it as AttrSchema describes an attribute:
+
+	ActionSchema{
+		Properties: []string{"discount", "freegift", "note"},
+		PropertySchema: []AttrSchema{
+			{Name: "discount", ValType: TypeInt, ValMin: 0, ValMax: 100},
+			{Name: "freegift", ValType: TypeEnum, Vals: map[string]bool{"mug": true, "pen": true}},
+		},
+	}
+
+The value-type of a property is int, float, decimal, bool, enum or str. Its limits, vals,
+currency, precision and scale are as for attributes. The value of the property in every rule must
+be valid for its schema as an entity value of an attribute must be (see validate_entity.go), so
+that set(discount=ten) does not pass verification. Properties that are not in PropertySchema, such
+as note above, may have any value.
+
+Property values are strings in an ActionSet as in a rule. Prop() returns a value as it is, and
+IntProp(), FloatProp(), DecimalProp() and BoolProp() convert it.
+*/
+
+package crux
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// The value-types that properties may have
+var propTypes = map[string]bool{
+	TypeInt: true, TypeFloat: true, TypeDecimal: true, TypeBool: true, TypeEnum: true, TypeStr: true,
+}
+
+// Checks the schema of each typed property in rs: it must be a property in the action-schema,
+// have only one schema, have one of propTypes, and be a valid schema otherwise
+func verifyPropertySchema(rs RuleSchema) error {
+	seen := map[string]bool{}
+	for _, ps := range rs.ActionSchema.PropertySchema {
+		switch {
+		case !isStringInArray(ps.Name, rs.ActionSchema.Properties):
+			return fmt.Errorf("property %v in property-schema is not a property in the action-schema", ps.Name)
+		case seen[ps.Name]:
+			return fmt.Errorf("property %v has more than one schema", ps.Name)
+		case !propTypes[ps.ValType]:
+			return fmt.Errorf("%v is not a valid value-type for property %v", ps.ValType, ps.Name)
+		case ps.ValType == TypeEnum && len(ps.Vals) == 0:
+			return fmt.Errorf("no valid values for enum property %v", ps.Name)
+		case ps.Required || ps.Default != "":
+			return fmt.Errorf("property %v cannot be required or have a default", ps.Name)
+		}
+		seen[ps.Name] = true
+		if err := verifyLimits(ps); err != nil {
+			return err
+		} else if err := verifyTimeFormat(ps); err != nil {
+			return err
+		} else if err := verifyDecimalFormat(ps); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Checks the value of each property in ra that has a schema in schema
+func verifyPropertyVals(ra RuleActions, schema RuleSchema) error {
+	for _, p := range ra.Properties {
+		for _, ps := range schema.ActionSchema.PropertySchema {
+			if ps.Name != p.Name {
+				continue
+			}
+			if _, msg := validateAttrVal(p.Val, ps, newAttrFormat(ps)); msg != "" {
+				return fmt.Errorf("invalid value for property %v: %v", p.Name, msg)
+			}
+		}
+	}
+	return nil
+}
+
+// Prop returns the value of the property name in a, and whether a has it
+func (a ActionSet) Prop(name string) (string, bool) {
+	for _, p := range a.Properties {
+		if p.Name == name {
+			return p.Val, true
+		}
+	}
+	return "", false
+}
+
+// IntProp returns the value of the int property name in a, and whether a has it. The error is
+// from converting the value.
+func (a ActionSet) IntProp(name string) (int, bool, error) {
+	val, ok := a.Prop(name)
+	if !ok {
+		return 0, false, nil
+	}
+	n, err := strconv.Atoi(val)
+	if err != nil {
+		return 0, true, fmt.Errorf("property %v: %w", name, err)
+	}
+	return n, true, nil
+}
+
+// FloatProp is like IntProp, for float properties
+func (a ActionSet) FloatProp(name string) (float64, bool, error) {
+	val, ok := a.Prop(name)
+	if !ok {
+		return 0, false, nil
+	}
+	f, err := strconv.ParseFloat(val, 64)
+	if err != nil {
+		return 0, true, fmt.Errorf("property %v: %w", name, err)
+	}
+	return f, true, nil
+}
+
+// DecimalProp is like IntProp, for decimal properties. The value's currency code, if it has one,
+// is ignored.
+func (a ActionSet) DecimalProp(name string) (Decimal, bool, error) {
+	val, ok := a.Prop(name)
+	if !ok {
+		return Decimal{}, false, nil
+	}
+	num, _ := splitCurrency(val)
+	d, err := ParseDecimal(num)
+	if err != nil {
+		return Decimal{}, true, fmt.Errorf("property %v: %w", name, err)
+	}
+	return d, true, nil
+}
+
+// BoolProp is like IntProp, for bool properties
+func (a ActionSet) BoolProp(name string) (bool, bool, error) {
+	val, ok := a.Prop(name)
+	if !ok {
+		return false, false, nil
+	}
+	b, err := strconv.ParseBool(val)
+	if err != nil {
+		return false, true, fmt.Errorf("property %v: %w", name, err)
+	}
+	return b, true, nil
+}
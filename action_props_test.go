@@ -0,0 +1,119 @@
+package crux
+
+import (
+	"reflect"
+	"testing"
+)
+
+func testPropSchema() RuleSchema {
+	schema := testTransactionSchema()
+	schema.ActionSchema.PropertySchema = []AttrSchema{
+		{Name: "discount", ValType: TypeInt, ValMin: 0, ValMax: 100},
+		{Name: "pointsmult", ValType: TypeDecimal, Precision: 3, Scale: 1},
+		{Name: "note", ValType: TypeStr, LenMax: 10},
+	}
+	return schema
+}
+
+func TestVerifyPropertySchema(t *testing.T) {
+	tests := []struct {
+		name    string
+		ps      []AttrSchema
+		wantErr bool
+	}{
+		{"typed", testPropSchema().ActionSchema.PropertySchema, false},
+		{"bool", []AttrSchema{{Name: "note", ValType: TypeBool}}, false},
+		{"enum", []AttrSchema{{Name: "note", ValType: TypeEnum, Vals: map[string]bool{"gift": true}}}, false},
+		{"not a property", []AttrSchema{{Name: "cashback", ValType: TypeInt}}, true},
+		{"twice", []AttrSchema{{Name: "note", ValType: TypeStr}, {Name: "note", ValType: TypeInt}}, true},
+		{"ts", []AttrSchema{{Name: "note", ValType: TypeTS}}, true},
+		{"list", []AttrSchema{{Name: "note", ValType: TypeList, ElemType: TypeStr}}, true},
+		{"enum without vals", []AttrSchema{{Name: "note", ValType: TypeEnum}}, true},
+		{"required", []AttrSchema{{Name: "note", ValType: TypeStr, Required: true}}, true},
+		{"range on str", []AttrSchema{{Name: "note", ValType: TypeStr, ValMax: 5}}, true},
+		{"currency on int", []AttrSchema{{Name: "discount", ValType: TypeInt, Currency: "INR"}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			schema := testTransactionSchema()
+			schema.ActionSchema.PropertySchema = tt.ps
+			if err := testEngine.VerifyRuleSchema(schema, false); (err != nil) != tt.wantErr {
+				t.Errorf("VerifyRuleSchema() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestVerifyPropertyVals(t *testing.T) {
+	e := NewEngine()
+	e.AddRuleSchema(testPropSchema())
+	tests := []struct {
+		name    string
+		props   []Property
+		wantErr bool
+	}{
+		{"valid", []Property{{"discount", "10"}, {"pointsmult", "1.5"}, {"note", "winter"}}, false},
+		{"limits", []Property{{"discount", "100"}, {"pointsmult", "99.9"}, {"note", ""}}, false},
+		{"not an int", []Property{{"discount", "ten"}}, true},
+		{"out of range", []Property{{"discount", "150"}}, true},
+		{"too many digits", []Property{{"pointsmult", "1.25"}}, true},
+		{"too long", []Property{{"note", "happy new year"}}, true},
+		{"in a later rule", []Property{{"discount", "5"}, {"discount", "-5"}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var rules []Rule
+			for _, p := range tt.props {
+				rules = append(rules, Rule{[]RulePatternTerm{{"price", OpGT, 10}}, RuleActions{Properties: []Property{p}}})
+			}
+			if err := e.VerifyRuleSet(RuleSet{Ver: 1, Class: transactionClass, SetName: mainRS, Rules: rules}, false); (err != nil) != tt.wantErr {
+				t.Errorf("VerifyRuleSet() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestActionSetProps(t *testing.T) {
+	a := ActionSet{Properties: []Property{
+		{"discount", "10"}, {"rate", "2.5"}, {"amount", "INR 50.80"}, {"gift", trueStr}, {"note", "ten"},
+	}}
+	if val, ok := a.Prop("note"); val != "ten" || !ok {
+		t.Errorf("Prop() = %v, %v", val, ok)
+	}
+	if val, ok := a.Prop("cashback"); val != "" || ok {
+		t.Errorf("Prop() = %v, %v for a missing property", val, ok)
+	}
+	n, ok, err := a.IntProp("discount")
+	if n != 10 || !ok || err != nil {
+		t.Errorf("IntProp() = %v, %v, %v", n, ok, err)
+	}
+	f, ok, err := a.FloatProp("rate")
+	if f != 2.5 || !ok || err != nil {
+		t.Errorf("FloatProp() = %v, %v, %v", f, ok, err)
+	}
+	d, ok, err := a.DecimalProp("amount")
+	if want, _ := ParseDecimal("50.8"); d != want || !ok || err != nil {
+		t.Errorf("DecimalProp() = %v, %v, %v", d, ok, err)
+	}
+	b, ok, err := a.BoolProp("gift")
+	if !b || !ok || err != nil {
+		t.Errorf("BoolProp() = %v, %v, %v", b, ok, err)
+	}
+	if _, ok, err := a.IntProp("note"); !ok || err == nil {
+		t.Errorf("IntProp() = %v, %v, want an error for a value that is not an int", ok, err)
+	}
+	if _, ok, err := a.BoolProp("cashback"); ok || err != nil {
+		t.Errorf("BoolProp() = %v, %v for a missing property", ok, err)
+	}
+
+	// The properties of a match, as the consumer sees them
+	e := NewEngine()
+	e.AddRuleSchema(testPropSchema())
+	e.AddRuleSet(RuleSet{Ver: 1, Class: transactionClass, SetName: mainRS, Rules: []Rule{{
+		[]RulePatternTerm{{"price", OpGT, 10}}, RuleActions{Properties: []Property{{"discount", "15"}}},
+	}}})
+	result, _ := e.Match(transactionEntity("pen", "20", falseStr, "cash", falseStr), mainRS)
+	if n, ok, err := result.IntProp("discount"); n != 15 || !ok || err != nil || !reflect.DeepEqual(result.Tasks, []string(nil)) {
+		t.Errorf("IntProp() = %v, %v, %v from %v", n, ok, err, result)
+	}
+}
@@ -0,0 +1,198 @@
+/*
+This file contains the batch and streaming forms of Engine.Match: MatchBatch() for a slice of
+entities, MatchStream() for a channel of entities, and MatchNDJSON(), which reads entities from
+an io.Reader and writes results to an io.Writer, one JSON object per line.
+
+All three match entities on a bounded pool of worker goroutines, and deliver the results in the
+order in which the entities were given. Each entity is matched on its own, as by Match, so an
+error in one entity is reported with that entity's result and does not stop the others. All the
+entities in one call are matched against the schemas and rulesets present in the engine when
+the call was made.
+
+In NDJSON mode, each input line holds one entity, encoded as in Entity's JSON tags:
+
+	{"class": "inventoryitem", "attrs": [{"name": "cat", "val": "textbook"}]}
+
+and each output line holds the result for the input line "line", counting from 1. Blank input
+lines are skipped. "error" is present only if the line could not be decoded or the match failed.
+
+	{"line": 1, "result": {"tasks": ["dodiscount"], "properties": [{"name": "discount", "val": "10"}]}}
+	{"line": 2, "result": {"tasks": null, "properties": null}, "error": "no ruleset found with name main"}
+*/
+
+package crux
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"runtime"
+	"sync"
+)
+
+// The longest line that MatchNDJSON() accepts
+const maxNDJSONLine = 16 * 1024 * 1024
+
+// BatchResult is the outcome of matching one entity in a batch or stream
+type BatchResult struct {
+	// The position of the entity among the entities given, counting from 0
+	Index  int
+	Result ActionSet
+	Err    error
+}
+
+type batchJob struct {
+	index int
+	// For MatchNDJSON(), the input line of the entity, counting from 1
+	line   int
+	entity Entity
+	// Set if the entity could not be read, in which case it is not matched
+	err    error
+	result chan BatchResult
+}
+
+// MatchBatch matches each of entities against the ruleset named setName, using at most workers
+// goroutines, or runtime.GOMAXPROCS(0) goroutines if workers is 0 or less. The i'th result is
+// for the i'th entity.
+func (e *Engine) MatchBatch(entities []Entity, setName string, workers int) []BatchResult {
+	in := make(chan Entity)
+	go func() {
+		for _, entity := range entities {
+			in <- entity
+		}
+		close(in)
+	}()
+	results := make([]BatchResult, 0, len(entities))
+	for r := range e.MatchStream(in, setName, workers) {
+		results = append(results, r)
+	}
+	return results
+}
+
+// MatchStream matches each entity received from entities against the ruleset named setName,
+// using at most workers goroutines, or runtime.GOMAXPROCS(0) goroutines if workers is 0 or less.
+// Results are sent on the returned channel in the order in which the entities were received.
+// The channel is closed after entities is closed and the last result has been sent. The caller
+// must receive every result; at most a few entities per worker are held while it does not.
+func (e *Engine) MatchStream(entities <-chan Entity, setName string, workers int) <-chan BatchResult {
+	jobs := make(chan batchJob)
+	go func() {
+		index := 0
+		for entity := range entities {
+			jobs <- batchJob{index: index, entity: entity}
+			index++
+		}
+		close(jobs)
+	}()
+	out := make(chan BatchResult)
+	go func() {
+		runBatch(e.registry.load(), jobs, setName, workers, func(_ batchJob, res BatchResult) {
+			out <- res
+		})
+		close(out)
+	}()
+	return out
+}
+
+// MatchNDJSON reads entities from r, one JSON object per line, matches each against the ruleset
+// named setName as MatchStream does, and writes one line of JSON per entity to w, in input order.
+// Lines that cannot be decoded are reported in the output and do not stop the run. The error
+// returned is from reading r or writing w.
+func (e *Engine) MatchNDJSON(r io.Reader, w io.Writer, setName string, workers int) error {
+	jobs := make(chan batchJob)
+	var readErr error
+	go func() {
+		defer close(jobs)
+		scanner := bufio.NewScanner(r)
+		scanner.Buffer(make([]byte, 64*1024), maxNDJSONLine)
+		index := 0
+		for line := 1; scanner.Scan(); line++ {
+			data := bytes.TrimSpace(scanner.Bytes())
+			if len(data) == 0 {
+				continue
+			}
+			job := batchJob{index: index, line: line}
+			if err := json.Unmarshal(data, &job.entity); err != nil {
+				job.err = fmt.Errorf("error decoding entity: %w", err)
+			}
+			jobs <- job
+			index++
+		}
+		readErr = scanner.Err()
+	}()
+
+	bw := bufio.NewWriter(w)
+	enc := json.NewEncoder(bw)
+	var writeErr error
+	runBatch(e.registry.load(), jobs, setName, workers, func(job batchJob, res BatchResult) {
+		if writeErr != nil {
+			// Keep going, so that the workers can finish
+			return
+		}
+		out := ndjsonResult{Line: job.line, Result: res.Result}
+		if res.Err != nil {
+			out.Error = res.Err.Error()
+		}
+		writeErr = enc.Encode(out)
+	})
+	if writeErr == nil {
+		writeErr = bw.Flush()
+	}
+	if readErr != nil {
+		return fmt.Errorf("error reading entities: %w", readErr)
+	}
+	if writeErr != nil {
+		return fmt.Errorf("error writing results: %w", writeErr)
+	}
+	return nil
+}
+
+type ndjsonResult struct {
+	Line   int       `json:"line"`
+	Result ActionSet `json:"result"`
+	Error  string    `json:"error,omitempty"`
+}
+
+// Matches the entities in jobs against setName in st, and calls emit with each job and its result
+// in the order of jobs. Returns when jobs is closed and the last result has been emitted. Each
+// job is queued for output before it is handed to a worker, and the caller waits for the result
+// of each queued job in turn. The queue is bounded, so a slow entity or a slow emit holds up the
+// input rather than letting results pile up.
+func runBatch(st *ruleStore, jobs <-chan batchJob, setName string, workers int, emit func(job batchJob, res BatchResult)) {
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	work := make(chan batchJob)
+	queue := make(chan batchJob, 2*workers)
+
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range work {
+				res := BatchResult{Index: job.index, Err: job.err}
+				if job.err == nil {
+					// match() starts each entity with its own seenRuleSets and action-set
+					res.Result, res.Err = match(st, job.entity, setName)
+				}
+				job.result <- res
+			}
+		}()
+	}
+	go func() {
+		for job := range jobs {
+			job.result = make(chan BatchResult, 1)
+			queue <- job
+			work <- job
+		}
+		close(queue)
+		close(work)
+		wg.Wait()
+	}()
+	for job := range queue {
+		emit(job, <-job.result)
+	}
+}
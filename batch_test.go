@@ -0,0 +1,186 @@
+package crux
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// Returns an engine whose main ruleset calls a second ruleset for counters greater than 0, and
+// counter entities 1, -1, x, 2, -2, x, ... , whose values in turn match, do not match, and fail
+func setupBatch(numEntities int) (*Engine, []Entity) {
+	e := NewEngine()
+	e.AddRuleSchema(testCounterSchema())
+	e.AddRuleSet(testCounterCallingRuleSets("v1")...)
+	entities := make([]Entity, numEntities)
+	for i := range entities {
+		n := strconv.Itoa(i/3 + 1)
+		switch i % 3 {
+		case 1:
+			n = "-" + n
+		case 2:
+			n = "x"
+		}
+		entities[i] = Entity{counterClass, []Attr{{"n", n}}}
+	}
+	return e, entities
+}
+
+func checkBatchResult(t *testing.T, i int, res BatchResult) {
+	t.Helper()
+	if res.Index != i {
+		t.Fatalf("result %v has index %v", i, res.Index)
+	}
+	switch i % 3 {
+	case 0:
+		want := ActionSet{Tasks: []string{"v1"}, Properties: []Property{{"version", "v1"}}}
+		if res.Err != nil || !reflect.DeepEqual(res.Result, want) {
+			t.Errorf("result %v = %v, %v, want %v", i, res.Result, res.Err, want)
+		}
+	case 1:
+		if res.Err != nil || len(res.Result.Tasks) != 0 {
+			t.Errorf("result %v = %v, %v, want no actions", i, res.Result, res.Err)
+		}
+	case 2:
+		if res.Err == nil {
+			t.Errorf("result %v: expected but did not get error", i)
+		}
+	}
+}
+
+func TestMatchBatch(t *testing.T) {
+	e, entities := setupBatch(1000)
+	for _, workers := range []int{0, 1, 7} {
+		results := e.MatchBatch(entities, mainRS, workers)
+		if len(results) != len(entities) {
+			t.Fatalf("MatchBatch() returned %v results, want %v", len(results), len(entities))
+		}
+		for i, res := range results {
+			checkBatchResult(t, i, res)
+		}
+	}
+	if results := e.MatchBatch(nil, mainRS, 4); len(results) != 0 {
+		t.Errorf("MatchBatch(nil) = %v, want no results", results)
+	}
+}
+
+func TestMatchStream(t *testing.T) {
+	e, entities := setupBatch(300)
+	in := make(chan Entity)
+	go func() {
+		for _, entity := range entities {
+			in <- entity
+		}
+		close(in)
+	}()
+	i := 0
+	for res := range e.MatchStream(in, mainRS, 4) {
+		checkBatchResult(t, i, res)
+		i++
+	}
+	if i != len(entities) {
+		t.Errorf("MatchStream() sent %v results, want %v", i, len(entities))
+	}
+}
+
+func TestMatchNDJSON(t *testing.T) {
+	e, _ := setupBatch(0)
+	input := strings.Join([]string{
+		`{"class": "counter", "attrs": [{"name": "n", "val": "5"}]}`,
+		``,
+		`{"class": "counter", "attrs": [{"name": "n", "val": "-5"}]}`,
+		`{"class": "counter", "attrs": [`,
+		`{"class": "counter", "attrs": [{"name": "n", "val": "five"}]}`,
+	}, "\n")
+	var output bytes.Buffer
+	if err := e.MatchNDJSON(strings.NewReader(input), &output, mainRS, 2); err != nil {
+		t.Fatalf("MatchNDJSON() error = %v", err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(output.String()), "\n")
+	if len(lines) != 4 {
+		t.Fatalf("MatchNDJSON() wrote %v lines, want 4:\n%v", len(lines), output.String())
+	}
+	wantLines := []int{1, 3, 4, 5}
+	wantErrs := []bool{false, false, true, true}
+	for i, line := range lines {
+		var got ndjsonResult
+		if err := json.Unmarshal([]byte(line), &got); err != nil {
+			t.Fatalf("output line %v is not valid JSON: %v", i+1, err)
+		}
+		if got.Line != wantLines[i] || (got.Error != "") != wantErrs[i] {
+			t.Errorf("output line %v = %+v, want line %v, error %v", i+1, got, wantLines[i], wantErrs[i])
+		}
+	}
+	if !strings.Contains(lines[0], `"tasks":["v1"]`) {
+		t.Errorf("output line 1 = %v, want task v1", lines[0])
+	}
+}
+
+// Output lines report the input line of each entity, whatever blank lines come before it
+func TestMatchNDJSONBlankLines(t *testing.T) {
+	e, _ := setupBatch(0)
+	input := strings.Join([]string{
+		``,
+		`{"class": "counter", "attrs": [{"name": "n", "val": "5"}]}`,
+		``,
+		`   `,
+		`{"class": "counter", "attrs": [{"name": "n", "val": "-5"}]}`,
+		"\t",
+		`{"class": "counter", "attrs": [{"name": "n", "val": "7"}]}`,
+		``,
+		``,
+	}, "\n")
+	var output bytes.Buffer
+	if err := e.MatchNDJSON(strings.NewReader(input), &output, mainRS, 3); err != nil {
+		t.Fatalf("MatchNDJSON() error = %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(output.String()), "\n")
+	wantLines := []int{2, 5, 7}
+	if len(lines) != len(wantLines) {
+		t.Fatalf("MatchNDJSON() wrote %v lines, want %v:\n%v", len(lines), len(wantLines), output.String())
+	}
+	for i, line := range lines {
+		var got ndjsonResult
+		if err := json.Unmarshal([]byte(line), &got); err != nil {
+			t.Fatalf("output line %v is not valid JSON: %v", i+1, err)
+		}
+		if got.Line != wantLines[i] || got.Error != "" {
+			t.Errorf("output line %v = %+v, want line %v and no error", i+1, got, wantLines[i])
+		}
+	}
+}
+
+// The value of a list attribute may be given as a JSON array rather than a string
+func TestMatchNDJSONLists(t *testing.T) {
+	e := NewEngine()
+	e.AddRuleSchema(RuleSchema{Class: "customer", PatternSchema: []AttrSchema{
+		{Name: "tags", ValType: TypeList, ElemType: TypeStr},
+	}, ActionSchema: ActionSchema{Tasks: []string{"vip"}}})
+	e.AddRuleSet(RuleSet{Ver: 1, Class: "customer", SetName: mainRS, Rules: []Rule{{
+		[]RulePatternTerm{{"tags", OpHasAny, []string{"gold"}}}, RuleActions{Tasks: []string{"vip"}},
+	}}})
+	input := strings.Join([]string{
+		`{"class": "customer", "attrs": [{"name": "tags", "val": ["silver", "gold"]}]}`,
+		`{"class": "customer", "attrs": [{"name": "tags", "val": "[\"gold\"]"}]}`,
+		`{"class": "customer", "attrs": [{"name": "tags", "val": [ ]}]}`,
+		`{"class": "customer", "attrs": [{"name": "tags", "val": {"gold": true}}]}`,
+	}, "\n")
+	var output bytes.Buffer
+	if err := e.MatchNDJSON(strings.NewReader(input), &output, mainRS, 1); err != nil {
+		t.Fatalf("MatchNDJSON() error = %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(output.String()), "\n")
+	want := []string{`"tasks":["vip"]`, `"tasks":["vip"]`, `"tasks":null`, `"error"`}
+	if len(lines) != len(want) {
+		t.Fatalf("MatchNDJSON() wrote %v lines, want %v:\n%v", len(lines), len(want), output.String())
+	}
+	for i, line := range lines {
+		if !strings.Contains(line, want[i]) {
+			t.Errorf("output line %v = %v, want %v", i+1, line, want[i])
+		}
+	}
+}
@@ -0,0 +1,112 @@
+/*
+This file contains verifyCalls(), which checks the calls that rulesets make to each other
+through ThenCall and ElseCall before any of them is matched.
+
+At match time, doMatch() fails when a ruleset calls one that does not exist, one of another
+class, or one that is already being run further up the call stack. verifyCalls() walks every
+path of calls from a ruleset and reports each of these as a CallError, with the path that leads
+to it, whether or not any entity would actually take that path.
+*/
+
+package crux
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Kinds of CallError
+const (
+	CallCycle         = "cycle"
+	CallUnknown       = "unknown ruleset"
+	CallClassMismatch = "class mismatch"
+)
+
+// CallStep is one call in a path of calls: rule From calls the next ruleset via thencall or
+// elsecall
+type CallStep struct {
+	From RuleRef `json:"from"`
+	Via  string  `json:"via"`
+}
+
+// CallError reports a path of calls from a ruleset that would fail at match time. The last step
+// in Path calls Target.
+type CallError struct {
+	Kind   string     `json:"kind"`
+	Path   []CallStep `json:"path"`
+	Target string     `json:"target"`
+	// For CallClassMismatch only: the classes of the first ruleset in Path and of Target
+	Class       string `json:"class,omitempty"`
+	TargetClass string `json:"targetClass,omitempty"`
+}
+
+func (e *CallError) Error() string {
+	var b strings.Builder
+	for _, s := range e.Path {
+		fmt.Fprintf(&b, "%v[%v] %v ", s.From.SetName, s.From.RuleIndex, s.Via)
+	}
+	b.WriteString(e.Target)
+	switch e.Kind {
+	case CallCycle:
+		return fmt.Sprintf("ruleset %v is called again from itself: %v", e.Target, b.String())
+	case CallUnknown:
+		return fmt.Sprintf("call to unknown ruleset %v: %v", e.Target, b.String())
+	default:
+		return fmt.Sprintf("call to ruleset %v of class %v from class %v: %v", e.Target, e.TargetClass, e.Class,
+			b.String())
+	}
+}
+
+// Checks every path of calls from rs. Rulesets are looked up in pending first, and then in st,
+// so that a group of rulesets that call each other can be checked before they are added.
+func (st *ruleStore) verifyCalls(rs RuleSet, pending map[string]RuleSet) error {
+	lookup := func(setName string) (RuleSet, bool) {
+		if r, ok := pending[setName]; ok {
+			return r, true
+		}
+		return st.getRuleSet(setName)
+	}
+	// onPath holds the rulesets on the current path; clean holds rulesets from which every path
+	// of calls has already been checked
+	onPath := map[string]bool{}
+	clean := map[string]bool{}
+	var path []CallStep
+
+	var visit func(curr RuleSet) error
+	visit = func(curr RuleSet) error {
+		onPath[curr.SetName] = true
+		for i, rule := range curr.Rules {
+			for _, call := range []struct{ via, target string }{
+				{viaThenCall, rule.RuleActions.ThenCall}, {viaElseCall, rule.RuleActions.ElseCall},
+			} {
+				if call.target == "" {
+					continue
+				}
+				path = append(path, CallStep{RuleRef{curr.SetName, i}, call.via})
+				callErr := func(kind string) *CallError {
+					return &CallError{Kind: kind, Path: append([]CallStep(nil), path...), Target: call.target}
+				}
+				target, ok := lookup(call.target)
+				switch {
+				case !ok:
+					return callErr(CallUnknown)
+				case target.Class != rs.Class:
+					err := callErr(CallClassMismatch)
+					err.Class, err.TargetClass = rs.Class, target.Class
+					return err
+				case onPath[call.target]:
+					return callErr(CallCycle)
+				case !clean[call.target]:
+					if err := visit(target); err != nil {
+						return err
+					}
+				}
+				path = path[:len(path)-1]
+			}
+		}
+		onPath[curr.SetName] = false
+		clean[curr.SetName] = true
+		return nil
+	}
+	return visit(rs)
+}
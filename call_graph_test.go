@@ -0,0 +1,123 @@
+package crux
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// Returns a counter ruleset named setName whose first rule calls thenCall and whose second rule
+// calls elseCall; either may be ""
+func testCallingRuleSet(setName, thenCall, elseCall string) RuleSet {
+	return RuleSet{Ver: 1, Class: counterClass, SetName: setName, Rules: []Rule{
+		{[]RulePatternTerm{{"n", OpGT, 0}}, RuleActions{Tasks: []string{"pos"}, ThenCall: thenCall}},
+		{[]RulePatternTerm{{"n", OpLT, 0}}, RuleActions{Tasks: []string{"neg"}, ElseCall: elseCall}},
+	}}
+}
+
+func setupCallGraphEngine() *Engine {
+	e := NewEngine()
+	schema := testCounterSchema()
+	schema.ActionSchema = ActionSchema{Tasks: []string{"pos", "neg"}}
+	e.AddRuleSchema(schema, RuleSchema{
+		Class:         "other",
+		PatternSchema: []AttrSchema{{Name: "n", ValType: TypeInt}},
+		ActionSchema:  ActionSchema{Tasks: []string{"pos"}},
+	})
+	return e
+}
+
+func TestVerifyCalls(t *testing.T) {
+	e := setupCallGraphEngine()
+	e.AddRuleSet(
+		// a diamond: main calls left and right, which both call leaf
+		testCallingRuleSet(mainRS, "left", "right"),
+		testCallingRuleSet("left", "leaf", ""),
+		testCallingRuleSet("right", "", "leaf"),
+		testCallingRuleSet("leaf", "", ""),
+		// loop1 -> loop2 -> loop3 -> loop2
+		testCallingRuleSet("loop1", "", "loop2"),
+		testCallingRuleSet("loop2", "loop3", ""),
+		testCallingRuleSet("loop3", "leaf", "loop2"),
+		testCallingRuleSet("self", "self", ""),
+		testCallingRuleSet("dangling", "leaf", "nowhere"),
+		testCallingRuleSet("deepdangling", "dangling", ""),
+		RuleSet{Ver: 1, Class: "other", SetName: "foreign", Rules: []Rule{{[]RulePatternTerm{{"n", OpGT, 0}}, RuleActions{Tasks: []string{"pos"}}}}},
+		testCallingRuleSet("mixed", "foreign", ""),
+	)
+
+	tests := []struct {
+		setName string
+		want    *CallError
+	}{
+		{mainRS, nil},
+		{"loop1", &CallError{Kind: CallCycle, Target: "loop2", Path: []CallStep{
+			{RuleRef{"loop1", 1}, viaElseCall}, {RuleRef{"loop2", 0}, viaThenCall}, {RuleRef{"loop3", 1}, viaElseCall},
+		}}},
+		{"self", &CallError{Kind: CallCycle, Target: "self", Path: []CallStep{{RuleRef{"self", 0}, viaThenCall}}}},
+		{"deepdangling", &CallError{Kind: CallUnknown, Target: "nowhere", Path: []CallStep{
+			{RuleRef{"deepdangling", 0}, viaThenCall}, {RuleRef{"dangling", 1}, viaElseCall},
+		}}},
+		{"mixed", &CallError{Kind: CallClassMismatch, Target: "foreign", Class: counterClass, TargetClass: "other",
+			Path: []CallStep{{RuleRef{"mixed", 0}, viaThenCall}}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.setName, func(t *testing.T) {
+			rs, _ := e.GetRuleSet(tt.setName)
+			err := e.VerifyRuleSet(rs, false)
+			if tt.want == nil {
+				if err != nil {
+					t.Errorf("VerifyRuleSet() error = %v", err)
+				}
+				return
+			}
+			var callErr *CallError
+			if !errors.As(err, &callErr) {
+				t.Fatalf("VerifyRuleSet() error = %v, want a CallError", err)
+			}
+			if !reflect.DeepEqual(callErr, tt.want) {
+				t.Errorf("VerifyRuleSet() error = %+v, want %+v", callErr, tt.want)
+			}
+		})
+	}
+}
+
+func TestCallErrorMessage(t *testing.T) {
+	err := &CallError{Kind: CallCycle, Target: "loop2", Path: []CallStep{
+		{RuleRef{"loop1", 1}, viaElseCall}, {RuleRef{"loop2", 0}, viaThenCall}, {RuleRef{"loop3", 1}, viaElseCall},
+	}}
+	want := "ruleset loop2 is called again from itself: loop1[1] elsecall loop2[0] thencall loop3[1] elsecall loop2"
+	if err.Error() != want {
+		t.Errorf("Error() = %q, want %q", err.Error(), want)
+	}
+}
+
+func TestVerifyRuleSets(t *testing.T) {
+	e := setupCallGraphEngine()
+	group := []RuleSet{testCallingRuleSet(mainRS, "second", ""), testCallingRuleSet("second", "", "")}
+
+	// Each ruleset alone refers to one that the engine does not have yet
+	if err := e.VerifyRuleSet(group[0], false); err == nil {
+		t.Errorf("VerifyRuleSet(): expected but did not get error for a call to an unknown ruleset")
+	}
+	if err := e.VerifyRuleSets(group, false); err != nil {
+		t.Errorf("VerifyRuleSets() error = %v", err)
+	}
+
+	// A ruleset being verified replaces the engine's ruleset of the same name
+	e.AddRuleSet(group...)
+	if err := e.VerifyRuleSets([]RuleSet{testCallingRuleSet("second", mainRS, "")}, false); err == nil ||
+		!strings.Contains(err.Error(), "called again") {
+		t.Errorf("VerifyRuleSets() error = %v, want a cycle", err)
+	}
+}
+
+func TestMatchUnknownCall(t *testing.T) {
+	e := setupCallGraphEngine()
+	e.AddRuleSet(testCallingRuleSet(mainRS, "missing", ""))
+	_, err := e.Match(Entity{counterClass, []Attr{{"n", "1"}}}, mainRS)
+	if err == nil || !strings.Contains(err.Error(), "unknown ruleset missing") {
+		t.Errorf("Match() error = %v, want a call to an unknown ruleset", err)
+	}
+}
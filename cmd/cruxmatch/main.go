@@ -0,0 +1,107 @@
+/*
+Cruxmatch matches entities read from stdin against a ruleset, and writes the results to stdout,
+one JSON object per line. It is meant for use in pipelines:
+
+	cruxmatch -schema item.json -rules main.json -rules discounts.json -set main < items.ndjson
+
+Schemas and rulesets are read from files in the JSON format described in crux's json_format.go,
+and every ruleset is verified before any entity is read. Warnings about terms that are always or
+never true are written to stderr. The formats of input and output lines are described in crux's
+batch.go.
+*/
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/remiges-tech/crux"
+)
+
+type fileList []string
+
+func (f *fileList) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *fileList) Set(name string) error {
+	*f = append(*f, name)
+	return nil
+}
+
+func main() {
+	var schemaFiles, ruleSetFiles fileList
+	flag.Var(&schemaFiles, "schema", "file containing a rule schema in JSON (may be repeated)")
+	flag.Var(&ruleSetFiles, "rules", "file containing a ruleset in JSON (may be repeated)")
+	setName := flag.String("set", "main", "name of the ruleset to match against")
+	workers := flag.Int("workers", 0, "number of entities to match concurrently (0 for one per CPU)")
+	isWF := flag.Bool("wf", false, "the rulesets are workflows")
+	strict := flag.Bool("strict", false, "reject entities that are not valid for their class's schema")
+	now := flag.String("now", "", "time of every match, such as 2024-03-15T10:00:00Z (default the current time)")
+	flag.Parse()
+
+	e, err := loadEngine(schemaFiles, ruleSetFiles, *isWF)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "cruxmatch:", err)
+		os.Exit(2)
+	}
+	e.SetStrict(*strict)
+	if *now != "" {
+		t, err := time.Parse(time.RFC3339, *now)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "cruxmatch: invalid -now:", err)
+			os.Exit(2)
+		}
+		e.SetClock(func() time.Time { return t })
+	}
+	if err := e.MatchNDJSON(os.Stdin, os.Stdout, *setName, *workers); err != nil {
+		fmt.Fprintln(os.Stderr, "cruxmatch:", err)
+		os.Exit(1)
+	}
+}
+
+func loadEngine(schemaFiles, ruleSetFiles []string, isWF bool) (*crux.Engine, error) {
+	e := crux.NewEngine()
+	for _, name := range schemaFiles {
+		data, err := os.ReadFile(name)
+		if err != nil {
+			return nil, err
+		}
+		schema, err := crux.ParseRuleSchemaJSON(data)
+		if err != nil {
+			return nil, fmt.Errorf("%v: %w", name, err)
+		}
+		if err := e.VerifyRuleSchema(schema, isWF); err != nil {
+			return nil, fmt.Errorf("%v: %w", name, err)
+		}
+		e.AddRuleSchema(schema)
+	}
+	// Rulesets may call each other, so they are verified together
+	var ruleSets []crux.RuleSet
+	for _, name := range ruleSetFiles {
+		data, err := os.ReadFile(name)
+		if err != nil {
+			return nil, err
+		}
+		rs, err := e.ParseRuleSetJSON(data)
+		if err != nil {
+			return nil, fmt.Errorf("%v: %w", name, err)
+		}
+		ruleSets = append(ruleSets, rs)
+	}
+	warnings, err := e.VerifyRuleSetsWithWarnings(ruleSets, isWF)
+	if err != nil {
+		return nil, err
+	}
+	for i, rs := range ruleSets {
+		for _, w := range warnings[rs.SetName] {
+			fmt.Fprintf(os.Stderr, "cruxmatch: %v: warning: %v\n", ruleSetFiles[i], w)
+		}
+	}
+	e.AddRuleSet(ruleSets...)
+	return e, nil
+}
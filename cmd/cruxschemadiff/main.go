@@ -0,0 +1,92 @@
+/*
+Cruxschemadiff compares two versions of a rule schema, and lists each change with the rules that
+it may affect:
+
+	cruxschemadiff -old item.json -new item-v2.json -rules main.json -rules discounts.json
+
+Schemas and rulesets are read from files in the JSON format described in crux's json_format.go.
+Each change is written to stdout on a line of its own, marked breaking or compatible as described
+in crux's schema_diff.go, followed by one indented line per affected rule:
+
+	breaking: values removed from attribute cat: refbook
+	    main rule 0
+	compatible: task dodiscount added
+
+The exit status is 1 if any change is breaking, and 2 if the files cannot be read.
+*/
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/remiges-tech/crux"
+)
+
+type fileList []string
+
+func (f *fileList) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *fileList) Set(name string) error {
+	*f = append(*f, name)
+	return nil
+}
+
+func main() {
+	var ruleSetFiles fileList
+	oldFile := flag.String("old", "", "file containing the current rule schema in JSON")
+	newFile := flag.String("new", "", "file containing the new rule schema in JSON")
+	flag.Var(&ruleSetFiles, "rules", "file containing a ruleset in JSON (may be repeated)")
+	flag.Parse()
+
+	changes, err := diff(*oldFile, *newFile, ruleSetFiles)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "cruxschemadiff:", err)
+		os.Exit(2)
+	}
+	breaking := false
+	for _, c := range changes {
+		fmt.Println(c)
+		for _, ref := range c.Affected {
+			fmt.Printf("    %v rule %v\n", ref.SetName, ref.RuleIndex)
+		}
+		breaking = breaking || c.Breaking
+	}
+	if breaking {
+		os.Exit(1)
+	}
+}
+
+func diff(oldFile string, newFile string, ruleSetFiles []string) ([]crux.SchemaChange, error) {
+	var schemas [2]crux.RuleSchema
+	for i, name := range []string{oldFile, newFile} {
+		data, err := os.ReadFile(name)
+		if err != nil {
+			return nil, err
+		}
+		if schemas[i], err = crux.ParseRuleSchemaJSON(data); err != nil {
+			return nil, fmt.Errorf("%v: %w", name, err)
+		}
+	}
+	// The rulesets are decoded with the old schema, under which they were written
+	e := crux.NewEngine()
+	e.AddRuleSchema(schemas[0])
+	var ruleSets []crux.RuleSet
+	for _, name := range ruleSetFiles {
+		data, err := os.ReadFile(name)
+		if err != nil {
+			return nil, err
+		}
+		rs, err := e.ParseRuleSetJSON(data)
+		if err != nil {
+			return nil, fmt.Errorf("%v: %w", name, err)
+		}
+		ruleSets = append(ruleSets, rs)
+	}
+	return crux.DiffSchemas(schemas[0], schemas[1], ruleSets)
+}
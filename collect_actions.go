@@ -1,38 +1,38 @@
 /* This file contains the collectActions() function */
 
-package main
+package crux
 
 func collectActions(actionSet ActionSet, ruleActions RuleActions) ActionSet {
 	newActionSet := ActionSet{}
 
 	// Union-set of tasks
-	newActionSet.tasks = append(newActionSet.tasks, actionSet.tasks...)
+	newActionSet.Tasks = append(newActionSet.Tasks, actionSet.Tasks...)
 	for _, newTask := range ruleActions.Tasks {
 		found := false
-		for _, task := range newActionSet.tasks {
+		for _, task := range newActionSet.Tasks {
 			if newTask == task {
 				found = true
 				break
 			}
 		}
 		if !found {
-			newActionSet.tasks = append(newActionSet.tasks, newTask)
+			newActionSet.Tasks = append(newActionSet.Tasks, newTask)
 		}
 	}
 
 	// Perform "union-set" of properties, overwriting previous property values if needed
-	newActionSet.properties = append(newActionSet.properties, actionSet.properties...)
+	newActionSet.Properties = append(newActionSet.Properties, actionSet.Properties...)
 	for _, newProperty := range ruleActions.Properties {
 		found := false
-		for i, property := range newActionSet.properties {
+		for i, property := range newActionSet.Properties {
 			if property.Name == newProperty.Name {
-				newActionSet.properties[i].Val = newProperty.Val
+				newActionSet.Properties[i].Val = newProperty.Val
 				found = true
 				break
 			}
 		}
 		if !found {
-			newActionSet.properties = append(newActionSet.properties, newProperty)
+			newActionSet.Properties = append(newActionSet.Properties, newProperty)
 		}
 	}
 	return newActionSet
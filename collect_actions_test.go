@@ -1,4 +1,4 @@
-package main
+package crux
 
 import (
 	"reflect"
@@ -7,8 +7,8 @@ import (
 
 func TestCollectActionsBasic(t *testing.T) {
 	actionSet := ActionSet{
-		tasks:      []string{"dodiscount", "yearendsale"},
-		properties: []Property{{"discount", "7"}, {"shipby", "fedex"}},
+		Tasks:      []string{"dodiscount", "yearendsale"},
+		Properties: []Property{{"discount", "7"}, {"shipby", "fedex"}},
 	}
 
 	ruleActions := RuleActions{
@@ -20,8 +20,8 @@ func TestCollectActionsBasic(t *testing.T) {
 	}
 
 	want := ActionSet{
-		tasks:      []string{"dodiscount", "yearendsale", "summersale"},
-		properties: []Property{{"discount", "9"}, {"shipby", "fedex"}, {"cashback", "10"}},
+		Tasks:      []string{"dodiscount", "yearendsale", "summersale"},
+		Properties: []Property{{"discount", "9"}, {"shipby", "fedex"}, {"cashback", "10"}},
 	}
 
 	res := collectActions(actionSet, ruleActions)
@@ -32,15 +32,15 @@ func TestCollectActionsBasic(t *testing.T) {
 
 func TestCollectActionsWithEmptyRuleActions(t *testing.T) {
 	actionSet := ActionSet{
-		tasks:      []string{"dodiscount", "yearendsale"},
-		properties: []Property{{"discount", "7"}, {"shipby", "fedex"}},
+		Tasks:      []string{"dodiscount", "yearendsale"},
+		Properties: []Property{{"discount", "7"}, {"shipby", "fedex"}},
 	}
 
 	ruleActions := RuleActions{}
 
 	want := ActionSet{
-		tasks:      []string{"dodiscount", "yearendsale"},
-		properties: []Property{{"discount", "7"}, {"shipby", "fedex"}},
+		Tasks:      []string{"dodiscount", "yearendsale"},
+		Properties: []Property{{"discount", "7"}, {"shipby", "fedex"}},
 	}
 
 	res := collectActions(actionSet, ruleActions)
@@ -61,8 +61,8 @@ func TestCollectActionsWithEmptyActionSet(t *testing.T) {
 	}
 
 	want := ActionSet{
-		tasks:      []string{"dodiscount", "yearendsale"},
-		properties: []Property{{"discount", "7"}, {"shipby", "fedex"}},
+		Tasks:      []string{"dodiscount", "yearendsale"},
+		Properties: []Property{{"discount", "7"}, {"shipby", "fedex"}},
 	}
 
 	res := collectActions(actionSet, ruleActions)
@@ -0,0 +1,437 @@
+/*
+This file contains the compiler that turns a RuleSet into a compiledRuleSet, and
+doMatchCompiled(), which matches an entity against a compiledRuleSet.
+
+matchPattern() scans the entity's attributes and the schema for every term, and converts the
+entity's value from a string every time it is compared. A compiledRuleSet instead refers to
+attributes by their position ("slot") in the class's pattern-schema, and holds term values that
+have already been converted to the attribute's type. Each entity is laid out in slots once per
+match, and each of its values is converted at most once, when a term first needs it.
+
+doMatchCompiled() follows the same steps as doMatch(), and gives the same results. Large
+compiledRulesets also carry a ruleIndex (see rule_index.go), which lets doMatchCompiled() skip
+rules that cannot match.
+
+Rulesets are compiled by the registry when they are added, and when the schema for their class
+changes. A ruleset that cannot be compiled, for instance because it does not pass verification,
+is run by doMatch() instead.
+*/
+
+package crux
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+)
+
+// compiledSchema maps the attribute names in a pattern-schema to slots, and holds the value-type
+// of each slot, and the format of each ts or date slot that has its own (see time_layouts.go)
+type compiledSchema struct {
+	class   string
+	slots   map[string]int
+	types   []string
+	formats []*attrFormat
+	// The required attributes, and the attributes that have defaults, with their defaults
+	required []string
+	defaults []Attr
+}
+
+type compiledRuleSet struct {
+	setName string
+	class   string
+	schema  *compiledSchema
+	rules   []compiledRule
+	missing string
+	// The rule index, for rulesets of at least indexMinRules rules
+	index *ruleIndex
+}
+
+type compiledRule struct {
+	terms   []compiledTerm
+	actions RuleActions
+}
+
+type compiledTerm struct {
+	attrName string
+	// The attribute's slot, or -1 if the attribute is a task in the action-schema or the term is
+	// a group
+	slot    int
+	valType string
+	op      string
+	// The term's value, converted to valType, a valSet for in and nin, or a *regexp.Regexp for
+	// matches
+	val any
+	// The terms of an any, all or not group
+	terms []compiledTerm
+	// For a term that compares two attributes, the other attribute's slot, or -1
+	refSlot int
+	// For a term that compares expressions, the two sides, the slots of the attributes they use,
+	// in the order in which matchExprTerm() looks them up, and whether evaluating them may fail
+	left, right *exprNode
+	exprSlots   []exprSlot
+	mayFail     bool
+}
+
+type exprSlot struct {
+	name    string
+	slot    int
+	valType string
+}
+
+// slotVal holds an entity's value for one attribute, and its conversion to the attribute's type.
+// present is false if the entity does not have the attribute.
+type slotVal struct {
+	present   bool
+	raw       string
+	format    *attrFormat
+	conv      any
+	err       error
+	converted bool
+}
+
+func compileSchema(rs RuleSchema) *compiledSchema {
+	cs := &compiledSchema{class: rs.Class, slots: map[string]int{}}
+	for i, as := range rs.PatternSchema {
+		cs.slots[as.Name] = i
+		cs.types = append(cs.types, as.ValType)
+		cs.formats = append(cs.formats, newAttrFormat(as))
+		if as.Required {
+			cs.required = append(cs.required, as.Name)
+		}
+		if as.Default != "" {
+			cs.defaults = append(cs.defaults, Attr{as.Name, as.Default})
+		}
+	}
+	return cs
+}
+
+func compileRuleSet(ruleSet RuleSet, schema RuleSchema, cs *compiledSchema) (*compiledRuleSet, error) {
+	crs := &compiledRuleSet{setName: ruleSet.SetName, class: ruleSet.Class, schema: cs, missing: ruleSet.MissingAttrs}
+	for _, rule := range ruleSet.Rules {
+		cr := compiledRule{actions: rule.RuleActions}
+		for _, term := range rule.RulePattern {
+			ct, err := compileTerm(term, schema, cs)
+			if err != nil {
+				return nil, err
+			}
+			cr.terms = append(cr.terms, ct)
+		}
+		crs.rules = append(crs.rules, cr)
+	}
+	if len(crs.rules) >= indexMinRules {
+		crs.index = newRuleIndex(crs.rules, cs.types, crs.missing)
+	}
+	return crs, nil
+}
+
+func compileTerm(term RulePatternTerm, schema RuleSchema, cs *compiledSchema) (compiledTerm, error) {
+	ct := compiledTerm{attrName: term.AttrName, slot: -1, op: term.Op, val: term.AttrVal, refSlot: -1}
+	if groupOps[term.Op] {
+		terms, ok := term.AttrVal.([]RulePatternTerm)
+		if !ok || len(terms) == 0 {
+			return compiledTerm{}, fmt.Errorf("%v group has no terms", term.Op)
+		}
+		for _, t := range terms {
+			child, err := compileTerm(t, schema, cs)
+			if err != nil {
+				return compiledTerm{}, err
+			}
+			ct.terms = append(ct.terms, child)
+		}
+		ct.val = nil
+		return ct, nil
+	}
+	if isExprTerm(term) {
+		return compileExprTerm(ct, term, schema, cs)
+	}
+	if slot, ok := cs.slots[term.AttrName]; ok {
+		ct.slot, ct.valType = slot, cs.types[slot]
+	} else if isStringInArray(term.AttrName, schema.ActionSchema.Tasks) {
+		ct.valType = TypeBool
+	} else {
+		return compiledTerm{}, fmt.Errorf("attribute does not exist in schema: %v", term.AttrName)
+	}
+	if !validOps[term.Op] {
+		return compiledTerm{}, fmt.Errorf("invalid operation in rule: %v", term.Op)
+	}
+	if ref, ok := term.AttrVal.(AttrRef); ok {
+		if err := verifyRefTerm(term, ref, schema); err != nil {
+			return compiledTerm{}, err
+		}
+		ct.refSlot = cs.slots[ref.Attr]
+		return ct, nil
+	}
+	if (term.Op == OpExists || term.Op == OpNotExists) && ct.slot < 0 {
+		return compiledTerm{}, fmt.Errorf("%v applies only to attributes in the pattern-schema: %v", term.Op, term.AttrName)
+	}
+	if ct.valType == TypeList || listOps[term.Op] {
+		if err := verifyListTerm(term, schema); err != nil {
+			return compiledTerm{}, err
+		}
+		if hasOps[term.Op] {
+			ct.val, _ = makeValSet(term.AttrVal)
+		}
+		return ct, nil
+	}
+	if !verifyTermVal(term.AttrVal, ct.valType, term.Op) {
+		return compiledTerm{}, fmt.Errorf("value of this attribute does not match schema type: %v", term.AttrName)
+	}
+	if (ct.valType == TypeTS || ct.valType == TypeDate) && !timeOps[term.Op] {
+		ct.val, _ = termTime(term.AttrVal, ct.valType)
+	}
+	if ct.valType == TypeDecimal && refOps[term.Op] {
+		ct.val, _, _ = termDecimal(term.AttrVal)
+	}
+	if setOps[term.Op] {
+		ct.val, _ = makeValSet(term.AttrVal)
+	}
+	if timeOps[term.Op] {
+		ct.val, _ = compileTimeVal(term.Op, term.AttrVal)
+	}
+	if term.Op == OpMatches {
+		re, err := regexp.Compile(term.AttrVal.(string))
+		if err != nil {
+			return compiledTerm{}, fmt.Errorf("invalid regular expression for %v: %w", term.AttrName, err)
+		}
+		ct.val = re
+	}
+	return ct, nil
+}
+
+// Compiles a term that compares expressions. Its slot is -1, and the attributes it uses are in
+// exprSlots.
+func compileExprTerm(ct compiledTerm, term RulePatternTerm, schema RuleSchema, cs *compiledSchema) (compiledTerm, error) {
+	var err error
+	if ct.mayFail, err = verifyExprTerm(term, schema); err != nil {
+		return compiledTerm{}, err
+	}
+	ct.left, ct.right, _ = exprOperands(term, nil)
+	for _, name := range ct.right.attrs(ct.left.attrs(nil)) {
+		ct.exprSlots = append(ct.exprSlots, exprSlot{name, cs.slots[name], cs.types[cs.slots[name]]})
+	}
+	return ct, nil
+}
+
+// Compiles ruleSet if the schema for its class is in st, and records the result in st
+func (st *ruleStore) compile(ruleSet RuleSet) {
+	delete(st.compiled, ruleSet.SetName)
+	schema, ok := st.schemas[ruleSet.Class]
+	if !ok {
+		return
+	}
+	if crs, err := compileRuleSet(ruleSet, schema, st.compiledSchemas[ruleSet.Class]); err == nil {
+		st.compiled[ruleSet.SetName] = crs
+	}
+}
+
+// Lays out the attributes of entity in the slots of cs. As in getEntityAttrVal(), an attribute
+// with an empty value is treated as absent unless it is a str, and if an attribute appears more
+// than once, the last value is used.
+func (cs *compiledSchema) entitySlots(entity Entity) []slotVal {
+	vals := make([]slotVal, len(cs.types))
+	for _, attr := range entity.Attrs {
+		if slot, ok := cs.slots[attr.Name]; ok {
+			vals[slot].raw, vals[slot].format = attr.Val, cs.formats[slot]
+			vals[slot].present = attr.Val != "" || cs.types[slot] == TypeStr
+		}
+	}
+	return vals
+}
+
+// Returns whether or not the term matches, or errUnknown if it is unknown, as matchTerm() does
+func (ct *compiledTerm) match(m *matcher, vals []slotVal, actionSet ActionSet, missing string) (bool, error) {
+	if groupOps[ct.op] {
+		return matchCompiledGroup(m, ct.op, ct.terms, vals, actionSet, missing)
+	}
+	if ct.left != nil {
+		return ct.matchExprs(vals, missing)
+	}
+	present := ct.slot >= 0 && vals[ct.slot].present
+	absentAttr := ""
+	if ct.slot >= 0 && !present {
+		absentAttr = ct.attrName
+	} else if ct.refSlot >= 0 && !vals[ct.refSlot].present {
+		absentAttr = ct.val.(AttrRef).Attr
+	}
+	switch {
+	case ct.op == OpExists || ct.op == OpNotExists:
+		return present == (ct.op == OpExists), nil
+	case absentAttr == "" || (missing == MissingDefault && ct.refSlot < 0):
+	case missing == MissingUnknown:
+		return false, errUnknown
+	case missing == MissingNoMatch:
+		return false, nil
+	default:
+		return false, fmt.Errorf("error making comparison %w", missingAttrError(absentAttr))
+	}
+	var entityAttrValConv any
+	valType, termAttrVal := ct.valType, ct.val
+	if present {
+		var err error
+		if entityAttrValConv, err = vals[ct.slot].convert(valType); err != nil {
+			return false, fmt.Errorf("error making comparison %w", fmt.Errorf("error converting value: %w", err))
+		}
+		if ct.refSlot >= 0 {
+			if termAttrVal, err = vals[ct.refSlot].convert(valType); err != nil {
+				return false, fmt.Errorf("error making comparison %w", fmt.Errorf("error converting value: %w", err))
+			}
+		}
+		if timeOps[ct.op] {
+			matched, err := compareTimeVals(entityAttrValConv, termAttrVal, ct.op, m.now(), vals[ct.slot].format.location())
+			if err != nil {
+				return false, fmt.Errorf("error making comparison %w", err)
+			}
+			return matched, nil
+		}
+	} else {
+		// As in matchTerm(), a task, or under MissingDefault an absent attribute, is true if it is
+		// a task in the action-set, and false otherwise
+		entityAttrValConv = isStringInArray(ct.attrName, actionSet.Tasks)
+		valType = TypeBool
+	}
+	matched, err := compareVals(entityAttrValConv, termAttrVal, valType, ct.op)
+	if err != nil {
+		return false, fmt.Errorf("error making comparison %w", err)
+	}
+	return matched, nil
+}
+
+// Evaluates a term that compares expressions, as matchExprTerm() does
+func (ct *compiledTerm) matchExprs(vals []slotVal, missing string) (bool, error) {
+	for _, es := range ct.exprSlots {
+		if !vals[es.slot].present {
+			switch missing {
+			case MissingUnknown:
+				return false, errUnknown
+			case MissingNoMatch:
+				return false, nil
+			}
+			return false, fmt.Errorf("error making comparison %w", missingAttrError(es.name))
+		}
+		if _, err := vals[es.slot].convert(es.valType); err != nil {
+			return false, fmt.Errorf("error making comparison %w", fmt.Errorf("error converting value of %v: %w", es.name, err))
+		}
+	}
+	lookup := func(name string) (any, error) {
+		for _, es := range ct.exprSlots {
+			if es.name == name {
+				return vals[es.slot].conv, nil
+			}
+		}
+		return nil, fmt.Errorf("attribute does not exist in schema: %v", name)
+	}
+	var tt TermTrace
+	matched, err := tt.evalExprs(ct.left, ct.right, ct.op, lookup)
+	if err != nil {
+		return false, fmt.Errorf("error making comparison %w", err)
+	}
+	return matched, nil
+}
+
+// Returns the entity's value converted to valType, converting it on first use
+func (sv *slotVal) convert(valType string) (any, error) {
+	if !sv.converted {
+		sv.conv, sv.err = convertEntityAttrVal(sv.raw, valType, sv.format)
+		sv.converted = true
+	}
+	return sv.conv, sv.err
+}
+
+func (cr *compiledRule) matchPattern(m *matcher, vals []slotVal, actionSet ActionSet, missing string) (bool, error) {
+	return matchCompiledGroup(m, OpAll, cr.terms, vals, actionSet, missing)
+}
+
+// Evaluates a group of terms as matchGroup() does, and returns errUnknown if it is unknown
+func matchCompiledGroup(m *matcher, op string, terms []compiledTerm, vals []slotVal, actionSet ActionSet, missing string) (bool, error) {
+	deciding := op == OpAny
+	unknown := false
+	for i := range terms {
+		matched, err := terms[i].match(m, vals, actionSet, missing)
+		if errors.Is(err, errUnknown) {
+			unknown = true
+		} else if err != nil {
+			return false, err
+		} else if matched == deciding {
+			return deciding != (op == OpNot), nil
+		}
+	}
+	if unknown {
+		return false, errUnknown
+	}
+	return !deciding != (op == OpNot), nil
+}
+
+// doMatchCompiled is doMatch() for compiled rulesets. vals holds the entity's attributes laid
+// out by entitySlots(). A called ruleset that has not been compiled is run by doMatch().
+func (m *matcher) doMatchCompiled(entity Entity, vals []slotVal, crs *compiledRuleSet, actionSet ActionSet,
+	seenRuleSets map[string]bool) (ActionSet, bool, error) {
+	if seenRuleSets[crs.setName] {
+		return ActionSet{}, false, errors.New("ruleset has already been traversed")
+	}
+	seenRuleSets[crs.setName] = true
+	// If the ruleset is indexed, only the candidate rules are evaluated; the others cannot match
+	var candidates []int
+	numRules := len(crs.rules)
+	if crs.index != nil {
+		candidates = crs.index.candidates(vals)
+		numRules = len(candidates)
+	}
+	for k := 0; k < numRules; k++ {
+		i := k
+		if crs.index != nil {
+			i = candidates[k]
+		}
+		rule := &crs.rules[i]
+		willExit := false
+		matched, err := rule.matchPattern(m, vals, actionSet, crs.missing)
+		if errors.Is(err, errUnknown) {
+			continue
+		} else if err != nil {
+			return ActionSet{}, false, err
+		}
+		if matched {
+			actionSet = collectActions(actionSet, rule.actions)
+			if len(rule.actions.ThenCall) > 0 {
+				var err error
+				actionSet, willExit, err = m.callRuleSet(entity, vals, rule.actions.ThenCall, crs.setName, actionSet, seenRuleSets)
+				if err != nil {
+					return ActionSet{}, false, err
+				}
+			}
+			if willExit || rule.actions.WillExit {
+				return actionSet, true, nil
+			}
+			if rule.actions.WillReturn {
+				delete(seenRuleSets, crs.setName)
+				return actionSet, false, nil
+			}
+		} else if len(rule.actions.ElseCall) > 0 {
+			var err error
+			actionSet, willExit, err = m.callRuleSet(entity, vals, rule.actions.ElseCall, crs.setName, actionSet, seenRuleSets)
+			if err != nil {
+				return ActionSet{}, false, err
+			} else if willExit {
+				return actionSet, true, nil
+			}
+		}
+	}
+	delete(seenRuleSets, crs.setName)
+	return actionSet, false, nil
+}
+
+// Runs the ruleset setName, called from the compiled ruleset currSetName
+func (m *matcher) callRuleSet(entity Entity, vals []slotVal, setName string, currSetName string, actionSet ActionSet,
+	seenRuleSets map[string]bool) (ActionSet, bool, error) {
+	if crs, ok := m.store.compiled[setName]; ok {
+		if crs.class != entity.Class {
+			return ActionSet{}, false, inconsistentRuleSet(crs.setName, currSetName)
+		}
+		return m.doMatchCompiled(entity, vals, crs, actionSet, seenRuleSets)
+	}
+	setToCall, err := m.getRuleSetToCall(entity, setName, currSetName)
+	if err != nil {
+		return ActionSet{}, false, err
+	}
+	return m.doMatch(entity, setToCall, actionSet, seenRuleSets)
+}
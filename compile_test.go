@@ -0,0 +1,206 @@
+package crux
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"strconv"
+	"testing"
+)
+
+// Every doMatch() test is run by both doMatch() and doMatchCompiled(), which must agree
+func TestCompiledMatchesInterpreter(t *testing.T) {
+	tests := []doMatchTest{}
+	setupInventoryItemSchema()
+	testBasic(&tests)
+	testExit(&tests)
+	testReturn(&tests)
+	testTransactions(&tests)
+	testPurchases(&tests)
+	testOrders(&tests)
+	testUCCCreation(&tests)
+	testPrepareAOF(&tests)
+	testValidateAOF(&tests)
+
+	compiledCount := 0
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			testEngine.AddRuleSet(tt.ruleSet)
+			m := matcher{store: testEngine.registry.load()}
+			want, _, wantErr := m.doMatch(tt.entity, tt.ruleSet, ActionSet{}, map[string]bool{})
+			crs, ok := m.store.compiled[tt.ruleSet.SetName]
+			if !ok {
+				return
+			}
+			compiledCount++
+			got, _, err := m.doMatchCompiled(tt.entity, crs.schema.entitySlots(tt.entity), crs, ActionSet{}, map[string]bool{})
+			if !reflect.DeepEqual(got, want) || (err != nil) != (wantErr != nil) {
+				t.Errorf("doMatchCompiled() = %v, %v, doMatch() = %v, %v", got, err, want, wantErr)
+			}
+		})
+	}
+	t.Logf("%v of %v test rulesets were compiled", compiledCount, len(tests))
+	if compiledCount < len(tests)/2 {
+		t.Errorf("only %v of %v test rulesets were compiled", compiledCount, len(tests))
+	}
+}
+
+func TestCompileErrors(t *testing.T) {
+	e := NewEngine()
+	schema := testCounterSchema()
+	schema.PatternSchema = append(schema.PatternSchema, AttrSchema{Name: "s", ValType: TypeStr})
+	e.AddRuleSchema(schema)
+	e.AddRuleSet(
+		RuleSet{Ver: 1, Class: counterClass, SetName: "badattr", Rules: []Rule{{[]RulePatternTerm{{"m", OpEQ, 1}}, RuleActions{}}}},
+		RuleSet{Ver: 1, Class: counterClass, SetName: "badop", Rules: []Rule{{[]RulePatternTerm{{"n", "greater", 1}}, RuleActions{}}}},
+		RuleSet{Ver: 1, Class: counterClass, SetName: "badval", Rules: []Rule{{[]RulePatternTerm{{"n", OpEQ, 1.5}}, RuleActions{}}}},
+		RuleSet{Ver: 1, Class: counterClass, SetName: "badregexp", Rules: []Rule{{[]RulePatternTerm{{"s", OpMatches, "("}}, RuleActions{}}}},
+		testCounterRuleSet("good"),
+	)
+	st := e.registry.load()
+	for _, setName := range []string{"badattr", "badop", "badval", "badregexp"} {
+		if _, ok := st.compiled[setName]; ok {
+			t.Errorf("ruleset %v was compiled, but should not have been", setName)
+		}
+	}
+	if _, ok := st.compiled[mainRS]; !ok {
+		t.Errorf("ruleset %v was not compiled", mainRS)
+	}
+
+	// A schema change recompiles the rulesets of its class
+	schema.PatternSchema = append(schema.PatternSchema, AttrSchema{Name: "m", ValType: TypeInt})
+	e.AddRuleSchema(schema)
+	if _, ok := e.registry.load().compiled["badattr"]; !ok {
+		t.Errorf("ruleset badattr was not recompiled after the schema changed")
+	}
+}
+
+// The ruleset for the benchmarks has numRules rules over several attribute types. Only the last
+// rule matches the benchmark entity, so every rule is evaluated.
+func setupBenchmarkEngine(tb testing.TB, numRules int) *Engine {
+	schema := RuleSchema{
+		Class: "pricing",
+		PatternSchema: []AttrSchema{
+			{Name: "region", ValType: TypeEnum, Vals: map[string]bool{"north": true, "south": true, "east": true, "west": true}},
+			{Name: "product", ValType: TypeStr},
+			{Name: "qty", ValType: TypeInt},
+			{Name: "mrp", ValType: TypeFloat},
+			{Name: "ordered", ValType: TypeTS},
+			{Name: "member", ValType: TypeBool},
+			{Name: "channel", ValType: TypeEnum, Vals: map[string]bool{"online": true, "store": true, "web": true}},
+			{Name: "segment", ValType: TypeEnum, Vals: map[string]bool{"retail": true, "wholesale": true}},
+			{Name: "price", ValType: TypeDecimal, Currency: "INR"},
+			{Name: "tags", ValType: TypeList, ElemType: TypeEnum, Vals: map[string]bool{"new": true, "sale": true, "bulk": true}},
+		},
+		ActionSchema: ActionSchema{Tasks: []string{"discount"}, Properties: []string{"rate"}},
+	}
+	if _, err := verifyRuleSchema(schema, false); err != nil {
+		tb.Fatalf("benchmark schema is invalid: %v", err)
+	}
+	e := NewEngine()
+	e.AddRuleSchema(schema)
+	rules := make([]Rule, numRules)
+	for i := range rules {
+		rules[i] = Rule{
+			[]RulePatternTerm{
+				{"member", OpEQ, true},
+				{"ordered", OpGT, "2020-01-01T00:00:00Z"},
+				{"mrp", OpGE, 10.5},
+				{"qty", OpGE, i},
+				{"product", OpEQ, "product" + strconv.Itoa(i)},
+			},
+			RuleActions{Tasks: []string{"discount"}, Properties: []Property{{"rate", strconv.Itoa(i)}}},
+		}
+	}
+	e.AddRuleSet(RuleSet{Ver: 1, Class: "pricing", SetName: mainRS, Rules: rules})
+	return e
+}
+
+func benchmarkEntity(numRules int) Entity {
+	return Entity{"pricing", []Attr{
+		{"region", "west"},
+		{"channel", "online"},
+		{"segment", "retail"},
+		{"product", "product" + strconv.Itoa(numRules-1)},
+		{"qty", strconv.Itoa(numRules)},
+		{"mrp", "99.50"},
+		{"ordered", "2023-06-01T10:00:00Z"},
+		{"member", trueStr},
+	}}
+}
+
+func BenchmarkMatch(b *testing.B) {
+	for _, numRules := range []int{100, 1000, 5000} {
+		e := setupBenchmarkEngine(b, numRules)
+		entity := benchmarkEntity(numRules)
+		st := e.registry.load()
+		ruleSet, _ := st.getRuleSet(mainRS)
+		// Scan every rule, as BenchmarkIndexedMatch measures the rule index
+		crs := *st.compiled[mainRS]
+		crs.index = nil
+
+		b.Run(fmt.Sprintf("interpreted/%v", numRules), func(b *testing.B) {
+			m := matcher{store: st}
+			for i := 0; i < b.N; i++ {
+				if _, _, err := m.doMatch(entity, ruleSet, ActionSet{}, map[string]bool{}); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+		b.Run(fmt.Sprintf("compiled/%v", numRules), func(b *testing.B) {
+			m := matcher{store: st}
+			for i := 0; i < b.N; i++ {
+				vals := crs.schema.entitySlots(entity)
+				if _, _, err := m.doMatchCompiled(entity, vals, &crs, ActionSet{}, map[string]bool{}); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// The compiled ruleset holds the compiled form of a regular expression, and the engine's store
+// holds the regular expressions of its rulesets only while they have them
+func TestCompileRegexp(t *testing.T) {
+	e := NewEngine()
+	schema := testCounterSchema()
+	schema.PatternSchema = append(schema.PatternSchema, AttrSchema{Name: "s", ValType: TypeStr})
+	e.AddRuleSchema(schema)
+	e.AddRuleSet(RuleSet{Ver: 1, Class: counterClass, SetName: mainRS, Rules: []Rule{{[]RulePatternTerm{{"s", OpMatches, "^a+b$"}}, RuleActions{}}}})
+	st := e.registry.load()
+	ct := st.compiled[mainRS].rules[0].terms[0]
+	if re, ok := ct.val.(*regexp.Regexp); !ok || re.String() != "^a+b$" {
+		t.Errorf("compiled term value = %v, want the compiled regular expression", ct.val)
+	}
+	if len(st.regexps) != 1 || st.regexps["^a+b$"] == nil {
+		t.Errorf("store regexps = %v, want the ruleset's regular expression", st.regexps)
+	}
+
+	e.AddRuleSet(testCounterRuleSet("pos"))
+	if st := e.registry.load(); len(st.regexps) != 0 {
+		t.Errorf("store regexps = %v after the ruleset was replaced, want none", st.regexps)
+	}
+}
+
+// Expressions are parsed when their ruleset is added, and the engine's store holds them only
+// while its rulesets have them
+func TestCompileExprs(t *testing.T) {
+	e := NewEngine()
+	e.AddRuleSchema(testCounterSchema())
+	e.AddRuleSet(RuleSet{Ver: 1, Class: counterClass, SetName: mainRS, Rules: []Rule{{
+		[]RulePatternTerm{{"", OpGT, Arith{"n * 2", "n + 1"}}}, RuleActions{},
+	}}})
+	st := e.registry.load()
+	if len(st.exprs) != 2 || st.exprs["n * 2"] == nil || st.exprs["n + 1"] == nil {
+		t.Errorf("store exprs = %v, want the ruleset's two expressions", st.exprs)
+	}
+	_, trace, err := e.MatchWithTrace(Entity{counterClass, []Attr{{"n", "3"}}}, mainRS)
+	if tt := trace.Root.Rules[0].Terms[0]; err != nil || !tt.Matched || tt.EntityVal != "6" || tt.RefVal != "4" {
+		t.Errorf("MatchWithTrace() term = %+v, %v, want 6 > 4", tt, err)
+	}
+
+	e.AddRuleSet(testCounterRuleSet("pos"))
+	if st := e.registry.load(); len(st.exprs) != 0 {
+		t.Errorf("store exprs = %v after the ruleset was replaced, want none", st.exprs)
+	}
+}
@@ -2,51 +2,73 @@
 This file contains the data structures used by the matching engine
 */
 
-package main
+package crux
 
 type Entity struct {
-	class string
-	attrs []Attr
+	Class string `json:"class"`
+	Attrs []Attr `json:"attrs"`
 }
 
 type Attr struct {
-	name string
-	val  string
+	Name string `json:"name"`
+	Val  string `json:"val"`
 }
 
 type ActionSet struct {
-	tasks      []string
-	properties []Property
+	Tasks      []string   `json:"tasks"`
+	Properties []Property `json:"properties"`
 }
 
 type Property struct {
-	Name string
-	Val  string
+	Name string `json:"name"`
+	Val  string `json:"val"`
 }
 
 type RuleSet struct {
-	Ver     int
-	Class   string
-	SetName string
-	Rules   []Rule
+	Ver     int    `json:"ver"`
+	Class   string `json:"class"`
+	SetName string `json:"setName"`
+	Rules   []Rule `json:"rules"`
+	// How terms on attributes that an entity does not have are evaluated: one of the Missing
+	// constants
+	MissingAttrs string `json:"missingAttrs,omitempty"`
 }
 
 type Rule struct {
-	RulePattern []RulePatternTerm
-	RuleActions RuleActions
+	RulePattern []RulePatternTerm `json:"rulePattern"`
+	RuleActions RuleActions       `json:"ruleActions"`
 }
 
 type RulePatternTerm struct {
-	AttrName string
-	Op       string
-	AttrVal  any
+	AttrName string `json:"attrName"`
+	Op       string `json:"op"`
+	AttrVal  any    `json:"attrVal"`
+}
+
+// AttrRef, as the AttrVal of a term, compares the term's attribute with the attribute Attr of the
+// same entity. Both must be in the pattern-schema and have the same value-type.
+type AttrRef struct {
+	Attr string `json:"attr"`
+}
+
+// Expr, as the AttrVal of a term, compares the term's attribute with the value of the arithmetic
+// expression Src (see expr.go)
+type Expr struct {
+	Src string `json:"expr"`
+}
+
+// Arith, as the AttrVal of a term with no attribute name, compares the values of the arithmetic
+// expressions Left and Right with the term's operator
+type Arith struct {
+	Left  string `json:"left"`
+	Right string `json:"right"`
 }
 
 type RuleActions struct {
-	Tasks      []string
-	Properties []Property
-	ThenCall   string
-	ElseCall   string
-	WillReturn bool
-	WillExit   bool
+	Tasks      []string   `json:"tasks,omitempty"`
+	Properties []Property `json:"properties,omitempty"`
+	ThenCall   string     `json:"thenCall,omitempty"`
+	ElseCall   string     `json:"elseCall,omitempty"`
+	WillReturn bool       `json:"willReturn,omitempty"`
+	WillExit   bool       `json:"willExit,omitempty"`
 }
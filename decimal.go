@@ -0,0 +1,201 @@
+/*
+This file contains Decimal, the Go type of decimal values. A decimal attribute holds an exact
+decimal number of any size, such as an amount of money, which a float attribute cannot: "50.8"
+and "50.80" are equal as decimals, but 50.80000000001 is not 50.8. Decimals compare by value,
+whatever the number of digits after the point.
+
+A decimal attribute may have a currency, AttrSchema.Currency, which is a code of three capital
+letters such as "INR". Its entity values, and string values in rule-patterns, may then carry the
+code before or after the number, separated from it by a space, as in "INR 50.80" and
+"50.80 INR". A value with any other code is an error, as is a code on an attribute without a
+currency, so amounts in different currencies are never compared.
+
+AttrSchema.Precision and AttrSchema.Scale limit the values in rule-patterns as SQL's
+NUMERIC(precision, scale) does, so a term cannot compare an attribute with a value that it
+cannot hold:
+
+	{Name: "price", ValType: TypeDecimal, Currency: "INR", Precision: 10, Scale: 2}
+
+Decimal values in rule-patterns are Decimal values, made by ParseDecimal(), or strings.
+*/
+
+package crux
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"regexp"
+	"strings"
+)
+
+// Decimal is an exact decimal number. The zero value is 0. Decimals are comparable with ==,
+// which is true if they have the same value.
+type Decimal struct {
+	// The number in canonical form: an optional "-", the integer part without leading zeros,
+	// and the fractional part, if any, without trailing zeros. 0 is "".
+	s string
+}
+
+var currencyCodeRE = regexp.MustCompile(`^[A-Z]{3}$`)
+
+// ParseDecimal parses s, a decimal number with an optional sign, such as "50.80" or "-0.5"
+func ParseDecimal(s string) (Decimal, error) {
+	sign, digits := "", s
+	if digits != "" && (digits[0] == '-' || digits[0] == '+') {
+		if digits[0] == '-' {
+			sign = "-"
+		}
+		digits = digits[1:]
+	}
+	intPart, fracPart, hasPoint := strings.Cut(digits, ".")
+	if intPart == "" && fracPart == "" || hasPoint && fracPart == "" || !isDigits(intPart) || !isDigits(fracPart) {
+		return Decimal{}, fmt.Errorf("invalid decimal %q", s)
+	}
+	intPart, fracPart = strings.TrimLeft(intPart, "0"), strings.TrimRight(fracPart, "0")
+	if intPart == "" && fracPart == "" {
+		return Decimal{}, nil
+	}
+	if intPart == "" {
+		intPart = "0"
+	}
+	if fracPart != "" {
+		return Decimal{sign + intPart + "." + fracPart}, nil
+	}
+	return Decimal{sign + intPart}, nil
+}
+
+func isDigits(s string) bool {
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// String returns d in canonical form, with no trailing zeros after the point
+func (d Decimal) String() string {
+	if d.s == "" {
+		return "0"
+	}
+	return d.s
+}
+
+// MarshalText encodes d as String() does, so that JSON encodes it as a string
+func (d Decimal) MarshalText() ([]byte, error) {
+	return []byte(d.String()), nil
+}
+
+func (d *Decimal) UnmarshalText(text []byte) error {
+	var err error
+	*d, err = ParseDecimal(string(text))
+	return err
+}
+
+// Scale returns the number of digits after the point in d
+func (d Decimal) Scale() int {
+	if _, frac, ok := strings.Cut(d.s, "."); ok {
+		return len(frac)
+	}
+	return 0
+}
+
+// Returns the number of digits before the point in d, not counting a single 0
+func (d Decimal) intDigits() int {
+	intPart, _, _ := strings.Cut(strings.TrimPrefix(d.s, "-"), ".")
+	if intPart == "0" {
+		return 0
+	}
+	return len(intPart)
+}
+
+// Returns d × 10^scale, where scale is at least d.Scale()
+func (d Decimal) coef(scale int) *big.Int {
+	c, _ := new(big.Int).SetString(strings.Replace(d.String(), ".", "", 1)+strings.Repeat("0", scale-d.Scale()), 10)
+	return c
+}
+
+// Returns c × 10^-scale
+func newDecimal(c *big.Int, scale int) Decimal {
+	sign, digits := "", c.String()
+	if c.Sign() < 0 {
+		sign, digits = "-", digits[1:]
+	}
+	if len(digits) <= scale {
+		digits = strings.Repeat("0", scale-len(digits)+1) + digits
+	}
+	d, _ := ParseDecimal(sign + digits[:len(digits)-scale] + "." + digits[len(digits)-scale:] + "0")
+	return d
+}
+
+// Cmp returns -1, 0 or 1 as d is less than, equal to or greater than e
+func (d Decimal) Cmp(e Decimal) int {
+	scale := d.Scale()
+	if e.Scale() > scale {
+		scale = e.Scale()
+	}
+	return d.coef(scale).Cmp(e.coef(scale))
+}
+
+// Returns the absolute difference between d and e
+func (d Decimal) dist(e Decimal) Decimal {
+	scale := d.Scale()
+	if e.Scale() > scale {
+		scale = e.Scale()
+	}
+	diff := new(big.Int).Sub(d.coef(scale), e.coef(scale))
+	return newDecimal(diff.Abs(diff), scale)
+}
+
+// Returns whether d fits in NUMERIC(precision, scale), or true if precision is 0
+func (d Decimal) fits(precision int, scale int) bool {
+	return precision == 0 || d.Scale() <= scale && d.intDigits() <= precision-scale
+}
+
+// Splits s into a number and the currency code before or after it, if it has one
+func splitCurrency(s string) (string, string) {
+	if a, b, ok := strings.Cut(s, " "); ok {
+		if currencyCodeRE.MatchString(a) {
+			return b, a
+		} else if currencyCodeRE.MatchString(b) {
+			return a, b
+		}
+	}
+	return s, ""
+}
+
+// Parses the entity value s of a decimal attribute with the format af, which may be nil. A
+// currency code in s must be the attribute's currency.
+func parseDecimalVal(s string, af *attrFormat) (Decimal, error) {
+	num, code := splitCurrency(s)
+	if currency := af.currencyCode(); code != "" && code != currency {
+		if currency == "" {
+			return Decimal{}, fmt.Errorf("%v has a currency code, but the attribute has no currency", s)
+		}
+		return Decimal{}, fmt.Errorf("%v is not in %v", s, currency)
+	}
+	return ParseDecimal(num)
+}
+
+// Returns the currency of af, which is "" for a nil af
+func (af *attrFormat) currencyCode() string {
+	if af == nil {
+		return ""
+	}
+	return af.currency
+}
+
+// Converts val, a decimal value in a rule-pattern, to a Decimal, and returns the currency code
+// it was written with, if any
+func termDecimal(val any) (Decimal, string, error) {
+	switch v := val.(type) {
+	case Decimal:
+		return v, "", nil
+	case string:
+		num, code := splitCurrency(v)
+		d, err := ParseDecimal(num)
+		return d, code, err
+	}
+	return Decimal{}, "", errors.New("not a decimal")
+}
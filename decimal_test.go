@@ -0,0 +1,103 @@
+package crux
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestParseDecimal(t *testing.T) {
+	tests := []struct {
+		s       string
+		want    string
+		scale   int
+		wantErr bool
+	}{
+		{"50.80", "50.8", 1, false},
+		{"+050.800", "50.8", 1, false},
+		{"-0.05", "-0.05", 2, false},
+		{".5", "0.5", 1, false},
+		{"-0.000", "0", 0, false},
+		{"1200", "1200", 0, false},
+		{"123456789012345678901234567890.123456789", "123456789012345678901234567890.123456789", 9, false},
+		{"", "", 0, true},
+		{"5.", "", 0, true},
+		{"1e3", "", 0, true},
+		{"1,000", "", 0, true},
+		{"--5", "", 0, true},
+		{"INR 5", "", 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.s, func(t *testing.T) {
+			d, err := ParseDecimal(tt.s)
+			if (err != nil) != tt.wantErr || err == nil && (d.String() != tt.want || d.Scale() != tt.scale) {
+				t.Errorf("ParseDecimal() = %v (scale %v), %v, want %v (scale %v)", d, d.Scale(), err, tt.want, tt.scale)
+			}
+		})
+	}
+}
+
+func TestDecimalCmp(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"50.8", "50.80", 0},
+		{"50.8", "50.80000000001", -1},
+		{"-1", "-0.5", -1},
+		{"0", "-0", 0},
+		{"100000000000000000000.01", "100000000000000000000", 1},
+		{"9.99", "10", -1},
+	}
+	for _, tt := range tests {
+		a, _ := ParseDecimal(tt.a)
+		b, _ := ParseDecimal(tt.b)
+		if got := a.Cmp(b); got != tt.want {
+			t.Errorf("%v.Cmp(%v) = %v, want %v", tt.a, tt.b, got, tt.want)
+		}
+		if (a == b) != (tt.want == 0) {
+			t.Errorf("%v == %v is %v", tt.a, tt.b, a == b)
+		}
+	}
+	a, _ := ParseDecimal("10.5")
+	b, _ := ParseDecimal("-0.25")
+	if got := a.dist(b).String(); got != "10.75" {
+		t.Errorf("dist() = %v, want 10.75", got)
+	}
+	if got := b.dist(b).String(); got != "0" {
+		t.Errorf("dist() = %v, want 0", got)
+	}
+}
+
+func TestDecimalFits(t *testing.T) {
+	tests := []struct {
+		s                string
+		precision, scale int
+		want             bool
+	}{
+		{"12345678.99", 10, 2, true},
+		{"123456789.99", 10, 2, false},
+		{"1.999", 10, 2, false},
+		{"0.99", 2, 2, true},
+		{"1", 2, 2, false},
+		{"99", 2, 0, true},
+		{"123456789.999", 0, 0, true},
+	}
+	for _, tt := range tests {
+		d, _ := ParseDecimal(tt.s)
+		if got := d.fits(tt.precision, tt.scale); got != tt.want {
+			t.Errorf("%v fits(%v, %v) = %v, want %v", tt.s, tt.precision, tt.scale, got, tt.want)
+		}
+	}
+}
+
+func TestDecimalJSON(t *testing.T) {
+	d, _ := ParseDecimal("-1234.50")
+	data, err := json.Marshal(d)
+	if err != nil || string(data) != `"-1234.5"` {
+		t.Errorf("json.Marshal() = %s, %v", data, err)
+	}
+	var d2 Decimal
+	if err := json.Unmarshal(data, &d2); err != nil || d2 != d {
+		t.Errorf("json.Unmarshal() = %v, %v, want %v", d2, err, d)
+	}
+}
@@ -1,59 +1,79 @@
 /*
-This file contains doMatch() and a helper function called by doMatch().
-It also contains ruleSets, a map in which we are currently storing all
-rulesets for the purpose of testing doMatch().
+This file contains doMatch() and helper functions called by doMatch().
 */
 
-package main
+package crux
 
 import (
 	"errors"
 	"fmt"
+	"time"
 )
 
-var ruleSets = make(map[string]RuleSet)
+// matcher resolves schemas and called rulesets from a single ruleStore for the duration of a match
+type matcher struct {
+	store *ruleStore
 
-func doMatch(entity Entity, ruleSet RuleSet, actionSet ActionSet, seenRuleSets map[string]bool) (ActionSet, bool, error) {
+	// Only used when tracing: the trace for the next ruleset to be entered, and the trace for
+	// the rule whose pattern is being matched
+	nextSetTrace *RuleSetTrace
+	currRule     *RuleTrace
+
+	// The time of the match, once it has been read from the clock by now()
+	nowVal time.Time
+}
+
+func (m *matcher) doMatch(entity Entity, ruleSet RuleSet, actionSet ActionSet, seenRuleSets map[string]bool) (ActionSet, bool, error) {
+	setTrace := m.startRuleSetTrace(ruleSet.SetName)
 	if seenRuleSets[ruleSet.SetName] {
 		return ActionSet{}, false, errors.New("ruleset has already been traversed")
 	}
 	seenRuleSets[ruleSet.SetName] = true
-	for _, rule := range ruleSet.Rules {
+	for i, rule := range ruleSet.Rules {
 		willExit := false
-		matched, err := matchPattern(entity, rule.RulePattern, actionSet)
-		if err != nil {
+		ruleTrace := setTrace.addRule(i)
+		m.currRule = ruleTrace
+		matched, err := m.matchPattern(entity, rule.RulePattern, actionSet, ruleSet.MissingAttrs)
+		if errors.Is(err, errUnknown) {
+			ruleTrace.setUnknown()
+			continue
+		} else if err != nil {
 			return ActionSet{}, false, err
 		}
 		if matched {
+			ruleTrace.setMatched(rule.RuleActions)
 			actionSet = collectActions(actionSet, rule.RuleActions)
 			if len(rule.RuleActions.ThenCall) > 0 {
-				setToCall := ruleSets[rule.RuleActions.ThenCall]
-				if setToCall.Class != entity.class {
-					return inconsistentRuleSet(setToCall.SetName, ruleSet.SetName)
+				setToCall, err := m.getRuleSetToCall(entity, rule.RuleActions.ThenCall, ruleSet.SetName)
+				if err != nil {
+					return ActionSet{}, false, err
 				}
-				var err error
-				actionSet, willExit, err = doMatch(entity, setToCall, actionSet, seenRuleSets)
+				m.nextSetTrace = ruleTrace.addCall(viaThenCall)
+				actionSet, willExit, err = m.doMatch(entity, setToCall, actionSet, seenRuleSets)
 				if err != nil {
 					return ActionSet{}, false, err
 				}
 			}
 			if willExit || rule.RuleActions.WillExit {
+				ruleTrace.setExit()
 				return actionSet, true, nil
 			}
 			if rule.RuleActions.WillReturn {
+				ruleTrace.setReturn()
 				delete(seenRuleSets, ruleSet.SetName)
 				return actionSet, false, nil
 			}
 		} else if len(rule.RuleActions.ElseCall) > 0 {
-			setToCall := ruleSets[rule.RuleActions.ElseCall]
-			if setToCall.Class != entity.class {
-				return inconsistentRuleSet(setToCall.SetName, ruleSet.SetName)
+			setToCall, err := m.getRuleSetToCall(entity, rule.RuleActions.ElseCall, ruleSet.SetName)
+			if err != nil {
+				return ActionSet{}, false, err
 			}
-			var err error
-			actionSet, willExit, err = doMatch(entity, setToCall, actionSet, seenRuleSets)
+			m.nextSetTrace = ruleTrace.addCall(viaElseCall)
+			actionSet, willExit, err = m.doMatch(entity, setToCall, actionSet, seenRuleSets)
 			if err != nil {
 				return ActionSet{}, false, err
 			} else if willExit {
+				ruleTrace.setExit()
 				return actionSet, true, nil
 			}
 		}
@@ -62,8 +82,21 @@ func doMatch(entity Entity, ruleSet RuleSet, actionSet ActionSet, seenRuleSets m
 	return actionSet, false, nil
 }
 
-func inconsistentRuleSet(calledSetName string, currSetName string) (ActionSet, bool, error) {
-	return ActionSet{}, false, fmt.Errorf("system inconsistency with BRE rule terms, attempting to call %v from %v",
+// Returns the ruleset setName, to be called from the ruleset currSetName while matching entity.
+// verifyRuleSet() rejects rulesets whose calls would fail here.
+func (m *matcher) getRuleSetToCall(entity Entity, setName string, currSetName string) (RuleSet, error) {
+	setToCall, ok := m.store.getRuleSet(setName)
+	if !ok {
+		return RuleSet{}, fmt.Errorf("ruleset %v calls unknown ruleset %v", currSetName, setName)
+	}
+	if setToCall.Class != entity.Class {
+		return RuleSet{}, inconsistentRuleSet(setName, currSetName)
+	}
+	return setToCall, nil
+}
+
+func inconsistentRuleSet(calledSetName string, currSetName string) error {
+	return fmt.Errorf("system inconsistency with BRE rule terms, attempting to call %v from %v",
 		calledSetName, currSetName,
 	)
 }
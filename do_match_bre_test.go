@@ -6,7 +6,7 @@ Some of the definitions of rulesets below deliberately use a lot of whitespace t
 and to make it easier to understand, add to, and edit these tests
 */
 
-package main
+package crux
 
 import "testing"
 
@@ -29,10 +29,24 @@ var sampleEntity = Entity{inventoryItemClass, []Attr{
 	{"bulkorder", trueStr},
 }}
 
+func setupInventoryItemSchema() {
+	testEngine.AddRuleSchema(RuleSchema{
+		Class: inventoryItemClass,
+		PatternSchema: []AttrSchema{
+			{Name: "cat", ValType: TypeEnum},
+			{Name: "fullname", ValType: TypeStr},
+			{Name: "ageinstock", ValType: TypeInt},
+			{Name: "mrp", ValType: TypeFloat},
+			{Name: "received", ValType: TypeTS},
+			{Name: "bulkorder", ValType: TypeBool},
+		},
+	})
+}
+
 func testBasic(tests *[]doMatchTest) {
-	ruleSet := RuleSet{1, inventoryItemClass, mainRS,
-		[]Rule{{
-			[]RulePatternTerm{{"cat", opEQ, "textbook"}},
+	ruleSet := RuleSet{Ver: 1, Class: inventoryItemClass, SetName: mainRS,
+		Rules: []Rule{{
+			[]RulePatternTerm{{"cat", OpEQ, "textbook"}},
 			RuleActions{
 				Tasks:      []string{"yearendsale", "summersale"},
 				Properties: []Property{{"cashback", "10"}, {"discount", "9"}},
@@ -42,8 +56,8 @@ func testBasic(tests *[]doMatchTest) {
 	*tests = append(*tests, doMatchTest{
 		"basic test", sampleEntity, ruleSet, ActionSet{},
 		ActionSet{
-			tasks:      []string{"yearendsale", "summersale"},
-			properties: []Property{{"cashback", "10"}, {"discount", "9"}},
+			Tasks:      []string{"yearendsale", "summersale"},
+			Properties: []Property{{"cashback", "10"}, {"discount", "9"}},
 		},
 	})
 }
@@ -65,15 +79,15 @@ func testExit(tests *[]doMatchTest) {
 	rA4 := RuleActions{
 		Tasks: []string{"autumnsale"},
 	}
-	ruleSet := RuleSet{1, inventoryItemClass, mainRS, []Rule{
-		{[]RulePatternTerm{{"cat", opEQ, "refbook"}}, rA1},                           // no match
-		{[]RulePatternTerm{{"ageinstock", opLT, 7}, {"cat", opEQ, "textbook"}}, rA2}, // match
-		{[]RulePatternTerm{{"summersale", opEQ, true}}, rA3},                         // match then exit
-		{[]RulePatternTerm{{"ageinstock", opLT, 7}}, rA4},                            // ignored
+	ruleSet := RuleSet{Ver: 1, Class: inventoryItemClass, SetName: mainRS, Rules: []Rule{
+		{[]RulePatternTerm{{"cat", OpEQ, "refbook"}}, rA1},                           // no match
+		{[]RulePatternTerm{{"ageinstock", OpLT, 7}, {"cat", OpEQ, "textbook"}}, rA2}, // match
+		{[]RulePatternTerm{{"summersale", OpEQ, true}}, rA3},                         // match then exit
+		{[]RulePatternTerm{{"ageinstock", OpLT, 7}}, rA4},                            // ignored
 	}}
 	want := ActionSet{
-		tasks:      []string{"yearendsale", "summersale", "wintersale"},
-		properties: []Property{{"discount", "15"}, {"freegift", "mug"}},
+		Tasks:      []string{"yearendsale", "summersale", "wintersale"},
+		Properties: []Property{{"discount", "15"}, {"freegift", "mug"}},
 	}
 	*tests = append(*tests, doMatchTest{"exit", sampleEntity, ruleSet, ActionSet{}, want})
 }
@@ -91,27 +105,27 @@ func testReturn(tests *[]doMatchTest) {
 	rA3 := RuleActions{
 		Tasks: []string{"autumnsale"},
 	}
-	ruleSet := RuleSet{1, inventoryItemClass, mainRS, []Rule{
-		{[]RulePatternTerm{{"ageinstock", opLT, 7}, {"cat", opEQ, "textbook"}}, rA1}, // match
-		{[]RulePatternTerm{{"summersale", opEQ, true}}, rA2},                         // match then return
-		{[]RulePatternTerm{{"ageinstock", opLT, 7}}, rA3},                            // ignored
+	ruleSet := RuleSet{Ver: 1, Class: inventoryItemClass, SetName: mainRS, Rules: []Rule{
+		{[]RulePatternTerm{{"ageinstock", OpLT, 7}, {"cat", OpEQ, "textbook"}}, rA1}, // match
+		{[]RulePatternTerm{{"summersale", OpEQ, true}}, rA2},                         // match then return
+		{[]RulePatternTerm{{"ageinstock", OpLT, 7}}, rA3},                            // ignored
 	}}
 	want := ActionSet{
-		tasks:      []string{"yearendsale", "summersale", "springsale"},
-		properties: []Property{{"discount", "15"}, {"freegift", "mug"}},
+		Tasks:      []string{"yearendsale", "summersale", "springsale"},
+		Properties: []Property{{"discount", "15"}, {"freegift", "mug"}},
 	}
 	*tests = append(*tests, doMatchTest{"return", sampleEntity, ruleSet, ActionSet{}, want})
 }
 
 func testTransactions(tests *[]doMatchTest) {
-	ruleSchemas = append(ruleSchemas, RuleSchema{
-		class: transactionClass,
-		patternSchema: []AttrSchema{
-			{name: "productname", valType: typeStr},
-			{name: "price", valType: typeInt},
-			{name: "inwintersale", valType: typeBool},
-			{name: "paymenttype", valType: typeEnum},
-			{name: "ismember", valType: typeBool},
+	testEngine.AddRuleSchema(RuleSchema{
+		Class: transactionClass,
+		PatternSchema: []AttrSchema{
+			{Name: "productname", ValType: TypeStr},
+			{Name: "price", ValType: TypeInt},
+			{Name: "inwintersale", ValType: TypeBool},
+			{Name: "paymenttype", ValType: TypeEnum},
+			{Name: "ismember", ValType: TypeBool},
 		},
 	})
 
@@ -140,7 +154,7 @@ func testTransactions(tests *[]doMatchTest) {
 func setupRuleSetMainForTransaction() {
 	rule1 := Rule{
 		[]RulePatternTerm{
-			{"inwintersale", opEQ, true},
+			{"inwintersale", OpEQ, true},
 		},
 		RuleActions{
 			ThenCall: "winterdisc",
@@ -149,8 +163,8 @@ func setupRuleSetMainForTransaction() {
 	}
 	rule2 := Rule{
 		[]RulePatternTerm{
-			{"paymenttype", opEQ, "cash"},
-			{"price", opGT, 10},
+			{"paymenttype", OpEQ, "cash"},
+			{"price", OpGT, 10},
 		},
 		RuleActions{
 			Tasks: []string{"freepen"},
@@ -158,8 +172,8 @@ func setupRuleSetMainForTransaction() {
 	}
 	rule3 := Rule{
 		[]RulePatternTerm{
-			{"paymenttype", opEQ, "card"},
-			{"price", opGT, 10},
+			{"paymenttype", OpEQ, "card"},
+			{"price", OpGT, 10},
 		},
 		RuleActions{
 			Tasks: []string{"freemug"},
@@ -167,20 +181,20 @@ func setupRuleSetMainForTransaction() {
 	}
 	rule4 := Rule{
 		[]RulePatternTerm{
-			{"freehat", opEQ, true},
+			{"freehat", OpEQ, true},
 		},
 		RuleActions{Tasks: []string{"freebag"}},
 	}
-	ruleSets[mainRS] = RuleSet{1, transactionClass, mainRS,
-		[]Rule{rule1, rule2, rule3, rule4},
-	}
+	testEngine.AddRuleSet(RuleSet{Ver: 1, Class: transactionClass, SetName: mainRS,
+		Rules: []Rule{rule1, rule2, rule3, rule4},
+	})
 }
 
 func setupRuleSetWinterDisc() {
 	rule1 := Rule{
 		[]RulePatternTerm{
-			{"productname", opEQ, "jacket"},
-			{"price", opGT, 50},
+			{"productname", OpEQ, "jacket"},
+			{"price", OpGT, 50},
 		},
 		RuleActions{
 			Tasks:      []string{"freehat"},
@@ -190,7 +204,7 @@ func setupRuleSetWinterDisc() {
 	}
 	rule2 := Rule{
 		[]RulePatternTerm{
-			{"price", opLT, 100},
+			{"price", OpLT, 100},
 		},
 		RuleActions{
 			Properties: []Property{{"discount", "40"}, {"pointsmult", "2"}},
@@ -198,37 +212,37 @@ func setupRuleSetWinterDisc() {
 	}
 	rule3 := Rule{
 		[]RulePatternTerm{
-			{"price", opGE, 100},
+			{"price", OpGE, 100},
 		},
 		RuleActions{
 			Properties: []Property{{"discount", "45"}, {"pointsmult", "3"}},
 		},
 	}
-	ruleSets["winterdisc"] = RuleSet{1, transactionClass, "winterdisc",
-		[]Rule{rule1, rule2, rule3},
-	}
+	testEngine.AddRuleSet(RuleSet{Ver: 1, Class: transactionClass, SetName: "winterdisc",
+		Rules: []Rule{rule1, rule2, rule3},
+	})
 }
 
 func setupRuleSetRegularDisc() {
 	rule1 := Rule{
 		[]RulePatternTerm{
-			{"ismember", opEQ, true},
+			{"ismember", OpEQ, true},
 		},
 		RuleActions{
 			ThenCall: "memberdisc",
 			ElseCall: "nonmemberdisc",
 		},
 	}
-	ruleSets["regulardisc"] = RuleSet{1, transactionClass, "regulardisc",
-		[]Rule{rule1},
-	}
+	testEngine.AddRuleSet(RuleSet{Ver: 1, Class: transactionClass, SetName: "regulardisc",
+		Rules: []Rule{rule1},
+	})
 }
 
 func setupRuleSetMemberDisc() {
 	rule1 := Rule{
 		[]RulePatternTerm{
-			{"productname", opEQ, "lamp"},
-			{"price", opGT, 50},
+			{"productname", OpEQ, "lamp"},
+			{"price", OpGT, 50},
 		},
 		RuleActions{
 			Properties: []Property{{"discount", "35"}, {"pointsmult", "2"}},
@@ -237,7 +251,7 @@ func setupRuleSetMemberDisc() {
 	}
 	rule2 := Rule{
 		[]RulePatternTerm{
-			{"price", opLT, 100},
+			{"price", OpLT, 100},
 		},
 		RuleActions{
 			Properties: []Property{{"discount", "20"}},
@@ -245,21 +259,21 @@ func setupRuleSetMemberDisc() {
 	}
 	rule3 := Rule{
 		[]RulePatternTerm{
-			{"price", opGE, 100},
+			{"price", OpGE, 100},
 		},
 		RuleActions{
 			Properties: []Property{{"discount", "25"}},
 		},
 	}
-	ruleSets["memberdisc"] = RuleSet{1, transactionClass, "memberdisc",
-		[]Rule{rule1, rule2, rule3},
-	}
+	testEngine.AddRuleSet(RuleSet{Ver: 1, Class: transactionClass, SetName: "memberdisc",
+		Rules: []Rule{rule1, rule2, rule3},
+	})
 }
 
 func setupRuleSetNonMemberDisc() {
 	rule1 := Rule{
 		[]RulePatternTerm{
-			{"price", opLT, 50},
+			{"price", OpLT, 50},
 		},
 		RuleActions{
 			Properties: []Property{{"discount", "5"}},
@@ -267,7 +281,7 @@ func setupRuleSetNonMemberDisc() {
 	}
 	rule2 := Rule{
 		[]RulePatternTerm{
-			{"price", opGE, 50},
+			{"price", OpGE, 50},
 		},
 		RuleActions{
 			Properties: []Property{{"discount", "10"}},
@@ -275,15 +289,15 @@ func setupRuleSetNonMemberDisc() {
 	}
 	rule3 := Rule{
 		[]RulePatternTerm{
-			{"price", opGE, 100},
+			{"price", OpGE, 100},
 		},
 		RuleActions{
 			Properties: []Property{{"discount", "15"}},
 		},
 	}
-	ruleSets["nonmemberdisc"] = RuleSet{1, transactionClass, "nonmemberdisc",
-		[]Rule{rule1, rule2, rule3},
-	}
+	testEngine.AddRuleSet(RuleSet{Ver: 1, Class: transactionClass, SetName: "nonmemberdisc",
+		Rules: []Rule{rule1, rule2, rule3},
+	})
 }
 
 func testWinterDiscJacket60(tests *[]doMatchTest) {
@@ -297,13 +311,13 @@ func testWinterDiscJacket60(tests *[]doMatchTest) {
 		},
 	}
 	want := ActionSet{
-		tasks:      []string{"freehat", "freemug", "freebag"},
-		properties: []Property{{"discount", "50"}},
+		Tasks:      []string{"freehat", "freemug", "freebag"},
+		Properties: []Property{{"discount", "50"}},
 	}
 	*tests = append(*tests, doMatchTest{
 		"winterdisc jacket 60",
 		entity,
-		ruleSets[mainRS],
+		testRuleSet(mainRS),
 		ActionSet{},
 		want,
 	})
@@ -320,13 +334,13 @@ func testWinterDiscJacket40(tests *[]doMatchTest) {
 		},
 	}
 	want := ActionSet{
-		tasks:      []string{"freemug"},
-		properties: []Property{{"discount", "40"}, {"pointsmult", "2"}},
+		Tasks:      []string{"freemug"},
+		Properties: []Property{{"discount", "40"}, {"pointsmult", "2"}},
 	}
 	*tests = append(*tests, doMatchTest{
 		"winterdisc jacket 40",
 		entity,
-		ruleSets[mainRS],
+		testRuleSet(mainRS),
 		ActionSet{},
 		want,
 	})
@@ -343,13 +357,13 @@ func testWinterDiscKettle110Cash(tests *[]doMatchTest) {
 		},
 	}
 	want := ActionSet{
-		tasks:      []string{"freepen"},
-		properties: []Property{{"discount", "45"}, {"pointsmult", "3"}},
+		Tasks:      []string{"freepen"},
+		Properties: []Property{{"discount", "45"}, {"pointsmult", "3"}},
 	}
 	*tests = append(*tests, doMatchTest{
 		"winterdisc kettle 110 cash",
 		entity,
-		ruleSets[mainRS],
+		testRuleSet(mainRS),
 		ActionSet{},
 		want,
 	})
@@ -366,13 +380,13 @@ func testWinterDiscKettle110Card(tests *[]doMatchTest) {
 		},
 	}
 	want := ActionSet{
-		tasks:      []string{"freemug"},
-		properties: []Property{{"discount", "45"}, {"pointsmult", "3"}},
+		Tasks:      []string{"freemug"},
+		Properties: []Property{{"discount", "45"}, {"pointsmult", "3"}},
 	}
 	*tests = append(*tests, doMatchTest{
 		"winterdisc kettle 110 card",
 		entity,
-		ruleSets[mainRS],
+		testRuleSet(mainRS),
 		ActionSet{},
 		want,
 	})
@@ -389,12 +403,12 @@ func testMemberDiscLamp60(tests *[]doMatchTest) {
 		},
 	}
 	want := ActionSet{
-		properties: []Property{{"discount", "35"}, {"pointsmult", "2"}},
+		Properties: []Property{{"discount", "35"}, {"pointsmult", "2"}},
 	}
 	*tests = append(*tests, doMatchTest{
 		"memberdisc lamp 60",
 		entity,
-		ruleSets[mainRS],
+		testRuleSet(mainRS),
 		ActionSet{},
 		want,
 	})
@@ -411,13 +425,13 @@ func testMemberDiscKettle60Card(tests *[]doMatchTest) {
 		},
 	}
 	want := ActionSet{
-		tasks:      []string{"freemug"},
-		properties: []Property{{"discount", "20"}},
+		Tasks:      []string{"freemug"},
+		Properties: []Property{{"discount", "20"}},
 	}
 	*tests = append(*tests, doMatchTest{
 		"memberdisc kettle 60 card",
 		entity,
-		ruleSets[mainRS],
+		testRuleSet(mainRS),
 		ActionSet{},
 		want,
 	})
@@ -434,13 +448,13 @@ func testMemberDiscKettle60Cash(tests *[]doMatchTest) {
 		},
 	}
 	want := ActionSet{
-		tasks:      []string{"freepen"},
-		properties: []Property{{"discount", "20"}},
+		Tasks:      []string{"freepen"},
+		Properties: []Property{{"discount", "20"}},
 	}
 	*tests = append(*tests, doMatchTest{
 		"memberdisc kettle 60 cash",
 		entity,
-		ruleSets[mainRS],
+		testRuleSet(mainRS),
 		ActionSet{},
 		want,
 	})
@@ -457,13 +471,13 @@ func testMemberDiscKettle110Card(tests *[]doMatchTest) {
 		},
 	}
 	want := ActionSet{
-		tasks:      []string{"freemug"},
-		properties: []Property{{"discount", "25"}},
+		Tasks:      []string{"freemug"},
+		Properties: []Property{{"discount", "25"}},
 	}
 	*tests = append(*tests, doMatchTest{
 		"memberdisc kettle 110 card",
 		entity,
-		ruleSets[mainRS],
+		testRuleSet(mainRS),
 		ActionSet{},
 		want,
 	})
@@ -480,13 +494,13 @@ func testMemberDiscKettle110Cash(tests *[]doMatchTest) {
 		},
 	}
 	want := ActionSet{
-		tasks:      []string{"freepen"},
-		properties: []Property{{"discount", "25"}},
+		Tasks:      []string{"freepen"},
+		Properties: []Property{{"discount", "25"}},
 	}
 	*tests = append(*tests, doMatchTest{
 		"memberdisc kettle 110 cash",
 		entity,
-		ruleSets[mainRS],
+		testRuleSet(mainRS),
 		ActionSet{},
 		want,
 	})
@@ -503,13 +517,13 @@ func testNonMemberDiscLamp30(tests *[]doMatchTest) {
 		},
 	}
 	want := ActionSet{
-		tasks:      []string{"freepen"},
-		properties: []Property{{"discount", "5"}},
+		Tasks:      []string{"freepen"},
+		Properties: []Property{{"discount", "5"}},
 	}
 	*tests = append(*tests, doMatchTest{
 		"nonmemberdisc lamp 30",
 		entity,
-		ruleSets[mainRS],
+		testRuleSet(mainRS),
 		ActionSet{},
 		want,
 	})
@@ -526,13 +540,13 @@ func testNonMemberDiscKettle70(tests *[]doMatchTest) {
 		},
 	}
 	want := ActionSet{
-		tasks:      []string{"freepen"},
-		properties: []Property{{"discount", "10"}},
+		Tasks:      []string{"freepen"},
+		Properties: []Property{{"discount", "10"}},
 	}
 	*tests = append(*tests, doMatchTest{
 		"nonmemberdisc kettle 70",
 		entity,
-		ruleSets[mainRS],
+		testRuleSet(mainRS),
 		ActionSet{},
 		want,
 	})
@@ -549,13 +563,13 @@ func testNonMemberDiscKettle110Cash(tests *[]doMatchTest) {
 		},
 	}
 	want := ActionSet{
-		tasks:      []string{"freepen"},
-		properties: []Property{{"discount", "15"}},
+		Tasks:      []string{"freepen"},
+		Properties: []Property{{"discount", "15"}},
 	}
 	*tests = append(*tests, doMatchTest{
 		"nonmemberdisc kettle 110 cash",
 		entity,
-		ruleSets[mainRS],
+		testRuleSet(mainRS),
 		ActionSet{},
 		want,
 	})
@@ -572,13 +586,13 @@ func testNonMemberDiscKettle110Card(tests *[]doMatchTest) {
 		},
 	}
 	want := ActionSet{
-		tasks:      []string{"freemug"},
-		properties: []Property{{"discount", "15"}},
+		Tasks:      []string{"freemug"},
+		Properties: []Property{{"discount", "15"}},
 	}
 	*tests = append(*tests, doMatchTest{
 		"nonmemberdisc kettle 110 card",
 		entity,
-		ruleSets[mainRS],
+		testRuleSet(mainRS),
 		ActionSet{},
 		want,
 	})
@@ -607,17 +621,17 @@ func testPurchases(tests *[]doMatchTest) {
 }
 
 func setupPurchaseRuleSchema() {
-	ruleSchemas = append(ruleSchemas, RuleSchema{
-		class: purchaseClass,
-		patternSchema: []AttrSchema{
-			{name: "product", valType: typeStr},
-			{name: "price", valType: typeFloat},
-			{name: "ismember", valType: typeBool},
-		},
-		actionSchema: ActionSchema{
-			tasks: []string{"freepen", "freebottle", "freepencil", "freemug", "freejar", "freeplant",
+	testEngine.AddRuleSchema(RuleSchema{
+		Class: purchaseClass,
+		PatternSchema: []AttrSchema{
+			{Name: "product", ValType: TypeStr},
+			{Name: "price", ValType: TypeFloat},
+			{Name: "ismember", ValType: TypeBool},
+		},
+		ActionSchema: ActionSchema{
+			Tasks: []string{"freepen", "freebottle", "freepencil", "freemug", "freejar", "freeplant",
 				"freebag", "freenotebook"},
-			properties: []string{"discount", "pointsmult"},
+			Properties: []string{"discount", "pointsmult"},
 		},
 	})
 }
@@ -631,13 +645,13 @@ func testJacket35(tests *[]doMatchTest) {
 		},
 	}
 	want := ActionSet{
-		tasks:      []string{"freepen", "freebottle", "freepencil"},
-		properties: []Property{{"discount", "5"}},
+		Tasks:      []string{"freepen", "freebottle", "freepencil"},
+		Properties: []Property{{"discount", "5"}},
 	}
 	*tests = append(*tests, doMatchTest{
 		"jacket price 35",
 		entity,
-		ruleSets[mainRS],
+		testRuleSet(mainRS),
 		ActionSet{},
 		want,
 	})
@@ -652,13 +666,13 @@ func testJacket55ForMember(tests *[]doMatchTest) {
 		},
 	}
 	want := ActionSet{
-		tasks:      []string{"freepen", "freebottle", "freepencil", "freenotebook"},
-		properties: []Property{{"discount", "10"}},
+		Tasks:      []string{"freepen", "freebottle", "freepencil", "freenotebook"},
+		Properties: []Property{{"discount", "10"}},
 	}
 	*tests = append(*tests, doMatchTest{
 		"jacket price 55 for member",
 		entity,
-		ruleSets[mainRS],
+		testRuleSet(mainRS),
 		ActionSet{},
 		want,
 	})
@@ -673,13 +687,13 @@ func testJacket55ForNonMember(tests *[]doMatchTest) {
 		},
 	}
 	want := ActionSet{
-		tasks:      []string{"freepen", "freebottle", "freepencil", "freenotebook"},
-		properties: []Property{{"discount", "10"}},
+		Tasks:      []string{"freepen", "freebottle", "freepencil", "freenotebook"},
+		Properties: []Property{{"discount", "10"}},
 	}
 	*tests = append(*tests, doMatchTest{
 		"jacket price 55 for non-member",
 		entity,
-		ruleSets[mainRS],
+		testRuleSet(mainRS),
 		ActionSet{},
 		want,
 	})
@@ -694,13 +708,13 @@ func testJacket75ForMember(tests *[]doMatchTest) {
 		},
 	}
 	want := ActionSet{
-		tasks:      []string{"freepen", "freebottle", "freepencil", "freenotebook"},
-		properties: []Property{{"discount", "15"}, {"pointsmult", "2"}},
+		Tasks:      []string{"freepen", "freebottle", "freepencil", "freenotebook"},
+		Properties: []Property{{"discount", "15"}, {"pointsmult", "2"}},
 	}
 	*tests = append(*tests, doMatchTest{
 		"jacket price 75 for member",
 		entity,
-		ruleSets[mainRS],
+		testRuleSet(mainRS),
 		ActionSet{},
 		want,
 	})
@@ -715,13 +729,13 @@ func testJacket75ForNonMember(tests *[]doMatchTest) {
 		},
 	}
 	want := ActionSet{
-		tasks:      []string{"freepen", "freebottle", "freepencil", "freenotebook"},
-		properties: []Property{{"discount", "10"}},
+		Tasks:      []string{"freepen", "freebottle", "freepencil", "freenotebook"},
+		Properties: []Property{{"discount", "10"}},
 	}
 	*tests = append(*tests, doMatchTest{
 		"jacket price 75 for non-member",
 		entity,
-		ruleSets[mainRS],
+		testRuleSet(mainRS),
 		ActionSet{},
 		want,
 	})
@@ -736,13 +750,13 @@ func testLamp35(tests *[]doMatchTest) {
 		},
 	}
 	want := ActionSet{
-		tasks:      []string{"freemug", "freejar", "freeplant", "freebag"},
-		properties: []Property{{"discount", "20"}},
+		Tasks:      []string{"freemug", "freejar", "freeplant", "freebag"},
+		Properties: []Property{{"discount", "20"}},
 	}
 	*tests = append(*tests, doMatchTest{
 		"lamp price 35",
 		entity,
-		ruleSets[mainRS],
+		testRuleSet(mainRS),
 		ActionSet{},
 		want,
 	})
@@ -757,13 +771,13 @@ func testLamp55(tests *[]doMatchTest) {
 		},
 	}
 	want := ActionSet{
-		tasks:      []string{"freemug", "freejar", "freeplant", "freebag", "freenotebook"},
-		properties: []Property{{"discount", "25"}},
+		Tasks:      []string{"freemug", "freejar", "freeplant", "freebag", "freenotebook"},
+		Properties: []Property{{"discount", "25"}},
 	}
 	*tests = append(*tests, doMatchTest{
 		"lamp price 55",
 		entity,
-		ruleSets[mainRS],
+		testRuleSet(mainRS),
 		ActionSet{},
 		want,
 	})
@@ -778,13 +792,13 @@ func testLamp75ForMember(tests *[]doMatchTest) {
 		},
 	}
 	want := ActionSet{
-		tasks:      []string{"freemug", "freejar", "freeplant"},
-		properties: []Property{{"discount", "30"}, {"pointsmult", "3"}},
+		Tasks:      []string{"freemug", "freejar", "freeplant"},
+		Properties: []Property{{"discount", "30"}, {"pointsmult", "3"}},
 	}
 	*tests = append(*tests, doMatchTest{
 		"lamp price 75 for member",
 		entity,
-		ruleSets[mainRS],
+		testRuleSet(mainRS),
 		ActionSet{},
 		want,
 	})
@@ -799,13 +813,13 @@ func testLamp75ForNonMember(tests *[]doMatchTest) {
 		},
 	}
 	want := ActionSet{
-		tasks:      []string{"freemug", "freejar", "freeplant", "freebag", "freenotebook"},
-		properties: []Property{{"discount", "25"}},
+		Tasks:      []string{"freemug", "freejar", "freeplant", "freebag", "freenotebook"},
+		Properties: []Property{{"discount", "25"}},
 	}
 	*tests = append(*tests, doMatchTest{
 		"lamp price 75 for non-member",
 		entity,
-		ruleSets[mainRS],
+		testRuleSet(mainRS),
 		ActionSet{},
 		want,
 	})
@@ -820,12 +834,12 @@ func testKettle35(tests *[]doMatchTest) {
 		},
 	}
 	want := ActionSet{
-		properties: []Property{{"discount", "35"}},
+		Properties: []Property{{"discount", "35"}},
 	}
 	*tests = append(*tests, doMatchTest{
 		"kettle price 35",
 		entity,
-		ruleSets[mainRS],
+		testRuleSet(mainRS),
 		ActionSet{},
 		want,
 	})
@@ -840,13 +854,13 @@ func testKettle55(tests *[]doMatchTest) {
 		},
 	}
 	want := ActionSet{
-		tasks:      []string{"freenotebook"},
-		properties: []Property{{"discount", "40"}},
+		Tasks:      []string{"freenotebook"},
+		Properties: []Property{{"discount", "40"}},
 	}
 	*tests = append(*tests, doMatchTest{
 		"kettle price 55",
 		entity,
-		ruleSets[mainRS],
+		testRuleSet(mainRS),
 		ActionSet{},
 		want,
 	})
@@ -861,12 +875,12 @@ func testKettle75ForMember(tests *[]doMatchTest) {
 		},
 	}
 	want := ActionSet{
-		properties: []Property{{"discount", "45"}, {"pointsmult", "4"}},
+		Properties: []Property{{"discount", "45"}, {"pointsmult", "4"}},
 	}
 	*tests = append(*tests, doMatchTest{
 		"kettle price 75 for member",
 		entity,
-		ruleSets[mainRS],
+		testRuleSet(mainRS),
 		ActionSet{},
 		want,
 	})
@@ -881,13 +895,13 @@ func testKettle75ForNonMember(tests *[]doMatchTest) {
 		},
 	}
 	want := ActionSet{
-		tasks:      []string{"freenotebook"},
-		properties: []Property{{"discount", "40"}},
+		Tasks:      []string{"freenotebook"},
+		Properties: []Property{{"discount", "40"}},
 	}
 	*tests = append(*tests, doMatchTest{
 		"kettle price 75 for non-member",
 		entity,
-		ruleSets[mainRS],
+		testRuleSet(mainRS),
 		ActionSet{},
 		want,
 	})
@@ -905,7 +919,7 @@ func testOven35(tests *[]doMatchTest) {
 	*tests = append(*tests, doMatchTest{
 		"oven price 35",
 		entity,
-		ruleSets[mainRS],
+		testRuleSet(mainRS),
 		ActionSet{},
 		want,
 	})
@@ -920,12 +934,12 @@ func testOven55(tests *[]doMatchTest) {
 		},
 	}
 	want := ActionSet{
-		tasks: []string{"freenotebook"},
+		Tasks: []string{"freenotebook"},
 	}
 	*tests = append(*tests, doMatchTest{
 		"oven price 55",
 		entity,
-		ruleSets[mainRS],
+		testRuleSet(mainRS),
 		ActionSet{},
 		want,
 	})
@@ -934,8 +948,8 @@ func testOven55(tests *[]doMatchTest) {
 func setupRuleSetForPurchases() {
 	rule1 := Rule{
 		[]RulePatternTerm{
-			{"product", opEQ, "jacket"},
-			{"price", opGT, 30.0},
+			{"product", OpEQ, "jacket"},
+			{"price", OpGT, 30.0},
 		},
 		RuleActions{
 			Tasks:      []string{"freepen", "freebottle", "freepencil"},
@@ -944,8 +958,8 @@ func setupRuleSetForPurchases() {
 	}
 	rule2 := Rule{
 		[]RulePatternTerm{
-			{"product", opEQ, "jacket"},
-			{"price", opGT, 50.0},
+			{"product", OpEQ, "jacket"},
+			{"price", OpGT, 50.0},
 		},
 		RuleActions{
 			Properties: []Property{{"discount", "10"}},
@@ -953,9 +967,9 @@ func setupRuleSetForPurchases() {
 	}
 	rule3 := Rule{
 		[]RulePatternTerm{
-			{"product", opEQ, "jacket"},
-			{"price", opGT, 70.0},
-			{"ismember", opEQ, true},
+			{"product", OpEQ, "jacket"},
+			{"price", OpGT, 70.0},
+			{"ismember", OpEQ, true},
 		},
 		RuleActions{
 			Properties: []Property{{"discount", "15"}, {"pointsmult", "2"}},
@@ -963,8 +977,8 @@ func setupRuleSetForPurchases() {
 	}
 	rule4 := Rule{
 		[]RulePatternTerm{
-			{"product", opEQ, "lamp"},
-			{"price", opGT, 30.0},
+			{"product", OpEQ, "lamp"},
+			{"price", OpGT, 30.0},
 		},
 		RuleActions{
 			Tasks:      []string{"freemug", "freejar", "freeplant"},
@@ -973,8 +987,8 @@ func setupRuleSetForPurchases() {
 	}
 	rule5 := Rule{
 		[]RulePatternTerm{
-			{"product", opEQ, "lamp"},
-			{"price", opGT, 50.0},
+			{"product", OpEQ, "lamp"},
+			{"price", OpGT, 50.0},
 		},
 		RuleActions{
 			Properties: []Property{{"discount", "25"}},
@@ -982,9 +996,9 @@ func setupRuleSetForPurchases() {
 	}
 	rule6 := Rule{
 		[]RulePatternTerm{
-			{"product", opEQ, "lamp"},
-			{"price", opGT, 70.0},
-			{"ismember", opEQ, true},
+			{"product", OpEQ, "lamp"},
+			{"price", OpGT, 70.0},
+			{"ismember", OpEQ, true},
 		},
 		RuleActions{
 			Properties: []Property{{"discount", "30"}, {"pointsmult", "3"}},
@@ -993,8 +1007,8 @@ func setupRuleSetForPurchases() {
 	}
 	rule7 := Rule{
 		[]RulePatternTerm{
-			{"product", opEQ, "kettle"},
-			{"price", opGT, 30.0},
+			{"product", OpEQ, "kettle"},
+			{"price", OpGT, 30.0},
 		},
 		RuleActions{
 			Properties: []Property{{"discount", "35"}},
@@ -1002,8 +1016,8 @@ func setupRuleSetForPurchases() {
 	}
 	rule8 := Rule{
 		[]RulePatternTerm{
-			{"product", opEQ, "kettle"},
-			{"price", opGT, 50.0},
+			{"product", OpEQ, "kettle"},
+			{"price", OpGT, 50.0},
 		},
 		RuleActions{
 			Properties: []Property{{"discount", "40"}},
@@ -1011,9 +1025,9 @@ func setupRuleSetForPurchases() {
 	}
 	rule9 := Rule{
 		[]RulePatternTerm{
-			{"product", opEQ, "kettle"},
-			{"price", opGT, 70.0},
-			{"ismember", opEQ, true},
+			{"product", OpEQ, "kettle"},
+			{"price", OpGT, 70.0},
+			{"ismember", OpEQ, true},
 		},
 		RuleActions{
 			Properties: []Property{{"discount", "45"}, {"pointsmult", "4"}},
@@ -1022,7 +1036,7 @@ func setupRuleSetForPurchases() {
 	}
 	rule10 := Rule{
 		[]RulePatternTerm{
-			{"freemug", opEQ, true},
+			{"freemug", OpEQ, true},
 		},
 		RuleActions{
 			Tasks: []string{"freebag"},
@@ -1030,30 +1044,30 @@ func setupRuleSetForPurchases() {
 	}
 	rule11 := Rule{
 		[]RulePatternTerm{
-			{"price", opGT, 50.0},
+			{"price", OpGT, 50.0},
 		},
 		RuleActions{
 			Tasks: []string{"freenotebook"},
 		},
 	}
-	ruleSets[mainRS] = RuleSet{1, purchaseClass, mainRS,
-		[]Rule{rule1, rule2, rule3, rule4, rule5, rule6, rule7, rule8, rule9, rule10, rule11},
-	}
+	testEngine.AddRuleSet(RuleSet{Ver: 1, Class: purchaseClass, SetName: mainRS,
+		Rules: []Rule{rule1, rule2, rule3, rule4, rule5, rule6, rule7, rule8, rule9, rule10, rule11},
+	})
 }
 
 func testOrders(tests *[]doMatchTest) {
-	ruleSchemas = append(ruleSchemas, RuleSchema{
-		class: orderClass,
-		patternSchema: []AttrSchema{
-			{name: "ordertype", valType: typeEnum},
-			{name: "mode", valType: typeEnum},
-			{name: "liquidscheme", valType: typeBool},
-			{name: "overnightscheme", valType: typeBool},
-			{name: "extendedhours", valType: typeBool},
-		},
-		actionSchema: ActionSchema{
-			tasks:      []string{"unitstoamc", "unitstorta"},
-			properties: []string{"amfiordercutoff", "bseordercutoff", "fundscutoff", "unitscutoff"},
+	testEngine.AddRuleSchema(RuleSchema{
+		Class: orderClass,
+		PatternSchema: []AttrSchema{
+			{Name: "ordertype", ValType: TypeEnum},
+			{Name: "mode", ValType: TypeEnum},
+			{Name: "liquidscheme", ValType: TypeBool},
+			{Name: "overnightscheme", ValType: TypeBool},
+			{Name: "extendedhours", ValType: TypeBool},
+		},
+		ActionSchema: ActionSchema{
+			Tasks:      []string{"unitstoamc", "unitstorta"},
+			Properties: []string{"amfiordercutoff", "bseordercutoff", "fundscutoff", "unitscutoff"},
 		},
 	})
 
@@ -1074,7 +1088,7 @@ func testOrders(tests *[]doMatchTest) {
 func setupRuleSetMainForOrder() {
 	rule1 := Rule{
 		[]RulePatternTerm{
-			{"ordertype", opEQ, "purchase"},
+			{"ordertype", OpEQ, "purchase"},
 		},
 		RuleActions{
 			ThenCall: "purchaseorsip",
@@ -1082,7 +1096,7 @@ func setupRuleSetMainForOrder() {
 	}
 	rule2 := Rule{
 		[]RulePatternTerm{
-			{"ordertype", opEQ, "sip"},
+			{"ordertype", OpEQ, "sip"},
 		},
 		RuleActions{
 			ThenCall: "purchaseorsip",
@@ -1090,24 +1104,24 @@ func setupRuleSetMainForOrder() {
 	}
 	rule3 := Rule{
 		[]RulePatternTerm{
-			{"ordertype", opNE, "purchase"},
-			{"ordertype", opNE, "sip"},
+			{"ordertype", OpNE, "purchase"},
+			{"ordertype", OpNE, "sip"},
 		},
 		RuleActions{
 			Properties: []Property{{"amfiordercutoff", "1500"}, {"bseordercutoff", "1500"}},
 			ThenCall:   "otherordertypes",
 		},
 	}
-	ruleSets[mainRS] = RuleSet{1, orderClass, mainRS,
-		[]Rule{rule1, rule2, rule3},
-	}
+	testEngine.AddRuleSet(RuleSet{Ver: 1, Class: orderClass, SetName: mainRS,
+		Rules: []Rule{rule1, rule2, rule3},
+	})
 }
 
 func setupRuleSetPurchaseOrSIPForOrder() {
 	rule1 := Rule{
 		[]RulePatternTerm{
-			{"liquidscheme", opEQ, false},
-			{"overnightscheme", opEQ, false},
+			{"liquidscheme", OpEQ, false},
+			{"overnightscheme", OpEQ, false},
 		},
 		RuleActions{
 			Properties: []Property{{"amfiordercutoff", "1500"}, {"bseordercutoff", "1430"},
@@ -1122,15 +1136,15 @@ func setupRuleSetPurchaseOrSIPForOrder() {
 				{"fundscutoff", "1230"}},
 		},
 	}
-	ruleSets["purchaseorsip"] = RuleSet{1, orderClass, "purchaseorsip",
-		[]Rule{rule1, rule2},
-	}
+	testEngine.AddRuleSet(RuleSet{Ver: 1, Class: orderClass, SetName: "purchaseorsip",
+		Rules: []Rule{rule1, rule2},
+	})
 }
 
 func setupRuleSetOtherOrderTypesForOrder() {
 	rule1 := Rule{
 		[]RulePatternTerm{
-			{"mode", opEQ, "physical"},
+			{"mode", OpEQ, "physical"},
 		},
 		RuleActions{
 			Tasks: []string{"unitstoamc", "unitstorta"},
@@ -1138,8 +1152,8 @@ func setupRuleSetOtherOrderTypesForOrder() {
 	}
 	rule2 := Rule{
 		[]RulePatternTerm{
-			{"mode", opEQ, "demat"},
-			{"extendedhours", opEQ, false},
+			{"mode", OpEQ, "demat"},
+			{"extendedhours", OpEQ, false},
 		},
 		RuleActions{
 			Properties: []Property{{"unitscutoff", "1630"}},
@@ -1147,16 +1161,16 @@ func setupRuleSetOtherOrderTypesForOrder() {
 	}
 	rule3 := Rule{
 		[]RulePatternTerm{
-			{"mode", opEQ, "demat"},
-			{"extendedhours", opEQ, true},
+			{"mode", OpEQ, "demat"},
+			{"extendedhours", OpEQ, true},
 		},
 		RuleActions{
 			Properties: []Property{{"unitscutoff", "1730"}},
 		},
 	}
-	ruleSets["otherordertypes"] = RuleSet{1, orderClass, "otherordertypes",
-		[]Rule{rule1, rule2, rule3},
-	}
+	testEngine.AddRuleSet(RuleSet{Ver: 1, Class: orderClass, SetName: "otherordertypes",
+		Rules: []Rule{rule1, rule2, rule3},
+	})
 }
 
 func testSIPOrder(tests *[]doMatchTest) {
@@ -1170,13 +1184,13 @@ func testSIPOrder(tests *[]doMatchTest) {
 		},
 	}
 	want := ActionSet{
-		properties: []Property{{"amfiordercutoff", "1500"}, {"bseordercutoff", "1430"},
+		Properties: []Property{{"amfiordercutoff", "1500"}, {"bseordercutoff", "1430"},
 			{"fundscutoff", "1430"}},
 	}
 	*tests = append(*tests, doMatchTest{
 		"sip order",
 		entity,
-		ruleSets[mainRS],
+		testRuleSet(mainRS),
 		ActionSet{},
 		want,
 	})
@@ -1193,13 +1207,13 @@ func testSwitchDematOrder(tests *[]doMatchTest) {
 		},
 	}
 	want := ActionSet{
-		properties: []Property{{"amfiordercutoff", "1500"}, {"bseordercutoff", "1500"},
+		Properties: []Property{{"amfiordercutoff", "1500"}, {"bseordercutoff", "1500"},
 			{"unitscutoff", "1630"}},
 	}
 	*tests = append(*tests, doMatchTest{
 		"switch demat order",
 		entity,
-		ruleSets[mainRS],
+		testRuleSet(mainRS),
 		ActionSet{},
 		want,
 	})
@@ -1216,13 +1230,13 @@ func testSwitchDematExtHours(tests *[]doMatchTest) {
 		},
 	}
 	want := ActionSet{
-		properties: []Property{{"amfiordercutoff", "1500"}, {"bseordercutoff", "1500"},
+		Properties: []Property{{"amfiordercutoff", "1500"}, {"bseordercutoff", "1500"},
 			{"unitscutoff", "1730"}},
 	}
 	*tests = append(*tests, doMatchTest{
 		"switch demat ext-hours order",
 		entity,
-		ruleSets[mainRS],
+		testRuleSet(mainRS),
 		ActionSet{},
 		want,
 	})
@@ -1239,13 +1253,13 @@ func testRedemptionDematExtHours(tests *[]doMatchTest) {
 		},
 	}
 	want := ActionSet{
-		properties: []Property{{"amfiordercutoff", "1500"}, {"bseordercutoff", "1500"},
+		Properties: []Property{{"amfiordercutoff", "1500"}, {"bseordercutoff", "1500"},
 			{"unitscutoff", "1730"}},
 	}
 	*tests = append(*tests, doMatchTest{
 		"redemption demat ext-hours order",
 		entity,
-		ruleSets[mainRS],
+		testRuleSet(mainRS),
 		ActionSet{},
 		want,
 	})
@@ -1262,13 +1276,13 @@ func testPurchaseOvernightOrder(tests *[]doMatchTest) {
 		},
 	}
 	want := ActionSet{
-		properties: []Property{{"amfiordercutoff", "1330"}, {"bseordercutoff", "1300"},
+		Properties: []Property{{"amfiordercutoff", "1330"}, {"bseordercutoff", "1300"},
 			{"fundscutoff", "1230"}},
 	}
 	*tests = append(*tests, doMatchTest{
 		"purchase overnight order",
 		entity,
-		ruleSets[mainRS],
+		testRuleSet(mainRS),
 		ActionSet{},
 		want,
 	})
@@ -1285,13 +1299,13 @@ func testSIPLiquidOrder(tests *[]doMatchTest) {
 		},
 	}
 	want := ActionSet{
-		properties: []Property{{"amfiordercutoff", "1330"}, {"bseordercutoff", "1300"},
+		Properties: []Property{{"amfiordercutoff", "1330"}, {"bseordercutoff", "1300"},
 			{"fundscutoff", "1230"}},
 	}
 	*tests = append(*tests, doMatchTest{
 		"sip liquid order",
 		entity,
-		ruleSets[mainRS],
+		testRuleSet(mainRS),
 		ActionSet{},
 		want,
 	})
@@ -1308,13 +1322,13 @@ func testSwitchPhysicalOrder(tests *[]doMatchTest) {
 		},
 	}
 	want := ActionSet{
-		tasks:      []string{"unitstoamc", "unitstorta"},
-		properties: []Property{{"amfiordercutoff", "1500"}, {"bseordercutoff", "1500"}},
+		Tasks:      []string{"unitstoamc", "unitstorta"},
+		Properties: []Property{{"amfiordercutoff", "1500"}, {"bseordercutoff", "1500"}},
 	}
 	*tests = append(*tests, doMatchTest{
 		"switch physical order",
 		entity,
-		ruleSets[mainRS],
+		testRuleSet(mainRS),
 		ActionSet{},
 		want,
 	})
@@ -1323,7 +1337,7 @@ func testSwitchPhysicalOrder(tests *[]doMatchTest) {
 func testCycleError(t *testing.T) {
 	t.Log("Running cycle test")
 	setupRuleSetsForCycleError()
-	_, _, err := doMatch(sampleEntity, ruleSets[mainRS], ActionSet{}, map[string]bool{})
+	_, err := testEngine.Match(sampleEntity, mainRS)
 	if err == nil {
 		t.Errorf("test cycle: expected but did not get error")
 	}
@@ -1333,33 +1347,33 @@ func setupRuleSetsForCycleError() {
 	// main ruleset that contains a ThenCall to ruleset "second"
 	rule1 := Rule{
 		[]RulePatternTerm{
-			{"cat", opEQ, "textbook"},
+			{"cat", OpEQ, "textbook"},
 		},
 		RuleActions{
 			ThenCall: "second",
 		},
 	}
-	ruleSets[mainRS] = RuleSet{1, inventoryItemClass, mainRS,
-		[]Rule{rule1},
-	}
+	testEngine.AddRuleSet(RuleSet{Ver: 1, Class: inventoryItemClass, SetName: mainRS,
+		Rules: []Rule{rule1},
+	})
 
 	// "second" ruleset that contains a ThenCall to ruleset "third"
 	rule1 = Rule{
 		[]RulePatternTerm{
-			{"cat", opEQ, "textbook"},
+			{"cat", OpEQ, "textbook"},
 		},
 		RuleActions{
 			ThenCall: "third",
 		},
 	}
-	ruleSets["second"] = RuleSet{1, inventoryItemClass, "second",
-		[]Rule{rule1},
-	}
+	testEngine.AddRuleSet(RuleSet{Ver: 1, Class: inventoryItemClass, SetName: "second",
+		Rules: []Rule{rule1},
+	})
 
 	// "third" ruleset that contains a ThenCall back to ruleset "second"
 	rule1 = Rule{
 		[]RulePatternTerm{
-			{"cat", opEQ, "textbook"},
+			{"cat", OpEQ, "textbook"},
 		},
 		RuleActions{
 			Tasks: []string{"testtask"},
@@ -1367,13 +1381,13 @@ func setupRuleSetsForCycleError() {
 	}
 	rule2 := Rule{
 		[]RulePatternTerm{
-			{"cat", opEQ, "textbook"},
+			{"cat", OpEQ, "textbook"},
 		},
 		RuleActions{
 			ThenCall: "second",
 		},
 	}
-	ruleSets["third"] = RuleSet{1, inventoryItemClass, "third",
-		[]Rule{rule1, rule2},
-	}
+	testEngine.AddRuleSet(RuleSet{Ver: 1, Class: inventoryItemClass, SetName: "third",
+		Rules: []Rule{rule1, rule2},
+	})
 }
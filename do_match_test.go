@@ -1,6 +1,6 @@
 /* Sets up and runs all tests for doMatch() */
 
-package main
+package crux
 
 import (
 	"fmt"
@@ -8,6 +8,14 @@ import (
 	"testing"
 )
 
+var testEngine = NewEngine()
+
+// Returns the ruleset named setName from testEngine
+func testRuleSet(setName string) RuleSet {
+	rs, _ := testEngine.GetRuleSet(setName)
+	return rs
+}
+
 type doMatchTest struct {
 	name      string
 	entity    Entity
@@ -19,6 +27,8 @@ type doMatchTest struct {
 func TestDoMatch(t *testing.T) {
 	tests := []doMatchTest{}
 
+	setupInventoryItemSchema()
+
 	// Adds BRE-tests to the "tests" slice
 	testBasic(&tests)
 	testExit(&tests)
@@ -36,9 +46,11 @@ func TestDoMatch(t *testing.T) {
 	fmt.Printf("Running %v doMatch() tests\n", len(tests))
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got, _, _ := doMatch(tt.entity, tt.ruleSet, tt.actionSet, map[string]bool{})
+			// Several tests reuse the name of the "main" ruleset, so each test adds its own
+			testEngine.AddRuleSet(tt.ruleSet)
+			got, _ := testEngine.Match(tt.entity, tt.ruleSet.SetName)
 			if !reflect.DeepEqual(got, tt.want) {
-				t.Errorf("\n\ndoMatch() = %v, \n\nwant        %v\n\n", got, tt.want)
+				t.Errorf("\n\nMatch() = %v, \n\nwant        %v\n\n", got, tt.want)
 			}
 		})
 	}
@@ -6,7 +6,7 @@ Some of the definitions of rulesets below deliberately use a lot of whitespace t
 and to make it easier to understand, add to, and edit these tests
 */
 
-package main
+package crux
 
 const (
 	uccCreationClass = "ucccreation"
@@ -31,17 +31,21 @@ func testUCCCreation(tests *[]doMatchTest) {
 }
 
 func setupUCCCreationSchema() {
-	ruleSchemas = append(ruleSchemas, RuleSchema{
-		class: uccCreationClass,
-		patternSchema: []AttrSchema{
-			{name: step, valType: typeEnum},
-			{name: stepFailed, valType: typeBool},
-			{name: "mode", valType: typeEnum},
-		},
-		actionSchema: ActionSchema{
-			tasks: []string{"getcustdetails", "aof", "kycvalid", "nomauth", "bankaccvalid",
+	testEngine.AddRuleSchema(RuleSchema{
+		Class: uccCreationClass,
+		PatternSchema: []AttrSchema{
+			{Name: step, ValType: TypeEnum, Vals: map[string]bool{
+				start: true, "getcustdetails": true, "aof": true, "sendauthlinktoclient": true,
+				"downloadform": true, "printprefilledform": true, "signform": true, "receivesignedform": true,
+				"uploadsignedform": true, "sendaoftorta": true, "getresponsefromrta": true,
+			}},
+			{Name: stepFailed, ValType: TypeBool},
+			{Name: "mode", ValType: TypeEnum, Vals: map[string]bool{"physical": true, "demat": true}},
+		},
+		ActionSchema: ActionSchema{
+			Tasks: []string{"getcustdetails", "aof", "kycvalid", "nomauth", "bankaccvalid",
 				"dpandbankaccvalid", "sendauthlinktoclient"},
-			properties: []string{nextStep, done},
+			Properties: []string{nextStep, done},
 		},
 	})
 }
@@ -49,7 +53,7 @@ func setupUCCCreationSchema() {
 func setupUCCCreationRuleSet() {
 	rule1 := Rule{
 		[]RulePatternTerm{
-			{step, opEQ, start},
+			{step, OpEQ, start},
 		},
 		RuleActions{
 			Tasks:      []string{"getcustdetails"},
@@ -58,9 +62,9 @@ func setupUCCCreationRuleSet() {
 	}
 	rule2 := Rule{
 		[]RulePatternTerm{
-			{step, opEQ, "getcustdetails"},
-			{stepFailed, opEQ, false},
-			{"mode", opEQ, "physical"},
+			{step, OpEQ, "getcustdetails"},
+			{stepFailed, OpEQ, false},
+			{"mode", OpEQ, "physical"},
 		},
 		RuleActions{
 			Tasks:      []string{"aof", "kycvalid", "nomauth", "bankaccvalid"},
@@ -69,9 +73,9 @@ func setupUCCCreationRuleSet() {
 	}
 	rule3 := Rule{
 		[]RulePatternTerm{
-			{step, opEQ, "getcustdetails"},
-			{stepFailed, opEQ, false},
-			{"mode", opEQ, "demat"},
+			{step, OpEQ, "getcustdetails"},
+			{stepFailed, OpEQ, false},
+			{"mode", OpEQ, "demat"},
 		},
 		RuleActions{
 			Tasks:      []string{"aof", "kycvalid", "nomauth", "dpandbankaccvalid"},
@@ -80,8 +84,8 @@ func setupUCCCreationRuleSet() {
 	}
 	rule4 := Rule{
 		[]RulePatternTerm{
-			{step, opEQ, "getcustdetails"},
-			{stepFailed, opEQ, true},
+			{step, OpEQ, "getcustdetails"},
+			{stepFailed, OpEQ, true},
 		},
 		RuleActions{
 			Tasks:      []string{},
@@ -90,8 +94,8 @@ func setupUCCCreationRuleSet() {
 	}
 	rule5 := Rule{
 		[]RulePatternTerm{
-			{step, opEQ, "aof"},
-			{stepFailed, opEQ, false},
+			{step, OpEQ, "aof"},
+			{stepFailed, OpEQ, false},
 		},
 		RuleActions{
 			Tasks:      []string{"sendauthlinktoclient"},
@@ -100,8 +104,8 @@ func setupUCCCreationRuleSet() {
 	}
 	rule6 := Rule{
 		[]RulePatternTerm{
-			{step, opEQ, "aof"},
-			{stepFailed, opEQ, true},
+			{step, OpEQ, "aof"},
+			{stepFailed, OpEQ, true},
 		},
 		RuleActions{
 			Properties: []Property{{done, trueStr}},
@@ -109,15 +113,15 @@ func setupUCCCreationRuleSet() {
 	}
 	rule7 := Rule{
 		[]RulePatternTerm{
-			{step, opEQ, "sendauthlinktoclient"},
+			{step, OpEQ, "sendauthlinktoclient"},
 		},
 		RuleActions{
 			Properties: []Property{{done, trueStr}},
 		},
 	}
-	ruleSets["ucccreation"] = RuleSet{1, uccCreationClass, "ucccreation",
-		[]Rule{rule1, rule2, rule3, rule4, rule5, rule6, rule7},
-	}
+	testEngine.AddRuleSet(RuleSet{Ver: 1, Class: uccCreationClass, SetName: "ucccreation",
+		Rules: []Rule{rule1, rule2, rule3, rule4, rule5, rule6, rule7},
+	})
 }
 
 func testUCCStart(tests *[]doMatchTest) {
@@ -126,10 +130,10 @@ func testUCCStart(tests *[]doMatchTest) {
 		{"mode", "demat"},
 	}}
 	want := ActionSet{
-		tasks:      []string{"getcustdetails"},
-		properties: []Property{{nextStep, "getcustdetails"}},
+		Tasks:      []string{"getcustdetails"},
+		Properties: []Property{{nextStep, "getcustdetails"}},
 	}
-	*tests = append(*tests, doMatchTest{"ucc start", entity, ruleSets["ucccreation"], ActionSet{}, want})
+	*tests = append(*tests, doMatchTest{"ucc start", entity, testRuleSet("ucccreation"), ActionSet{}, want})
 }
 
 func testUCCGetCustDetailsDemat(tests *[]doMatchTest) {
@@ -139,10 +143,10 @@ func testUCCGetCustDetailsDemat(tests *[]doMatchTest) {
 		{"mode", "demat"},
 	}}
 	want := ActionSet{
-		tasks:      []string{"aof", "kycvalid", "nomauth", "dpandbankaccvalid"},
-		properties: []Property{{nextStep, "aof"}},
+		Tasks:      []string{"aof", "kycvalid", "nomauth", "dpandbankaccvalid"},
+		Properties: []Property{{nextStep, "aof"}},
 	}
-	*tests = append(*tests, doMatchTest{"ucc getcustdetails demat", entity, ruleSets["ucccreation"], ActionSet{}, want})
+	*tests = append(*tests, doMatchTest{"ucc getcustdetails demat", entity, testRuleSet("ucccreation"), ActionSet{}, want})
 }
 
 func testUCCGetCustDetailsDematFail(tests *[]doMatchTest) {
@@ -152,9 +156,9 @@ func testUCCGetCustDetailsDematFail(tests *[]doMatchTest) {
 		{"mode", "demat"},
 	}}
 	want := ActionSet{
-		properties: []Property{{done, trueStr}},
+		Properties: []Property{{done, trueStr}},
 	}
-	*tests = append(*tests, doMatchTest{"ucc getcustdetails demat fail", entity, ruleSets["ucccreation"], ActionSet{}, want})
+	*tests = append(*tests, doMatchTest{"ucc getcustdetails demat fail", entity, testRuleSet("ucccreation"), ActionSet{}, want})
 }
 
 func testUCCGetCustDetailsPhysical(tests *[]doMatchTest) {
@@ -164,10 +168,10 @@ func testUCCGetCustDetailsPhysical(tests *[]doMatchTest) {
 		{"mode", "physical"},
 	}}
 	want := ActionSet{
-		tasks:      []string{"aof", "kycvalid", "nomauth", "bankaccvalid"},
-		properties: []Property{{nextStep, "aof"}},
+		Tasks:      []string{"aof", "kycvalid", "nomauth", "bankaccvalid"},
+		Properties: []Property{{nextStep, "aof"}},
 	}
-	*tests = append(*tests, doMatchTest{"ucc getcustdetails physical", entity, ruleSets["ucccreation"], ActionSet{}, want})
+	*tests = append(*tests, doMatchTest{"ucc getcustdetails physical", entity, testRuleSet("ucccreation"), ActionSet{}, want})
 }
 
 func testUCCGetCustDetailsPhysicalFail(tests *[]doMatchTest) {
@@ -177,9 +181,9 @@ func testUCCGetCustDetailsPhysicalFail(tests *[]doMatchTest) {
 		{"mode", "physical"},
 	}}
 	want := ActionSet{
-		properties: []Property{{done, trueStr}},
+		Properties: []Property{{done, trueStr}},
 	}
-	*tests = append(*tests, doMatchTest{"ucc getcustdetails physical fail", entity, ruleSets["ucccreation"], ActionSet{}, want})
+	*tests = append(*tests, doMatchTest{"ucc getcustdetails physical fail", entity, testRuleSet("ucccreation"), ActionSet{}, want})
 }
 
 func testUCCAOF(tests *[]doMatchTest) {
@@ -189,10 +193,10 @@ func testUCCAOF(tests *[]doMatchTest) {
 		{"mode", "demat"},
 	}}
 	want := ActionSet{
-		tasks:      []string{"sendauthlinktoclient"},
-		properties: []Property{{nextStep, "sendauthlinktoclient"}},
+		Tasks:      []string{"sendauthlinktoclient"},
+		Properties: []Property{{nextStep, "sendauthlinktoclient"}},
 	}
-	*tests = append(*tests, doMatchTest{"ucc aof", entity, ruleSets["ucccreation"], ActionSet{}, want})
+	*tests = append(*tests, doMatchTest{"ucc aof", entity, testRuleSet("ucccreation"), ActionSet{}, want})
 }
 
 func testUCCAOFFail(tests *[]doMatchTest) {
@@ -202,9 +206,9 @@ func testUCCAOFFail(tests *[]doMatchTest) {
 		{"mode", "demat"},
 	}}
 	want := ActionSet{
-		properties: []Property{{done, trueStr}},
+		Properties: []Property{{done, trueStr}},
 	}
-	*tests = append(*tests, doMatchTest{"ucc aof fail", entity, ruleSets["ucccreation"], ActionSet{}, want})
+	*tests = append(*tests, doMatchTest{"ucc aof fail", entity, testRuleSet("ucccreation"), ActionSet{}, want})
 }
 
 func testUCCEndSuccess(tests *[]doMatchTest) {
@@ -214,9 +218,9 @@ func testUCCEndSuccess(tests *[]doMatchTest) {
 		{"mode", "demat"},
 	}}
 	want := ActionSet{
-		properties: []Property{{done, trueStr}},
+		Properties: []Property{{done, trueStr}},
 	}
-	*tests = append(*tests, doMatchTest{"ucc end-success", entity, ruleSets["ucccreation"], ActionSet{}, want})
+	*tests = append(*tests, doMatchTest{"ucc end-success", entity, testRuleSet("ucccreation"), ActionSet{}, want})
 }
 
 func testUCCEndFailure(tests *[]doMatchTest) {
@@ -226,17 +230,17 @@ func testUCCEndFailure(tests *[]doMatchTest) {
 		{"mode", "demat"},
 	}}
 	want := ActionSet{
-		properties: []Property{{done, trueStr}},
+		Properties: []Property{{done, trueStr}},
 	}
-	*tests = append(*tests, doMatchTest{"ucc end-failure", entity, ruleSets["ucccreation"], ActionSet{}, want})
+	*tests = append(*tests, doMatchTest{"ucc end-failure", entity, testRuleSet("ucccreation"), ActionSet{}, want})
 }
 
 func testPrepareAOF(tests *[]doMatchTest) {
-	ruleSchemas = append(ruleSchemas, RuleSchema{
-		class: prepareAOFClass,
-		patternSchema: []AttrSchema{
-			{name: step, valType: typeEnum},
-			{name: stepFailed, valType: typeBool},
+	testEngine.AddRuleSchema(RuleSchema{
+		Class: prepareAOFClass,
+		PatternSchema: []AttrSchema{
+			{Name: step, ValType: TypeEnum},
+			{Name: stepFailed, ValType: TypeBool},
 		},
 	})
 
@@ -258,10 +262,10 @@ func testDownloadAOF(tests *[]doMatchTest) {
 		{step, start},
 	}}
 	want := ActionSet{
-		tasks:      []string{"downloadform"},
-		properties: []Property{{nextStep, "downloadform"}},
+		Tasks:      []string{"downloadform"},
+		Properties: []Property{{nextStep, "downloadform"}},
 	}
-	*tests = append(*tests, doMatchTest{"download aof", entity, ruleSets["prepareaof"], ActionSet{}, want})
+	*tests = append(*tests, doMatchTest{"download aof", entity, testRuleSet("prepareaof"), ActionSet{}, want})
 }
 
 func testDownloadAOFFail(tests *[]doMatchTest) {
@@ -270,9 +274,9 @@ func testDownloadAOFFail(tests *[]doMatchTest) {
 		{stepFailed, trueStr},
 	}}
 	want := ActionSet{
-		properties: []Property{{done, trueStr}},
+		Properties: []Property{{done, trueStr}},
 	}
-	*tests = append(*tests, doMatchTest{"download aof fail", entity, ruleSets["prepareaof"], ActionSet{}, want})
+	*tests = append(*tests, doMatchTest{"download aof fail", entity, testRuleSet("prepareaof"), ActionSet{}, want})
 }
 
 func testPrintAOF(tests *[]doMatchTest) {
@@ -281,10 +285,10 @@ func testPrintAOF(tests *[]doMatchTest) {
 		{stepFailed, falseStr},
 	}}
 	want := ActionSet{
-		tasks:      []string{"printprefilledform"},
-		properties: []Property{{nextStep, "printprefilledform"}},
+		Tasks:      []string{"printprefilledform"},
+		Properties: []Property{{nextStep, "printprefilledform"}},
 	}
-	*tests = append(*tests, doMatchTest{"print prefilled aof", entity, ruleSets["prepareaof"], ActionSet{}, want})
+	*tests = append(*tests, doMatchTest{"print prefilled aof", entity, testRuleSet("prepareaof"), ActionSet{}, want})
 }
 
 func testSignAOF(tests *[]doMatchTest) {
@@ -293,10 +297,10 @@ func testSignAOF(tests *[]doMatchTest) {
 		{stepFailed, falseStr},
 	}}
 	want := ActionSet{
-		tasks:      []string{"signform"},
-		properties: []Property{{nextStep, "signform"}},
+		Tasks:      []string{"signform"},
+		Properties: []Property{{nextStep, "signform"}},
 	}
-	*tests = append(*tests, doMatchTest{"sign aof", entity, ruleSets["prepareaof"], ActionSet{}, want})
+	*tests = append(*tests, doMatchTest{"sign aof", entity, testRuleSet("prepareaof"), ActionSet{}, want})
 }
 
 func testSignAOFFail(tests *[]doMatchTest) {
@@ -305,9 +309,9 @@ func testSignAOFFail(tests *[]doMatchTest) {
 		{stepFailed, trueStr},
 	}}
 	want := ActionSet{
-		properties: []Property{{done, trueStr}},
+		Properties: []Property{{done, trueStr}},
 	}
-	*tests = append(*tests, doMatchTest{"sign aof fail", entity, ruleSets["prepareaof"], ActionSet{}, want})
+	*tests = append(*tests, doMatchTest{"sign aof fail", entity, testRuleSet("prepareaof"), ActionSet{}, want})
 }
 
 func testReceiveSignedAOF(tests *[]doMatchTest) {
@@ -316,10 +320,10 @@ func testReceiveSignedAOF(tests *[]doMatchTest) {
 		{stepFailed, falseStr},
 	}}
 	want := ActionSet{
-		tasks:      []string{"receivesignedform"},
-		properties: []Property{{nextStep, "receivesignedform"}},
+		Tasks:      []string{"receivesignedform"},
+		Properties: []Property{{nextStep, "receivesignedform"}},
 	}
-	*tests = append(*tests, doMatchTest{"receive signed aof", entity, ruleSets["prepareaof"], ActionSet{}, want})
+	*tests = append(*tests, doMatchTest{"receive signed aof", entity, testRuleSet("prepareaof"), ActionSet{}, want})
 }
 
 func testUploadAOF(tests *[]doMatchTest) {
@@ -328,10 +332,10 @@ func testUploadAOF(tests *[]doMatchTest) {
 		{stepFailed, falseStr},
 	}}
 	want := ActionSet{
-		tasks:      []string{"uploadsignedform"},
-		properties: []Property{{nextStep, "uploadsignedform"}},
+		Tasks:      []string{"uploadsignedform"},
+		Properties: []Property{{nextStep, "uploadsignedform"}},
 	}
-	*tests = append(*tests, doMatchTest{"upload signed aof", entity, ruleSets["prepareaof"], ActionSet{}, want})
+	*tests = append(*tests, doMatchTest{"upload signed aof", entity, testRuleSet("prepareaof"), ActionSet{}, want})
 }
 
 func testPrepareAOFEnd(tests *[]doMatchTest) {
@@ -340,15 +344,15 @@ func testPrepareAOFEnd(tests *[]doMatchTest) {
 		{stepFailed, falseStr},
 	}}
 	want := ActionSet{
-		properties: []Property{{done, trueStr}},
+		Properties: []Property{{done, trueStr}},
 	}
-	*tests = append(*tests, doMatchTest{"prepare aof end", entity, ruleSets["prepareaof"], ActionSet{}, want})
+	*tests = append(*tests, doMatchTest{"prepare aof end", entity, testRuleSet("prepareaof"), ActionSet{}, want})
 }
 
 func setupRuleSetForPrepareAOF() {
 	rule1 := Rule{
 		[]RulePatternTerm{
-			{step, opEQ, start},
+			{step, OpEQ, start},
 		},
 		RuleActions{
 			Tasks:      []string{"downloadform"},
@@ -357,8 +361,8 @@ func setupRuleSetForPrepareAOF() {
 	}
 	rule2 := Rule{
 		[]RulePatternTerm{
-			{step, opEQ, "downloadform"},
-			{stepFailed, opEQ, false},
+			{step, OpEQ, "downloadform"},
+			{stepFailed, OpEQ, false},
 		},
 		RuleActions{
 			Tasks:      []string{"printprefilledform"},
@@ -367,8 +371,8 @@ func setupRuleSetForPrepareAOF() {
 	}
 	rule2F := Rule{
 		[]RulePatternTerm{
-			{step, opEQ, "downloadform"},
-			{stepFailed, opEQ, true},
+			{step, OpEQ, "downloadform"},
+			{stepFailed, OpEQ, true},
 		},
 		RuleActions{
 			Properties: []Property{{done, trueStr}},
@@ -376,8 +380,8 @@ func setupRuleSetForPrepareAOF() {
 	}
 	rule3 := Rule{
 		[]RulePatternTerm{
-			{step, opEQ, "printprefilledform"},
-			{stepFailed, opEQ, false},
+			{step, OpEQ, "printprefilledform"},
+			{stepFailed, OpEQ, false},
 		},
 		RuleActions{
 			Tasks:      []string{"signform"},
@@ -386,8 +390,8 @@ func setupRuleSetForPrepareAOF() {
 	}
 	rule3F := Rule{
 		[]RulePatternTerm{
-			{step, opEQ, "printprefilledform"},
-			{stepFailed, opEQ, true},
+			{step, OpEQ, "printprefilledform"},
+			{stepFailed, OpEQ, true},
 		},
 		RuleActions{
 			Properties: []Property{{done, trueStr}},
@@ -395,8 +399,8 @@ func setupRuleSetForPrepareAOF() {
 	}
 	rule4 := Rule{
 		[]RulePatternTerm{
-			{step, opEQ, "signform"},
-			{stepFailed, opEQ, false},
+			{step, OpEQ, "signform"},
+			{stepFailed, OpEQ, false},
 		},
 		RuleActions{
 			Tasks:      []string{"receivesignedform"},
@@ -405,8 +409,8 @@ func setupRuleSetForPrepareAOF() {
 	}
 	rule4F := Rule{
 		[]RulePatternTerm{
-			{step, opEQ, "signform"},
-			{stepFailed, opEQ, true},
+			{step, OpEQ, "signform"},
+			{stepFailed, OpEQ, true},
 		},
 		RuleActions{
 			Properties: []Property{{done, trueStr}},
@@ -414,8 +418,8 @@ func setupRuleSetForPrepareAOF() {
 	}
 	rule5 := Rule{
 		[]RulePatternTerm{
-			{step, opEQ, "receivesignedform"},
-			{stepFailed, opEQ, false},
+			{step, OpEQ, "receivesignedform"},
+			{stepFailed, OpEQ, false},
 		},
 		RuleActions{
 			Tasks:      []string{"uploadsignedform"},
@@ -424,8 +428,8 @@ func setupRuleSetForPrepareAOF() {
 	}
 	rule5F := Rule{
 		[]RulePatternTerm{
-			{step, opEQ, "receivesignedform"},
-			{stepFailed, opEQ, true},
+			{step, OpEQ, "receivesignedform"},
+			{stepFailed, OpEQ, true},
 		},
 		RuleActions{
 			Properties: []Property{{done, trueStr}},
@@ -433,25 +437,25 @@ func setupRuleSetForPrepareAOF() {
 	}
 	rule6 := Rule{
 		[]RulePatternTerm{
-			{step, opEQ, "uploadsignedform"},
+			{step, OpEQ, "uploadsignedform"},
 		},
 		RuleActions{
 			Tasks:      []string{},
 			Properties: []Property{{done, trueStr}},
 		},
 	}
-	ruleSets["prepareaof"] = RuleSet{1, prepareAOFClass, "prepareaof",
-		[]Rule{rule1, rule2, rule2F, rule3, rule3F, rule4, rule4F, rule5, rule5F, rule6},
-	}
+	testEngine.AddRuleSet(RuleSet{Ver: 1, Class: prepareAOFClass, SetName: "prepareaof",
+		Rules: []Rule{rule1, rule2, rule2F, rule3, rule3F, rule4, rule4F, rule5, rule5F, rule6},
+	})
 }
 
 func testValidateAOF(tests *[]doMatchTest) {
-	ruleSchemas = append(ruleSchemas, RuleSchema{
-		class: validateAOFClass,
-		patternSchema: []AttrSchema{
-			{name: step, valType: typeEnum},
-			{name: stepFailed, valType: typeBool},
-			{name: "aofexists", valType: typeBool},
+	testEngine.AddRuleSchema(RuleSchema{
+		Class: validateAOFClass,
+		PatternSchema: []AttrSchema{
+			{Name: step, ValType: TypeEnum},
+			{Name: stepFailed, ValType: TypeBool},
+			{Name: "aofexists", ValType: TypeBool},
 		},
 	})
 
@@ -471,9 +475,9 @@ func testValidateExistingAOF(tests *[]doMatchTest) {
 		{"aofexists", trueStr},
 	}}
 	want := ActionSet{
-		properties: []Property{{done, trueStr}},
+		Properties: []Property{{done, trueStr}},
 	}
-	*tests = append(*tests, doMatchTest{"validate existing aof", entity, ruleSets["validateaof"], ActionSet{}, want})
+	*tests = append(*tests, doMatchTest{"validate existing aof", entity, testRuleSet("validateaof"), ActionSet{}, want})
 }
 
 func testValidateAOFStart(tests *[]doMatchTest) {
@@ -482,10 +486,10 @@ func testValidateAOFStart(tests *[]doMatchTest) {
 		{"aofexists", falseStr},
 	}}
 	want := ActionSet{
-		tasks:      []string{"sendaoftorta"},
-		properties: []Property{{nextStep, "sendaoftorta"}},
+		Tasks:      []string{"sendaoftorta"},
+		Properties: []Property{{nextStep, "sendaoftorta"}},
 	}
-	*tests = append(*tests, doMatchTest{"send aof to rta", entity, ruleSets["validateaof"], ActionSet{}, want})
+	*tests = append(*tests, doMatchTest{"send aof to rta", entity, testRuleSet("validateaof"), ActionSet{}, want})
 }
 
 func testSendAOFToRTAFail(tests *[]doMatchTest) {
@@ -495,9 +499,9 @@ func testSendAOFToRTAFail(tests *[]doMatchTest) {
 		{"aofexists", falseStr},
 	}}
 	want := ActionSet{
-		properties: []Property{{done, trueStr}},
+		Properties: []Property{{done, trueStr}},
 	}
-	*tests = append(*tests, doMatchTest{"send aof to rta fail", entity, ruleSets["validateaof"], ActionSet{}, want})
+	*tests = append(*tests, doMatchTest{"send aof to rta fail", entity, testRuleSet("validateaof"), ActionSet{}, want})
 }
 
 func testAOFGetResponseFromRTA(tests *[]doMatchTest) {
@@ -507,10 +511,10 @@ func testAOFGetResponseFromRTA(tests *[]doMatchTest) {
 		{"aofexists", falseStr},
 	}}
 	want := ActionSet{
-		tasks:      []string{"getresponsefromrta"},
-		properties: []Property{{nextStep, "getresponsefromrta"}},
+		Tasks:      []string{"getresponsefromrta"},
+		Properties: []Property{{nextStep, "getresponsefromrta"}},
 	}
-	*tests = append(*tests, doMatchTest{"aof - get response from rta", entity, ruleSets["validateaof"], ActionSet{}, want})
+	*tests = append(*tests, doMatchTest{"aof - get response from rta", entity, testRuleSet("validateaof"), ActionSet{}, want})
 }
 
 func testValidateAOFEnd(tests *[]doMatchTest) {
@@ -520,16 +524,16 @@ func testValidateAOFEnd(tests *[]doMatchTest) {
 		{"aofexists", falseStr},
 	}}
 	want := ActionSet{
-		properties: []Property{{done, trueStr}},
+		Properties: []Property{{done, trueStr}},
 	}
-	*tests = append(*tests, doMatchTest{"validate aof end", entity, ruleSets["validateaof"], ActionSet{}, want})
+	*tests = append(*tests, doMatchTest{"validate aof end", entity, testRuleSet("validateaof"), ActionSet{}, want})
 }
 
 func setupRuleSetForValidateAOF() {
 	rule1 := Rule{
 		[]RulePatternTerm{
-			{step, opEQ, start},
-			{"aofexists", opEQ, true},
+			{step, OpEQ, start},
+			{"aofexists", OpEQ, true},
 		},
 		RuleActions{
 			Properties: []Property{{done, trueStr}},
@@ -537,8 +541,8 @@ func setupRuleSetForValidateAOF() {
 	}
 	rule2 := Rule{
 		[]RulePatternTerm{
-			{step, opEQ, start},
-			{"aofexists", opEQ, false},
+			{step, OpEQ, start},
+			{"aofexists", OpEQ, false},
 		},
 		RuleActions{
 			Tasks:      []string{"sendaoftorta"},
@@ -547,9 +551,9 @@ func setupRuleSetForValidateAOF() {
 	}
 	rule3 := Rule{
 		[]RulePatternTerm{
-			{step, opEQ, "sendaoftorta"},
-			{stepFailed, opEQ, false},
-			{"aofexists", opEQ, false},
+			{step, OpEQ, "sendaoftorta"},
+			{stepFailed, OpEQ, false},
+			{"aofexists", OpEQ, false},
 		},
 		RuleActions{
 			Tasks:      []string{"getresponsefromrta"},
@@ -558,9 +562,9 @@ func setupRuleSetForValidateAOF() {
 	}
 	rule3F := Rule{
 		[]RulePatternTerm{
-			{step, opEQ, "sendaoftorta"},
-			{stepFailed, opEQ, true},
-			{"aofexists", opEQ, false},
+			{step, OpEQ, "sendaoftorta"},
+			{stepFailed, OpEQ, true},
+			{"aofexists", OpEQ, false},
 		},
 		RuleActions{
 			Tasks:      []string{},
@@ -569,14 +573,14 @@ func setupRuleSetForValidateAOF() {
 	}
 	rule4 := Rule{
 		[]RulePatternTerm{
-			{step, opEQ, "getresponsefromrta"},
-			{"aofexists", opEQ, false},
+			{step, OpEQ, "getresponsefromrta"},
+			{"aofexists", OpEQ, false},
 		},
 		RuleActions{
 			Properties: []Property{{done, trueStr}},
 		},
 	}
-	ruleSets["validateaof"] = RuleSet{1, validateAOFClass, "validateaof",
-		[]Rule{rule1, rule2, rule3, rule3F, rule4},
-	}
+	testEngine.AddRuleSet(RuleSet{Ver: 1, Class: validateAOFClass, SetName: "validateaof",
+		Rules: []Rule{rule1, rule2, rule3, rule3F, rule4},
+	})
 }
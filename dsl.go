@@ -0,0 +1,771 @@
+/*
+This file contains a parser for a textual language in which rulesets can be written, and
+FormatRuleSetDSL(), which prints any RuleSet in that language. For example:
+
+	# Discounts during the winter sale
+	ruleset winterdisc class transaction ver 1:
+	    when productname eq "jacket" and price gt 50
+	    then tasks(freehat) set(discount=50) return
+	    when price lt 100
+	    then set(discount=40, pointsmult=2) thencall memberdisc
+
+A ruleset is a header followed by rules. "ver" is optional and defaults to 1. The header may end
+with "missing unknown", "missing nomatch" or "missing error", which sets the ruleset's
+missing-attribute policy. Each rule is an optional "when" clause, whose terms are joined by
+"and", followed by a "then" clause. A rule without a "when" clause matches every entity. The
+actions in a "then" clause are tasks(...), set(name=value, ...), thencall <ruleset>, elsecall
+<ruleset>, return and exit, in any order.
+
+A term is <attribute> <op> <value>, or for the operators in and nin, <attribute> <op> (<value>,
+...), or for exists and notexists, just <attribute> <op>. In place of a value, attr(<attribute>)
+compares the term's attribute with another attribute of the entity. Either side of a term may
+instead be an arithmetic expression (see expr.go):
+
+	when ageinstock + 30 ge 90 and mrp * qty gt 10000
+
+An expression that is a single name or number is written in parentheses. The durations of within
+and olderthan are quoted strings, and the days and months of dayofweek and month are lists,
+which may be written without quotes:
+
+	when received olderthan "30d" and received dayofweek (sat, sun) and received hourin (22, 6)
+
+A term on a list attribute is <attribute> hasany (<element>, ...), and likewise for hasall and
+hasnone, or <attribute> count <op> <number>. Enum elements may be written without quotes (see
+list_ops.go):
+
+	when tags hasany (gold, platinum) and tags hasnone (blocked) and holdings count ge 3
+
+Terms may be grouped as any(<term>, ...), all(<term>, ...) and not(<term>, ...), which nest:
+
+	when cat eq textbook and any(mrp lt 20, not(bulkorder eq true, ageinstock gt 30))
+
+Values are written as in Go: "quoted strings", numbers, true and false. Enum values may also be
+written without quotes. Timestamps and dates are quoted strings in RFC 3339 or "2006-01-02".
+Decimals are numbers, which are read exactly, or quoted strings with a currency code, such as
+"INR 50.80". Property values in set(...) may be names, numbers or quoted strings. Everything
+from # to the end of a line is a comment.
+*/
+
+package crux
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+const (
+	tokIdent = iota
+	tokNumber
+	tokString
+	tokPunct
+	tokEOF
+)
+
+type dslToken struct {
+	kind int
+	text string
+	line int
+	col  int
+}
+
+// DSLError is an error found at a position in the source text of a ruleset
+type DSLError struct {
+	Line int
+	Col  int
+	Msg  string
+}
+
+func (e DSLError) Error() string {
+	return fmt.Sprintf("line %v, column %v: %v", e.Line, e.Col, e.Msg)
+}
+
+// DSLErrors is returned when verification finds errors in more than one rule
+type DSLErrors []DSLError
+
+func (errs DSLErrors) Error() string {
+	msgs := make([]string, len(errs))
+	for i, e := range errs {
+		msgs[i] = e.Error()
+	}
+	return strings.Join(msgs, "\n")
+}
+
+// Splits src into tokens
+func lexDSL(src string) ([]dslToken, error) {
+	var tokens []dslToken
+	runes := []rune(src)
+	line, col := 1, 1
+	advance := func(n int) {
+		for ; n > 0; n-- {
+			if runes[0] == '\n' {
+				line, col = line+1, 1
+			} else {
+				col++
+			}
+			runes = runes[1:]
+		}
+	}
+	for len(runes) > 0 {
+		r := runes[0]
+		tok := dslToken{line: line, col: col}
+		n := 0
+		switch {
+		case unicode.IsSpace(r):
+			advance(1)
+			continue
+		case r == '#':
+			for n < len(runes) && runes[n] != '\n' {
+				n++
+			}
+			advance(n)
+			continue
+		case r == '_' || unicode.IsLetter(r):
+			for n < len(runes) && (runes[n] == '_' || unicode.IsLetter(runes[n]) || unicode.IsDigit(runes[n])) {
+				n++
+			}
+			tok.kind = tokIdent
+		case unicode.IsDigit(r) || (r == '-' && len(runes) > 1 && unicode.IsDigit(runes[1])):
+			n = 1
+			for n < len(runes) && strings.ContainsRune("0123456789.eE", runes[n]) ||
+				n < len(runes) && (runes[n] == '-' || runes[n] == '+') && (runes[n-1] == 'e' || runes[n-1] == 'E') {
+				n++
+			}
+			tok.kind = tokNumber
+			if !json.Valid([]byte(string(runes[:n]))) {
+				return nil, DSLError{line, col, fmt.Sprintf("invalid number %v", string(runes[:n]))}
+			}
+		case r == '"':
+			n = 1
+			for n < len(runes) && runes[n] != '"' && runes[n] != '\n' {
+				if runes[n] == '\\' {
+					n++
+				}
+				n++
+			}
+			if n >= len(runes) || runes[n] != '"' {
+				return nil, DSLError{line, col, "unterminated string"}
+			}
+			n++
+			tok.kind = tokString
+		case strings.ContainsRune("(),=:+-*/", r):
+			n = 1
+			tok.kind = tokPunct
+		default:
+			return nil, DSLError{line, col, fmt.Sprintf("unexpected character %q", r)}
+		}
+		tok.text = string(runes[:n])
+		advance(n)
+		tokens = append(tokens, tok)
+	}
+	tokens = append(tokens, dslToken{kind: tokEOF, line: line, col: col})
+	return tokens, nil
+}
+
+type dslParser struct {
+	tokens []dslToken
+	pos    int
+	schema RuleSchema
+	// Position of the "when" (or "then") that starts each rule, for diagnostics
+	rulePos []dslToken
+}
+
+func (p *dslParser) peek() dslToken {
+	return p.tokens[p.pos]
+}
+
+func (p *dslParser) next() dslToken {
+	tok := p.tokens[p.pos]
+	if tok.kind != tokEOF {
+		p.pos++
+	}
+	return tok
+}
+
+func (p *dslParser) errorAt(tok dslToken, format string, args ...any) error {
+	return DSLError{tok.line, tok.col, fmt.Sprintf(format, args...)}
+}
+
+func (p *dslParser) isKeyword(word string) bool {
+	tok := p.peek()
+	return tok.kind == tokIdent && tok.text == word
+}
+
+func (p *dslParser) expectKeyword(word string) error {
+	tok := p.next()
+	if tok.kind != tokIdent || tok.text != word {
+		return p.errorAt(tok, "expected %q, found %q", word, tok.text)
+	}
+	return nil
+}
+
+func (p *dslParser) expectPunct(punct string) error {
+	tok := p.next()
+	if tok.kind != tokPunct || tok.text != punct {
+		return p.errorAt(tok, "expected %q, found %q", punct, tok.text)
+	}
+	return nil
+}
+
+func (p *dslParser) expectIdent(what string) (string, error) {
+	tok := p.next()
+	if tok.kind != tokIdent {
+		return "", p.errorAt(tok, "expected %v, found %q", what, tok.text)
+	}
+	return tok.text, nil
+}
+
+func (p *dslParser) parseRuleSet() (RuleSet, error) {
+	rs := RuleSet{Ver: 1}
+	var err error
+	if err = p.expectKeyword("ruleset"); err != nil {
+		return RuleSet{}, err
+	}
+	if rs.SetName, err = p.expectIdent("ruleset name"); err != nil {
+		return RuleSet{}, err
+	}
+	if err = p.expectKeyword("class"); err != nil {
+		return RuleSet{}, err
+	}
+	classTok := p.peek()
+	if rs.Class, err = p.expectIdent("class name"); err != nil {
+		return RuleSet{}, err
+	}
+	if rs.Class != p.schema.Class {
+		return RuleSet{}, p.errorAt(classTok, "no schema found for class %v", rs.Class)
+	}
+	if p.isKeyword("ver") {
+		p.next()
+		tok := p.next()
+		if rs.Ver, err = strconv.Atoi(tok.text); tok.kind != tokNumber || err != nil {
+			return RuleSet{}, p.errorAt(tok, "expected version number, found %q", tok.text)
+		}
+	}
+	if p.isKeyword("missing") {
+		p.next()
+		tok := p.next()
+		if rs.MissingAttrs = tok.text; tok.kind != tokIdent || !missingPolicies[tok.text] {
+			return RuleSet{}, p.errorAt(tok, "expected unknown, nomatch or error, found %q", tok.text)
+		}
+	}
+	if err = p.expectPunct(":"); err != nil {
+		return RuleSet{}, err
+	}
+	for p.peek().kind != tokEOF {
+		rule, err := p.parseRule()
+		if err != nil {
+			return RuleSet{}, err
+		}
+		rs.Rules = append(rs.Rules, rule)
+	}
+	return rs, nil
+}
+
+func (p *dslParser) parseRule() (Rule, error) {
+	rule := Rule{RulePattern: []RulePatternTerm{}}
+	p.rulePos = append(p.rulePos, p.peek())
+	if p.isKeyword("when") {
+		p.next()
+		for {
+			term, err := p.parseTerm()
+			if err != nil {
+				return Rule{}, err
+			}
+			rule.RulePattern = append(rule.RulePattern, term)
+			if !p.isKeyword("and") {
+				break
+			}
+			p.next()
+		}
+	}
+	if err := p.expectKeyword("then"); err != nil {
+		return Rule{}, err
+	}
+	for p.peek().kind != tokEOF && !p.isKeyword("when") && !p.isKeyword("then") {
+		if err := p.parseAction(&rule.RuleActions); err != nil {
+			return Rule{}, err
+		}
+	}
+	return rule, nil
+}
+
+func (p *dslParser) parseTerm() (RulePatternTerm, error) {
+	if tok := p.peek(); tok.kind == tokIdent && groupOps[tok.text] &&
+		p.tokens[p.pos+1].kind == tokPunct && p.tokens[p.pos+1].text == "(" {
+		return p.parseGroup()
+	}
+	if tok := p.peek(); tok.kind != tokIdent || p.tokens[p.pos+1].kind != tokIdent {
+		return p.parseArith()
+	}
+	attrTok := p.peek()
+	attrName, err := p.expectIdent("attribute name")
+	if err != nil {
+		return RulePatternTerm{}, err
+	}
+	op, err := p.expectIdent("operator")
+	if err != nil {
+		return RulePatternTerm{}, err
+	}
+	valType := getTermType(p.schema, attrName)
+	if valType == "" {
+		return RulePatternTerm{}, p.errorAt(attrTok, "attribute does not exist in schema: %v", attrName)
+	}
+	if op == countPrefix && valType == TypeList {
+		countOp, err := p.expectIdent("operator")
+		if err != nil {
+			return RulePatternTerm{}, err
+		}
+		op = countPrefix + countOp
+	}
+	if op == OpExists || op == OpNotExists {
+		return RulePatternTerm{AttrName: attrName, Op: op}, nil
+	}
+	if p.isKeyword("attr") && p.tokens[p.pos+1].kind == tokPunct && p.tokens[p.pos+1].text == "(" {
+		p.next()
+		p.next()
+		ref, err := p.expectIdent("attribute name")
+		if err != nil {
+			return RulePatternTerm{}, err
+		}
+		if err := p.expectPunct(")"); err != nil {
+			return RulePatternTerm{}, err
+		}
+		return RulePatternTerm{attrName, op, AttrRef{ref}}, nil
+	}
+	if timeOps[op] && valType == TypeTS {
+		return p.parseTimeTerm(attrName, op)
+	}
+	if listOps[op] && valType == TypeList {
+		return p.parseListTerm(attrName, op)
+	}
+	if !setOps[op] && p.isExprStart() {
+		right, err := p.parseExpr()
+		if err != nil {
+			return RulePatternTerm{}, err
+		}
+		return RulePatternTerm{attrName, op, Expr{right.String()}}, nil
+	}
+	if setOps[op] {
+		list, err := p.parseList(attrName, valType)
+		if err != nil {
+			return RulePatternTerm{}, err
+		}
+		return RulePatternTerm{attrName, op, list}, nil
+	}
+	valTok := p.next()
+	val, err := p.literalValue(valTok, valType)
+	if err != nil {
+		return RulePatternTerm{}, p.errorAt(valTok, "invalid value for %v: %v", attrName, err)
+	}
+	return RulePatternTerm{attrName, op, val}, nil
+}
+
+// Parses the value of a term on the ts attribute attrName with op, one of timeOps: a duration in
+// a string, or a list of days, months or hours, where days and months may be written without
+// quotes
+func (p *dslParser) parseTimeTerm(attrName string, op string) (RulePatternTerm, error) {
+	if calendarOps[op] {
+		elemType := TypeEnum
+		if timeOpValType(op) == TypeInt {
+			elemType = TypeInt
+		}
+		list, err := p.parseList(attrName, elemType)
+		if err != nil {
+			return RulePatternTerm{}, err
+		}
+		return RulePatternTerm{attrName, op, list}, nil
+	}
+	valTok := p.next()
+	val, err := p.literalValue(valTok, TypeStr)
+	if err != nil {
+		return RulePatternTerm{}, p.errorAt(valTok, "invalid value for %v: %v", attrName, err)
+	}
+	return RulePatternTerm{attrName, op, val}, nil
+}
+
+// Parses the value of a term on the list attribute attrName with op, one of listOps: a list of
+// elements, where enum elements may be written without quotes, or for the count operators, a
+// number
+func (p *dslParser) parseListTerm(attrName string, op string) (RulePatternTerm, error) {
+	valType := listOpValType(op, getAttrSchema(p.schema, attrName).ElemType)
+	if hasOps[op] {
+		list, err := p.parseList(attrName, valType)
+		if err != nil {
+			return RulePatternTerm{}, err
+		}
+		return RulePatternTerm{attrName, op, list}, nil
+	}
+	valTok := p.next()
+	val, err := p.literalValue(valTok, valType)
+	if err != nil {
+		return RulePatternTerm{}, p.errorAt(valTok, "invalid value for %v: %v", attrName, err)
+	}
+	return RulePatternTerm{attrName, op, val}, nil
+}
+
+// Parses a term that compares two expressions: <expression> <op> <expression>
+func (p *dslParser) parseArith() (RulePatternTerm, error) {
+	left, err := p.parseExpr()
+	if err != nil {
+		return RulePatternTerm{}, err
+	}
+	op, err := p.expectIdent("operator")
+	if err != nil {
+		return RulePatternTerm{}, err
+	}
+	right, err := p.parseExpr()
+	if err != nil {
+		return RulePatternTerm{}, err
+	}
+	return RulePatternTerm{Op: op, AttrVal: Arith{left.String(), right.String()}}, nil
+}
+
+// Returns whether the value of a term starts at the next token with an expression, rather than a
+// literal: a parenthesis, a unary minus, days(...) or hours(...), or a number or name followed by
+// an arithmetic operator
+func (p *dslParser) isExprStart() bool {
+	tok := p.peek()
+	if tok.kind == tokEOF || p.pos+1 >= len(p.tokens) {
+		return false
+	}
+	after := p.tokens[p.pos+1]
+	switch {
+	case tok.kind == tokPunct:
+		return tok.text == "(" || tok.text == "-"
+	case tok.kind == tokIdent && exprFuncs[tok.text] > 0 && after.kind == tokPunct && after.text == "(":
+		return true
+	case tok.kind != tokIdent && tok.kind != tokNumber:
+		return false
+	}
+	return after.kind == tokPunct && exprPrec[after.text] > 0 ||
+		after.kind == tokNumber && strings.HasPrefix(after.text, "-")
+}
+
+// Parses an expression starting at the next token
+func (p *dslParser) parseExpr() (*exprNode, error) {
+	ep := exprParser{tokens: p.tokens, pos: p.pos}
+	n, err := ep.parse()
+	p.pos = ep.pos
+	return n, err
+}
+
+// Parses an any, all or not group: op(term, term, ...)
+func (p *dslParser) parseGroup() (RulePatternTerm, error) {
+	op := p.next().text
+	p.next()
+	var terms []RulePatternTerm
+	for {
+		term, err := p.parseTerm()
+		if err != nil {
+			return RulePatternTerm{}, err
+		}
+		terms = append(terms, term)
+		if p.peek().kind != tokPunct || p.peek().text != "," {
+			break
+		}
+		p.next()
+	}
+	if err := p.expectPunct(")"); err != nil {
+		return RulePatternTerm{}, err
+	}
+	return RulePatternTerm{Op: op, AttrVal: terms}, nil
+}
+
+// Parses the list of values of an in or nin term on attrName: (value, value, ...)
+func (p *dslParser) parseList(attrName string, valType string) ([]any, error) {
+	if err := p.expectPunct("("); err != nil {
+		return nil, err
+	}
+	var list []any
+	for {
+		valTok := p.next()
+		val, err := p.literalValue(valTok, valType)
+		if err != nil {
+			return nil, p.errorAt(valTok, "invalid value for %v: %v", attrName, err)
+		}
+		list = append(list, val)
+		if p.peek().kind != tokPunct || p.peek().text != "," {
+			break
+		}
+		p.next()
+	}
+	if err := p.expectPunct(")"); err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+// Converts the literal in tok to the Go type for valType
+func (p *dslParser) literalValue(tok dslToken, valType string) (any, error) {
+	var raw any
+	switch tok.kind {
+	case tokNumber:
+		raw = json.Number(tok.text)
+	case tokString:
+		s, err := strconv.Unquote(tok.text)
+		if err != nil {
+			return nil, err
+		}
+		raw = s
+	case tokIdent:
+		if tok.text == trueStr || tok.text == falseStr {
+			raw = tok.text == trueStr
+		} else if valType == TypeEnum {
+			raw = tok.text
+		}
+	}
+	if raw == nil {
+		return nil, fmt.Errorf("expected a value, found %q", tok.text)
+	}
+	return decodeAttrVal(raw, valType)
+}
+
+func (p *dslParser) parseAction(ra *RuleActions) error {
+	tok := p.next()
+	if tok.kind != tokIdent {
+		return p.errorAt(tok, "expected an action, found %q", tok.text)
+	}
+	var err error
+	switch tok.text {
+	case "tasks":
+		if err = p.expectPunct("("); err != nil {
+			return err
+		}
+		for {
+			var task string
+			if task, err = p.expectIdent("task name"); err != nil {
+				return err
+			}
+			ra.Tasks = append(ra.Tasks, task)
+			if p.peek().text != "," {
+				break
+			}
+			p.next()
+		}
+		return p.expectPunct(")")
+	case "set":
+		if err = p.expectPunct("("); err != nil {
+			return err
+		}
+		for {
+			var prop Property
+			if prop.Name, err = p.expectIdent("property name"); err != nil {
+				return err
+			}
+			if err = p.expectPunct("="); err != nil {
+				return err
+			}
+			valTok := p.next()
+			switch valTok.kind {
+			case tokIdent, tokNumber:
+				prop.Val = valTok.text
+			case tokString:
+				if prop.Val, err = strconv.Unquote(valTok.text); err != nil {
+					return p.errorAt(valTok, "invalid string %v", valTok.text)
+				}
+			default:
+				return p.errorAt(valTok, "expected a property value, found %q", valTok.text)
+			}
+			ra.Properties = append(ra.Properties, prop)
+			if p.peek().text != "," {
+				break
+			}
+			p.next()
+		}
+		return p.expectPunct(")")
+	case "thencall":
+		ra.ThenCall, err = p.expectIdent("ruleset name")
+	case "elsecall":
+		ra.ElseCall, err = p.expectIdent("ruleset name")
+	case "return":
+		ra.WillReturn = true
+	case "exit":
+		ra.WillExit = true
+	default:
+		return p.errorAt(tok, "expected an action, found %q", tok.text)
+	}
+	return err
+}
+
+// Parses src without verifying the result. Also returns the position at which each rule starts.
+func parseRuleSetDSL(src string, schema RuleSchema) (RuleSet, []dslToken, error) {
+	tokens, err := lexDSL(src)
+	if err != nil {
+		return RuleSet{}, nil, err
+	}
+	p := dslParser{tokens: tokens, schema: schema}
+	rs, err := p.parseRuleSet()
+	if err != nil {
+		return RuleSet{}, nil, err
+	}
+	return rs, p.rulePos, nil
+}
+
+// ParseRuleSetDSL parses src, converting the values in rule-pattern terms according to schema,
+// which must be the schema for the ruleset's class, and then verifies the ruleset. isWF is true
+// if the ruleset is a workflow. Errors are of type DSLError or DSLErrors. Calls to other
+// rulesets are not followed; VerifyRuleSet() and VerifyRuleSets() check them.
+func ParseRuleSetDSL(src string, schema RuleSchema, isWF bool) (RuleSet, error) {
+	rs, rulePos, err := parseRuleSetDSL(src, schema)
+	if err != nil {
+		return RuleSet{}, err
+	}
+
+	// Verify the rules one at a time, so that each error can be reported at the rule it is in
+	st := &ruleStore{schemas: map[string]RuleSchema{schema.Class: schema}}
+	var errs DSLErrors
+	for i, rule := range rs.Rules {
+		single := rs
+		single.Rules = []Rule{rule}
+		if _, err := st.verifyRules(single, isWF); err != nil {
+			errs = append(errs, DSLError{rulePos[i].line, rulePos[i].col, err.Error()})
+		}
+	}
+	if len(errs) == 1 {
+		return RuleSet{}, errs[0]
+	} else if len(errs) > 1 {
+		return RuleSet{}, errs
+	}
+	return rs, nil
+}
+
+// ParseRuleSetDSL parses and verifies src using the engine's schema for the ruleset's class
+func (e *Engine) ParseRuleSetDSL(src string, isWF bool) (RuleSet, error) {
+	tokens, err := lexDSL(src)
+	if err != nil {
+		return RuleSet{}, err
+	}
+	// The class name is the fourth token: ruleset <name> class <class>. If it isn't there,
+	// the parser reports the error.
+	if len(tokens) < 4 || tokens[3].kind != tokIdent {
+		return ParseRuleSetDSL(src, RuleSchema{}, isWF)
+	}
+	schema, err := e.registry.load().getSchema(tokens[3].text)
+	if err != nil {
+		return RuleSet{}, DSLError{tokens[3].line, tokens[3].col, err.Error()}
+	}
+	return ParseRuleSetDSL(src, schema, isWF)
+}
+
+var dslBareWordRegExp = regexp.MustCompile(`^([A-Za-z_][A-Za-z0-9_]*|-?[0-9]+(\.[0-9]+)?)$`)
+
+// FormatRuleSetDSL prints rs in the rule language, in the canonical layout
+func FormatRuleSetDSL(rs RuleSet) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "ruleset %v class %v ver %v", rs.SetName, rs.Class, rs.Ver)
+	if rs.MissingAttrs != MissingDefault {
+		fmt.Fprintf(&b, " missing %v", rs.MissingAttrs)
+	}
+	b.WriteString(":\n")
+	for _, rule := range rs.Rules {
+		if len(rule.RulePattern) > 0 {
+			b.WriteString("    when ")
+			for i, term := range rule.RulePattern {
+				if i > 0 {
+					b.WriteString(" and ")
+				}
+				b.WriteString(formatDSLTerm(term))
+			}
+			b.WriteString("\n")
+		}
+		b.WriteString("    then")
+		ra := rule.RuleActions
+		if len(ra.Tasks) > 0 {
+			fmt.Fprintf(&b, " tasks(%v)", strings.Join(ra.Tasks, ", "))
+		}
+		if len(ra.Properties) > 0 {
+			props := make([]string, len(ra.Properties))
+			for i, prop := range ra.Properties {
+				val := prop.Val
+				if !dslBareWordRegExp.MatchString(val) {
+					val = strconv.Quote(val)
+				}
+				props[i] = prop.Name + "=" + val
+			}
+			fmt.Fprintf(&b, " set(%v)", strings.Join(props, ", "))
+		}
+		if len(ra.ThenCall) > 0 {
+			fmt.Fprintf(&b, " thencall %v", ra.ThenCall)
+		}
+		if len(ra.ElseCall) > 0 {
+			fmt.Fprintf(&b, " elsecall %v", ra.ElseCall)
+		}
+		if ra.WillReturn {
+			b.WriteString(" return")
+		}
+		if ra.WillExit {
+			b.WriteString(" exit")
+		}
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
+// Returns term written in the rule language
+func formatDSLTerm(term RulePatternTerm) string {
+	if terms, ok := term.AttrVal.([]RulePatternTerm); ok && groupOps[term.Op] {
+		parts := make([]string, len(terms))
+		for i, t := range terms {
+			parts[i] = formatDSLTerm(t)
+		}
+		return term.Op + "(" + strings.Join(parts, ", ") + ")"
+	}
+	if term.Op == OpExists || term.Op == OpNotExists {
+		return term.AttrName + " " + term.Op
+	}
+	if arith, ok := term.AttrVal.(Arith); ok {
+		return fmt.Sprintf("%v %v %v", formatDSLExpr(arith.Left), term.Op, formatDSLExpr(arith.Right))
+	}
+	if countOp, ok := countOps[term.Op]; ok {
+		return fmt.Sprintf("%v %v %v %v", term.AttrName, countPrefix, countOp, formatDSLValue(term.AttrVal))
+	}
+	return fmt.Sprintf("%v %v %v", term.AttrName, term.Op, formatDSLValue(term.AttrVal))
+}
+
+// Returns the expression src written in canonical form, in parentheses if it is a single name or
+// number, which would otherwise be read as a literal or an attribute
+func formatDSLExpr(src string) string {
+	n, err := getExpr(src, nil)
+	if err != nil {
+		return src
+	}
+	if n.op == "" {
+		return "(" + n.String() + ")"
+	}
+	return n.String()
+}
+
+// Returns val written as a literal in the rule language
+func formatDSLValue(val any) string {
+	if ref, ok := val.(AttrRef); ok {
+		return "attr(" + ref.Attr + ")"
+	}
+	if expr, ok := val.(Expr); ok {
+		return formatDSLExpr(expr.Src)
+	}
+	if list, ok := termList(val); ok {
+		elems := make([]string, len(list))
+		for i, elem := range list {
+			elems[i] = formatDSLValue(elem)
+		}
+		return "(" + strings.Join(elems, ", ") + ")"
+	}
+	switch v := val.(type) {
+	case string:
+		return strconv.Quote(v)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case Decimal:
+		return v.String()
+	case time.Time:
+		return strconv.Quote(formatTime(v.UTC()))
+	case time.Duration:
+		return strconv.Quote(formatDuration(v))
+	default:
+		return fmt.Sprint(v)
+	}
+}
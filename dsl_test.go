@@ -0,0 +1,277 @@
+package crux
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+const winterDiscDSL = `
+# Discounts during the winter sale
+ruleset winterdisc class transaction:
+    when productname eq "jacket" and price gt 50
+    then tasks(freehat) set(discount=50) return
+    when price lt 100
+    then set(discount=40, pointsmult=2) thencall memberdisc
+    then set(note="no \"when\" clause") exit
+`
+
+func TestParseRuleSetDSL(t *testing.T) {
+	schema := testTransactionSchema()
+	rs, err := ParseRuleSetDSL(winterDiscDSL, schema, false)
+	if err != nil {
+		t.Fatalf("ParseRuleSetDSL() error = %v", err)
+	}
+	want := RuleSet{Ver: 1, Class: transactionClass, SetName: "winterdisc", Rules: []Rule{{
+		[]RulePatternTerm{{"productname", OpEQ, "jacket"}, {"price", OpGT, 50}},
+		RuleActions{
+			Tasks:      []string{"freehat"},
+			Properties: []Property{{"discount", "50"}},
+			WillReturn: true,
+		},
+	}, {
+		[]RulePatternTerm{{"price", OpLT, 100}},
+		RuleActions{
+			Properties: []Property{{"discount", "40"}, {"pointsmult", "2"}},
+			ThenCall:   "memberdisc",
+		},
+	}, {
+		[]RulePatternTerm{},
+		RuleActions{
+			Properties: []Property{{"note", `no "when" clause`}},
+			WillExit:   true,
+		},
+	}}}
+	if !reflect.DeepEqual(rs, want) {
+		t.Errorf("ParseRuleSetDSL() = %v, want %v", rs, want)
+	}
+}
+
+func TestParseRuleSetDSLExprs(t *testing.T) {
+	src := "ruleset a class transaction:\n  when price*2 -5 ge 90 and price lt (price+1)*2 and price gt -price\n  then tasks(freepen)"
+	rs, err := ParseRuleSetDSL(src, testTransactionSchema(), false)
+	if err != nil {
+		t.Fatalf("ParseRuleSetDSL() error = %v", err)
+	}
+	want := []RulePatternTerm{
+		{"", OpGE, Arith{"price * 2 - 5", "90"}},
+		{"price", OpLT, Expr{"(price + 1) * 2"}},
+		{"price", OpGT, Expr{"-price"}},
+	}
+	if !reflect.DeepEqual(rs.Rules[0].RulePattern, want) {
+		t.Errorf("ParseRuleSetDSL() pattern = %v, want %v", rs.Rules[0].RulePattern, want)
+	}
+}
+
+// Decimal numbers are read exactly, and strings with a currency code are kept as they are
+func TestParseRuleSetDSLDecimals(t *testing.T) {
+	schema := testTransactionSchema()
+	schema.PatternSchema = append(schema.PatternSchema, AttrSchema{Name: "amount", ValType: TypeDecimal, Currency: "INR"})
+	src := "ruleset a class transaction:\n  when amount gt 50.80000000000000000001 and amount le \"INR 1000\" and amount ne -0.50\n  then tasks(freepen)"
+	rs, err := ParseRuleSetDSL(src, schema, false)
+	if err != nil {
+		t.Fatalf("ParseRuleSetDSL() error = %v", err)
+	}
+	low, _ := ParseDecimal("50.80000000000000000001")
+	neg, _ := ParseDecimal("-0.5")
+	want := []RulePatternTerm{{"amount", OpGT, low}, {"amount", OpLE, "INR 1000"}, {"amount", OpNE, neg}}
+	if !reflect.DeepEqual(rs.Rules[0].RulePattern, want) {
+		t.Errorf("ParseRuleSetDSL() pattern = %v, want %v", rs.Rules[0].RulePattern, want)
+	}
+	rs2, err := ParseRuleSetDSL(FormatRuleSetDSL(rs), schema, false)
+	if err != nil || !reflect.DeepEqual(rs2, rs) {
+		t.Errorf("round trip gave %v, %v, want %v", rs2, err, rs)
+	}
+	if _, err := ParseRuleSetDSL("ruleset a class transaction:\n  when amount gt 1e3\n  then tasks(freepen)", schema, false); err == nil {
+		t.Errorf("ParseRuleSetDSL() with an exponent: expected but did not get error")
+	}
+}
+
+func TestParseRuleSetDSLLists(t *testing.T) {
+	schema := testTransactionSchema()
+	schema.PatternSchema = append(schema.PatternSchema,
+		AttrSchema{Name: "tags", ValType: TypeList, ElemType: TypeEnum, Vals: map[string]bool{"gold": true, "nri": true}},
+		AttrSchema{Name: "accts", ValType: TypeList, ElemType: TypeInt})
+	src := "ruleset a class transaction:\n  when tags hasany (gold, \"nri\") and accts hasnone (7) and accts count ge 2\n  then tasks(freepen)"
+	rs, err := ParseRuleSetDSL(src, schema, false)
+	if err != nil {
+		t.Fatalf("ParseRuleSetDSL() error = %v", err)
+	}
+	want := []RulePatternTerm{{"tags", OpHasAny, []any{"gold", "nri"}}, {"accts", OpHasNone, []any{7}}, {"accts", OpCountGE, 2}}
+	if !reflect.DeepEqual(rs.Rules[0].RulePattern, want) {
+		t.Errorf("ParseRuleSetDSL() pattern = %v, want %v", rs.Rules[0].RulePattern, want)
+	}
+	rs2, err := ParseRuleSetDSL(FormatRuleSetDSL(rs), schema, false)
+	if err != nil || !reflect.DeepEqual(rs2, rs) {
+		t.Errorf("round trip gave %v, %v, want %v", rs2, err, rs)
+	}
+	for _, when := range []string{"tags hasany (silver)", "accts count 2", "accts count ge (2)", "price count gt 1"} {
+		src := "ruleset a class transaction:\n  when " + when + "\n  then tasks(freepen)"
+		if _, err := ParseRuleSetDSL(src, schema, false); err == nil {
+			t.Errorf("ParseRuleSetDSL() with %q: expected but did not get error", when)
+		}
+	}
+}
+
+// Every test ruleset printed by FormatRuleSetDSL() must parse back into the same ruleset
+func TestFormatRuleSetDSL(t *testing.T) {
+	setupInventoryItemSchema()
+	setupPurchaseRuleSchema()
+	setupRuleSetForPurchases()
+	setupUCCCreationSchema()
+	setupUCCCreationRuleSet()
+
+	receivedTime, _ := time.Parse(time.RFC3339, "2018-05-15T12:00:00Z")
+	inventory := RuleSet{Ver: 2, Class: inventoryItemClass, SetName: "inventory", Rules: []Rule{{
+		[]RulePatternTerm{
+			{"cat", OpEQ, "textbook"},
+			{"fullname", OpGE, "Advanced \"Physics\""},
+			{"ageinstock", OpLE, -7},
+			{"mrp", OpLT, 51.25},
+			{"received", OpGT, receivedTime},
+			{"received", OpLT, time.Date(2018, 5, 16, 0, 0, 0, 0, time.UTC)},
+			{"received", OpNE, time.Date(2018, 5, 16, 9, 30, 0, 250000000, time.UTC)},
+			{"bulkorder", OpNE, false},
+			{"cat", OpIn, []any{"textbook", "refbook"}},
+			{"ageinstock", OpNIn, []any{1, 2, 3}},
+			{"fullname", OpMatches, `^Adv\w+\s`},
+			{"mrp", OpExists, nil},
+			{"ageinstock", OpLT, AttrRef{"ageinstock"}},
+			{"received", OpNotExists, nil},
+			{"ageinstock", OpGE, Expr{"ageinstock + 30"}},
+			{"ageinstock", OpEQ, Expr{"ageinstock"}},
+			{"received", OpLT, Expr{"received - days(2 * (ageinstock + 1))"}},
+			{"", OpGT, Arith{"mrp * ageinstock", "10000"}},
+			{"", OpLT, Arith{"-mrp", "2.0"}},
+			{"received", OpWithin, "30d"},
+			{"received", OpOlderThan, "1d12h"},
+			{"received", OpDayOfWeek, []any{"sat", "sun"}},
+			{"received", OpMonth, []any{"dec"}},
+			{"received", OpHourIn, []any{22, 6}},
+			{"", OpAny, []RulePatternTerm{
+				{"mrp", OpLT, 20.5},
+				{"", OpLE, Arith{"(mrp - 1) / 2", "mrp - 1 / 2"}},
+				{"", OpNot, []RulePatternTerm{{"bulkorder", OpEQ, true}, {"cat", OpIn, []any{"refbook"}}}},
+			}},
+		},
+		RuleActions{Properties: []Property{{"shipby", "fedex ground"}}, ElseCall: "other"},
+	}}, MissingAttrs: MissingUnknown}
+
+	for _, rs := range []RuleSet{inventory, testRuleSet(mainRS), testRuleSet(uccCreation)} {
+		t.Run(rs.SetName, func(t *testing.T) {
+			src := FormatRuleSetDSL(rs)
+			schema, _ := testEngine.registry.load().getSchema(rs.Class)
+			// Not every test ruleset passes verification, so only parse the source
+			got, _, err := parseRuleSetDSL(src, schema)
+			if err != nil {
+				t.Fatalf("parseRuleSetDSL() error = %v\n%v", err, src)
+			}
+			normaliseForDSL(&rs)
+			if !reflect.DeepEqual(got, rs) {
+				t.Errorf("round trip gave\n%v\nwant\n%v\nsource:\n%v", got, rs, src)
+			}
+		})
+	}
+}
+
+// The parser returns an empty, not nil, rule-pattern for a rule without a "when" clause, and nil
+// for a rule without tasks. The rules are copied, since rs may be shared with testEngine.
+func normaliseForDSL(rs *RuleSet) {
+	rs.Rules = append([]Rule{}, rs.Rules...)
+	for i := range rs.Rules {
+		if rs.Rules[i].RulePattern == nil {
+			rs.Rules[i].RulePattern = []RulePatternTerm{}
+		}
+		if len(rs.Rules[i].RuleActions.Tasks) == 0 {
+			rs.Rules[i].RuleActions.Tasks = nil
+		}
+	}
+}
+
+func TestParseRuleSetDSLErrors(t *testing.T) {
+	schema := testTransactionSchema()
+	tests := []struct {
+		name     string
+		src      string
+		wantLine int
+		wantCol  int
+	}{
+		{"missing colon", "ruleset a class transaction\n  when price gt 5 then", 2, 3},
+		{"wrong class", "ruleset a class purchase:", 1, 17},
+		{"unknown attribute", "ruleset a class transaction:\n  when colour eq \"red\" then", 2, 8},
+		{"string for int", "ruleset a class transaction:\n  when price gt \"5\" then", 2, 17},
+		{"fraction for int", "ruleset a class transaction:\n  when price gt 5.5 then", 2, 17},
+		{"missing then", "ruleset a class transaction:\n  when price gt 5\n  when price lt 9 then", 3, 3},
+		{"unterminated string", "ruleset a class transaction:\n  when productname eq \"abc then", 2, 23},
+		{"bad character", "ruleset a class transaction:\n  when price > 5 then", 2, 14},
+		{"unknown action", "ruleset a class transaction:\n  then freepen", 2, 8},
+		{"invalid op", "ruleset a class transaction:\n  then tasks(freepen)\n  when price greater 5 then tasks(freepen)", 3, 3},
+		{"task not in schema", "ruleset a class transaction:\n  when price gt 5\n  then tasks(freeeraser)", 2, 3},
+		{"in without list", "ruleset a class transaction:\n  when price in 5 then", 2, 17},
+		{"unclosed list", "ruleset a class transaction:\n  when price in (5, 6 then", 2, 23},
+		{"enum value not in vals", "ruleset a class transaction:\n  when paymenttype in (cash, cheque) then tasks(freepen)", 2, 3},
+		{"unknown missing policy", "ruleset a class transaction missing maybe:", 1, 37},
+		{"value for exists", "ruleset a class transaction:\n  when price exists 5 then", 2, 21},
+		{"unclosed group", "ruleset a class transaction:\n  when any(price gt 5, price lt 2 then", 2, 35},
+		{"attr without name", "ruleset a class transaction:\n  when price lt attr() then", 2, 22},
+		{"empty group", "ruleset a class transaction:\n  when not() then", 2, 12},
+		{"number for duration", "ruleset a class transaction:\n  when paid within 30 then", 2, 20},
+		{"unknown day", "ruleset a class transaction:\n  when paid dayofweek (sat, sunday) then tasks(freepen)", 2, 3},
+		{"unclosed expression", "ruleset a class transaction:\n  when price gt (5 then", 2, 20},
+		{"expression without operator", "ruleset a class transaction:\n  when price * 2 5 then", 2, 18},
+		{"str in expression", "ruleset a class transaction:\n  when price gt productname + 1 then tasks(freepen)", 2, 3},
+		{"end after operator", "ruleset a class transaction:\n  when price gt", 2, 16},
+		{"end after expression operand", "ruleset a class transaction:\n  when price gt 5 *", 2, 20},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseRuleSetDSL(tt.src, schema, false)
+			var dslErr DSLError
+			if !errors.As(err, &dslErr) {
+				t.Fatalf("ParseRuleSetDSL() error = %v, want a DSLError", err)
+			}
+			if dslErr.Line != tt.wantLine || dslErr.Col != tt.wantCol {
+				t.Errorf("error at line %v, column %v, want line %v, column %v: %v",
+					dslErr.Line, dslErr.Col, tt.wantLine, tt.wantCol, dslErr)
+			}
+		})
+	}
+
+	// Verification errors in two rules are both reported
+	src := "ruleset a class transaction:\n  when price eq 5 then tasks(freeeraser)\n  when price eq 6 then set(colour=red)"
+	_, err := ParseRuleSetDSL(src, schema, false)
+	var errs DSLErrors
+	if !errors.As(err, &errs) || len(errs) != 2 || errs[0].Line != 2 || errs[1].Line != 3 {
+		t.Errorf("ParseRuleSetDSL() error = %v, want errors at lines 2 and 3", err)
+	}
+}
+
+func TestEngineParseRuleSetDSL(t *testing.T) {
+	e := NewEngine()
+	e.AddRuleSchema(testTransactionSchema())
+	if _, err := e.ParseRuleSetDSL(winterDiscDSL, false); err != nil {
+		t.Errorf("ParseRuleSetDSL() error = %v", err)
+	}
+	if _, err := e.ParseRuleSetDSL("ruleset a class purchase:", false); err == nil {
+		t.Errorf("ParseRuleSetDSL(): expected but did not get error for a class without a schema")
+	}
+}
+
+func testTransactionSchema() RuleSchema {
+	return RuleSchema{
+		Class: transactionClass,
+		PatternSchema: []AttrSchema{
+			{Name: "productname", ValType: TypeStr},
+			{Name: "price", ValType: TypeInt},
+			{Name: "inwintersale", ValType: TypeBool},
+			{Name: "paymenttype", ValType: TypeEnum, Vals: map[string]bool{"cash": true, "card": true}},
+			{Name: "ismember", ValType: TypeBool},
+			{Name: "paid", ValType: TypeTS},
+		},
+		ActionSchema: ActionSchema{
+			Tasks:      []string{"freepen", "freemug", "freebag", "freehat"},
+			Properties: []string{"discount", "pointsmult", "note"},
+		},
+	}
+}
@@ -0,0 +1,171 @@
+/*
+This file contains Engine, the public entry point to the matching engine. Its methods wrap
+doMatch(), verifyRuleSchema() and verifyRuleSet().
+*/
+
+package crux
+
+import "fmt"
+
+// An Engine owns its schemas and rulesets, so several engines can coexist in one process.
+// An Engine is safe for concurrent use: rulesets may be added while other goroutines are matching.
+type Engine struct {
+	registry *ruleRegistry
+}
+
+func NewEngine() *Engine {
+	return &Engine{registry: newRuleRegistry()}
+}
+
+// AddRuleSchema adds schemas to the engine, replacing any existing schemas for the same classes.
+// All of them become visible to matches at the same time. They are not verified; call
+// VerifyRuleSchema() for that.
+func (e *Engine) AddRuleSchema(schemas ...RuleSchema) {
+	e.registry.update(func(st *ruleStore) {
+		for _, rs := range schemas {
+			st.schemas[rs.Class] = rs
+			st.compiledSchemas[rs.Class] = compileSchema(rs)
+			for _, ruleSet := range st.ruleSets {
+				if ruleSet.Class == rs.Class {
+					st.compile(ruleSet)
+				}
+			}
+		}
+	})
+}
+
+// AddRuleSet adds ruleSets to the engine, replacing any existing rulesets with the same names.
+// All of them become visible to matches at the same time. They are not verified; call
+// VerifyRuleSet() for that. The engine keeps the rulesets as they are, so callers must not
+// modify them after adding them.
+func (e *Engine) AddRuleSet(ruleSets ...RuleSet) {
+	e.registry.update(func(st *ruleStore) {
+		for _, rs := range ruleSets {
+			st.ruleSets[rs.SetName] = rs
+			st.compile(rs)
+		}
+	})
+}
+
+// GetRuleSet returns the ruleset named setName
+func (e *Engine) GetRuleSet(setName string) (RuleSet, bool) {
+	return e.registry.load().getRuleSet(setName)
+}
+
+// Match runs the ruleset named setName, which must be for the class of entity, and any rulesets
+// it calls, against entity and returns the actions collected along the way. The whole match uses the schemas and
+// rulesets present in the engine when Match was called. Compiled rulesets are used
+// where they are available (see compile.go). entity is first given the defaults of the attributes
+// it is missing, and checked for required attributes, or in strict mode, validated (see
+// validate_entity.go).
+func (e *Engine) Match(entity Entity, setName string) (ActionSet, error) {
+	return match(e.registry.load(), entity, setName)
+}
+
+func match(st *ruleStore, entity Entity, setName string) (ActionSet, error) {
+	entity, err := st.prepareEntity(entity)
+	if err != nil {
+		return ActionSet{}, err
+	}
+	ruleSet, err := st.getRootRuleSet(entity, setName)
+	if err != nil {
+		return ActionSet{}, err
+	}
+	m := matcher{store: st}
+	if crs, ok := m.store.compiled[setName]; ok {
+		vals := crs.schema.entitySlots(entity)
+		actionSet, _, err := m.doMatchCompiled(entity, vals, crs, ActionSet{}, map[string]bool{})
+		return actionSet, err
+	}
+	actionSet, _, err := m.doMatch(entity, ruleSet, ActionSet{}, map[string]bool{})
+	return actionSet, err
+}
+
+// Returns the ruleset setName, with which a match of entity starts. Its class must be that of
+// entity.
+func (st *ruleStore) getRootRuleSet(entity Entity, setName string) (RuleSet, error) {
+	ruleSet, ok := st.getRuleSet(setName)
+	if !ok {
+		return RuleSet{}, fmt.Errorf("no ruleset found with name %v", setName)
+	}
+	if ruleSet.Class != entity.Class {
+		return RuleSet{}, fmt.Errorf("ruleset %v is for class %v, not %v", setName, ruleSet.Class, entity.Class)
+	}
+	return ruleSet, nil
+}
+
+// MatchWithTrace is like Match, but also returns a trace of every ruleset entered, every rule
+// evaluated and every term compared. The trace is returned even if the match fails, and shows
+// how far the match got.
+func (e *Engine) MatchWithTrace(entity Entity, setName string) (ActionSet, *MatchTrace, error) {
+	return matchWithTrace(e.registry.load(), entity, setName)
+}
+
+func matchWithTrace(st *ruleStore, entity Entity, setName string) (ActionSet, *MatchTrace, error) {
+	trace := &MatchTrace{Root: &RuleSetTrace{}}
+	entity, err := st.prepareEntity(entity)
+	if err != nil {
+		trace.Error = err.Error()
+		return ActionSet{}, trace, err
+	}
+	m := matcher{store: st, nextSetTrace: trace.Root}
+	ruleSet, err := st.getRootRuleSet(entity, setName)
+	if err != nil {
+		trace.Error = err.Error()
+		return ActionSet{}, trace, err
+	}
+	actionSet, _, err := m.doMatch(entity, ruleSet, ActionSet{}, map[string]bool{})
+	trace.Result = actionSet
+	if err != nil {
+		trace.Error = err.Error()
+	}
+	return actionSet, trace, err
+}
+
+// VerifyRuleSchema checks rs for errors. isWF is true if rs applies to a workflow.
+func (e *Engine) VerifyRuleSchema(rs RuleSchema, isWF bool) error {
+	_, err := verifyRuleSchema(rs, isWF)
+	return err
+}
+
+// VerifyRuleSet checks rs against the engine's schema for its class. isWF is true if rs is a workflow.
+// Every path of calls from rs through the engine's rulesets is checked too (see call_graph.go).
+func (e *Engine) VerifyRuleSet(rs RuleSet, isWF bool) error {
+	_, err := e.VerifyRuleSetWithWarnings(rs, isWF)
+	return err
+}
+
+// VerifyRuleSetWithWarnings checks rs as VerifyRuleSet does, and if it is valid, returns a
+// warning for each of its terms that is always or never true (see rule_warnings.go)
+func (e *Engine) VerifyRuleSetWithWarnings(rs RuleSet, isWF bool) ([]RuleWarning, error) {
+	return e.registry.load().verifyRuleSet(rs, isWF)
+}
+
+// VerifyRuleSets checks ruleSets as VerifyRuleSet does, before they are added to the engine. Calls
+// are followed into ruleSets first and then into the engine's rulesets, so rulesets that call each
+// other can be verified together.
+func (e *Engine) VerifyRuleSets(ruleSets []RuleSet, isWF bool) error {
+	_, err := e.VerifyRuleSetsWithWarnings(ruleSets, isWF)
+	return err
+}
+
+// VerifyRuleSetsWithWarnings checks ruleSets as VerifyRuleSets does, and if they are valid,
+// returns the warnings for each of them that has any, by set name
+func (e *Engine) VerifyRuleSetsWithWarnings(ruleSets []RuleSet, isWF bool) (map[string][]RuleWarning, error) {
+	st := e.registry.load()
+	pending := make(map[string]RuleSet, len(ruleSets))
+	for _, rs := range ruleSets {
+		pending[rs.SetName] = rs
+	}
+	warnings := map[string][]RuleWarning{}
+	for _, rs := range ruleSets {
+		ws, err := st.verifyRuleSetWith(rs, isWF, pending)
+		if err != nil {
+			return nil, fmt.Errorf("ruleset %v: %w", rs.SetName, err)
+		}
+		if len(ws) > 0 {
+			warnings[rs.SetName] = ws
+		}
+	}
+	return warnings, nil
+}
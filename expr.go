@@ -0,0 +1,674 @@
+/*
+This file contains the arithmetic expressions that rule-pattern terms may compare. A term whose
+value is an Expr compares its attribute with the value of the expression, and a term whose value
+is an Arith compares the values of two expressions:
+
+	RulePatternTerm{"ageinstock", OpGE, Expr{"daysonshelf + 30"}}
+	RulePatternTerm{"", OpGT, Arith{"mrp * qty", "10000"}}
+
+An expression is made of int and float numbers, the int, float and ts attributes of the
+entity's class, the operators + - * / and unary -, parentheses, and the functions days(n) and
+hours(n), which turn a number into a duration. The value-types combine as follows:
+
+	int op int                 int, where / truncates
+	int op float, float op int float
+	ts + duration, ts - duration
+	duration + ts              ts
+	ts - ts                    duration
+	duration + duration, duration - duration,
+	duration * number, number * duration,
+	duration / number          duration
+
+and for instance "shipped - received gt days(2)" compares two durations. int and float values
+may be compared with each other, ts only with ts and durations only with durations.
+
+Expressions are checked against the class's schema when a ruleset is verified. They have no
+side effects, loops or user-defined functions, and may have at most maxExprNodes numbers,
+attributes, operators and functions, so the cost of evaluating one is bounded. An expression
+fails with an error when it divides by zero or an int or duration overflows. A term with an
+expression that uses an attribute that the entity does not have is evaluated under the
+ruleset's missing-attribute policy, and under MissingDefault, fails with an error.
+*/
+
+package crux
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The largest number of nodes that an expression may have
+const maxExprNodes = 64
+
+// The value-type of expressions such as "shipped - received". No attribute has this type.
+const typeDuration = "duration"
+
+// exprNode is a node of a parsed expression: a number (val), an attribute (attr), or an operator
+// or function (op) applied to args
+type exprNode struct {
+	op   string
+	attr string
+	val  any
+	args []*exprNode
+}
+
+var exprFuncs = map[string]time.Duration{"days": 24 * time.Hour, "hours": time.Hour}
+
+// The precedence of each binary operator
+var exprPrec = map[string]int{"+": 1, "-": 1, "*": 2, "/": 2}
+
+var errDivByZero = errors.New("division by zero")
+var errOverflow = errors.New("integer overflow")
+
+// Returns the parsed form of the expression src, from exprs if it is there
+func getExpr(src string, exprs map[string]*exprNode) (*exprNode, error) {
+	if n, ok := exprs[src]; ok {
+		return n, nil
+	}
+	n, err := parseExpr(src)
+	if err != nil {
+		return nil, fmt.Errorf("invalid expression %q: %w", src, err)
+	}
+	return n, nil
+}
+
+// Returns the parsed form of each valid expression in the terms of ruleSets, by source. Those in
+// prev are reused.
+func compileExprs(ruleSets map[string]RuleSet, prev map[string]*exprNode) map[string]*exprNode {
+	exprs := map[string]*exprNode{}
+	forEachLeafTerm(ruleSets, func(term RulePatternTerm) {
+		var srcs []string
+		switch v := term.AttrVal.(type) {
+		case Expr:
+			srcs = []string{v.Src}
+		case Arith:
+			srcs = []string{v.Left, v.Right}
+		}
+		for _, src := range srcs {
+			if _, ok := exprs[src]; ok {
+				continue
+			}
+			if n, err := getExpr(src, prev); err == nil {
+				exprs[src] = n
+			}
+		}
+	})
+	return exprs
+}
+
+func parseExpr(src string) (*exprNode, error) {
+	tokens, err := lexDSL(src)
+	if err != nil {
+		return nil, err
+	}
+	p := exprParser{tokens: tokens}
+	n, err := p.parse()
+	if err != nil {
+		return nil, err
+	}
+	if tok := p.tokens[p.pos]; tok.kind != tokEOF {
+		return nil, DSLError{tok.line, tok.col, fmt.Sprintf("unexpected %q", tok.text)}
+	}
+	return n, nil
+}
+
+// exprParser parses an expression from tokens, starting at pos, and leaves pos at the first
+// token after it
+type exprParser struct {
+	tokens []dslToken
+	pos    int
+	nodes  int
+}
+
+func (p *exprParser) parse() (*exprNode, error) {
+	return p.parseBinary(1)
+}
+
+// Parses a sequence of operands joined by binary operators of precedence prec or higher
+func (p *exprParser) parseBinary(prec int) (*exprNode, error) {
+	if prec > 2 {
+		return p.parseUnary()
+	}
+	left, err := p.parseBinary(prec + 1)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		op := p.binaryOp()
+		if exprPrec[op] != prec {
+			return left, nil
+		}
+		if tok := &p.tokens[p.pos]; tok.kind == tokNumber {
+			// "a -5" is lexed as a name and a negative number
+			tok.text, tok.col = tok.text[1:], tok.col+1
+		} else {
+			p.pos++
+		}
+		right, err := p.parseBinary(prec + 1)
+		if err != nil {
+			return nil, err
+		}
+		if left, err = p.node(&exprNode{op: op, args: []*exprNode{left, right}}); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// Returns the binary operator at pos, or ""
+func (p *exprParser) binaryOp() string {
+	tok := p.tokens[p.pos]
+	switch {
+	case tok.kind == tokPunct && exprPrec[tok.text] > 0:
+		return tok.text
+	case tok.kind == tokNumber && strings.HasPrefix(tok.text, "-"):
+		return "-"
+	}
+	return ""
+}
+
+func (p *exprParser) parseUnary() (*exprNode, error) {
+	tok := p.tokens[p.pos]
+	if tok.kind == tokPunct && tok.text == "-" {
+		p.pos++
+		arg, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return p.node(&exprNode{op: "neg", args: []*exprNode{arg}})
+	}
+	return p.parsePrimary()
+}
+
+func (p *exprParser) parsePrimary() (*exprNode, error) {
+	tok := p.tokens[p.pos]
+	p.pos++
+	switch {
+	case tok.kind == tokNumber:
+		if i, err := strconv.Atoi(tok.text); err == nil {
+			return p.node(&exprNode{val: i})
+		}
+		f, err := strconv.ParseFloat(tok.text, 64)
+		if err != nil {
+			return nil, DSLError{tok.line, tok.col, fmt.Sprintf("invalid number %v", tok.text)}
+		}
+		return p.node(&exprNode{val: f})
+	case tok.kind == tokIdent && exprFuncs[tok.text] > 0 && p.isPunct("("):
+		p.pos++
+		arg, err := p.parse()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expectPunct(")"); err != nil {
+			return nil, err
+		}
+		return p.node(&exprNode{op: tok.text, args: []*exprNode{arg}})
+	case tok.kind == tokIdent:
+		return p.node(&exprNode{attr: tok.text})
+	case tok.kind == tokPunct && tok.text == "(":
+		n, err := p.parse()
+		if err != nil {
+			return nil, err
+		}
+		return n, p.expectPunct(")")
+	}
+	p.pos--
+	return nil, DSLError{tok.line, tok.col, fmt.Sprintf("expected a number, attribute or \"(\", found %q", tok.text)}
+}
+
+func (p *exprParser) isPunct(punct string) bool {
+	tok := p.tokens[p.pos]
+	return tok.kind == tokPunct && tok.text == punct
+}
+
+func (p *exprParser) expectPunct(punct string) error {
+	tok := p.tokens[p.pos]
+	if !p.isPunct(punct) {
+		return DSLError{tok.line, tok.col, fmt.Sprintf("expected %q, found %q", punct, tok.text)}
+	}
+	p.pos++
+	return nil
+}
+
+// Counts n towards the limit on the size of the expression
+func (p *exprParser) node(n *exprNode) (*exprNode, error) {
+	if p.nodes++; p.nodes > maxExprNodes {
+		tok := p.tokens[p.pos-1]
+		return nil, DSLError{tok.line, tok.col, fmt.Sprintf("expression has more than %v terms", maxExprNodes)}
+	}
+	return n, nil
+}
+
+// Returns n written in canonical form, with only the parentheses that it needs
+func (n *exprNode) String() string {
+	return n.format(0, false)
+}
+
+// Writes n as an operand of an operator of precedence prec, on its right if right is true
+func (n *exprNode) format(prec int, right bool) string {
+	switch {
+	case n.op == "":
+		if n.attr != "" {
+			return n.attr
+		}
+		s := fmt.Sprint(n.val)
+		if f, ok := n.val.(float64); ok && f == math.Trunc(f) && !strings.ContainsAny(s, "e") {
+			// Keep the value a float when it is parsed again
+			s += ".0"
+		}
+		return s
+	case n.op == "neg":
+		return "-" + n.args[0].format(3, false)
+	case exprFuncs[n.op] > 0:
+		return n.op + "(" + n.args[0].format(0, false) + ")"
+	}
+	s := n.args[0].format(exprPrec[n.op], false) + " " + n.op + " " + n.args[1].format(exprPrec[n.op], true)
+	if exprPrec[n.op] < prec || (exprPrec[n.op] == prec && right) {
+		return "(" + s + ")"
+	}
+	return s
+}
+
+// Appends the names of the attributes used in n to names, each once
+func (n *exprNode) attrs(names []string) []string {
+	if n.attr != "" && !isStringInArray(n.attr, names) {
+		names = append(names, n.attr)
+	}
+	for _, arg := range n.args {
+		names = arg.attrs(names)
+	}
+	return names
+}
+
+// Returns the value-type of n, where attrType returns the value-type of an attribute, and whether
+// evaluating n may fail with an error: if it divides, or does int or duration arithmetic, which
+// may overflow
+func (n *exprNode) check(attrType func(string) string) (string, bool, error) {
+	switch {
+	case n.attr != "":
+		valType := attrType(n.attr)
+		if valType == "" {
+			return "", false, fmt.Errorf("attribute does not exist in schema: %v", n.attr)
+		} else if valType != TypeInt && valType != TypeFloat && valType != TypeTS {
+			return "", false, fmt.Errorf("attribute %v cannot be used in an expression", n.attr)
+		}
+		return valType, false, nil
+	case n.op == "":
+		if _, ok := n.val.(int); ok {
+			return TypeInt, false, nil
+		}
+		return TypeFloat, false, nil
+	}
+	var types []string
+	mayFail := n.op == "/"
+	for _, arg := range n.args {
+		valType, argMayFail, err := arg.check(attrType)
+		if err != nil {
+			return "", false, err
+		}
+		types = append(types, valType)
+		mayFail = mayFail || argMayFail
+	}
+	valType := exprType(n.op, types)
+	if valType == "" {
+		return "", false, fmt.Errorf("%v cannot be applied to %v", n.op, strings.Join(types, " and "))
+	}
+	return valType, mayFail || valType == TypeInt || valType == typeDuration, nil
+}
+
+func isNumType(valType string) bool {
+	return valType == TypeInt || valType == TypeFloat
+}
+
+// Returns the value-type of op applied to operands of types, or "" if op cannot be applied to
+// them
+func exprType(op string, types []string) string {
+	a := types[0]
+	if len(types) == 1 {
+		if exprFuncs[op] > 0 && isNumType(a) {
+			return typeDuration
+		} else if op == "neg" && (isNumType(a) || a == typeDuration) {
+			return a
+		}
+		return ""
+	}
+	b := types[1]
+	switch {
+	case isNumType(a) && isNumType(b):
+		if a == TypeInt && b == TypeInt {
+			return TypeInt
+		}
+		return TypeFloat
+	case op == "+" && (a == TypeTS && b == typeDuration || a == typeDuration && b == TypeTS):
+		return TypeTS
+	case op == "-" && a == TypeTS && b == typeDuration:
+		return TypeTS
+	case op == "-" && a == TypeTS && b == TypeTS:
+		return typeDuration
+	case (op == "+" || op == "-") && a == typeDuration && b == typeDuration:
+		return typeDuration
+	case op == "*" && (a == typeDuration && isNumType(b) || isNumType(a) && b == typeDuration):
+		return typeDuration
+	case op == "/" && a == typeDuration && isNumType(b):
+		return typeDuration
+	}
+	return ""
+}
+
+// Returns whether values of the types a and b may be compared
+func exprTypesComparable(a string, b string) bool {
+	return a == b || isNumType(a) && isNumType(b)
+}
+
+// Returns the value of n, where lookup returns the value of an attribute converted to its
+// value-type. The value is an int, float64, time.Time or time.Duration.
+func (n *exprNode) eval(lookup func(string) (any, error)) (any, error) {
+	switch {
+	case n.attr != "":
+		return lookup(n.attr)
+	case n.op == "":
+		return n.val, nil
+	}
+	args := make([]any, len(n.args))
+	for i, arg := range n.args {
+		var err error
+		if args[i], err = arg.eval(lookup); err != nil {
+			return nil, err
+		}
+	}
+	if unit := exprFuncs[n.op]; unit > 0 {
+		return scaleDuration(unit, toFloat(args[0]))
+	}
+	if n.op == "neg" {
+		switch a := args[0].(type) {
+		case int:
+			return subInts(0, a)
+		case float64:
+			return -a, nil
+		case time.Duration:
+			d, err := subInts(0, int(a))
+			return time.Duration(d), err
+		}
+	}
+	return evalBinary(n.op, args[0], args[1])
+}
+
+func evalBinary(op string, a any, b any) (any, error) {
+	switch a := a.(type) {
+	case int:
+		if b, ok := b.(int); ok {
+			return evalInts(op, a, b)
+		}
+	case time.Time:
+		switch b := b.(type) {
+		case time.Duration:
+			if op == "-" {
+				b = -b
+			}
+			return a.Add(b), nil
+		case time.Time:
+			return a.Sub(b), nil
+		}
+	case time.Duration:
+		switch b := b.(type) {
+		case time.Time:
+			return b.Add(a), nil
+		case time.Duration:
+			d, err := evalInts(op, int(a), int(b))
+			if err != nil {
+				return nil, err
+			}
+			return time.Duration(d.(int)), nil
+		}
+		if op == "/" && toFloat(b) == 0 {
+			return nil, errDivByZero
+		} else if op == "/" {
+			return scaleDuration(a, 1/toFloat(b))
+		}
+		return scaleDuration(a, toFloat(b))
+	}
+	if d, ok := b.(time.Duration); ok {
+		return scaleDuration(d, toFloat(a))
+	}
+	x, y := toFloat(a), toFloat(b)
+	switch op {
+	case "+":
+		return x + y, nil
+	case "-":
+		return x - y, nil
+	case "*":
+		return x * y, nil
+	}
+	if y == 0 {
+		return nil, errDivByZero
+	}
+	return x / y, nil
+}
+
+// Applies op to two ints, failing on overflow and division by zero
+func evalInts(op string, a int, b int) (any, error) {
+	switch op {
+	case "+":
+		return addInts(a, b)
+	case "-":
+		return subInts(a, b)
+	case "*":
+		if a != 0 && b != 0 && ((a*b)/b != a || (a == -1 && b == math.MinInt) || (b == -1 && a == math.MinInt)) {
+			return nil, errOverflow
+		}
+		return a * b, nil
+	}
+	if b == 0 {
+		return nil, errDivByZero
+	} else if a == math.MinInt && b == -1 {
+		return nil, errOverflow
+	}
+	return a / b, nil
+}
+
+func addInts(a int, b int) (int, error) {
+	c := a + b
+	if (a > 0 && b > 0 && c < 0) || (a < 0 && b < 0 && c >= 0) {
+		return 0, errOverflow
+	}
+	return c, nil
+}
+
+func subInts(a int, b int) (int, error) {
+	if b == math.MinInt {
+		if a >= 0 {
+			return 0, errOverflow
+		}
+		return a - b, nil
+	}
+	return addInts(a, -b)
+}
+
+// Returns d * f, failing if it is out of the range of a time.Duration
+func scaleDuration(d time.Duration, f float64) (time.Duration, error) {
+	x := float64(d) * f
+	if math.IsNaN(x) || x >= math.MaxInt64 || x < math.MinInt64 {
+		return 0, errOverflow
+	}
+	return time.Duration(x), nil
+}
+
+func toFloat(v any) float64 {
+	if i, ok := v.(int); ok {
+		return float64(i)
+	}
+	return v.(float64)
+}
+
+// Returns whether "a op b" is true for two values returned by eval(). An int compared with a
+// float is converted to a float.
+func compareExprVals(a any, b any, op string) (bool, error) {
+	_, aIsInt := a.(int)
+	_, bIsInt := b.(int)
+	if aIsInt != bIsInt {
+		if _, ok := a.(float64); ok || aIsInt {
+			a, b = toFloat(a), toFloat(b)
+		}
+	}
+	switch op {
+	case OpEQ:
+		return a == b, nil
+	case OpNE:
+		return a != b, nil
+	}
+	c, err := compare(a, b)
+	if err != nil {
+		return false, err
+	}
+	switch op {
+	case OpLT:
+		return c == -1, nil
+	case OpLE:
+		return c <= 0, nil
+	case OpGT:
+		return c == 1, nil
+	case OpGE:
+		return c >= 0, nil
+	}
+	return false, errors.New("invalid operation in rule: " + op)
+}
+
+// Returns v, a value returned by eval(), as it is shown in traces
+func formatExprVal(v any) string {
+	if t, ok := v.(time.Time); ok {
+		return formatTime(t)
+	}
+	return fmt.Sprint(v)
+}
+
+// Returns the two expressions compared by term, whose value is an Expr or an Arith, taking those
+// that have been parsed from exprs, which may be nil. The left side of a term with an Expr is the
+// term's attribute.
+func exprOperands(term RulePatternTerm, exprs map[string]*exprNode) (*exprNode, *exprNode, error) {
+	switch v := term.AttrVal.(type) {
+	case Expr:
+		right, err := getExpr(v.Src, exprs)
+		return &exprNode{attr: term.AttrName}, right, err
+	case Arith:
+		left, err := getExpr(v.Left, exprs)
+		if err != nil {
+			return nil, nil, err
+		}
+		right, err := getExpr(v.Right, exprs)
+		return left, right, err
+	}
+	return nil, nil, fmt.Errorf("%v is not an expression", term.AttrVal)
+}
+
+func isExprTerm(term RulePatternTerm) bool {
+	switch term.AttrVal.(type) {
+	case Expr, Arith:
+		return true
+	}
+	return false
+}
+
+// Verifies a term that compares expressions, and returns whether evaluating it may fail with an
+// error
+func verifyExprTerm(term RulePatternTerm, schema RuleSchema) (bool, error) {
+	if _, ok := term.AttrVal.(Arith); ok && term.AttrName != "" {
+		return false, fmt.Errorf("a term that compares two expressions has no attribute name: %v", term.AttrName)
+	}
+	if !refOps[term.Op] {
+		return false, fmt.Errorf("%v cannot compare expressions", term.Op)
+	}
+	left, right, err := exprOperands(term, nil)
+	if err != nil {
+		return false, err
+	}
+	attrType := func(name string) string { return getType(schema, name) }
+	leftType, leftMayFail, err := left.check(attrType)
+	if err != nil {
+		return false, err
+	}
+	rightType, rightMayFail, err := right.check(attrType)
+	if err != nil {
+		return false, err
+	}
+	if !exprTypesComparable(leftType, rightType) {
+		return false, fmt.Errorf("%v (%v) cannot be compared with %v (%v)", left, leftType, right, rightType)
+	}
+	return leftMayFail || rightMayFail, nil
+}
+
+// Evaluates a term that compares expressions. The trace records the values of the two sides, as
+// EntityVal and RefVal. If the entity does not have an attribute used in the term, the term is
+// evaluated under the missing-attribute policy, and under MissingDefault, fails with an error.
+func (m *matcher) matchExprTerm(entity Entity, term RulePatternTerm, actionSet ActionSet, missing string) (TermTrace, error) {
+	tt := TermTrace{Term: term, ValSource: valSourceExpr}
+	left, right, err := exprOperands(term, m.store.exprs)
+	if err != nil {
+		tt.Error = err.Error()
+		return tt, err
+	}
+	vals := map[string]any{}
+	for _, name := range right.attrs(left.attrs(nil)) {
+		entityAttrVal, valType, valSource := m.getEntityAttrVal(entity, name, actionSet)
+		if valSource == valSourceMissing {
+			switch missing {
+			case MissingUnknown:
+				tt.Unknown = true
+				return tt, nil
+			case MissingNoMatch:
+				return tt, nil
+			}
+			err = missingAttrError(name)
+			tt.Error = err.Error()
+			return tt, err
+		}
+		if valType != TypeInt && valType != TypeFloat && valType != TypeTS {
+			err = fmt.Errorf("attribute %v cannot be used in an expression", name)
+			tt.Error = err.Error()
+			return tt, err
+		}
+		if vals[name], err = convertEntityAttrVal(entityAttrVal, valType, m.attrFormat(entity.Class, name)); err != nil {
+			err = fmt.Errorf("error converting value of %v: %w", name, err)
+			tt.Error = err.Error()
+			return tt, err
+		}
+	}
+	lookup := func(name string) (any, error) { return vals[name], nil }
+	tt.Matched, err = tt.evalExprs(left, right, term.Op, lookup)
+	return tt, err
+}
+
+// Evaluates "left op right", and records the two values and their value-type in tt
+func (tt *TermTrace) evalExprs(left *exprNode, right *exprNode, op string,
+	lookup func(string) (any, error)) (bool, error) {
+	a, err := left.eval(lookup)
+	if err == nil {
+		tt.EntityVal, tt.ValType = formatExprVal(a), exprValType(a)
+		var b any
+		if b, err = right.eval(lookup); err == nil {
+			tt.RefVal = formatExprVal(b)
+			var matched bool
+			if matched, err = compareExprVals(a, b, op); err == nil {
+				return matched, nil
+			}
+		}
+	}
+	err = fmt.Errorf("error evaluating expression: %w", err)
+	tt.Error = err.Error()
+	return false, err
+}
+
+// Returns the value-type of v, a value returned by eval()
+func exprValType(v any) string {
+	switch v.(type) {
+	case int:
+		return TypeInt
+	case float64:
+		return TypeFloat
+	case time.Time:
+		return TypeTS
+	}
+	return typeDuration
+}
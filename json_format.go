@@ -0,0 +1,348 @@
+/*
+This file contains the JSON encoding of RuleSchema and RuleSet, and ParseRuleSetJSON(), which
+decodes the values in rule-pattern terms according to the schema of the ruleset's class.
+
+A RuleSchema is encoded as follows. "vals" is only needed for enums and enum lists, and
+"valMin", "valMax", "lenMin", "lenMax", for ts and date attributes "layouts" and "tz", and for
+decimal attributes "currency", "precision" and "scale", may be omitted, as may "required" and
+"default", whose value is written as in an entity. List attributes have an "elemType".
+
+	{
+	  "class": "inventoryitem",
+	  "patternSchema": [
+	    {"name": "cat", "valType": "enum", "vals": ["refbook", "textbook"]},
+	    {"name": "fullname", "valType": "str", "lenMin": 1, "lenMax": 80, "required": true},
+	    {"name": "mrp", "valType": "float", "valMin": 0, "valMax": 10000},
+	    {"name": "bulkorder", "valType": "bool", "default": "false"},
+	    {"name": "price", "valType": "decimal", "currency": "INR", "precision": 10, "scale": 2},
+	    {"name": "tags", "valType": "list", "elemType": "enum", "vals": ["new", "sale"]},
+	    {"name": "received", "valType": "ts", "layouts": ["02/01/2006 15:04"], "tz": "Asia/Kolkata"}
+	  ],
+	  "actionSchema": {
+	    "tasks": ["dodiscount"],
+	    "properties": ["discount", "note"],
+	    "propertySchema": [{"name": "discount", "valType": "int", "valMin": 0, "valMax": 100}]
+	  }
+	}
+
+"propertySchema", which gives the value-types of properties (see action_props.go), may be
+omitted.
+
+A RuleSet is encoded as follows. Fields of "ruleActions" that are empty or false may be omitted.
+
+	{
+	  "ver": 1,
+	  "class": "inventoryitem",
+	  "setName": "main",
+	  "rules": [{
+	    "rulePattern": [
+	      {"attrName": "cat", "op": "eq", "attrVal": "textbook"},
+	      {"attrName": "mrp", "op": "gt", "attrVal": 50},
+	      {"attrName": "received", "op": "lt", "attrVal": "2018-05-15T12:00:00Z"}
+	    ],
+	    "ruleActions": {
+	      "tasks": ["dodiscount"],
+	      "properties": [{"name": "discount", "val": "10"}],
+	      "thenCall": "discounts",
+	      "willExit": true
+	    }
+	  }]
+	}
+
+The JSON type of "attrVal" must suit the attribute's value-type: a boolean for bool attributes
+and for tasks used as attributes, an integer for int, any number for float, and a string for
+str, enum, ts and date. A ts or date value is a string in RFC 3339 or "2006-01-02". A decimal
+value is a number or a string, which may have a currency code, such as "INR 50.80"; decimals are
+encoded as strings, so that no digits are lost. For the operators "in" and "nin", "attrVal" is a
+list of such values:
+
+	{"attrName": "cat", "op": "in", "attrVal": ["textbook", "refbook"]}
+
+To compare two attributes of the entity, "attrVal" names the other attribute:
+
+	{"attrName": "saleprice", "op": "lt", "attrVal": {"attr": "costprice"}}
+
+Either side of a term may instead be an arithmetic expression (see expr.go). An expression in
+place of the value is written as {"expr": src}, and a term that compares two expressions has no
+"attrName":
+
+	{"attrName": "ageinstock", "op": "ge", "attrVal": {"expr": "daysonshelf + 30"}}
+	{"op": "gt", "attrVal": {"left": "mrp * qty", "right": "10000"}}
+
+For "within" and "olderthan", "attrVal" is a duration string, and for "dayofweek", "month" and
+"hourin", a list of names or hours (see time_ops.go):
+
+	{"attrName": "received", "op": "olderthan", "attrVal": "30d"}
+	{"attrName": "received", "op": "dayofweek", "attrVal": ["sat", "sun"]}
+
+For "hasany", "hasall" and "hasnone", "attrVal" is a list of elements of the list attribute, and
+for the count operators, an integer (see list_ops.go):
+
+	{"attrName": "tags", "op": "hasall", "attrVal": ["gold", "nri"]}
+	{"attrName": "holdings", "op": "countgt", "attrVal": 2}
+
+For "exists" and "notexists", "attrVal" is omitted or null. For the groups "any", "all" and
+"not", "attrName" is omitted and "attrVal" is a list of terms, which may themselves be groups:
+
+	{"op": "any", "attrVal": [
+	  {"attrName": "cat", "op": "eq", "attrVal": "textbook"},
+	  {"op": "not", "attrVal": [{"attrName": "mrp", "op": "gt", "attrVal": 50}]}
+	]}
+
+A ruleset's missing-attribute policy is given by "missingAttrs", which may be omitted for the
+default:
+
+	{"ver": 1, "class": "inventoryitem", "setName": "main", "missingAttrs": "unknown", "rules": [...]}
+*/
+
+package crux
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+)
+
+type attrSchemaJSON struct {
+	Name      string   `json:"name"`
+	ValType   string   `json:"valType"`
+	Vals      []string `json:"vals,omitempty"`
+	ValMin    float64  `json:"valMin,omitempty"`
+	ValMax    float64  `json:"valMax,omitempty"`
+	LenMin    int      `json:"lenMin,omitempty"`
+	LenMax    int      `json:"lenMax,omitempty"`
+	Layouts   []string `json:"layouts,omitempty"`
+	TZ        string   `json:"tz,omitempty"`
+	Currency  string   `json:"currency,omitempty"`
+	Precision int      `json:"precision,omitempty"`
+	Scale     int      `json:"scale,omitempty"`
+	ElemType  string   `json:"elemType,omitempty"`
+	Required  bool     `json:"required,omitempty"`
+	Default   string   `json:"default,omitempty"`
+}
+
+// MarshalJSON encodes the set of valid values of an enum as a sorted list
+func (as AttrSchema) MarshalJSON() ([]byte, error) {
+	asj := attrSchemaJSON{as.Name, as.ValType, nil, as.ValMin, as.ValMax, as.LenMin, as.LenMax, as.Layouts, as.TZ,
+		as.Currency, as.Precision, as.Scale, as.ElemType, as.Required, as.Default}
+	for val := range as.Vals {
+		asj.Vals = append(asj.Vals, val)
+	}
+	sort.Strings(asj.Vals)
+	return json.Marshal(asj)
+}
+
+func (as *AttrSchema) UnmarshalJSON(data []byte) error {
+	var asj attrSchemaJSON
+	if err := json.Unmarshal(data, &asj); err != nil {
+		return err
+	}
+	*as = AttrSchema{asj.Name, asj.ValType, nil, asj.ValMin, asj.ValMax, asj.LenMin, asj.LenMax, asj.Layouts, asj.TZ,
+		asj.Currency, asj.Precision, asj.Scale, asj.ElemType, asj.Required, asj.Default}
+	if asj.Vals != nil {
+		as.Vals = map[string]bool{}
+		for _, val := range asj.Vals {
+			as.Vals[val] = true
+		}
+	}
+	return nil
+}
+
+// ParseRuleSchemaJSON decodes a RuleSchema from data
+func ParseRuleSchemaJSON(data []byte) (RuleSchema, error) {
+	var rs RuleSchema
+	if err := json.Unmarshal(data, &rs); err != nil {
+		return RuleSchema{}, fmt.Errorf("error decoding schema: %w", err)
+	}
+	return rs, nil
+}
+
+// ParseRuleSetJSON decodes a RuleSet from data. The value in each rule-pattern term is converted
+// to the Go type used for its attribute's value-type in schema, which must be the schema for
+// the ruleset's class.
+func ParseRuleSetJSON(data []byte, schema RuleSchema) (RuleSet, error) {
+	var rs RuleSet
+	d := json.NewDecoder(bytes.NewReader(data))
+	d.UseNumber()
+	if err := d.Decode(&rs); err != nil {
+		return RuleSet{}, fmt.Errorf("error decoding ruleset: %w", err)
+	}
+	if rs.Class != schema.Class {
+		return RuleSet{}, fmt.Errorf("ruleset %v is of class %v, but the schema is for class %v",
+			rs.SetName, rs.Class, schema.Class)
+	}
+	for i, rule := range rs.Rules {
+		for j, term := range rule.RulePattern {
+			var err error
+			if rs.Rules[i].RulePattern[j], err = decodeTerm(term, schema); err != nil {
+				return RuleSet{}, fmt.Errorf("error decoding rule %v of ruleset %v: %w", i, rs.SetName, err)
+			}
+		}
+	}
+	return rs, nil
+}
+
+// Converts the value of term, as decoded by a json.Decoder with UseNumber() set, to the Go type
+// for its attribute's value-type in schema. The value of an any, all or not group is decoded into
+// its terms, which are converted in turn, and the value of a term with no attribute name into an
+// Arith.
+func decodeTerm(term RulePatternTerm, schema RuleSchema) (RulePatternTerm, error) {
+	if groupOps[term.Op] {
+		data, err := json.Marshal(term.AttrVal)
+		if err != nil {
+			return RulePatternTerm{}, err
+		}
+		var terms []RulePatternTerm
+		d := json.NewDecoder(bytes.NewReader(data))
+		d.UseNumber()
+		if err := d.Decode(&terms); err != nil {
+			return RulePatternTerm{}, fmt.Errorf("error decoding terms of %v group: %w", term.Op, err)
+		}
+		for i := range terms {
+			if terms[i], err = decodeTerm(terms[i], schema); err != nil {
+				return RulePatternTerm{}, err
+			}
+		}
+		term.AttrVal = terms
+		return term, nil
+	}
+	if obj, ok := term.AttrVal.(map[string]any); ok && term.AttrName == "" {
+		left, leftOK := obj["left"].(string)
+		right, rightOK := obj["right"].(string)
+		if !leftOK || !rightOK || len(obj) != 2 {
+			return RulePatternTerm{}, fmt.Errorf("%v is not a pair of expressions", term.AttrVal)
+		}
+		term.AttrVal = Arith{left, right}
+		return term, nil
+	}
+	valType := getTermType(schema, term.AttrName)
+	if valType == "" {
+		return RulePatternTerm{}, fmt.Errorf("attribute does not exist in schema: %v", term.AttrName)
+	}
+	if valType == TypeList && listOps[term.Op] {
+		// The value is a list of elements, or a count
+		valType = listOpValType(term.Op, getAttrSchema(schema, term.AttrName).ElemType)
+	}
+	val, err := decodeTermVal(term.AttrVal, valType, term.Op)
+	if err != nil {
+		return RulePatternTerm{}, fmt.Errorf("error decoding value of %v: %w", term.AttrName, err)
+	}
+	term.AttrVal = val
+	return term, nil
+}
+
+// ParseRuleSetJSON decodes a RuleSet from data using the engine's schema for the ruleset's class
+func (e *Engine) ParseRuleSetJSON(data []byte) (RuleSet, error) {
+	var header struct {
+		Class string `json:"class"`
+	}
+	if err := json.Unmarshal(data, &header); err != nil {
+		return RuleSet{}, fmt.Errorf("error decoding ruleset: %w", err)
+	}
+	schema, err := e.registry.load().getSchema(header.Class)
+	if err != nil {
+		return RuleSet{}, err
+	}
+	return ParseRuleSetJSON(data, schema)
+}
+
+// Converts val, the value of a term with op, to the Go type for valType, or for in and nin, to a
+// list of values of that type. The values of terms with timeOps are converted as described in
+// time_ops.go. An object {"attr": name} is converted to an AttrRef, and an object
+// {"expr": src} to an Expr. The value of an exists or notexists term is left for verification,
+// which requires it to be absent.
+func decodeTermVal(val any, valType string, op string) (any, error) {
+	if op == OpExists || op == OpNotExists {
+		return val, nil
+	}
+	if obj, ok := val.(map[string]any); ok {
+		if src, ok := obj["expr"].(string); ok && len(obj) == 1 {
+			return Expr{src}, nil
+		}
+		attr, ok := obj["attr"].(string)
+		if !ok || len(obj) != 1 {
+			return nil, fmt.Errorf("%v is not an attribute reference or expression", val)
+		}
+		return AttrRef{attr}, nil
+	}
+	if timeOps[op] && valType == TypeTS {
+		// The value is a duration string, a time.Duration encoded as nanoseconds, or a list of
+		// names or hours
+		valType = timeOpValType(op)
+		if n, ok := val.(json.Number); ok && !calendarOps[op] {
+			d, err := n.Int64()
+			return time.Duration(d), err
+		} else if !calendarOps[op] {
+			return decodeAttrVal(val, valType)
+		}
+	} else if !setOps[op] && !hasOps[op] {
+		return decodeAttrVal(val, valType)
+	}
+	elems, ok := val.([]any)
+	if !ok {
+		return nil, fmt.Errorf("%v is not a list", val)
+	}
+	list := make([]any, len(elems))
+	for i, elem := range elems {
+		var err error
+		if list[i], err = decodeAttrVal(elem, valType); err != nil {
+			return nil, err
+		}
+	}
+	return list, nil
+}
+
+// Converts val, as decoded by a json.Decoder with UseNumber() set, to the Go type for valType
+func decodeAttrVal(val any, valType string) (any, error) {
+	var conv any
+	var err error
+	switch valType {
+	case TypeBool:
+		conv, err = decodeAs[bool](val, "a boolean")
+	case TypeInt:
+		var n json.Number
+		if n, err = decodeAs[json.Number](val, "an integer"); err == nil {
+			var i int64
+			i, err = n.Int64()
+			conv = int(i)
+		}
+	case TypeFloat:
+		var n json.Number
+		if n, err = decodeAs[json.Number](val, "a number"); err == nil {
+			conv, err = n.Float64()
+		}
+	case TypeStr, TypeEnum:
+		conv, err = decodeAs[string](val, "a string")
+	case TypeTS, TypeDate:
+		var s string
+		if s, err = decodeAs[string](val, "a timestamp string"); err == nil {
+			conv, err = termTime(s, valType)
+		}
+	case TypeDecimal:
+		// A string with a currency code is kept as it is, for verifyRuleSet() to check the code
+		var s, code string
+		if n, ok := val.(json.Number); ok {
+			conv, err = ParseDecimal(n.String())
+		} else if s, err = decodeAs[string](val, "a decimal number or string"); err == nil {
+			if conv, code, err = termDecimal(s); code != "" {
+				conv = s
+			}
+		}
+	default:
+		err = fmt.Errorf("%v is not a valid value-type", valType)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return conv, nil
+}
+
+func decodeAs[T any](val any, want string) (T, error) {
+	v, ok := val.(T)
+	if !ok {
+		return v, fmt.Errorf("%v is not %v", val, want)
+	}
+	return v, nil
+}
@@ -0,0 +1,339 @@
+package crux
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+var inventoryItemSchemaJSON = []byte(`{
+	"class": "inventoryitem",
+	"patternSchema": [
+		{"name": "cat", "valType": "enum", "vals": ["textbook", "refbook"]},
+		{"name": "fullname", "valType": "str", "lenMin": 1, "lenMax": 80},
+		{"name": "ageinstock", "valType": "int", "valMin": 0, "valMax": 365},
+		{"name": "mrp", "valType": "float"},
+		{"name": "received", "valType": "ts"},
+		{"name": "bulkorder", "valType": "bool"}
+	],
+	"actionSchema": {"tasks": ["dodiscount"], "properties": ["discount"]}
+}`)
+
+func TestRuleSchemaJSON(t *testing.T) {
+	rs, err := ParseRuleSchemaJSON(inventoryItemSchemaJSON)
+	if err != nil {
+		t.Fatalf("ParseRuleSchemaJSON() error = %v", err)
+	}
+	want := RuleSchema{
+		Class: inventoryItemClass,
+		PatternSchema: []AttrSchema{
+			{Name: "cat", ValType: TypeEnum, Vals: map[string]bool{"textbook": true, "refbook": true}},
+			{Name: "fullname", ValType: TypeStr, LenMin: 1, LenMax: 80},
+			{Name: "ageinstock", ValType: TypeInt, ValMin: 0, ValMax: 365},
+			{Name: "mrp", ValType: TypeFloat},
+			{Name: "received", ValType: TypeTS},
+			{Name: "bulkorder", ValType: TypeBool},
+		},
+		ActionSchema: ActionSchema{Tasks: []string{"dodiscount"}, Properties: []string{"discount"}},
+	}
+	if !reflect.DeepEqual(rs, want) {
+		t.Errorf("ParseRuleSchemaJSON() = %v, want %v", rs, want)
+	}
+
+	// Round trip
+	data, err := json.Marshal(rs)
+	if err != nil {
+		t.Fatalf("json.Marshal() error = %v", err)
+	}
+	rs2, err := ParseRuleSchemaJSON(data)
+	if err != nil || !reflect.DeepEqual(rs2, rs) {
+		t.Errorf("round trip gave %v, %v, want %v", rs2, err, rs)
+	}
+
+	// Layouts and time zones of ts and date attributes, the currency, precision and scale of
+	// decimals, the element type of lists, and required attributes and defaults
+	data = []byte(`{"class": "shipment", "patternSchema": [
+		{"name": "shipped", "valType": "ts", "layouts": ["02/01/2006 15:04"], "tz": "Asia/Kolkata", "required": true},
+		{"name": "due", "valType": "date", "tz": "America/New_York", "default": "2024-01-01"},
+		{"name": "charge", "valType": "decimal", "currency": "INR", "precision": 10, "scale": 2},
+		{"name": "flags", "valType": "list", "elemType": "enum", "vals": ["fragile", "urgent"]}
+	], "actionSchema": {"tasks": ["chase"], "properties": ["carrier"],
+		"propertySchema": [{"name": "carrier", "valType": "enum", "vals": ["dhl", "ups"]}]}}`)
+	rs, err = ParseRuleSchemaJSON(data)
+	want = RuleSchema{
+		Class: "shipment",
+		PatternSchema: []AttrSchema{
+			{Name: "shipped", ValType: TypeTS, Layouts: []string{"02/01/2006 15:04"}, TZ: "Asia/Kolkata", Required: true},
+			{Name: "due", ValType: TypeDate, TZ: "America/New_York", Default: "2024-01-01"},
+			{Name: "charge", ValType: TypeDecimal, Currency: "INR", Precision: 10, Scale: 2},
+			{Name: "flags", ValType: TypeList, ElemType: TypeEnum, Vals: map[string]bool{"fragile": true, "urgent": true}},
+		},
+		ActionSchema: ActionSchema{Tasks: []string{"chase"}, Properties: []string{"carrier"},
+			PropertySchema: []AttrSchema{{Name: "carrier", ValType: TypeEnum, Vals: map[string]bool{"dhl": true, "ups": true}}}},
+	}
+	if err != nil || !reflect.DeepEqual(rs, want) {
+		t.Errorf("ParseRuleSchemaJSON() = %v, %v, want %v", rs, err, want)
+	}
+	data, _ = json.Marshal(rs)
+	if rs2, err := ParseRuleSchemaJSON(data); err != nil || !reflect.DeepEqual(rs2, rs) {
+		t.Errorf("round trip gave %v, %v, want %v", rs2, err, rs)
+	}
+}
+
+var inventoryItemRuleSetJSON = []byte(`{
+	"ver": 1,
+	"class": "inventoryitem",
+	"setName": "main",
+	"rules": [{
+		"rulePattern": [
+			{"attrName": "cat", "op": "eq", "attrVal": "textbook"},
+			{"attrName": "ageinstock", "op": "le", "attrVal": 7},
+			{"attrName": "mrp", "op": "lt", "attrVal": 51},
+			{"attrName": "received", "op": "gt", "attrVal": "2018-05-15T12:00:00Z"},
+			{"attrName": "bulkorder", "op": "eq", "attrVal": true},
+			{"attrName": "dodiscount", "op": "eq", "attrVal": false}
+		],
+		"ruleActions": {
+			"tasks": ["dodiscount"],
+			"properties": [{"name": "discount", "val": "10"}],
+			"willExit": true
+		}
+	}]
+}`)
+
+func TestRuleSetJSON(t *testing.T) {
+	schema, _ := ParseRuleSchemaJSON(inventoryItemSchemaJSON)
+	rs, err := ParseRuleSetJSON(inventoryItemRuleSetJSON, schema)
+	if err != nil {
+		t.Fatalf("ParseRuleSetJSON() error = %v", err)
+	}
+	receivedTime, _ := time.Parse(time.RFC3339, "2018-05-15T12:00:00Z")
+	want := RuleSet{Ver: 1, Class: inventoryItemClass, SetName: mainRS, Rules: []Rule{{
+		[]RulePatternTerm{
+			{"cat", OpEQ, "textbook"},
+			{"ageinstock", OpLE, 7},
+			{"mrp", OpLT, 51.0},
+			{"received", OpGT, receivedTime},
+			{"bulkorder", OpEQ, true},
+			{"dodiscount", OpEQ, false},
+		},
+		RuleActions{
+			Tasks:      []string{"dodiscount"},
+			Properties: []Property{{"discount", "10"}},
+			WillExit:   true,
+		},
+	}}}
+	if !reflect.DeepEqual(rs, want) {
+		t.Errorf("ParseRuleSetJSON() = %v, want %v", rs, want)
+	}
+
+	// The values of in and nin terms are lists
+	data := []byte(`{"ver": 1, "class": "inventoryitem", "setName": "lists", "rules": [{"rulePattern": [
+		{"attrName": "cat", "op": "in", "attrVal": ["textbook", "refbook"]},
+		{"attrName": "mrp", "op": "nin", "attrVal": [1, 2.5]}
+	], "ruleActions": {}}]}`)
+	lists, err := ParseRuleSetJSON(data, schema)
+	wantPattern := []RulePatternTerm{{"cat", OpIn, []any{"textbook", "refbook"}}, {"mrp", OpNIn, []any{1.0, 2.5}}}
+	if err != nil || !reflect.DeepEqual(lists.Rules[0].RulePattern, wantPattern) {
+		t.Errorf("ParseRuleSetJSON() = %v, %v, want pattern %v", lists, err, wantPattern)
+	}
+
+	// exists and notexists terms have no value, and the missing-attribute policy is optional
+	data = []byte(`{"ver": 1, "class": "inventoryitem", "setName": "exists", "missingAttrs": "nomatch",
+		"rules": [{"rulePattern": [
+		{"attrName": "cat", "op": "exists"},
+		{"attrName": "mrp", "op": "notexists", "attrVal": null}
+	], "ruleActions": {}}]}`)
+	exists, err := ParseRuleSetJSON(data, schema)
+	wantPattern = []RulePatternTerm{{"cat", OpExists, nil}, {"mrp", OpNotExists, nil}}
+	if err != nil || exists.MissingAttrs != MissingNoMatch || !reflect.DeepEqual(exists.Rules[0].RulePattern, wantPattern) {
+		t.Errorf("ParseRuleSetJSON() = %v, %v, want policy nomatch and pattern %v", exists, err, wantPattern)
+	}
+
+	// The terms of groups are decoded in turn, and {"attr": name} refers to another attribute
+	data = []byte(`{"ver": 1, "class": "inventoryitem", "setName": "groups", "rules": [{"rulePattern": [
+		{"op": "any", "attrVal": [
+			{"attrName": "ageinstock", "op": "lt", "attrVal": 3},
+			{"op": "not", "attrVal": [{"attrName": "mrp", "op": "ge", "attrVal": 50}]}
+		]},
+		{"attrName": "ageinstock", "op": "gt", "attrVal": {"attr": "ageinstock"}}
+	], "ruleActions": {}}]}`)
+	groups, err := ParseRuleSetJSON(data, schema)
+	wantPattern = []RulePatternTerm{{"", OpAny, []RulePatternTerm{
+		{"ageinstock", OpLT, 3},
+		{"", OpNot, []RulePatternTerm{{"mrp", OpGE, 50.0}}},
+	}}, {"ageinstock", OpGT, AttrRef{"ageinstock"}}}
+	if err != nil || !reflect.DeepEqual(groups.Rules[0].RulePattern, wantPattern) {
+		t.Errorf("ParseRuleSetJSON() = %v, %v, want pattern %v", groups, err, wantPattern)
+	}
+	data, _ = json.Marshal(groups)
+	if groups2, err := ParseRuleSetJSON(data, schema); err != nil || !reflect.DeepEqual(groups2, groups) {
+		t.Errorf("round trip gave %v, %v, want %v", groups2, err, groups)
+	}
+
+	// {"expr": src} is an expression, and a term without an attribute name compares two
+	data = []byte(`{"ver": 1, "class": "inventoryitem", "setName": "exprs", "rules": [{"rulePattern": [
+		{"attrName": "ageinstock", "op": "ge", "attrVal": {"expr": "ageinstock * 2"}},
+		{"op": "gt", "attrVal": {"left": "mrp * ageinstock", "right": "10000"}}
+	], "ruleActions": {}}]}`)
+	exprs, err := ParseRuleSetJSON(data, schema)
+	wantPattern = []RulePatternTerm{{"ageinstock", OpGE, Expr{"ageinstock * 2"}}, {"", OpGT, Arith{"mrp * ageinstock", "10000"}}}
+	if err != nil || !reflect.DeepEqual(exprs.Rules[0].RulePattern, wantPattern) {
+		t.Errorf("ParseRuleSetJSON() = %v, %v, want pattern %v", exprs, err, wantPattern)
+	}
+	data, _ = json.Marshal(exprs)
+	if exprs2, err := ParseRuleSetJSON(data, schema); err != nil || !reflect.DeepEqual(exprs2, exprs) {
+		t.Errorf("round trip gave %v, %v, want %v", exprs2, err, exprs)
+	}
+
+	// Durations are strings, or nanoseconds as a time.Duration is encoded, calendar values are
+	// lists, and timestamps may be dates or have an offset
+	data = []byte(`{"ver": 1, "class": "inventoryitem", "setName": "times", "rules": [{"rulePattern": [
+		{"attrName": "received", "op": "within", "attrVal": "30d"},
+		{"attrName": "received", "op": "olderthan", "attrVal": 3600000000000},
+		{"attrName": "received", "op": "dayofweek", "attrVal": ["sat", "sun"]},
+		{"attrName": "received", "op": "hourin", "attrVal": [22, 6]},
+		{"attrName": "received", "op": "lt", "attrVal": "2018-05-16"},
+		{"attrName": "received", "op": "gt", "attrVal": "2018-05-15T17:30:00+05:30"}
+	], "ruleActions": {}}]}`)
+	times, err := ParseRuleSetJSON(data, schema)
+	wantPattern = []RulePatternTerm{{"received", OpWithin, "30d"}, {"received", OpOlderThan, time.Hour},
+		{"received", OpDayOfWeek, []any{"sat", "sun"}}, {"received", OpHourIn, []any{22, 6}},
+		{"received", OpLT, time.Date(2018, 5, 16, 0, 0, 0, 0, time.UTC)},
+		{"received", OpGT, time.Date(2018, 5, 15, 12, 0, 0, 0, time.UTC)}}
+	if err != nil || !reflect.DeepEqual(times.Rules[0].RulePattern, wantPattern) {
+		t.Errorf("ParseRuleSetJSON() = %v, %v, want pattern %v", times, err, wantPattern)
+	}
+	data, _ = json.Marshal(times)
+	if times2, err := ParseRuleSetJSON(data, schema); err != nil || !reflect.DeepEqual(times2, times) {
+		t.Errorf("round trip gave %v, %v, want %v", times2, err, times)
+	}
+
+	// The decoded ruleset must match the entity that the equivalent Go literal matches
+	e := NewEngine()
+	e.AddRuleSchema(schema)
+	if err := e.VerifyRuleSet(rs, false); err != nil {
+		t.Errorf("VerifyRuleSet() error = %v", err)
+	}
+	e.AddRuleSet(rs)
+	got, err := e.Match(sampleEntity, mainRS)
+	if err != nil || !reflect.DeepEqual(got.Tasks, []string{"dodiscount"}) {
+		t.Errorf("Match() = %v, %v, want task dodiscount", got, err)
+	}
+
+	// Round trip through the engine, which finds the schema by class
+	data, err = json.Marshal(rs)
+	if err != nil {
+		t.Fatalf("json.Marshal() error = %v", err)
+	}
+	rs2, err := e.ParseRuleSetJSON(data)
+	if err != nil || !reflect.DeepEqual(rs2, rs) {
+		t.Errorf("round trip gave %v, %v, want %v", rs2, err, rs)
+	}
+}
+
+// Decimal values are read exactly from numbers and strings, and encoded as strings. A string
+// with a currency code is kept as it is.
+func TestRuleSetJSONDecimals(t *testing.T) {
+	schema := RuleSchema{Class: "invoice", PatternSchema: []AttrSchema{
+		{Name: "amount", ValType: TypeDecimal, Currency: "INR"},
+	}, ActionSchema: ActionSchema{Tasks: []string{"audit"}}}
+	data := []byte(`{"ver": 1, "class": "invoice", "setName": "main", "rules": [{"rulePattern": [
+		{"attrName": "amount", "op": "gt", "attrVal": 50.80000000000000000001},
+		{"attrName": "amount", "op": "lt", "attrVal": "1000.50"},
+		{"attrName": "amount", "op": "ne", "attrVal": "INR 500.00"}
+	], "ruleActions": {"tasks": ["audit"]}}]}`)
+	rs, err := ParseRuleSetJSON(data, schema)
+	low, _ := ParseDecimal("50.80000000000000000001")
+	high, _ := ParseDecimal("1000.5")
+	wantPattern := []RulePatternTerm{{"amount", OpGT, low}, {"amount", OpLT, high}, {"amount", OpNE, "INR 500.00"}}
+	if err != nil || !reflect.DeepEqual(rs.Rules[0].RulePattern, wantPattern) {
+		t.Fatalf("ParseRuleSetJSON() = %v, %v, want pattern %v", rs, err, wantPattern)
+	}
+	data, _ = json.Marshal(rs)
+	if !strings.Contains(string(data), `"attrVal":"50.80000000000000000001"`) {
+		t.Errorf("json.Marshal() = %s, want decimals encoded as strings", data)
+	}
+	if rs2, err := ParseRuleSetJSON(data, schema); err != nil || !reflect.DeepEqual(rs2, rs) {
+		t.Errorf("round trip gave %v, %v, want %v", rs2, err, rs)
+	}
+	for _, attrVal := range []string{`1e3`, `"50,80"`, `true`, `"INR"`} {
+		data := []byte(`{"ver": 1, "class": "invoice", "setName": "main", "rules": [{"rulePattern": [
+			{"attrName": "amount", "op": "eq", "attrVal": ` + attrVal + `}], "ruleActions": {}}]}`)
+		if _, err := ParseRuleSetJSON(data, schema); err == nil {
+			t.Errorf("ParseRuleSetJSON() with value %v: expected but did not get error", attrVal)
+		}
+	}
+}
+
+// The value of hasany, hasall and hasnone is a list of elements, and of a count op, an integer
+func TestRuleSetJSONLists(t *testing.T) {
+	schema := RuleSchema{Class: "customer", PatternSchema: []AttrSchema{
+		{Name: "tags", ValType: TypeList, ElemType: TypeEnum, Vals: map[string]bool{"gold": true, "nri": true}},
+		{Name: "accts", ValType: TypeList, ElemType: TypeInt},
+	}, ActionSchema: ActionSchema{Tasks: []string{"review"}}}
+	data := []byte(`{"ver": 1, "class": "customer", "setName": "main", "rules": [{"rulePattern": [
+		{"attrName": "tags", "op": "hasall", "attrVal": ["gold", "nri"]},
+		{"attrName": "accts", "op": "hasnone", "attrVal": [101, 102]},
+		{"attrName": "accts", "op": "countgt", "attrVal": 2}
+	], "ruleActions": {"tasks": ["review"]}}]}`)
+	rs, err := ParseRuleSetJSON(data, schema)
+	wantPattern := []RulePatternTerm{{"tags", OpHasAll, []any{"gold", "nri"}}, {"accts", OpHasNone, []any{101, 102}},
+		{"accts", OpCountGT, 2}}
+	if err != nil || !reflect.DeepEqual(rs.Rules[0].RulePattern, wantPattern) {
+		t.Fatalf("ParseRuleSetJSON() = %v, %v, want pattern %v", rs, err, wantPattern)
+	}
+	data, _ = json.Marshal(rs)
+	if rs2, err := ParseRuleSetJSON(data, schema); err != nil || !reflect.DeepEqual(rs2, rs) {
+		t.Errorf("round trip gave %v, %v, want %v", rs2, err, rs)
+	}
+	for _, term := range []string{
+		`{"attrName": "tags", "op": "hasany", "attrVal": "gold"}`,
+		`{"attrName": "accts", "op": "hasany", "attrVal": ["101"]}`,
+		`{"attrName": "accts", "op": "counteq", "attrVal": 1.5}`,
+		`{"attrName": "accts", "op": "counteq", "attrVal": [1]}`,
+	} {
+		data := []byte(`{"ver": 1, "class": "customer", "setName": "main", "rules": [{"rulePattern": [` +
+			term + `], "ruleActions": {}}]}`)
+		if _, err := ParseRuleSetJSON(data, schema); err == nil {
+			t.Errorf("ParseRuleSetJSON() with term %v: expected but did not get error", term)
+		}
+	}
+}
+
+func TestRuleSetJSONErrors(t *testing.T) {
+	schema, _ := ParseRuleSchemaJSON(inventoryItemSchemaJSON)
+	tests := []struct {
+		name string
+		term string
+	}{
+		{"fraction for int", `{"attrName": "ageinstock", "op": "eq", "attrVal": 7.5}`},
+		{"string for int", `{"attrName": "ageinstock", "op": "eq", "attrVal": "7"}`},
+		{"number for enum", `{"attrName": "cat", "op": "eq", "attrVal": 3}`},
+		{"bad timestamp", `{"attrName": "received", "op": "eq", "attrVal": "15/05/2018"}`},
+		{"string for task", `{"attrName": "dodiscount", "op": "eq", "attrVal": "true"}`},
+		{"unknown attribute", `{"attrName": "colour", "op": "eq", "attrVal": "red"}`},
+		{"scalar for in", `{"attrName": "cat", "op": "in", "attrVal": "textbook"}`},
+		{"wrong type in list", `{"attrName": "ageinstock", "op": "nin", "attrVal": [1, "2"]}`},
+		{"bad attribute reference", `{"attrName": "ageinstock", "op": "gt", "attrVal": {"name": "mrp"}}`},
+		{"group of values", `{"op": "any", "attrVal": [1, 2]}`},
+		{"list for duration", `{"attrName": "received", "op": "within", "attrVal": ["30d"]}`},
+		{"fractional nanoseconds", `{"attrName": "received", "op": "within", "attrVal": 1.5}`},
+		{"hours not ints", `{"attrName": "received", "op": "hourin", "attrVal": ["9", "17"]}`},
+		{"expression not a string", `{"attrName": "ageinstock", "op": "gt", "attrVal": {"expr": 5}}`},
+		{"one expression", `{"op": "gt", "attrVal": {"left": "mrp * ageinstock"}}`},
+		{"expressions not strings", `{"op": "gt", "attrVal": {"left": 1, "right": 2}}`},
+		{"bad term in group", `{"op": "not", "attrVal": [{"attrName": "ageinstock", "op": "eq", "attrVal": 7.5}]}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := []byte(`{"ver": 1, "class": "inventoryitem", "setName": "main", "rules": [{"rulePattern": [` +
+				tt.term + `], "ruleActions": {}}]}`)
+			if _, err := ParseRuleSetJSON(data, schema); err == nil {
+				t.Errorf("ParseRuleSetJSON(): expected but did not get error")
+			}
+		})
+	}
+}
@@ -0,0 +1,185 @@
+/*
+This file contains list attributes, whose values are lists of enum, str or int elements, such as
+the tags of a customer or the instruments held in an account, and the operators on them:
+
+	hasany      the value is a non-empty list of elements; the attribute has at least one of them
+	hasall      the value is a non-empty list of elements; the attribute has all of them
+	hasnone     the value is a non-empty list of elements; the attribute has none of them
+	counteq, countne, countlt, countle, countgt, countge
+	            the value is an int; the number of elements of the attribute compares with it as
+	            eq, ne, lt, le, gt or ge would
+
+The type of the elements is AttrSchema.ElemType, and the elements of an enum list must be in
+AttrSchema.Vals:
+
+	{Name: "tags", ValType: TypeList, ElemType: TypeEnum, Vals: map[string]bool{"gold": true, "nri": true}}
+
+In an entity, the value of a list attribute is a JSON array of its elements, such as
+["gold", "nri"] or [1, 5], which EncodeList() writes. The elements of an int list may also be
+strings of digits. [] is an empty list, and like other attributes, an empty value is missing.
+When an entity is decoded from JSON, "val" may be the array itself rather than a string:
+
+	{"name": "tags", "val": ["gold", "nri"]}
+
+No other operators apply to list attributes, except exists and notexists.
+*/
+
+package crux
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+const (
+	OpHasAny  = "hasany"
+	OpHasAll  = "hasall"
+	OpHasNone = "hasnone"
+
+	OpCountEQ = "counteq"
+	OpCountNE = "countne"
+	OpCountLT = "countlt"
+	OpCountLE = "countle"
+	OpCountGT = "countgt"
+	OpCountGE = "countge"
+
+	// The prefix of the count operators, which is written as a separate word in the rule language
+	countPrefix = "count"
+)
+
+// The operators whose value is a list of elements
+var hasOps = map[string]bool{OpHasAny: true, OpHasAll: true, OpHasNone: true}
+
+// The count operators, and the operator each compares the number of elements with
+var countOps = map[string]string{
+	OpCountEQ: OpEQ, OpCountNE: OpNE, OpCountLT: OpLT, OpCountLE: OpLE, OpCountGT: OpGT, OpCountGE: OpGE,
+}
+
+// The operators on list attributes
+var listOps = map[string]bool{
+	OpHasAny: true, OpHasAll: true, OpHasNone: true,
+	OpCountEQ: true, OpCountNE: true, OpCountLT: true, OpCountLE: true, OpCountGT: true, OpCountGE: true,
+}
+
+// The value-types of the elements of list attributes
+var elemTypes = map[string]bool{TypeEnum: true, TypeStr: true, TypeInt: true}
+
+// EncodeList returns elems as the value of a list attribute in an entity
+func EncodeList(elems ...string) string {
+	data, _ := json.Marshal(append([]string{}, elems...))
+	return string(data)
+}
+
+// UnmarshalJSON accepts the value of a list attribute as a JSON array, as well as a string
+func (a *Attr) UnmarshalJSON(data []byte) error {
+	var aj struct {
+		Name string          `json:"name"`
+		Val  json.RawMessage `json:"val"`
+	}
+	if err := json.Unmarshal(data, &aj); err != nil {
+		return err
+	}
+	*a = Attr{Name: aj.Name}
+	val := bytes.TrimSpace(aj.Val)
+	if len(val) == 0 {
+		return nil
+	}
+	if val[0] == '[' {
+		var b bytes.Buffer
+		if err := json.Compact(&b, val); err != nil {
+			return err
+		}
+		a.Val = b.String()
+		return nil
+	}
+	return json.Unmarshal(val, &a.Val)
+}
+
+// Returns the value-type of the value of a term with op, one of listOps, on a list attribute whose
+// elements are of elemType
+func listOpValType(op string, elemType string) string {
+	if hasOps[op] {
+		return elemType
+	}
+	return TypeInt
+}
+
+// Parses the entity value s of a list attribute with the format af into a []any of elements of
+// the attribute's element type
+func parseListVal(s string, af *attrFormat) ([]any, error) {
+	d := json.NewDecoder(strings.NewReader(s))
+	d.UseNumber()
+	var elems []any
+	if err := d.Decode(&elems); err != nil || elems == nil {
+		return nil, fmt.Errorf("%v is not a list", s)
+	}
+	if _, err := d.Token(); err != io.EOF {
+		return nil, fmt.Errorf("%v is not a list", s)
+	}
+	elemType := TypeStr
+	if af != nil {
+		elemType = af.elemType
+	}
+	for i, elem := range elems {
+		var err error
+		switch v := elem.(type) {
+		case json.Number:
+			if elemType == TypeInt {
+				elems[i], err = strconv.Atoi(v.String())
+			} else {
+				err = errors.New("not a string")
+			}
+		case string:
+			if elemType == TypeInt {
+				elems[i], err = strconv.Atoi(v)
+			}
+		default:
+			err = errors.New("not a valid element")
+		}
+		if err != nil {
+			return nil, fmt.Errorf("element %v of %v: %w", i, s, err)
+		}
+	}
+	return elems, nil
+}
+
+// Returns whether the list entityAttrValConv satisfies a term with op and the value termAttrVal,
+// which is a list or valSet of elements for hasany, hasall and hasnone, and an int for the count
+// operators. Any other op fails with an error, as does a value that is not a list.
+func compareLists(entityAttrValConv any, termAttrVal any, op string) (bool, error) {
+	if !listOps[op] {
+		return false, fmt.Errorf("%v does not apply to list attributes", op)
+	}
+	list, ok := entityAttrValConv.([]any)
+	if !ok {
+		return false, errors.New(op + " applies only to list attributes")
+	}
+	if countOp, ok := countOps[op]; ok {
+		return compareVals(len(list), termAttrVal, TypeInt, countOp)
+	}
+	set, ok := termAttrVal.(valSet)
+	if !ok {
+		var err error
+		if set, err = makeValSet(termAttrVal); err != nil {
+			return false, err
+		}
+	}
+	found := valSet{}
+	for _, elem := range list {
+		if set[elem] {
+			found[elem] = true
+		}
+	}
+	switch op {
+	case OpHasAny:
+		return len(found) > 0, nil
+	case OpHasAll:
+		return len(found) == len(set), nil
+	}
+	return len(found) == 0, nil
+}
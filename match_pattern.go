@@ -1,113 +1,356 @@
 /* This file contains matchPattern(), and helper functions called by matchPattern() */
 
-package main
+package crux
 
 import (
 	"errors"
 	"fmt"
+	"math"
+	"reflect"
+	"regexp"
 	"strconv"
+	"strings"
 	"time"
 )
 
 const (
-	typeBool  = "bool"
-	typeInt   = "int"
-	typeFloat = "float"
-	typeStr   = "str"
-	typeEnum  = "enum"
-	typeTS    = "ts"
-
-	timeLayout = "2006-01-02T15:04:05Z"
-
-	opEQ = "eq"
-	opNE = "ne"
-	opLT = "lt"
-	opLE = "le"
-	opGT = "gt"
-	opGE = "ge"
+	TypeBool  = "bool"
+	TypeInt   = "int"
+	TypeFloat = "float"
+	TypeStr   = "str"
+	TypeEnum  = "enum"
+	TypeTS    = "ts"
+	TypeDate  = "date"
+	// An exact decimal number, such as an amount of money (see decimal.go)
+	TypeDecimal = "decimal"
+	// A list of enum, str or int elements (see list_ops.go)
+	TypeList = "list"
+
+	OpEQ = "eq"
+	OpNE = "ne"
+	OpLT = "lt"
+	OpLE = "le"
+	OpGT = "gt"
+	OpGE = "ge"
+
+	// Set-membership: the term's value is a list, and the entity's value must be (in) or must
+	// not be (nin) one of its elements
+	OpIn  = "in"
+	OpNIn = "nin"
+
+	// For str attributes only. matches is true if the regular expression matches any part of the
+	// entity's value; use ^ and $ to match all of it.
+	OpContains   = "contains"
+	OpStartsWith = "startswith"
+	OpEndsWith   = "endswith"
+	OpMatches    = "matches"
+
+	// Whether or not the entity has the attribute. These terms have no value.
+	OpExists    = "exists"
+	OpNotExists = "notexists"
+
+	// Groups of terms. The term has no attribute name, and its value is a non-empty
+	// []RulePatternTerm: any is true if any of the terms is, all if all of them are, and not if
+	// they are not all true. A rule-pattern is itself an all group.
+	OpAny = "any"
+	OpAll = "all"
+	OpNot = "not"
+
+	// Missing-attribute policies, for RuleSet.MissingAttrs. A term on an attribute in the
+	// pattern-schema that the entity does not have is: compared as if the attribute were a task
+	// (MissingDefault), unknown (MissingUnknown), false (MissingNoMatch), or an error that ends the
+	// match (MissingError). exists and notexists terms are never affected.
+	MissingDefault = ""
+	MissingUnknown = "unknown"
+	MissingNoMatch = "nomatch"
+	MissingError   = "error"
 
 	trueStr  = "true"
 	falseStr = "false"
 )
 
-func matchPattern(entity Entity, rulePattern []RulePatternTerm, actionSet ActionSet) (bool, error) {
-	for _, term := range rulePattern {
-		valType := ""
-		entityAttrVal := ""
-		for _, entityAttr := range entity.attrs {
-			if entityAttr.name == term.AttrName {
-				entityAttrVal = entityAttr.val
-				valType = getTypeFromSchema(entity.class, entityAttr.name)
-			}
-		}
-		if entityAttrVal == "" {
-			// Check whether the attribute name in the pattern term matches any of the tasks in
-			// the action-set
-			for _, task := range actionSet.tasks {
-				if task == term.AttrName {
-					entityAttrVal = trueStr
-					valType = typeBool
-				}
-			}
-		}
-		if entityAttrVal == "" {
-			entityAttrVal = falseStr
-			valType = typeBool
-		}
-		matched, err := makeComparison(entityAttrVal, term.AttrVal, valType, term.Op)
+var orderedTypes = map[string]bool{
+	TypeInt: true, TypeFloat: true, TypeTS: true, TypeStr: true, TypeDate: true, TypeDecimal: true,
+}
+
+var setOps = map[string]bool{OpIn: true, OpNIn: true}
+
+// The value-types whose attributes may be compared with in and nin. Decimal is left out on
+// purpose: equal decimals may be written with different scales and currencies, so membership
+// would need scale-aware comparison in the interpreter, in compiled terms and in the hashed keys
+// of the rule index. A decimal attribute is compared with a set of values by an any of eq terms.
+var setTypes = map[string]bool{TypeInt: true, TypeFloat: true, TypeStr: true, TypeEnum: true}
+
+// Returned by matchPattern() for a rule-pattern whose outcome is unknown, because it depends on
+// an attribute that the entity does not have. Such a rule neither matches nor fails to match, so
+// neither its actions nor its ElseCall are carried out.
+var errUnknown = errors.New("rule-pattern is unknown")
+
+var missingPolicies = map[string]bool{
+	MissingDefault: true, MissingUnknown: true, MissingNoMatch: true, MissingError: true,
+}
+
+var groupOps = map[string]bool{OpAny: true, OpAll: true, OpNot: true}
+
+// The operators that may compare two attributes, with an AttrRef as the term's value
+var refOps = map[string]bool{OpEQ: true, OpNE: true, OpLT: true, OpLE: true, OpGT: true, OpGE: true}
+
+var stringOps = map[string]bool{OpContains: true, OpStartsWith: true, OpEndsWith: true, OpMatches: true}
+
+// valSet holds the elements of the list in an in or nin term, for lookup
+type valSet map[any]bool
+
+// Returns whether or not entity matches rulePattern. missing is the missing-attribute policy of
+// the ruleset that rulePattern is in. Under MissingUnknown, a pattern in which no term is false but
+// some term is unknown is itself unknown, and errUnknown is returned.
+func (m *matcher) matchPattern(entity Entity, rulePattern []RulePatternTerm, actionSet ActionSet, missing string) (bool, error) {
+	matched, unknown, err := m.matchGroup(entity, OpAll, rulePattern, actionSet, missing)
+	if err != nil {
+		return false, fmt.Errorf("error making comparison %w", err)
+	}
+	if unknown {
+		return false, errUnknown
+	}
+	return matched, nil
+}
+
+// Evaluates the group of terms with op (any, all or not), and returns whether or not it matched
+// and whether it is unknown. The terms are evaluated in order, and evaluation stops at the first
+// term that decides the outcome, or fails with an error.
+func (m *matcher) matchGroup(entity Entity, op string, terms []RulePatternTerm, actionSet ActionSet,
+	missing string) (bool, bool, error) {
+	// A true term decides an any group, and a false term decides an all or not group
+	deciding := op == OpAny
+	unknown := false
+	for _, term := range terms {
+		tt, err := m.matchTerm(entity, term, actionSet, missing)
 		if err != nil {
-			return false, fmt.Errorf("error making comparison %w", err)
+			return false, false, err
 		}
-		if !matched {
-			return false, nil
+		if tt.Unknown {
+			unknown = true
+		} else if tt.Matched == deciding {
+			return deciding != (op == OpNot), false, nil
 		}
 	}
-	return true, nil
+	if unknown {
+		return false, true, nil
+	}
+	return !deciding != (op == OpNot), false, nil
 }
 
-func getTypeFromSchema(class string, attrName string) string {
-	for _, ruleSchema := range ruleSchemas {
-		if ruleSchema.class == class {
-			for _, attrSchema := range ruleSchema.patternSchema {
-				if attrSchema.name == attrName {
-					return attrSchema.valType
-				}
+// Evaluates term against entity under the missing-attribute policy missing. The result records
+// the entity value used, and whether the term matched or, under MissingUnknown, is unknown. Every
+// term other than a group is added to the trace of the current rule.
+func (m *matcher) matchTerm(entity Entity, term RulePatternTerm, actionSet ActionSet, missing string) (TermTrace, error) {
+	if groupOps[term.Op] {
+		tt := TermTrace{Term: term}
+		terms, _ := term.AttrVal.([]RulePatternTerm)
+		var err error
+		tt.Matched, tt.Unknown, err = m.matchGroup(entity, term.Op, terms, actionSet, missing)
+		return tt, err
+	}
+	tt, err := m.matchLeaf(entity, term, actionSet, missing)
+	m.currRule.addTerm(tt)
+	return tt, err
+}
+
+// Evaluates a term other than a group. A term that compares two attributes is evaluated under
+// the missing-attribute policy if the entity does not have either of them, and under
+// MissingDefault, fails with an error.
+func (m *matcher) matchLeaf(entity Entity, term RulePatternTerm, actionSet ActionSet, missing string) (TermTrace, error) {
+	if isExprTerm(term) {
+		return m.matchExprTerm(entity, term, actionSet, missing)
+	}
+	entityAttrVal, valType, valSource := m.getEntityAttrVal(entity, term.AttrName, actionSet)
+	tt := TermTrace{Term: term, EntityVal: entityAttrVal, ValType: valType, ValSource: valSource}
+	termAttrVal, absentAttr := term.AttrVal, ""
+	if pattern, ok := term.AttrVal.(string); ok && term.Op == OpMatches && m.store.regexps[pattern] != nil {
+		termAttrVal = m.store.regexps[pattern]
+	}
+	if valSource == valSourceMissing {
+		absentAttr = term.AttrName
+	}
+	ref, isRef := term.AttrVal.(AttrRef)
+	var err error
+	if isRef {
+		var refSource string
+		tt.RefVal, _, refSource = m.getEntityAttrVal(entity, ref.Attr, actionSet)
+		if refSource == valSourceMissing && absentAttr == "" {
+			absentAttr = ref.Attr
+		} else if absentAttr == "" {
+			if termAttrVal, err = convertEntityAttrVal(tt.RefVal, valType, m.attrFormat(entity.Class, ref.Attr)); err != nil {
+				err = fmt.Errorf("error converting value of %v: %w", ref.Attr, err)
+				tt.Error = err.Error()
+				return tt, err
 			}
 		}
 	}
-	return ""
+	switch {
+	case term.Op == OpExists || term.Op == OpNotExists:
+		tt.Matched = (valSource != valSourceMissing) == (term.Op == OpExists)
+	case absentAttr == "" && timeOps[term.Op]:
+		af := m.attrFormat(entity.Class, term.AttrName)
+		var entityAttrValConv any
+		if entityAttrValConv, err = convertEntityAttrVal(entityAttrVal, valType, af); err != nil {
+			err = fmt.Errorf("error converting value: %w", err)
+		} else {
+			tt.Matched, err = compareTimeVals(entityAttrValConv, termAttrVal, term.Op, m.now(), af.location())
+		}
+	case absentAttr == "":
+		tt.Matched, err = makeComparison(entityAttrVal, termAttrVal, valType, term.Op, m.attrFormat(entity.Class, term.AttrName))
+	case missing == MissingUnknown:
+		tt.Unknown = true
+	case missing == MissingNoMatch:
+	case missing == MissingError || isRef:
+		err = missingAttrError(absentAttr)
+	default:
+		// The absent attribute is compared as a task of the same name would be
+		tt.EntityVal, tt.ValType, tt.ValSource = getTaskVal(term.AttrName, actionSet)
+		tt.Matched, err = makeComparison(tt.EntityVal, term.AttrVal, tt.ValType, term.Op, nil)
+	}
+	if err != nil {
+		tt.Error = err.Error()
+	}
+	return tt, err
+}
+
+// Returns the value to be compared with a term on attrName, its value-type, and where it came
+// from (one of the valSource constants). An attribute in the class's pattern-schema that the
+// entity does not have, or has with an empty value unless it is a str, is reported as missing.
+// Any other attrName is a task, which is true if it is in actionSet and false otherwise.
+func (m *matcher) getEntityAttrVal(entity Entity, attrName string, actionSet ActionSet) (string, string, string) {
+	valType := m.store.getTypeFromSchema(entity.Class, attrName)
+	entityAttrVal, found := "", false
+	for _, entityAttr := range entity.Attrs {
+		if entityAttr.Name == attrName {
+			entityAttrVal, found = entityAttr.Val, true
+		}
+	}
+	if found && (entityAttrVal != "" || valType == TypeStr) {
+		return entityAttrVal, valType, valSourceAttr
+	}
+	if valType != "" {
+		return "", valType, valSourceMissing
+	}
+	return getTaskVal(attrName, actionSet)
+}
+
+// Returns the value of the task attrName used as an attribute, its value-type, and its valSource
+func getTaskVal(attrName string, actionSet ActionSet) (string, string, string) {
+	if isStringInArray(attrName, actionSet.Tasks) {
+		return trueStr, TypeBool, valSourceTask
+	}
+	return falseStr, TypeBool, valSourceDefault
+}
+
+// attrFormat holds what is needed to convert the entity values of an attribute besides its
+// value-type: the layouts and time zone of a ts or date attribute, the currency of a decimal, and
+// the element type of a list
+type attrFormat struct {
+	layouts  []string
+	loc      *time.Location
+	currency string
+	elemType string
+}
+
+// Returns the attrFormat of as, or nil if as is not a ts or date attribute with its own layouts
+// or time zone, a decimal attribute with a currency, or a list. A time zone that cannot be loaded
+// is taken to be UTC; verifyPatternSchema() rejects it.
+func newAttrFormat(as AttrSchema) *attrFormat {
+	switch {
+	case as.ValType == TypeList:
+		return &attrFormat{elemType: as.ElemType}
+	case as.ValType == TypeDecimal && as.Currency != "":
+		return &attrFormat{currency: as.Currency}
+	case as.ValType != TypeTS && as.ValType != TypeDate, len(as.Layouts) == 0 && as.TZ == "":
+		return nil
+	}
+	af := &attrFormat{layouts: as.Layouts, loc: time.UTC}
+	if loc, err := time.LoadLocation(as.TZ); err == nil {
+		af.loc = loc
+	}
+	return af
+}
+
+// Returns the format of the attribute attrName of class if it has one, and nil otherwise
+func (m *matcher) attrFormat(class string, attrName string) *attrFormat {
+	cs, ok := m.store.compiledSchemas[class]
+	if !ok {
+		return nil
+	}
+	if slot, ok := cs.slots[attrName]; ok {
+		return cs.formats[slot]
+	}
+	return nil
+}
+
+func missingAttrError(attrName string) error {
+	return fmt.Errorf("attribute %v is missing", attrName)
 }
 
 // Returns whether or not the comparison represented by {entityAttrVal, op, termAttrVal} is true
-// For example, {7, gt (greater than), 5} is true but {3, gt, 5} is false
-func makeComparison(entityAttrVal string, termAttrVal any, valType string, op string) (bool, error) {
-	entityAttrValConv, err := convertEntityAttrVal(entityAttrVal, valType)
+// For example, {7, gt (greater than), 5} is true but {3, gt, 5} is false. af is the format of
+// the attribute, or nil.
+func makeComparison(entityAttrVal string, termAttrVal any, valType string, op string, af *attrFormat) (bool, error) {
+	entityAttrValConv, err := convertEntityAttrVal(entityAttrVal, valType, af)
 	if err != nil {
 		return false, fmt.Errorf("error converting value: %w", err)
 	}
+	return compareVals(entityAttrValConv, termAttrVal, valType, op)
+}
+
+// Like makeComparison(), but with an entity value that has already been converted to valType
+func compareVals(entityAttrValConv any, termAttrVal any, valType string, op string) (bool, error) {
+	if valType == TypeList || listOps[op] {
+		return compareLists(entityAttrValConv, termAttrVal, op)
+	}
+	var err error
+	// verifyType() allows ts and date values in rule-patterns to be strings, and time.Time
+	// values that are not normalised
+	if (valType == TypeTS || valType == TypeDate) && !setOps[op] {
+		if termAttrVal, err = termTime(termAttrVal, valType); err != nil {
+			return false, fmt.Errorf("error converting value: %w", err)
+		}
+	}
+	// It also allows decimal values to be strings, which may have a currency code
+	if valType == TypeDecimal && !setOps[op] {
+		if termAttrVal, _, err = termDecimal(termAttrVal); err != nil {
+			return false, fmt.Errorf("error converting value: %w", err)
+		}
+	}
 	switch op {
-	case opEQ:
+	case OpIn, OpNIn:
+		set, ok := termAttrVal.(valSet)
+		if !ok {
+			if set, err = makeValSet(termAttrVal); err != nil {
+				return false, err
+			}
+		}
+		return set[entityAttrValConv] == (op == OpIn), nil
+	case OpEQ:
 		return entityAttrValConv == termAttrVal, nil
-	case opNE:
+	case OpNE:
 		return entityAttrValConv != termAttrVal, nil
+	case OpContains, OpStartsWith, OpEndsWith, OpMatches:
+		return compareStrings(entityAttrValConv, termAttrVal, valType, op)
 	}
-	orderedTypes := map[string]bool{typeInt: true, typeFloat: true, typeTS: true, typeStr: true}
 	if !orderedTypes[valType] {
 		return false, errors.New("not an ordered type")
 	}
 	var result int8
 	var match bool
 	switch op {
-	case opLT:
+	case OpLT:
 		result, err = compare(entityAttrValConv, termAttrVal)
 		match = (result == -1)
-	case opLE:
+	case OpLE:
 		result, err = compare(entityAttrValConv, termAttrVal)
 		match = (result == -1) || (result == 0)
-	case opGT:
+	case OpGT:
 		result, err = compare(entityAttrValConv, termAttrVal)
 		match = (result == 1)
-	case opGE:
+	case OpGE:
 		result, err = compare(entityAttrValConv, termAttrVal)
 		match = (result == 1) || (result == 0)
 	}
@@ -117,21 +360,32 @@ func makeComparison(entityAttrVal string, termAttrVal any, valType string, op st
 	return match, nil
 }
 
-// Converts the string entityAttrVal to its schema-provided type
-func convertEntityAttrVal(entityAttrVal string, valType string) (any, error) {
+// Converts the string entityAttrVal to its schema-provided type. af is the format of the
+// attribute, or nil for the default format. A float may not be NaN, which is neither less than,
+// equal to nor greater than any value.
+func convertEntityAttrVal(entityAttrVal string, valType string, af *attrFormat) (any, error) {
 	var entityAttrValConv any
 	var err error
 	switch valType {
-	case typeBool:
+	case TypeBool:
 		entityAttrValConv, err = strconv.ParseBool(entityAttrVal)
-	case typeInt:
+	case TypeInt:
 		entityAttrValConv, err = strconv.Atoi(entityAttrVal)
-	case typeFloat:
-		entityAttrValConv, err = strconv.ParseFloat(entityAttrVal, 64)
-	case typeStr, typeEnum:
+	case TypeFloat:
+		var f float64
+		f, err = strconv.ParseFloat(entityAttrVal, 64)
+		if err == nil && math.IsNaN(f) {
+			err = fmt.Errorf("%v is not a number", entityAttrVal)
+		}
+		entityAttrValConv = f
+	case TypeStr, TypeEnum:
 		entityAttrValConv = entityAttrVal
-	case typeTS:
-		entityAttrValConv, err = time.Parse(timeLayout, entityAttrVal)
+	case TypeTS, TypeDate:
+		entityAttrValConv, err = parseTime(entityAttrVal, valType, af)
+	case TypeDecimal:
+		entityAttrValConv, err = parseDecimalVal(entityAttrVal, af)
+	case TypeList:
+		entityAttrValConv, err = parseListVal(entityAttrVal, af)
 	}
 	if err != nil {
 		return nil, err
@@ -165,6 +419,14 @@ func compare(a any, b any) (int8, error) {
 		if a.(time.Time).Before(b.(time.Time)) {
 			lessThan = true
 		}
+	case time.Duration:
+		if a.(time.Duration) < b.(time.Duration) {
+			lessThan = true
+		}
+	case Decimal:
+		if a.(Decimal).Cmp(b.(Decimal)) < 0 {
+			lessThan = true
+		}
 	default:
 		return -2, errors.New("invalid type")
 	}
@@ -174,3 +436,92 @@ func compare(a any, b any) (int8, error) {
 		return 1, nil
 	}
 }
+
+func compareStrings(entityAttrValConv any, termAttrVal any, valType string, op string) (bool, error) {
+	if valType != TypeStr {
+		return false, errors.New("not a string type")
+	}
+	s := entityAttrValConv.(string)
+	switch op {
+	case OpContains:
+		return strings.Contains(s, termAttrVal.(string)), nil
+	case OpStartsWith:
+		return strings.HasPrefix(s, termAttrVal.(string)), nil
+	case OpEndsWith:
+		return strings.HasSuffix(s, termAttrVal.(string)), nil
+	}
+	re, ok := termAttrVal.(*regexp.Regexp)
+	if !ok {
+		var err error
+		if re, err = regexp.Compile(termAttrVal.(string)); err != nil {
+			return false, err
+		}
+	}
+	return re.MatchString(s), nil
+}
+
+// Returns the compiled form of each regular expression in the matches terms of ruleSets, by
+// pattern. Those in prev are reused, and those that are not valid are left out.
+func compileRegexps(ruleSets map[string]RuleSet, prev map[string]*regexp.Regexp) map[string]*regexp.Regexp {
+	regexps := map[string]*regexp.Regexp{}
+	forEachLeafTerm(ruleSets, func(term RulePatternTerm) {
+		pattern, ok := term.AttrVal.(string)
+		if term.Op != OpMatches || !ok || regexps[pattern] != nil {
+			return
+		}
+		re, ok := prev[pattern]
+		if !ok {
+			re, _ = regexp.Compile(pattern)
+		}
+		if re != nil {
+			regexps[pattern] = re
+		}
+	})
+	return regexps
+}
+
+// Calls f for every term in the patterns of ruleSets that is not a group, including the terms in
+// groups
+func forEachLeafTerm(ruleSets map[string]RuleSet, f func(term RulePatternTerm)) {
+	var visit func(terms []RulePatternTerm)
+	visit = func(terms []RulePatternTerm) {
+		for _, term := range terms {
+			if group, ok := term.AttrVal.([]RulePatternTerm); ok && groupOps[term.Op] {
+				visit(group)
+			} else {
+				f(term)
+			}
+		}
+	}
+	for _, rs := range ruleSets {
+		for _, rule := range rs.Rules {
+			visit(rule.RulePattern)
+		}
+	}
+}
+
+// Returns the elements of val if it is a slice or array, which is how the values of in and nin
+// terms are written. A valSet is not a list.
+func termList(val any) ([]any, bool) {
+	v := reflect.ValueOf(val)
+	if v.Kind() != reflect.Slice && v.Kind() != reflect.Array {
+		return nil, false
+	}
+	list := make([]any, v.Len())
+	for i := range list {
+		list[i] = v.Index(i).Interface()
+	}
+	return list, true
+}
+
+func makeValSet(val any) (valSet, error) {
+	list, ok := termList(val)
+	if !ok {
+		return nil, fmt.Errorf("%v is not a list", val)
+	}
+	set := make(valSet, len(list))
+	for _, elem := range list {
+		set[elem] = true
+	}
+	return set, nil
+}
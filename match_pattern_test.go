@@ -1,6 +1,10 @@
-package main
+package crux
 
 import (
+	"fmt"
+	"math"
+	"reflect"
+	"strings"
 	"testing"
 	"time"
 )
@@ -11,35 +15,38 @@ func TestMatchPattern(t *testing.T) {
 	var rulePatterns []([]RulePatternTerm)
 	var resultsExpected []any
 
-	actionSet := ActionSet{tasks: []string{"dodiscount", "yearendsale"}}
+	setupInventoryItemSchema()
+	m := matcher{store: testEngine.registry.load()}
+
+	actionSet := ActionSet{Tasks: []string{"dodiscount", "yearendsale"}}
 
 	// Test: many terms, everything matches
 	testNames = append(testNames, "everything matches")
 	entities = append(entities, sampleEntity)
-	receivedTime, _ := time.Parse(timeLayout, "2018-05-15T12:00:00Z")
+	receivedTime, _ := time.Parse(time.RFC3339, "2018-05-15T12:00:00Z")
 	rulePatterns = append(rulePatterns, []RulePatternTerm{
-		{"cat", opEQ, "textbook"},
-		{"fullname", opEQ, "Advanced Physics"},
-		{"ageinstock", opLE, 7},
-		{"mrp", opLT, 51.2},
-		{"received", opGT, receivedTime},
-		{"bulkorder", opNE, false},
-		{"dodiscount", opEQ, true},
+		{"cat", OpEQ, "textbook"},
+		{"fullname", OpEQ, "Advanced Physics"},
+		{"ageinstock", OpLE, 7},
+		{"mrp", OpLT, 51.2},
+		{"received", OpGT, receivedTime},
+		{"bulkorder", OpNE, false},
+		{"dodiscount", OpEQ, true},
 	})
 	resultsExpected = append(resultsExpected, true)
 
 	// Test: many terms, mrp doesn't match
 	testNames = append(testNames, "mrp doesn't match")
 	entities = append(entities, sampleEntity)
-	receivedTime, _ = time.Parse(timeLayout, "2018-05-15T12:00:00Z")
+	receivedTime, _ = time.Parse(time.RFC3339, "2018-05-15T12:00:00Z")
 	rulePatterns = append(rulePatterns, []RulePatternTerm{
-		{"cat", opEQ, "textbook"},
-		{"fullname", opEQ, "Advanced Physics"},
-		{"ageinstock", opLE, 7},
-		{"mrp", opGE, 51.2},
-		{"received", opGT, receivedTime},
-		{"bulkorder", opNE, false},
-		{"dodiscount", opEQ, true},
+		{"cat", OpEQ, "textbook"},
+		{"fullname", OpEQ, "Advanced Physics"},
+		{"ageinstock", OpLE, 7},
+		{"mrp", OpGE, 51.2},
+		{"received", OpGT, receivedTime},
+		{"bulkorder", OpNE, false},
+		{"dodiscount", OpEQ, true},
 	})
 	resultsExpected = append(resultsExpected, false)
 
@@ -47,7 +54,7 @@ func TestMatchPattern(t *testing.T) {
 	testNames = append(testNames, "bool ne")
 	entities = append(entities, sampleEntity)
 	rulePatterns = append(rulePatterns, []RulePatternTerm{
-		{"bulkorder", opNE, true},
+		{"bulkorder", OpNE, true},
 	})
 	resultsExpected = append(resultsExpected, false)
 
@@ -55,7 +62,7 @@ func TestMatchPattern(t *testing.T) {
 	testNames = append(testNames, "enum ne")
 	entities = append(entities, sampleEntity)
 	rulePatterns = append(rulePatterns, []RulePatternTerm{
-		{"cat", opNE, "refbook"},
+		{"cat", OpNE, "refbook"},
 	})
 	resultsExpected = append(resultsExpected, true)
 
@@ -63,7 +70,7 @@ func TestMatchPattern(t *testing.T) {
 	testNames = append(testNames, "float eq")
 	entities = append(entities, sampleEntity)
 	rulePatterns = append(rulePatterns, []RulePatternTerm{
-		{"mrp", opEQ, 50.8},
+		{"mrp", OpEQ, 50.8},
 	})
 	resultsExpected = append(resultsExpected, true)
 
@@ -71,25 +78,25 @@ func TestMatchPattern(t *testing.T) {
 	testNames = append(testNames, "float ge")
 	entities = append(entities, sampleEntity)
 	rulePatterns = append(rulePatterns, []RulePatternTerm{
-		{"mrp", opGE, 50.8},
+		{"mrp", OpGE, 50.8},
 	})
 	resultsExpected = append(resultsExpected, true)
 
 	// Test: timestamp "lt"
 	testNames = append(testNames, "timestamp lt")
 	entities = append(entities, sampleEntity)
-	receivedTime, _ = time.Parse(timeLayout, "2018-06-10T15:04:05Z")
+	receivedTime, _ = time.Parse(time.RFC3339, "2018-06-10T15:04:05Z")
 	rulePatterns = append(rulePatterns, []RulePatternTerm{
-		{"received", opLT, receivedTime},
+		{"received", OpLT, receivedTime},
 	})
 	resultsExpected = append(resultsExpected, true)
 
 	// Test: timestamp "le"
 	testNames = append(testNames, "timestamp le")
 	entities = append(entities, sampleEntity)
-	receivedTime, _ = time.Parse(timeLayout, "2018-05-01T15:04:05Z")
+	receivedTime, _ = time.Parse(time.RFC3339, "2018-05-01T15:04:05Z")
 	rulePatterns = append(rulePatterns, []RulePatternTerm{
-		{"received", opLE, receivedTime},
+		{"received", OpLE, receivedTime},
 	})
 	resultsExpected = append(resultsExpected, false)
 
@@ -97,7 +104,7 @@ func TestMatchPattern(t *testing.T) {
 	testNames = append(testNames, "string lt")
 	entities = append(entities, sampleEntity)
 	rulePatterns = append(rulePatterns, []RulePatternTerm{
-		{"fullname", opLT, "Advanced Science"},
+		{"fullname", OpLT, "Advanced Science"},
 	})
 	resultsExpected = append(resultsExpected, true)
 
@@ -105,7 +112,7 @@ func TestMatchPattern(t *testing.T) {
 	testNames = append(testNames, "string gt")
 	entities = append(entities, sampleEntity)
 	rulePatterns = append(rulePatterns, []RulePatternTerm{
-		{"fullname", opGT, "Accelerated Physics"},
+		{"fullname", OpGT, "Accelerated Physics"},
 	})
 	resultsExpected = append(resultsExpected, true)
 
@@ -113,8 +120,8 @@ func TestMatchPattern(t *testing.T) {
 	testNames = append(testNames, "tasks found in action set")
 	entities = append(entities, sampleEntity)
 	rulePatterns = append(rulePatterns, []RulePatternTerm{
-		{"dodiscount", opEQ, true},
-		{"yearendsale", opNE, false},
+		{"dodiscount", OpEQ, true},
+		{"yearendsale", OpNE, false},
 	})
 	resultsExpected = append(resultsExpected, true)
 
@@ -122,8 +129,8 @@ func TestMatchPattern(t *testing.T) {
 	testNames = append(testNames, "task not in action set")
 	entities = append(entities, sampleEntity)
 	rulePatterns = append(rulePatterns, []RulePatternTerm{
-		{"dodiscount", opEQ, true},
-		{"summersale", opEQ, true},
+		{"dodiscount", OpEQ, true},
+		{"summersale", OpEQ, true},
 	})
 	resultsExpected = append(resultsExpected, false)
 
@@ -131,10 +138,70 @@ func TestMatchPattern(t *testing.T) {
 	testNames = append(testNames, "task 'eq false' in pattern, and not in action set")
 	entities = append(entities, sampleEntity)
 	rulePatterns = append(rulePatterns, []RulePatternTerm{
-		{"summersale", opEQ, false},
+		{"summersale", OpEQ, false},
 	})
 	resultsExpected = append(resultsExpected, true)
 
+	// Test: enum "in"
+	testNames = append(testNames, "enum in")
+	entities = append(entities, sampleEntity)
+	rulePatterns = append(rulePatterns, []RulePatternTerm{
+		{"cat", OpIn, []string{"refbook", "textbook"}},
+	})
+	resultsExpected = append(resultsExpected, true)
+
+	// Test: int "nin"
+	testNames = append(testNames, "int nin")
+	entities = append(entities, sampleEntity)
+	rulePatterns = append(rulePatterns, []RulePatternTerm{
+		{"ageinstock", OpNIn, []any{3, 5, 7}},
+	})
+	resultsExpected = append(resultsExpected, false)
+
+	// Test: float "in", with a value that is not in the list
+	testNames = append(testNames, "float in")
+	entities = append(entities, sampleEntity)
+	rulePatterns = append(rulePatterns, []RulePatternTerm{
+		{"mrp", OpIn, []float64{50.5, 51.0}},
+	})
+	resultsExpected = append(resultsExpected, false)
+
+	// Test: an attribute that the entity does not have is in no list
+	testNames = append(testNames, "missing attribute in and nin")
+	entities = append(entities, Entity{inventoryItemClass, []Attr{}})
+	rulePatterns = append(rulePatterns, []RulePatternTerm{
+		{"cat", OpNIn, []string{"refbook", "textbook"}},
+		{"fullname", OpNIn, []string{"false"}},
+	})
+	resultsExpected = append(resultsExpected, true)
+
+	// Test: string operators
+	testNames = append(testNames, "string contains, startswith, endswith and matches")
+	entities = append(entities, sampleEntity)
+	rulePatterns = append(rulePatterns, []RulePatternTerm{
+		{"fullname", OpContains, "ced Phy"},
+		{"fullname", OpStartsWith, "Advanced"},
+		{"fullname", OpEndsWith, "Physics"},
+		{"fullname", OpMatches, `^Adv\w+ P`},
+	})
+	resultsExpected = append(resultsExpected, true)
+
+	// Test: string operator that does not match
+	testNames = append(testNames, "string endswith doesn't match")
+	entities = append(entities, sampleEntity)
+	rulePatterns = append(rulePatterns, []RulePatternTerm{
+		{"fullname", OpEndsWith, "Advanced"},
+	})
+	resultsExpected = append(resultsExpected, false)
+
+	// Test: string operator on an attribute that the entity does not have
+	testNames = append(testNames, "deliberate error: string operator on missing attribute")
+	entities = append(entities, Entity{inventoryItemClass, []Attr{}})
+	rulePatterns = append(rulePatterns, []RulePatternTerm{
+		{"fullname", OpContains, "Physics"},
+	})
+	resultsExpected = append(resultsExpected, nil)
+
 	// Test: edge case - no rule pattern
 	testNames = append(testNames, "no rule pattern")
 	entities = append(entities, sampleEntity)
@@ -147,7 +214,7 @@ func TestMatchPattern(t *testing.T) {
 		{"ageinstock", "abc"},
 	}})
 	rulePatterns = append(rulePatterns, []RulePatternTerm{
-		{"ageinstock", opGT, 5},
+		{"ageinstock", OpGT, 5},
 	})
 	resultsExpected = append(resultsExpected, nil)
 
@@ -155,7 +222,7 @@ func TestMatchPattern(t *testing.T) {
 	testNames = append(testNames, "deliberate error: not an ordered type")
 	entities = append(entities, sampleEntity)
 	rulePatterns = append(rulePatterns, []RulePatternTerm{
-		{"bulkorder", opGT, true},
+		{"bulkorder", OpGT, true},
 	})
 	resultsExpected = append(resultsExpected, nil)
 
@@ -164,7 +231,7 @@ func TestMatchPattern(t *testing.T) {
 
 	for i, rulePattern := range rulePatterns {
 		t.Logf("Test: %s", testNames[i])
-		res, err := matchPattern(entities[i], rulePattern, actionSet)
+		res, err := m.matchPattern(entities[i], rulePattern, actionSet, MissingDefault)
 		if resultsExpected[i] == nil && err == nil {
 			t.Errorf("Expected but did not get error")
 			continue
@@ -175,3 +242,504 @@ func TestMatchPattern(t *testing.T) {
 		}
 	}
 }
+
+// A ruleset under each missing-attribute policy is matched against entities with and without its
+// attributes, by doMatch(), and by doMatchCompiled() with and without the index
+func TestMissingAttrs(t *testing.T) {
+	schema := RuleSchema{
+		Class:         counterClass,
+		PatternSchema: []AttrSchema{{Name: "n", ValType: TypeInt}, {Name: "s", ValType: TypeStr}},
+		ActionSchema:  ActionSchema{Tasks: []string{"pos", "hasn", "non", "emptys", "elsecalled"}},
+	}
+	ruleSet := func(missing string) []RuleSet {
+		return []RuleSet{{1, counterClass, mainRS, []Rule{
+			{[]RulePatternTerm{{"n", OpGT, 0}}, RuleActions{Tasks: []string{"pos"}, ElseCall: "other"}},
+			{[]RulePatternTerm{{"n", OpExists, nil}}, RuleActions{Tasks: []string{"hasn"}}},
+			{[]RulePatternTerm{{"n", OpNotExists, nil}}, RuleActions{Tasks: []string{"non"}}},
+			{[]RulePatternTerm{{"s", OpEQ, ""}}, RuleActions{Tasks: []string{"emptys"}}},
+		}, missing}, {1, counterClass, "other", []Rule{
+			{[]RulePatternTerm{}, RuleActions{Tasks: []string{"elsecalled"}}},
+		}, missing}}
+	}
+	present := []Attr{{"n", "5"}, {"s", ""}}
+	// An empty int is missing, but an empty str is not
+	noN := []Attr{{"n", ""}, {"s", ""}}
+	tests := []struct {
+		missing string
+		attrs   []Attr
+		want    []string
+	}{
+		{MissingDefault, present, []string{"pos", "hasn", "emptys"}},
+		{MissingUnknown, present, []string{"pos", "hasn", "emptys"}},
+		{MissingNoMatch, present, []string{"pos", "hasn", "emptys"}},
+		{MissingError, present, []string{"pos", "hasn", "emptys"}},
+		// A missing attribute in an ordered comparison has always been an error
+		{MissingDefault, noN, nil},
+		// An unknown rule neither matches nor calls its ElseCall
+		{MissingUnknown, noN, []string{"non", "emptys"}},
+		{MissingNoMatch, noN, []string{"elsecalled", "non", "emptys"}},
+		{MissingError, noN, nil},
+		{MissingNoMatch, []Attr{{"n", "5"}}, []string{"pos", "hasn"}},
+		{MissingError, []Attr{{"n", "5"}}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%q %v", tt.missing, tt.attrs), func(t *testing.T) {
+			entity := Entity{counterClass, tt.attrs}
+			engines := map[string]*Engine{}
+			for _, minRules := range []int{math.MaxInt, 0} {
+				e := NewEngine()
+				e.AddRuleSchema(schema)
+				withIndexMinRules(minRules, func() { e.AddRuleSet(ruleSet(tt.missing)...) })
+				if err := e.VerifyRuleSets(ruleSet(tt.missing), false); err != nil {
+					t.Fatalf("VerifyRuleSets() error = %v", err)
+				}
+				engines[fmt.Sprintf("compiled, index from %v rules", minRules)] = e
+			}
+			for name, e := range engines {
+				got, err := e.Match(entity, mainRS)
+				if tt.want == nil {
+					if err == nil {
+						t.Errorf("%v: Match() = %v, want error", name, got)
+					}
+				} else if err != nil || !reflect.DeepEqual(got.Tasks, tt.want) {
+					t.Errorf("%v: Match() = %v, %v, want tasks %v", name, got, err, tt.want)
+				}
+			}
+			m := matcher{store: engines["compiled, index from 0 rules"].registry.load()}
+			got, _, err := m.doMatch(entity, ruleSet(tt.missing)[0], ActionSet{}, map[string]bool{})
+			if (err != nil) != (tt.want == nil) || (err == nil && !reflect.DeepEqual(got.Tasks, tt.want)) {
+				t.Errorf("doMatch() = %v, %v, want tasks %v", got, err, tt.want)
+			}
+		})
+	}
+}
+
+// Matches entity against a ruleset whose first two rules have pattern, under the missing-attribute
+// policy missing, by doMatch(), and by doMatchCompiled() with and without the index. want is true
+// or false, errUnknown for an unknown pattern, or nil for an error.
+// A clock for tests, which is always at Friday 2024-03-15 10:00 UTC
+func testClock() time.Time {
+	return time.Date(2024, 3, 15, 10, 0, 0, 0, time.UTC)
+}
+
+func testPatternAllWays(t *testing.T, schema RuleSchema, pattern []RulePatternTerm, missing string, entity Entity, want any) {
+	t.Helper()
+	schema.ActionSchema = ActionSchema{Tasks: []string{"yes", "yes2", "no"}}
+	ruleSets := []RuleSet{{1, schema.Class, mainRS, []Rule{
+		{pattern, RuleActions{Tasks: []string{"yes"}, ElseCall: "else"}},
+		{pattern, RuleActions{Tasks: []string{"yes2"}}},
+	}, missing}, {1, schema.Class, "else", []Rule{
+		{[]RulePatternTerm{}, RuleActions{Tasks: []string{"no"}}},
+	}, missing}}
+	var wantTasks []string
+	switch want {
+	case true:
+		wantTasks = []string{"yes", "yes2"}
+	case false:
+		wantTasks = []string{"no"}
+	}
+	var st *ruleStore
+	for _, minRules := range []int{math.MaxInt, 0} {
+		e := NewEngine()
+		e.SetClock(testClock)
+		e.AddRuleSchema(schema)
+		withIndexMinRules(minRules, func() { e.AddRuleSet(ruleSets...) })
+		st = e.registry.load()
+		if _, ok := st.compiled[mainRS]; !ok {
+			t.Fatalf("ruleset was not compiled")
+		}
+		got, err := e.Match(entity, mainRS)
+		if (err != nil) != (want == nil) || (err == nil && !reflect.DeepEqual(got.Tasks, wantTasks)) {
+			t.Errorf("index from %v rules: Match() = %v, %v, want tasks %v", minRules, got, err, wantTasks)
+		}
+	}
+	m := matcher{store: st}
+	got, _, err := m.doMatch(entity, ruleSets[0], ActionSet{}, map[string]bool{})
+	if (err != nil) != (want == nil) || (err == nil && !reflect.DeepEqual(got.Tasks, wantTasks)) {
+		t.Errorf("doMatch() = %v, %v, want tasks %v", got, err, wantTasks)
+	}
+}
+
+func TestMatchGroups(t *testing.T) {
+	schema := RuleSchema{
+		Class: counterClass,
+		PatternSchema: []AttrSchema{
+			{Name: "n", ValType: TypeInt}, {Name: "s", ValType: TypeStr}, {Name: "b", ValType: TypeBool},
+		},
+	}
+	yes, no := RulePatternTerm{"n", OpGT, 0}, RulePatternTerm{"n", OpLT, 0}
+	// An ordered comparison on a bool fails with an error when it is evaluated
+	fails := RulePatternTerm{"b", OpGT, true}
+	// s is not in the entity
+	unknown := RulePatternTerm{"s", OpEQ, "x"}
+	group := func(op string, terms ...RulePatternTerm) RulePatternTerm {
+		return RulePatternTerm{Op: op, AttrVal: terms}
+	}
+	tests := []struct {
+		name    string
+		pattern []RulePatternTerm
+		want    any
+	}{
+		{"any true", []RulePatternTerm{group(OpAny, no, yes)}, true},
+		{"any false", []RulePatternTerm{group(OpAny, no, no)}, false},
+		{"all true", []RulePatternTerm{group(OpAll, yes, yes)}, true},
+		{"all false", []RulePatternTerm{group(OpAll, yes, no)}, false},
+		{"not true", []RulePatternTerm{group(OpNot, yes)}, false},
+		{"not false", []RulePatternTerm{group(OpNot, yes, no)}, true},
+		{"nested", []RulePatternTerm{yes, group(OpAny, group(OpNot, yes), group(OpAll, yes, group(OpNot, no)))}, true},
+		{"any stops at true", []RulePatternTerm{group(OpAny, yes, fails)}, true},
+		{"all stops at false", []RulePatternTerm{group(OpAll, no, fails)}, false},
+		{"not stops at false", []RulePatternTerm{group(OpNot, no, fails)}, true},
+		{"error before true", []RulePatternTerm{group(OpAny, fails, yes)}, nil},
+		{"any unknown", []RulePatternTerm{group(OpAny, no, unknown)}, errUnknown},
+		{"any unknown or true", []RulePatternTerm{group(OpAny, unknown, yes)}, true},
+		{"all unknown and false", []RulePatternTerm{group(OpAll, unknown, no)}, false},
+		{"not unknown", []RulePatternTerm{group(OpNot, unknown)}, errUnknown},
+	}
+	entity := Entity{counterClass, []Attr{{"n", "5"}, {"b", trueStr}}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			testPatternAllWays(t, schema, tt.pattern, MissingUnknown, entity, tt.want)
+		})
+	}
+}
+
+func TestMatchAttrRefs(t *testing.T) {
+	schema := RuleSchema{
+		Class: "item",
+		PatternSchema: []AttrSchema{
+			{Name: "cost", ValType: TypeFloat}, {Name: "sale", ValType: TypeFloat},
+			{Name: "received", ValType: TypeTS}, {Name: "shipped", ValType: TypeTS},
+			{Name: "name", ValType: TypeStr}, {Name: "alias", ValType: TypeStr},
+		},
+	}
+	item := func(attrs ...Attr) Entity {
+		return Entity{"item", append([]Attr{
+			{"cost", "10.5"}, {"received", "2023-05-01T00:00:00Z"}, {"shipped", "2023-05-03T00:00:00Z"}, {"name", "pen"},
+		}, attrs...)}
+	}
+	lowSale := item(Attr{"sale", "9.99"})
+	tests := []struct {
+		name    string
+		term    RulePatternTerm
+		missing string
+		entity  Entity
+		want    any
+	}{
+		{"sale below cost", RulePatternTerm{"sale", OpLT, AttrRef{"cost"}}, MissingDefault, lowSale, true},
+		{"sale not above cost", RulePatternTerm{"sale", OpGT, AttrRef{"cost"}}, MissingDefault, lowSale, false},
+		{"equal floats", RulePatternTerm{"sale", OpEQ, AttrRef{"cost"}}, MissingDefault, item(Attr{"sale", "10.50"}), true},
+		{"received before shipped", RulePatternTerm{"received", OpLT, AttrRef{"shipped"}}, MissingDefault, lowSale, true},
+		{"strings", RulePatternTerm{"name", OpNE, AttrRef{"alias"}}, MissingDefault, item(Attr{"alias", ""}), true},
+		{"unconvertible other attribute", RulePatternTerm{"cost", OpGT, AttrRef{"sale"}}, MissingDefault, item(Attr{"sale", "abc"}), nil},
+		{"missing other attribute", RulePatternTerm{"cost", OpGT, AttrRef{"sale"}}, MissingDefault, item(), nil},
+		{"missing attribute", RulePatternTerm{"sale", OpLT, AttrRef{"cost"}}, MissingDefault, item(), nil},
+		{"missing, unknown", RulePatternTerm{"cost", OpGT, AttrRef{"sale"}}, MissingUnknown, item(), errUnknown},
+		{"missing, nomatch", RulePatternTerm{"cost", OpGT, AttrRef{"sale"}}, MissingNoMatch, item(), false},
+		{"missing, error", RulePatternTerm{"cost", OpGT, AttrRef{"sale"}}, MissingError, item(), nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			testPatternAllWays(t, schema, []RulePatternTerm{tt.term}, tt.missing, tt.entity, tt.want)
+		})
+	}
+}
+
+func TestMatchExprs(t *testing.T) {
+	schema := RuleSchema{
+		Class: "item",
+		PatternSchema: []AttrSchema{
+			{Name: "mrp", ValType: TypeFloat}, {Name: "qty", ValType: TypeInt}, {Name: "ageinstock", ValType: TypeInt},
+			{Name: "received", ValType: TypeTS}, {Name: "shipped", ValType: TypeTS}, {Name: "big", ValType: TypeInt},
+		},
+	}
+	item := func(attrs ...Attr) Entity {
+		return Entity{"item", append([]Attr{
+			{"mrp", "250.5"}, {"qty", "40"}, {"ageinstock", "65"},
+			{"received", "2023-05-01T00:00:00Z"}, {"shipped", "2023-05-03T12:00:00Z"},
+		}, attrs...)}
+	}
+	tests := []struct {
+		name    string
+		term    RulePatternTerm
+		missing string
+		entity  Entity
+		want    any
+	}{
+		{"product", RulePatternTerm{"", OpGT, Arith{"mrp * qty", "10000"}}, MissingDefault, item(), true},
+		{"product not above", RulePatternTerm{"", OpGT, Arith{"mrp * qty", "10020"}}, MissingDefault, item(), false},
+		{"sum", RulePatternTerm{"", OpGE, Arith{"ageinstock + 30", "90"}}, MissingDefault, item(), true},
+		{"attribute vs expression", RulePatternTerm{"ageinstock", OpLT, Expr{"qty * 2 - 10"}}, MissingDefault, item(), true},
+		{"precedence", RulePatternTerm{"qty", OpEQ, Expr{"(2 + 3) * 8"}}, MissingDefault, item(), true},
+		{"int division truncates", RulePatternTerm{"qty", OpEQ, Expr{"81 / 2 - -1 + -1"}}, MissingDefault, item(), true},
+		{"int equals float", RulePatternTerm{"qty", OpEQ, Expr{"80.0 / 2"}}, MissingDefault, item(), true},
+		{"unary minus", RulePatternTerm{"", OpLT, Arith{"-qty", "0"}}, MissingDefault, item(), true},
+		{"ts plus days", RulePatternTerm{"shipped", OpLT, Expr{"received + days(3)"}}, MissingDefault, item(), true},
+		{"ts minus hours", RulePatternTerm{"received", OpLE, Expr{"shipped - hours(60)"}}, MissingDefault, item(), true},
+		{"ts difference", RulePatternTerm{"", OpGT, Arith{"shipped - received", "days(2)"}}, MissingDefault, item(), true},
+		{"ts difference scaled", RulePatternTerm{"", OpEQ, Arith{"(shipped - received) * 2", "hours(120)"}}, MissingDefault, item(), true},
+		{"fractional days", RulePatternTerm{"", OpEQ, Arith{"shipped - received", "days(2.5)"}}, MissingDefault, item(), true},
+		{"division by zero", RulePatternTerm{"", OpGT, Arith{"qty / (ageinstock - 65)", "1"}}, MissingDefault, item(), nil},
+		{"float division by zero", RulePatternTerm{"", OpGT, Arith{"mrp / 0.0", "1"}}, MissingDefault, item(), nil},
+		{"overflow", RulePatternTerm{"", OpGT, Arith{"big * big", "1"}}, MissingDefault, item(Attr{"big", "9223372036854775807"}), nil},
+		{"unconvertible attribute", RulePatternTerm{"", OpGT, Arith{"mrp * qty", "1"}}, MissingDefault, item(Attr{"qty", "x"}), nil},
+		{"missing attribute", RulePatternTerm{"", OpGT, Arith{"big + 1", "1"}}, MissingDefault, item(), nil},
+		{"missing term attribute", RulePatternTerm{"big", OpGT, Expr{"qty"}}, MissingDefault, item(), nil},
+		{"missing, unknown", RulePatternTerm{"", OpGT, Arith{"big + 1", "1"}}, MissingUnknown, item(), errUnknown},
+		{"missing, nomatch", RulePatternTerm{"", OpGT, Arith{"big + 1", "1"}}, MissingNoMatch, item(), false},
+		{"missing, error", RulePatternTerm{"", OpGT, Arith{"big + 1", "1"}}, MissingError, item(), nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			testPatternAllWays(t, schema, []RulePatternTerm{tt.term}, tt.missing, tt.entity, tt.want)
+		})
+	}
+}
+
+func TestExprString(t *testing.T) {
+	tests := []struct {
+		src  string
+		want string
+	}{
+		{"a+b*c", "a + b * c"},
+		{"(a+b)*c", "(a + b) * c"},
+		{"a-(b-c)", "a - (b - c)"},
+		{"(a-b)-c", "a - b - c"},
+		{"a -5", "a - 5"},
+		{"a/(b*c)", "a / (b * c)"},
+		{"-(a+b)", "-(a + b)"},
+		{"2.0*days(a+1)", "2.0 * days(a + 1)"},
+		{"((x))", "x"},
+	}
+	for _, tt := range tests {
+		n, err := parseExpr(tt.src)
+		if err != nil {
+			t.Errorf("parseExpr(%q) error: %v", tt.src, err)
+		} else if got := n.String(); got != tt.want {
+			t.Errorf("parseExpr(%q).String() = %q, want %q", tt.src, got, tt.want)
+		} else if n2, _ := parseExpr(got); !reflect.DeepEqual(n, n2) {
+			t.Errorf("%q does not parse back to the same expression", got)
+		}
+	}
+	long := "x" + strings.Repeat(" + x", maxExprNodes/2)
+	for _, src := range []string{"", "a +", "a b", "(a", "days(1", "a + \"s\"", long} {
+		if _, err := parseExpr(src); err == nil {
+			t.Errorf("parseExpr(%q) succeeded, want error", src)
+		}
+	}
+}
+
+func TestMatchTimeOps(t *testing.T) {
+	schema := RuleSchema{
+		Class:         "item",
+		PatternSchema: []AttrSchema{{Name: "received", ValType: TypeTS}, {Name: "qty", ValType: TypeInt}},
+	}
+	received := func(ts string) Entity {
+		return Entity{"item", []Attr{{"received", ts}, {"qty", "1"}}}
+	}
+	// testClock() is at 2024-03-15T10:00:00Z
+	weekAgo := received("2024-03-08T10:00:00Z")
+	sunday := received("2024-03-10T23:30:00Z")
+	tests := []struct {
+		name    string
+		term    RulePatternTerm
+		missing string
+		entity  Entity
+		want    any
+	}{
+		{"within", RulePatternTerm{"received", OpWithin, "7d"}, MissingDefault, weekAgo, true},
+		{"not within", RulePatternTerm{"received", OpWithin, "6d23h"}, MissingDefault, weekAgo, false},
+		{"within as duration", RulePatternTerm{"received", OpWithin, 7 * 24 * time.Hour}, MissingDefault, weekAgo, true},
+		{"future not within", RulePatternTerm{"received", OpWithin, "7d"}, MissingDefault, received("2024-03-15T10:00:01Z"), false},
+		{"older than", RulePatternTerm{"received", OpOlderThan, "6d23h59m"}, MissingDefault, weekAgo, true},
+		{"not older than", RulePatternTerm{"received", OpOlderThan, "7d"}, MissingDefault, weekAgo, false},
+		{"weekend", RulePatternTerm{"received", OpDayOfWeek, []string{"sat", "sun"}}, MissingDefault, sunday, true},
+		{"weekday", RulePatternTerm{"received", OpDayOfWeek, []any{"mon", "fri"}}, MissingDefault, sunday, false},
+		{"month", RulePatternTerm{"received", OpMonth, []string{"feb", "mar"}}, MissingDefault, sunday, true},
+		{"not month", RulePatternTerm{"received", OpMonth, []string{"jan"}}, MissingDefault, sunday, false},
+		{"hour window", RulePatternTerm{"received", OpHourIn, []int{9, 17}}, MissingDefault, weekAgo, true},
+		{"end of window", RulePatternTerm{"received", OpHourIn, []int{8, 10}}, MissingDefault, weekAgo, false},
+		{"window over midnight", RulePatternTerm{"received", OpHourIn, []int{22, 6}}, MissingDefault, sunday, true},
+		{"to midnight", RulePatternTerm{"received", OpHourIn, []int{23, 24}}, MissingDefault, sunday, true},
+		{"unconvertible", RulePatternTerm{"received", OpWithin, "7d"}, MissingDefault, received("yesterday"), nil},
+		{"missing", RulePatternTerm{"received", OpWithin, "7d"}, MissingDefault, received(""), nil},
+		{"missing, unknown", RulePatternTerm{"received", OpDayOfWeek, []string{"sun"}}, MissingUnknown, received(""), errUnknown},
+		{"missing, nomatch", RulePatternTerm{"received", OpOlderThan, "1h"}, MissingNoMatch, received(""), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			testPatternAllWays(t, schema, []RulePatternTerm{tt.term}, tt.missing, tt.entity, tt.want)
+		})
+	}
+}
+
+func TestMatchTimeFormats(t *testing.T) {
+	schema := RuleSchema{
+		Class: "item",
+		PatternSchema: []AttrSchema{
+			{Name: "received", ValType: TypeTS},
+			{Name: "local", ValType: TypeTS, Layouts: []string{"02/01/2006 15:04"}, TZ: "Asia/Kolkata"},
+			{Name: "day", ValType: TypeDate},
+			{Name: "shipday", ValType: TypeDate, TZ: "America/New_York"},
+		},
+	}
+	entity := func(attrs ...Attr) Entity {
+		return Entity{"item", attrs}
+	}
+	tests := []struct {
+		name   string
+		term   RulePatternTerm
+		entity Entity
+		want   any
+	}{
+		{"offset", RulePatternTerm{"received", OpEQ, "2024-03-15T10:00:00Z"},
+			entity(Attr{"received", "2024-03-15T15:30:00+05:30"}), true},
+		{"fractional seconds", RulePatternTerm{"received", OpGT, "2024-03-15T10:00:00Z"},
+			entity(Attr{"received", "2024-03-15T10:00:00.250Z"}), true},
+		{"date as ts", RulePatternTerm{"received", OpEQ, time.Date(2024, 3, 15, 0, 0, 0, 0, time.UTC)},
+			entity(Attr{"received", "2024-03-15"}), true},
+		{"custom layout in tz", RulePatternTerm{"local", OpEQ, "2024-03-15T10:00:00Z"},
+			entity(Attr{"local", "15/03/2024 15:30"}), true},
+		{"custom layout only", RulePatternTerm{"local", OpEQ, "2024-03-15T10:00:00Z"},
+			entity(Attr{"local", "2024-03-15T10:00:00Z"}), nil},
+		{"hour in tz", RulePatternTerm{"local", OpHourIn, []int{15, 16}},
+			entity(Attr{"local", "15/03/2024 15:30"}), true},
+		{"date", RulePatternTerm{"day", OpEQ, "2024-03-15"}, entity(Attr{"day", "2024-03-15"}), true},
+		{"date before", RulePatternTerm{"day", OpLT, "2024-03-16"}, entity(Attr{"day", "2024-03-15"}), true},
+		{"date after", RulePatternTerm{"day", OpGT, "2024-03-15"}, entity(Attr{"day", "2024-03-15"}), false},
+		{"date from ts", RulePatternTerm{"day", OpEQ, "2024-03-16"},
+			entity(Attr{"day", "2024-03-15T23:30:00-05:00"}), true},
+		{"date from ts in tz", RulePatternTerm{"shipday", OpEQ, "2024-03-15"},
+			entity(Attr{"shipday", "2024-03-16T02:00:00Z"}), true},
+		{"date literal with time", RulePatternTerm{"day", OpEQ, time.Date(2024, 3, 15, 18, 0, 0, 0, time.UTC)},
+			entity(Attr{"day", "2024-03-15"}), true},
+		{"dates compared", RulePatternTerm{"shipday", OpLE, AttrRef{"day"}},
+			entity(Attr{"day", "2024-03-16T01:00:00Z"}, Attr{"shipday", "2024-03-16T01:00:00Z"}), true},
+		{"unparseable date", RulePatternTerm{"day", OpEQ, "2024-03-15"}, entity(Attr{"day", "15/03/2024"}), nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			testPatternAllWays(t, schema, []RulePatternTerm{tt.term}, MissingNoMatch, tt.entity, tt.want)
+		})
+	}
+}
+
+func TestMatchDecimals(t *testing.T) {
+	schema := RuleSchema{
+		Class: "item",
+		PatternSchema: []AttrSchema{
+			{Name: "price", ValType: TypeDecimal, Currency: "INR"},
+			{Name: "listprice", ValType: TypeDecimal, Currency: "INR"},
+			{Name: "weight", ValType: TypeDecimal},
+		},
+	}
+	dec := func(s string) Decimal {
+		d, _ := ParseDecimal(s)
+		return d
+	}
+	price := func(s string) Entity {
+		return Entity{"item", []Attr{{"price", s}, {"listprice", "50.8"}, {"weight", "1.5"}}}
+	}
+	tests := []struct {
+		name   string
+		term   RulePatternTerm
+		entity Entity
+		want   any
+	}{
+		{"equal scales differ", RulePatternTerm{"price", OpEQ, dec("50.8")}, price("50.80"), true},
+		{"not equal", RulePatternTerm{"price", OpEQ, dec("50.8")}, price("50.80000000001"), false},
+		{"ne", RulePatternTerm{"price", OpNE, dec("50.8")}, price("50.80000000001"), true},
+		{"string literal", RulePatternTerm{"price", OpGT, "50.8"}, price("50.80000000001"), true},
+		{"literal with currency", RulePatternTerm{"price", OpLE, "INR 50.80"}, price("50.8"), true},
+		{"value with currency", RulePatternTerm{"price", OpEQ, dec("50.8")}, price("INR 50.800"), true},
+		{"code after value", RulePatternTerm{"price", OpLT, dec("51")}, price("50.99 INR"), true},
+		{"other currency", RulePatternTerm{"price", OpEQ, dec("50.8")}, price("USD 50.8"), nil},
+		{"no currency", RulePatternTerm{"weight", OpEQ, dec("1.5")}, Entity{"item", []Attr{{"weight", "KGS 1.5"}}}, nil},
+		{"large", RulePatternTerm{"price", OpGT, dec("99999999999999999999.99")}, price("100000000000000000000"), true},
+		{"negative", RulePatternTerm{"price", OpLT, dec("-0.5")}, price("-0.51"), true},
+		{"unparseable", RulePatternTerm{"price", OpGE, dec("1")}, price("1e3"), nil},
+		{"attributes compared", RulePatternTerm{"price", OpEQ, AttrRef{"listprice"}}, price("INR 50.80"), true},
+		{"attributes ordered", RulePatternTerm{"price", OpLT, AttrRef{"listprice"}}, price("50.799"), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			testPatternAllWays(t, schema, []RulePatternTerm{tt.term}, MissingNoMatch, tt.entity, tt.want)
+		})
+	}
+}
+
+func TestMatchLists(t *testing.T) {
+	schema := RuleSchema{
+		Class: "customer",
+		PatternSchema: []AttrSchema{
+			{Name: "tags", ValType: TypeList, ElemType: TypeEnum, Vals: map[string]bool{"gold": true, "nri": true, "blocked": true}},
+			{Name: "held", ValType: TypeList, ElemType: TypeStr},
+			{Name: "accts", ValType: TypeList, ElemType: TypeInt},
+		},
+	}
+	customer := func(tags string) Entity {
+		return Entity{"customer", []Attr{{"tags", tags}, {"held", `["INFY", "TCS, Ltd"]`}, {"accts", `[101, "102"]`}}}
+	}
+	goldNRI := customer(EncodeList("gold", "nri"))
+	tests := []struct {
+		name    string
+		term    RulePatternTerm
+		missing string
+		entity  Entity
+		want    any
+	}{
+		{"hasany", RulePatternTerm{"tags", OpHasAny, []string{"blocked", "nri"}}, MissingDefault, goldNRI, true},
+		{"not hasany", RulePatternTerm{"tags", OpHasAny, []string{"blocked"}}, MissingDefault, goldNRI, false},
+		{"hasall", RulePatternTerm{"tags", OpHasAll, []any{"nri", "gold"}}, MissingDefault, goldNRI, true},
+		{"not hasall", RulePatternTerm{"tags", OpHasAll, []string{"gold", "blocked"}}, MissingDefault, goldNRI, false},
+		{"hasnone", RulePatternTerm{"tags", OpHasNone, []string{"blocked"}}, MissingDefault, goldNRI, true},
+		{"not hasnone", RulePatternTerm{"tags", OpHasNone, []string{"blocked", "gold"}}, MissingDefault, goldNRI, false},
+		{"duplicates", RulePatternTerm{"tags", OpHasAll, []string{"gold", "nri"}}, MissingDefault, customer(`["gold", "gold"]`), false},
+		{"str with comma", RulePatternTerm{"held", OpHasAny, []string{"TCS, Ltd"}}, MissingDefault, goldNRI, true},
+		{"ints", RulePatternTerm{"accts", OpHasAll, []int{101, 102}}, MissingDefault, goldNRI, true},
+		{"count", RulePatternTerm{"tags", OpCountEQ, 2}, MissingDefault, goldNRI, true},
+		{"count gt", RulePatternTerm{"tags", OpCountGT, 2}, MissingDefault, goldNRI, false},
+		{"count le", RulePatternTerm{"held", OpCountLE, 2}, MissingDefault, goldNRI, true},
+		{"empty list count", RulePatternTerm{"tags", OpCountEQ, 0}, MissingDefault, customer("[]"), true},
+		{"empty list hasnone", RulePatternTerm{"tags", OpHasNone, []string{"gold"}}, MissingDefault, customer("[]"), true},
+		{"not a list", RulePatternTerm{"tags", OpHasAny, []string{"gold"}}, MissingDefault, customer("gold"), nil},
+		{"trailing data", RulePatternTerm{"tags", OpHasAny, []string{"gold"}}, MissingDefault, customer(`["gold"] []`), nil},
+		{"number in enum list", RulePatternTerm{"tags", OpCountGE, 1}, MissingDefault, customer(`[1]`), nil},
+		{"missing", RulePatternTerm{"tags", OpHasAny, []string{"gold"}}, MissingDefault, customer(""), nil},
+		{"missing, unknown", RulePatternTerm{"tags", OpCountLT, 3}, MissingUnknown, customer(""), errUnknown},
+		{"missing, nomatch", RulePatternTerm{"tags", OpHasNone, []string{"gold"}}, MissingNoMatch, customer(""), false},
+		{"exists", RulePatternTerm{"tags", OpExists, nil}, MissingDefault, customer("[]"), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			testPatternAllWays(t, schema, []RulePatternTerm{tt.term}, tt.missing, tt.entity, tt.want)
+		})
+	}
+}
+
+// Every term in a match sees the same time, however long the match takes
+func TestClockReadOncePerMatch(t *testing.T) {
+	e := NewEngine()
+	e.AddRuleSchema(RuleSchema{Class: "item", PatternSchema: []AttrSchema{{Name: "received", ValType: TypeTS}},
+		ActionSchema: ActionSchema{Tasks: []string{"recent", "old"}}})
+	e.AddRuleSet(RuleSet{Ver: 1, Class: "item", SetName: mainRS, Rules: []Rule{
+		{[]RulePatternTerm{{"received", OpWithin, "1h"}}, RuleActions{Tasks: []string{"recent"}}},
+		{[]RulePatternTerm{{"received", OpOlderThan, "1h"}}, RuleActions{Tasks: []string{"old"}}},
+	}})
+	now := testClock()
+	reads := 0
+	e.SetClock(func() time.Time {
+		reads++
+		now = now.Add(time.Hour)
+		return now
+	})
+	entity := Entity{"item", []Attr{{"received", testClock().Add(59 * time.Minute).Format(time.RFC3339)}}}
+	got, err := e.Match(entity, mainRS)
+	if err != nil || !reflect.DeepEqual(got.Tasks, []string{"recent"}) || reads != 1 {
+		t.Errorf("Match() = %v, %v after %v clock reads, want task recent after 1", got, err, reads)
+	}
+	got, err = e.Match(entity, mainRS)
+	if err != nil || !reflect.DeepEqual(got.Tasks, []string{"old"}) || reads != 2 {
+		t.Errorf("Match() = %v, %v after %v clock reads, want task old after 2", got, err, reads)
+	}
+}
@@ -0,0 +1,114 @@
+/*
+This file contains ruleRegistry, which holds the schemas and rulesets owned by an Engine, and
+ruleStore, an immutable version of those schemas and rulesets.
+*/
+
+package crux
+
+import (
+	"fmt"
+	"regexp"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// A ruleStore is never modified after it has been published by a ruleRegistry. A match reads
+// from one ruleStore from start to finish, so it never sees a half-applied update.
+type ruleStore struct {
+	schemas  map[string]RuleSchema
+	ruleSets map[string]RuleSet
+
+	// The compiled forms of the schemas, and of the rulesets that could be compiled
+	compiledSchemas map[string]*compiledSchema
+	compiled        map[string]*compiledRuleSet
+
+	// The engine's clock, or nil for time.Now() (see time_ops.go)
+	clock func() time.Time
+
+	// Whether entities are validated before they are matched (see validate_entity.go)
+	strict bool
+
+	// The compiled regular expressions in the matches terms of the rulesets, by pattern, and
+	// the parsed expressions in their terms, by source, for rulesets that are not compiled and
+	// for traces
+	regexps map[string]*regexp.Regexp
+	exprs   map[string]*exprNode
+}
+
+// ruleRegistry is safe for concurrent use. Readers load the current ruleStore without locking.
+// Writers are serialised by mu, and publish a modified copy of the current ruleStore.
+type ruleRegistry struct {
+	mu    sync.Mutex
+	store atomic.Pointer[ruleStore]
+}
+
+func newRuleRegistry() *ruleRegistry {
+	r := &ruleRegistry{}
+	r.store.Store(&ruleStore{
+		schemas:         map[string]RuleSchema{},
+		ruleSets:        map[string]RuleSet{},
+		compiledSchemas: map[string]*compiledSchema{},
+		compiled:        map[string]*compiledRuleSet{},
+	})
+	return r
+}
+
+// Returns the current version of the registry's schemas and rulesets
+func (r *ruleRegistry) load() *ruleStore {
+	return r.store.Load()
+}
+
+// Applies change to a copy of the current ruleStore and publishes the copy
+func (r *ruleRegistry) update(change func(st *ruleStore)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	curr := r.store.Load()
+	next := &ruleStore{
+		schemas:         make(map[string]RuleSchema, len(curr.schemas)),
+		ruleSets:        make(map[string]RuleSet, len(curr.ruleSets)),
+		compiledSchemas: make(map[string]*compiledSchema, len(curr.compiledSchemas)),
+		compiled:        make(map[string]*compiledRuleSet, len(curr.compiled)),
+		clock:           curr.clock,
+		strict:          curr.strict,
+	}
+	for class, s := range curr.schemas {
+		next.schemas[class] = s
+	}
+	for setName, rs := range curr.ruleSets {
+		next.ruleSets[setName] = rs
+	}
+	for class, cs := range curr.compiledSchemas {
+		next.compiledSchemas[class] = cs
+	}
+	for setName, crs := range curr.compiled {
+		next.compiled[setName] = crs
+	}
+	change(next)
+	next.regexps = compileRegexps(next.ruleSets, curr.regexps)
+	next.exprs = compileExprs(next.ruleSets, curr.exprs)
+	r.store.Store(next)
+}
+
+func (st *ruleStore) getSchema(class string) (RuleSchema, error) {
+	s, ok := st.schemas[class]
+	if !ok {
+		return RuleSchema{}, fmt.Errorf("no schema found for class %v", class)
+	}
+	return s, nil
+}
+
+func (st *ruleStore) getRuleSet(setName string) (RuleSet, bool) {
+	rs, ok := st.ruleSets[setName]
+	return rs, ok
+}
+
+// Returns the value-type of the attribute attrName in the schema for class, or "" if there is
+// no such attribute
+func (st *ruleStore) getTypeFromSchema(class string, attrName string) string {
+	s, ok := st.schemas[class]
+	if !ok {
+		return ""
+	}
+	return getType(s, attrName)
+}
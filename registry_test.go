@@ -0,0 +1,110 @@
+package crux
+
+import (
+	"reflect"
+	"sync"
+	"testing"
+)
+
+func TestEnginesAreIndependent(t *testing.T) {
+	e1, e2 := NewEngine(), NewEngine()
+	e1.AddRuleSchema(testCounterSchema())
+	e2.AddRuleSchema(testCounterSchema())
+	e1.AddRuleSet(testCounterRuleSet("one"))
+	e2.AddRuleSet(testCounterRuleSet("two"))
+
+	entity := Entity{counterClass, []Attr{{"n", "1"}}}
+	got1, err1 := e1.Match(entity, mainRS)
+	got2, err2 := e2.Match(entity, mainRS)
+	if err1 != nil || err2 != nil {
+		t.Fatalf("unexpected errors: %v, %v", err1, err2)
+	}
+	if !reflect.DeepEqual(got1.Tasks, []string{"one"}) || !reflect.DeepEqual(got2.Tasks, []string{"two"}) {
+		t.Errorf("engines share rulesets: got %v and %v", got1, got2)
+	}
+}
+
+// A match must start with a ruleset of the entity's class
+func TestMatchRuleSetOfOtherClass(t *testing.T) {
+	e := NewEngine()
+	e.AddRuleSchema(testCounterSchema(), RuleSchema{
+		Class:         "gauge",
+		PatternSchema: []AttrSchema{{Name: "n", ValType: TypeInt}},
+	})
+	e.AddRuleSet(testCounterRuleSet("one"))
+
+	entity := Entity{"gauge", []Attr{{"n", "1"}}}
+	if got, err := e.Match(entity, mainRS); err == nil {
+		t.Errorf("Match() = %v, expected but did not get error", got)
+	}
+	if got, _, err := e.MatchWithTrace(entity, mainRS); err == nil {
+		t.Errorf("MatchWithTrace() = %v, expected but did not get error", got)
+	}
+}
+
+// Matches run concurrently with updates that replace both "main" and the ruleset it calls.
+// Each match must see one consistent version, in which the two rulesets set the same task.
+func TestConcurrentMatchAndUpdate(t *testing.T) {
+	e := NewEngine()
+	e.AddRuleSchema(testCounterSchema())
+	e.AddRuleSet(testCounterCallingRuleSets("v0")...)
+	entity := Entity{counterClass, []Attr{{"n", "1"}}}
+
+	var wg sync.WaitGroup
+	versions := []string{"vone", "vtwo", "vthree", "vfour"}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 200; i++ {
+			e.AddRuleSet(testCounterCallingRuleSets(versions[i%len(versions)])...)
+		}
+	}()
+	for g := 0; g < 4; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 200; i++ {
+				got, err := e.Match(entity, mainRS)
+				if err != nil {
+					t.Errorf("unexpected error: %v", err)
+					return
+				}
+				if len(got.Tasks) != 1 || len(got.Properties) != 1 || got.Tasks[0] != got.Properties[0].Val {
+					t.Errorf("match saw an inconsistent registry: %v", got)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+const counterClass = "counter"
+
+func testCounterSchema() RuleSchema {
+	return RuleSchema{
+		Class:         counterClass,
+		PatternSchema: []AttrSchema{{Name: "n", ValType: TypeInt}},
+	}
+}
+
+func testCounterRuleSet(task string) RuleSet {
+	return RuleSet{Ver: 1, Class: counterClass, SetName: mainRS, Rules: []Rule{{
+		[]RulePatternTerm{{"n", OpGT, 0}},
+		RuleActions{Tasks: []string{task}},
+	}}}
+}
+
+// Returns a "main" ruleset that sets the task version, and a "second" ruleset, called by
+// "main", that sets the property "version" to version
+func testCounterCallingRuleSets(version string) []RuleSet {
+	main := RuleSet{Ver: 1, Class: counterClass, SetName: mainRS, Rules: []Rule{{
+		[]RulePatternTerm{{"n", OpGT, 0}},
+		RuleActions{Tasks: []string{version}, ThenCall: "second"},
+	}}}
+	second := RuleSet{Ver: 1, Class: counterClass, SetName: "second", Rules: []Rule{{
+		[]RulePatternTerm{{"n", OpGT, 0}},
+		RuleActions{Properties: []Property{{"version", version}}},
+	}}}
+	return []RuleSet{main, second}
+}
@@ -0,0 +1,272 @@
+/*
+This file contains ruleIndex, a discrimination network over the rules of a compiledRuleSet.
+For a given entity, it returns the rules that could match, so that doMatchCompiled() need not
+evaluate the others.
+
+Each rule is indexed by one of the terms of its pattern that are not in a group, its "key":
+preferably an eq or in term on an enum or str attribute, which is looked up in a hash table of
+values, or failing that, an lt, le, gt or ge term on an int, float or ts attribute, which is
+looked up by binary search in the constants of all such terms on that attribute and operator. A
+rule that is not returned by the index has a key term that is false for the entity, so the rule
+cannot match.
+
+Skipping a rule must not change the outcome of a match, so the following rules are always
+returned as candidates:
+  - rules without a suitable key term
+  - rules with an ElseCall, whose actions depend on the rule not matching
+  - rules with a term that cannot be evaluated without an error, or with an expression that
+    may fail with an error, such as a division by zero
+  - for each entity, rules with a term whose attribute has a value that cannot be converted to
+    the attribute's type, such as a NaN float, or a term on an attribute that the entity does not have which fails
+    with an error under the ruleset's missing-attribute policy: an ordered or string comparison,
+    or a comparison of two attributes or expressions, under MissingDefault, and any comparison
+    under MissingError; matchPattern() returns an error for such terms, and so must
+    doMatchCompiled()
+Terms in any, all and not groups are never keys, but count as terms of the rule for the last two
+kinds of candidates. Candidates are evaluated in their order in the ruleset, so WillExit,
+WillReturn and ThenCall behave exactly as they do without the index.
+*/
+
+package crux
+
+import (
+	"math/bits"
+	"sort"
+)
+
+// Rulesets with fewer rules than this are not indexed, since scanning them is cheap enough
+var indexMinRules = 64
+
+type rangeEntry struct {
+	val  any
+	rule int
+}
+
+type rangeKey struct {
+	slot int
+	op   string
+}
+
+type ruleIndex struct {
+	// The value-types of the slots of the ruleset's schema
+	types []string
+	// Rules that must always be evaluated
+	always []uint64
+	// For eq and in terms on enum and str attributes: slot -> value -> rules
+	hashed map[int]map[string][]int
+	// For ordered terms on int, float and ts attributes: rules sorted by the term's value
+	ranges map[rangeKey][]rangeEntry
+	// Slots used by any term, and for each slot, the rules that use it in any term, and in terms
+	// that fail with an error if the entity does not have the attribute
+	usedSlots   []int
+	slotRules   map[int][]int
+	absentRules map[int][]int
+}
+
+var indexRangeTypes = map[string]bool{TypeInt: true, TypeFloat: true, TypeTS: true, TypeDate: true, TypeDecimal: true}
+var orderedOps = map[string]bool{OpLT: true, OpLE: true, OpGT: true, OpGE: true}
+
+func newRuleIndex(rules []compiledRule, types []string, missing string) *ruleIndex {
+	idx := &ruleIndex{
+		types:       types,
+		always:      make([]uint64, (len(rules)+63)/64),
+		hashed:      map[int]map[string][]int{},
+		ranges:      map[rangeKey][]rangeEntry{},
+		slotRules:   map[int][]int{},
+		absentRules: map[int][]int{},
+	}
+	used := map[int]bool{}
+	for i, rule := range rules {
+		for _, ct := range leafTerms(rule.terms) {
+			// A term that compares two attributes, or expressions, uses the slots of all their
+			// attributes, and under MissingDefault, fails with an error if any of them is missing
+			var slots []int
+			if ct.slot >= 0 {
+				slots = append(slots, ct.slot)
+			}
+			if ct.refSlot >= 0 {
+				slots = append(slots, ct.refSlot)
+			}
+			for _, es := range ct.exprSlots {
+				slots = append(slots, es.slot)
+			}
+			compares := ct.refSlot >= 0 || ct.left != nil
+			for _, slot := range slots {
+				if !used[slot] {
+					used[slot] = true
+					idx.usedSlots = append(idx.usedSlots, slot)
+				}
+				idx.slotRules[slot] = appendOnce(idx.slotRules[slot], i)
+				if failsIfAbsent(ct.op, missing) || (compares && missing == MissingDefault) {
+					idx.absentRules[slot] = appendOnce(idx.absentRules[slot], i)
+				}
+			}
+		}
+		key := chooseKeyTerm(rule)
+		if key == nil {
+			setBit(idx.always, i)
+			continue
+		}
+		if key.op == OpEQ || key.op == OpIn {
+			if idx.hashed[key.slot] == nil {
+				idx.hashed[key.slot] = map[string][]int{}
+			}
+			// An in term is indexed under each of its values
+			keyVals := []any{key.val}
+			if key.op == OpIn {
+				keyVals = keyVals[:0]
+				for val := range key.val.(valSet) {
+					keyVals = append(keyVals, val)
+				}
+			}
+			for _, val := range keyVals {
+				s := val.(string)
+				idx.hashed[key.slot][s] = append(idx.hashed[key.slot][s], i)
+			}
+		} else {
+			rk := rangeKey{key.slot, key.op}
+			idx.ranges[rk] = append(idx.ranges[rk], rangeEntry{key.val, i})
+		}
+	}
+	for _, entries := range idx.ranges {
+		sort.SliceStable(entries, func(a, b int) bool {
+			c, _ := compare(entries[a].val, entries[b].val)
+			return c == -1
+		})
+	}
+	return idx
+}
+
+// Returns the terms in terms and, recursively, in the groups in terms, other than the groups
+func leafTerms(terms []compiledTerm) []*compiledTerm {
+	var leaves []*compiledTerm
+	for i := range terms {
+		if groupOps[terms[i].op] {
+			leaves = append(leaves, leafTerms(terms[i].terms)...)
+		} else {
+			leaves = append(leaves, &terms[i])
+		}
+	}
+	return leaves
+}
+
+// Returns whether a term with op on an attribute that the entity does not have fails with an error
+// under the missing-attribute policy missing. Under MissingUnknown and MissingNoMatch, such a term
+// is unknown or false, and the rule can be skipped if its key term is false.
+func failsIfAbsent(op string, missing string) bool {
+	switch missing {
+	case MissingDefault:
+		return orderedOps[op] || stringOps[op] || timeOps[op] || listOps[op]
+	case MissingError:
+		return op != OpExists && op != OpNotExists
+	}
+	return false
+}
+
+// Returns the term by which rule is indexed, or nil if it must always be evaluated
+func chooseKeyTerm(rule compiledRule) *compiledTerm {
+	if len(rule.actions.ElseCall) > 0 {
+		return nil
+	}
+	var rangeTerm *compiledTerm
+	for _, ct := range leafTerms(rule.terms) {
+		if orderedOps[ct.op] && !orderedTypes[ct.valType] && ct.left == nil {
+			// This term always fails with an error when it is reached
+			return nil
+		}
+		if ct.mayFail {
+			// This term may fail with an error, such as a division by zero, for any entity
+			return nil
+		}
+	}
+	for i := range rule.terms {
+		ct := &rule.terms[i]
+		if ct.slot < 0 || ct.refSlot >= 0 {
+			// Terms on tasks, and terms that compare two attributes or expressions, have no
+			// constant to look up
+			continue
+		}
+		if (ct.op == OpEQ || ct.op == OpIn) && (ct.valType == TypeEnum || ct.valType == TypeStr) {
+			return ct
+		}
+		if rangeTerm == nil && orderedOps[ct.op] && indexRangeTypes[ct.valType] {
+			rangeTerm = ct
+		}
+	}
+	return rangeTerm
+}
+
+// Returns, in ascending order, the indexes of the rules that could match the entity whose
+// values are in vals. Converts the values of all slots used by the ruleset.
+func (idx *ruleIndex) candidates(vals []slotVal) []int {
+	set := make([]uint64, len(idx.always))
+	copy(set, idx.always)
+	for _, slot := range idx.usedSlots {
+		sv := &vals[slot]
+		if !sv.present {
+			setBits(set, idx.absentRules[slot])
+			continue
+		}
+		if _, err := sv.convert(idx.types[slot]); err != nil {
+			setBits(set, idx.slotRules[slot])
+		}
+	}
+	for slot, byVal := range idx.hashed {
+		if vals[slot].present && vals[slot].err == nil {
+			setBits(set, byVal[vals[slot].raw])
+		}
+	}
+	for rk, entries := range idx.ranges {
+		sv := vals[rk.slot]
+		if !sv.present || sv.err != nil {
+			continue
+		}
+		// Find the entries whose constant c makes "entity value <op> c" true
+		var from, to int
+		switch rk.op {
+		case OpGT, OpGE:
+			// c < x, or c <= x: a prefix of the sorted entries
+			to = sort.Search(len(entries), func(k int) bool {
+				c, _ := compare(entries[k].val, sv.conv)
+				return c == 1 || (c == 0 && rk.op == OpGT)
+			})
+		case OpLT, OpLE:
+			// c > x, or c >= x: a suffix of the sorted entries
+			from = sort.Search(len(entries), func(k int) bool {
+				c, _ := compare(entries[k].val, sv.conv)
+				return c == 1 || (c == 0 && rk.op == OpLE)
+			})
+			to = len(entries)
+		}
+		for _, e := range entries[from:to] {
+			setBit(set, e.rule)
+		}
+	}
+
+	var result []int
+	for w, word := range set {
+		for word != 0 {
+			b := bits.TrailingZeros64(word)
+			result = append(result, w*64+b)
+			word &= word - 1
+		}
+	}
+	return result
+}
+
+func appendOnce(rules []int, rule int) []int {
+	if len(rules) > 0 && rules[len(rules)-1] == rule {
+		return rules
+	}
+	return append(rules, rule)
+}
+
+func setBit(set []uint64, i int) {
+	set[i/64] |= 1 << (i % 64)
+}
+
+func setBits(set []uint64, rules []int) {
+	for _, i := range rules {
+		setBit(set, i)
+	}
+}
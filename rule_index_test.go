@@ -0,0 +1,303 @@
+package crux
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+// Indexes the compiled rulesets of at least minRules rules that are added while f runs
+func withIndexMinRules(minRules int, f func()) {
+	saved := indexMinRules
+	indexMinRules = minRules
+	defer func() { indexMinRules = saved }()
+	f()
+}
+
+// Indexes every compiled ruleset added while f runs, however small
+func withIndexedRuleSets(f func()) {
+	withIndexMinRules(0, f)
+}
+
+// Every doMatch() test is run by both doMatch() and doMatchCompiled() on an indexed ruleset,
+// which must agree
+func TestIndexedMatchesInterpreter(t *testing.T) {
+	tests := []doMatchTest{}
+	setupInventoryItemSchema()
+	testBasic(&tests)
+	testExit(&tests)
+	testReturn(&tests)
+	testTransactions(&tests)
+	testPurchases(&tests)
+	testOrders(&tests)
+	testUCCCreation(&tests)
+	testPrepareAOF(&tests)
+	testValidateAOF(&tests)
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			withIndexedRuleSets(func() { testEngine.AddRuleSet(tt.ruleSet) })
+			m := matcher{store: testEngine.registry.load()}
+			want, _, wantErr := m.doMatch(tt.entity, tt.ruleSet, ActionSet{}, map[string]bool{})
+			crs, ok := m.store.compiled[tt.ruleSet.SetName]
+			if !ok {
+				return
+			}
+			if crs.index == nil {
+				t.Fatalf("ruleset %v was not indexed", tt.ruleSet.SetName)
+			}
+			got, _, err := m.doMatchCompiled(tt.entity, crs.schema.entitySlots(tt.entity), crs, ActionSet{}, map[string]bool{})
+			if !reflect.DeepEqual(got, want) || (err != nil) != (wantErr != nil) {
+				t.Errorf("indexed doMatchCompiled() = %v, %v, doMatch() = %v, %v", got, err, want, wantErr)
+			}
+		})
+	}
+	// Put back the unindexed versions of the test rulesets
+	for _, tt := range tests {
+		testEngine.AddRuleSet(tt.ruleSet)
+	}
+}
+
+// Random rulesets, with exits, returns, calls, tasks used as attributes, groups, comparisons of
+// two attributes, decimals, lists and every missing-attribute policy, are matched against random entities, some
+// with missing or unparseable values, with and without the index
+func TestIndexedMatchesUnindexed(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	regions := []string{"north", "south", "east", "west"}
+	var randomTerm func() RulePatternTerm
+	randomTerm = func() RulePatternTerm {
+		ops := []string{OpEQ, OpNE, OpLT, OpLE, OpGT, OpGE}
+		switch rnd.Intn(17) {
+		case 0:
+			return RulePatternTerm{"region", []string{OpEQ, OpNE}[rnd.Intn(2)], regions[rnd.Intn(len(regions))]}
+		case 1:
+			return RulePatternTerm{"product", []string{OpEQ, OpNE}[rnd.Intn(2)], "product" + strconv.Itoa(rnd.Intn(5))}
+		case 2:
+			return RulePatternTerm{"qty", ops[rnd.Intn(len(ops))], rnd.Intn(10)}
+		case 3:
+			return RulePatternTerm{"mrp", ops[rnd.Intn(len(ops))], float64(rnd.Intn(10)) + 0.5}
+		case 4:
+			return RulePatternTerm{"ordered", ops[rnd.Intn(len(ops))], fmt.Sprintf("2023-0%v-01T00:00:00Z", 1+rnd.Intn(9))}
+		case 5:
+			return RulePatternTerm{"region", []string{OpIn, OpNIn}[rnd.Intn(2)], regions[:1+rnd.Intn(len(regions)-1)]}
+		case 6:
+			return RulePatternTerm{"qty", []string{OpIn, OpNIn}[rnd.Intn(2)], []int{rnd.Intn(10), rnd.Intn(10)}}
+		case 7:
+			stringOps := []string{OpContains, OpStartsWith, OpEndsWith, OpMatches}
+			return RulePatternTerm{"product", stringOps[rnd.Intn(len(stringOps))], strconv.Itoa(rnd.Intn(5))}
+		case 8:
+			return RulePatternTerm{[]string{"qty", "product"}[rnd.Intn(2)], []string{OpExists, OpNotExists}[rnd.Intn(2)], nil}
+		case 9:
+			terms := []RulePatternTerm{randomTerm()}
+			for j := rnd.Intn(3); j > 0; j-- {
+				terms = append(terms, randomTerm())
+			}
+			return RulePatternTerm{Op: []string{OpAny, OpAll, OpNot}[rnd.Intn(3)], AttrVal: terms}
+		case 10:
+			return RulePatternTerm{"channel", []string{OpEQ, OpNE}[rnd.Intn(2)], AttrRef{"region"}}
+		case 11:
+			return RulePatternTerm{"member", []string{OpEQ, OpNE, OpGT}[rnd.Intn(3)], rnd.Intn(2) == 0}
+		case 12:
+			exprs := []RulePatternTerm{
+				{"", OpGT, Arith{"mrp * qty", strconv.Itoa(rnd.Intn(40))}},
+				{"qty", OpLE, Expr{"mrp - " + strconv.Itoa(rnd.Intn(5))}},
+				{"ordered", OpLT, Expr{"ordered - hours(mrp * 10) + days(" + strconv.Itoa(rnd.Intn(3)) + ")"}},
+				{"", OpNE, Arith{"12 / (qty - 3)", "2"}},
+			}
+			term := exprs[rnd.Intn(len(exprs))]
+			term.Op = ops[rnd.Intn(len(ops))]
+			return term
+		case 13:
+			return []RulePatternTerm{
+				{"ordered", OpDayOfWeek, []string{"mon", "sun"}[:1+rnd.Intn(2)]},
+				{"ordered", OpMonth, []string{"jan", "may"}[:1+rnd.Intn(2)]},
+				{"ordered", OpHourIn, []int{rnd.Intn(24), 24}},
+				{"ordered", OpOlderThan, "30d"},
+			}[rnd.Intn(4)]
+		case 14:
+			price, _ := ParseDecimal([]string{"4.5", "4.50", "7", "19.99"}[rnd.Intn(4)])
+			return RulePatternTerm{"price", ops[rnd.Intn(len(ops))], []any{price, "INR " + price.String()}[rnd.Intn(2)]}
+		case 15:
+			if rnd.Intn(2) == 0 {
+				return RulePatternTerm{"tags", []string{OpCountEQ, OpCountGT, OpCountLE}[rnd.Intn(3)], rnd.Intn(3)}
+			}
+			tags := []string{"new", "sale", "bulk"}[rnd.Intn(3):]
+			return RulePatternTerm{"tags", []string{OpHasAny, OpHasAll, OpHasNone}[rnd.Intn(3)], tags}
+		default:
+			return RulePatternTerm{"discount", OpEQ, rnd.Intn(2) == 0}
+		}
+	}
+	randomRuleSet := func(setName string, numRules int, callable []string) RuleSet {
+		rules := make([]Rule, numRules)
+		for i := range rules {
+			var pattern []RulePatternTerm
+			for j := rnd.Intn(4); j > 0; j-- {
+				pattern = append(pattern, randomTerm())
+			}
+			actions := RuleActions{Properties: []Property{{"rate", setName + strconv.Itoa(i)}}}
+			if rnd.Intn(3) == 0 {
+				actions.Tasks = []string{"discount"}
+			}
+			switch n := rnd.Intn(20); {
+			case n == 0:
+				actions.WillExit = true
+			case n == 1:
+				actions.WillReturn = true
+			case n < 4 && len(callable) > 0:
+				actions.ThenCall = callable[rnd.Intn(len(callable))]
+			case n < 6 && len(callable) > 0:
+				actions.ElseCall = callable[rnd.Intn(len(callable))]
+			}
+			rules[i] = Rule{pattern, actions}
+		}
+		policies := []string{MissingDefault, MissingUnknown, MissingNoMatch, MissingError}
+		return RuleSet{Ver: 1, Class: "pricing", SetName: setName, Rules: rules, MissingAttrs: policies[rnd.Intn(len(policies))]}
+	}
+	randomVal := func(vals ...string) string {
+		switch rnd.Intn(10) {
+		case 0:
+			return ""
+		case 1:
+			return "bad"
+		}
+		return vals[rnd.Intn(len(vals))]
+	}
+
+	for round := 0; round < 20; round++ {
+		plain := setupBenchmarkEngine(t, 0)
+		indexed := setupBenchmarkEngine(t, 0)
+		ruleSets := []RuleSet{randomRuleSet("leaf", 30, nil), randomRuleSet("mid", 30, []string{"leaf"})}
+		ruleSets = append(ruleSets, randomRuleSet(mainRS, 200, []string{"leaf", "mid"}))
+		withIndexMinRules(math.MaxInt, func() { plain.AddRuleSet(ruleSets...) })
+		withIndexedRuleSets(func() { indexed.AddRuleSet(ruleSets...) })
+		if plain.registry.load().compiled[mainRS].index != nil || indexed.registry.load().compiled[mainRS].index == nil {
+			t.Fatalf("ruleset %v was indexed in the wrong engine", mainRS)
+		}
+
+		for i := 0; i < 50; i++ {
+			entity := Entity{"pricing", []Attr{
+				{"region", randomVal(regions...)},
+				{"product", randomVal("product0", "product1", "product2", "product3", "product4")},
+				{"qty", randomVal("0", "3", "5", "9", "12")},
+				{"mrp", randomVal("0.5", "4.5", "7", "20", "NaN")},
+				{"ordered", randomVal("2023-01-01T00:00:00Z", "2023-05-01T00:00:00Z", "2024-01-01T00:00:00Z")},
+				{"member", randomVal(trueStr, falseStr)},
+				{"channel", randomVal("online", "store", "web")},
+				{"price", randomVal("4.5", "INR 4.500", "7.00 INR", "USD 7", "20")},
+				{"tags", randomVal("[]", `["new"]`, `["sale", "bulk"]`, `["new", "sale", "bulk"]`)},
+			}}
+			want, wantErr := plain.Match(entity, mainRS)
+			got, err := indexed.Match(entity, mainRS)
+			if !reflect.DeepEqual(got, want) || (err != nil) != (wantErr != nil) {
+				t.Fatalf("round %v: indexed Match(%v) = %v, %v, unindexed Match() = %v, %v", round, entity, got, err, want, wantErr)
+			}
+		}
+	}
+}
+
+func TestIndexCandidates(t *testing.T) {
+	var e *Engine
+	withIndexedRuleSets(func() { e = setupBenchmarkEngine(t, 100) })
+	crs := e.registry.load().compiled[mainRS]
+
+	// Each rule is keyed on its product, so only one rule is a candidate
+	entity := benchmarkEntity(100)
+	if got := crs.index.candidates(crs.schema.entitySlots(entity)); !reflect.DeepEqual(got, []int{99}) {
+		t.Errorf("candidates() = %v, want [99]", got)
+	}
+
+	// Without a product, every rule's ordered terms on the other attributes must be evaluated,
+	// since a missing qty fails with an error
+	entity.Attrs[3].Val = ""
+	entity.Attrs[4].Val = ""
+	if got := crs.index.candidates(crs.schema.entitySlots(entity)); len(got) != 100 {
+		t.Errorf("candidates() returned %v rules, want 100", len(got))
+	}
+	if _, err := e.Match(entity, mainRS); err == nil {
+		t.Errorf("Match(): expected but did not get error for a missing qty")
+	}
+}
+
+// A NaN float is not a valid value, so the index and the interpreter both fail the match with an
+// error, rather than the index dropping rules that compare it
+func TestIndexNaN(t *testing.T) {
+	var rules []Rule
+	for i := 0; i < 4; i++ {
+		for _, op := range []string{OpGT, OpLE} {
+			rules = append(rules, Rule{[]RulePatternTerm{{"mrp", op, float64(i) + 0.5}},
+				RuleActions{Properties: []Property{{"rate", op + strconv.Itoa(i)}}}})
+		}
+	}
+	ruleSet := RuleSet{Ver: 1, Class: "pricing", SetName: mainRS, Rules: rules}
+	plain := setupBenchmarkEngine(t, 0)
+	indexed := setupBenchmarkEngine(t, 0)
+	withIndexMinRules(math.MaxInt, func() { plain.AddRuleSet(ruleSet) })
+	withIndexedRuleSets(func() { indexed.AddRuleSet(ruleSet) })
+	if indexed.registry.load().compiled[mainRS].index == nil {
+		t.Fatalf("ruleset %v was not indexed", mainRS)
+	}
+
+	entity := Entity{"pricing", []Attr{{"mrp", "NaN"}}}
+	want, wantErr := plain.Match(entity, mainRS)
+	got, err := indexed.Match(entity, mainRS)
+	if !reflect.DeepEqual(got, want) || err == nil || wantErr == nil {
+		t.Errorf("indexed Match() = %v, %v, unindexed Match() = %v, %v, want errors", got, err, want, wantErr)
+	}
+}
+
+// A rule whose key term is false must still be evaluated if an earlier term fails with an error
+func TestIndexKeepsErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		term  RulePatternTerm
+		attrs []Attr
+	}{
+		{"ordered op on missing attribute", RulePatternTerm{"qty", OpGT, 1}, []Attr{{"region", "south"}}},
+		{"string op on missing attribute", RulePatternTerm{"product", OpContains, "x"}, []Attr{{"region", "south"}}},
+		{"unconvertible value", RulePatternTerm{"mrp", OpNE, 1.5}, []Attr{{"region", "south"}, {"mrp", "abc"}}},
+		{"term in group", RulePatternTerm{Op: OpNot, AttrVal: []RulePatternTerm{{"qty", OpGT, 1}}}, []Attr{{"region", "south"}}},
+		{"missing other attribute", RulePatternTerm{"channel", OpNE, AttrRef{"segment"}}, []Attr{{"region", "south"}, {"channel", "web"}}},
+		{"time op on missing attribute", RulePatternTerm{"ordered", OpWithin, "30d"}, []Attr{{"region", "south"}}},
+		{"division by zero", RulePatternTerm{"", OpGT, Arith{"mrp / (qty - 5)", "1"}}, []Attr{{"region", "south"}, {"mrp", "2"}, {"qty", "5"}}},
+		{"missing attribute in expression", RulePatternTerm{"", OpGT, Arith{"mrp * 2", "1"}}, []Attr{{"region", "south"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := setupBenchmarkEngine(t, 0)
+			withIndexedRuleSets(func() {
+				e.AddRuleSet(RuleSet{Ver: 1, Class: "pricing", SetName: mainRS, Rules: []Rule{{
+					[]RulePatternTerm{tt.term, {"region", OpEQ, "north"}},
+					RuleActions{Tasks: []string{"discount"}},
+				}}})
+			})
+			if _, err := e.Match(Entity{"pricing", tt.attrs}, mainRS); err == nil {
+				t.Errorf("Match(): expected but did not get error")
+			}
+		})
+	}
+}
+
+func BenchmarkIndexedMatch(b *testing.B) {
+	for _, numRules := range []int{100, 1000, 5000} {
+		e := setupBenchmarkEngine(b, numRules)
+		entity := benchmarkEntity(numRules)
+		st := e.registry.load()
+		crs := st.compiled[mainRS]
+		if crs.index == nil {
+			b.Fatalf("ruleset with %v rules was not indexed", numRules)
+		}
+
+		b.Run(fmt.Sprintf("indexed/%v", numRules), func(b *testing.B) {
+			m := matcher{store: st}
+			for i := 0; i < b.N; i++ {
+				vals := crs.schema.entitySlots(entity)
+				if _, _, err := m.doMatchCompiled(entity, vals, crs, ActionSet{}, map[string]bool{}); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
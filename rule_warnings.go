@@ -0,0 +1,110 @@
+/*
+This file contains the warnings that verification returns, through VerifyRuleSetWithWarnings()
+and VerifyRuleSetsWithWarnings(), for terms that are valid but probably not what was meant,
+because they compare an attribute with a value that the attribute cannot have under the limits
+in its AttrSchema (see validate_entity.go). Such a term is always true or never true:
+
+	int, float, decimal  a value of an eq, ne, lt, le, gt, ge, in or nin term is less than
+	                     AttrSchema.ValMin or more than AttrSchema.ValMax
+	str                  a value of an eq, ne, in or nin term is shorter than AttrSchema.LenMin or
+	                     longer than AttrSchema.LenMax, or the value of a contains, startswith or
+	                     endswith term is longer than LenMax
+
+An enum value that is not in AttrSchema.Vals is not a warning; verifyRuleSet() rejects it.
+*/
+
+package crux
+
+import (
+	"fmt"
+	"unicode/utf8"
+)
+
+// RuleWarning describes a term in the rule Rule of a ruleset, counting from 0, that is always or
+// never true. Term is the term itself, even if it is in a group.
+type RuleWarning struct {
+	Rule int             `json:"rule"`
+	Term RulePatternTerm `json:"term"`
+	Msg  string          `json:"msg"`
+}
+
+func (w RuleWarning) String() string {
+	return fmt.Sprintf("rule %v: %v", w.Rule, w.Msg)
+}
+
+// Returns a warning for each term of rs that compares an attribute with a value outside its limits
+// in schema. rs has been verified against schema.
+func ruleSetWarnings(rs RuleSet, schema RuleSchema) []RuleWarning {
+	var warnings []RuleWarning
+	for i, rule := range rs.Rules {
+		for _, term := range rule.RulePattern {
+			warnings = appendTermWarnings(warnings, i, term, schema)
+		}
+	}
+	return warnings
+}
+
+// Appends the warnings for term, in the rule ruleIdx, to warnings. The terms in a group are
+// checked in turn.
+func appendTermWarnings(warnings []RuleWarning, ruleIdx int, term RulePatternTerm, schema RuleSchema) []RuleWarning {
+	if groupOps[term.Op] {
+		terms, _ := term.AttrVal.([]RulePatternTerm)
+		for _, t := range terms {
+			warnings = appendTermWarnings(warnings, ruleIdx, t, schema)
+		}
+		return warnings
+	}
+	if !refOps[term.Op] && !setOps[term.Op] && !stringOps[term.Op] {
+		return warnings
+	}
+	as := getAttrSchema(schema, term.AttrName)
+	vals, ok := termList(term.AttrVal)
+	if !setOps[term.Op] {
+		vals, ok = []any{term.AttrVal}, true
+	}
+	if !ok {
+		return warnings
+	}
+	for _, val := range vals {
+		if msg := literalWarning(val, term.Op, as); msg != "" {
+			warnings = append(warnings, RuleWarning{Rule: ruleIdx, Term: term, Msg: msg})
+		}
+	}
+	return warnings
+}
+
+// Returns a warning if val, a value of a term with op on the attribute as, is outside the limits
+// of as, and "" otherwise
+func literalWarning(val any, op string, as AttrSchema) string {
+	switch as.ValType {
+	case TypeInt, TypeFloat, TypeDecimal:
+		if as.ValMin == 0 && as.ValMax == 0 || stringOps[op] {
+			return ""
+		}
+		conv := val
+		if as.ValType == TypeDecimal {
+			d, _, err := termDecimal(val)
+			if err != nil {
+				return ""
+			}
+			conv = d
+		}
+		if below, above := outOfRange(conv, as.ValMin, as.ValMax); below || above {
+			return fmt.Sprintf("%v is outside the range of %v, %v to %v", val, as.Name, as.ValMin, as.ValMax)
+		}
+	case TypeStr:
+		s, ok := val.(string)
+		if !ok || op == OpMatches {
+			return ""
+		}
+		n := utf8.RuneCountInString(s)
+		if stringOps[op] {
+			if as.LenMax > 0 && n > as.LenMax {
+				return fmt.Sprintf("%q is longer than any value of %v: %v characters, more than %v", s, as.Name, n, as.LenMax)
+			}
+		} else if _, msg := checkLength(n, "characters", as); msg != "" {
+			return fmt.Sprintf("%q cannot be a value of %v: %v", s, as.Name, msg)
+		}
+	}
+	return ""
+}
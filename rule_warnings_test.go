@@ -0,0 +1,58 @@
+package crux
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRuleSetWarnings(t *testing.T) {
+	e := testValidationEngine(t)
+	price := func(s string) Decimal {
+		d, _ := ParseDecimal(s)
+		return d
+	}
+	terms := []RulePatternTerm{
+		{"ageinstock", OpLE, 365},
+		{"ageinstock", OpGT, 400},
+		{"ageinstock", OpIn, []int{10, -1, 20}},
+		{"mrp", OpGT, 1e9},
+		{"price", OpLT, "INR 0.25"},
+		{"price", OpGE, price("1000")},
+		{"fullname", OpEQ, ""},
+		{"fullname", OpContains, "a"},
+		{"fullname", OpStartsWith, strings.Repeat("x", 81)},
+		{"fullname", OpMatches, strings.Repeat("x", 81)},
+		{"mrp", OpLT, AttrRef{"mrp"}},
+		{"", OpAny, []RulePatternTerm{{"cat", OpEQ, "textbook"}, {"ageinstock", OpNE, 1000}}},
+	}
+	rs := RuleSet{Ver: 1, Class: inventoryItemClass, SetName: mainRS, Rules: []Rule{
+		{terms[:6], RuleActions{Tasks: []string{"dodiscount"}}},
+		{terms[6:], RuleActions{Tasks: []string{"dodiscount"}}},
+	}}
+	want := []RuleWarning{
+		{0, terms[1], "400 is outside the range of ageinstock, 0 to 365"},
+		{0, terms[2], "-1 is outside the range of ageinstock, 0 to 365"},
+		{0, terms[4], "INR 0.25 is outside the range of price, 0.5 to 1000"},
+		{1, terms[6], `"" cannot be a value of fullname: 0 characters, less than 1`},
+		{1, terms[8], `"` + strings.Repeat("x", 81) + `" is longer than any value of fullname: 81 characters, more than 80`},
+		{1, RulePatternTerm{"ageinstock", OpNE, 1000}, "1000 is outside the range of ageinstock, 0 to 365"},
+	}
+	got, err := e.VerifyRuleSetWithWarnings(rs, false)
+	if err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("VerifyRuleSetWithWarnings() = %v, %v, want %v", got, err, want)
+	}
+	clean := RuleSet{Ver: 1, Class: inventoryItemClass, SetName: "second", Rules: []Rule{
+		{[]RulePatternTerm{{"ageinstock", OpLT, 10}}, RuleActions{Tasks: []string{"dodiscount"}}},
+	}}
+	byName, err := e.VerifyRuleSetsWithWarnings([]RuleSet{rs, clean}, false)
+	if err != nil || len(byName) != 1 || !reflect.DeepEqual(byName[mainRS], want) {
+		t.Errorf("VerifyRuleSetsWithWarnings() = %v, %v, want %v for %v only", byName, err, want, mainRS)
+	}
+
+	// An invalid ruleset has no warnings, only an error
+	rs.Rules[0].RulePattern = append(rs.Rules[0].RulePattern, RulePatternTerm{"cat", OpEQ, "spaceship"})
+	if got, err := e.VerifyRuleSetWithWarnings(rs, false); err == nil || got != nil {
+		t.Errorf("VerifyRuleSetWithWarnings() = %v, %v, want an error", got, err)
+	}
+}
@@ -1,35 +1,43 @@
-package main
-
-// Scaffolding for testing the matching engine
-var ruleSchemas = []RuleSchema{{
-	class: inventoryItemClass,
-	patternSchema: []AttrSchema{
-		{name: "cat", valType: typeEnum},
-		{name: "fullname", valType: typeStr},
-		{name: "ageinstock", valType: typeInt},
-		{name: "mrp", valType: typeFloat},
-		{name: "received", valType: typeTS},
-		{name: "bulkorder", valType: typeBool},
-	},
-}}
+package crux
 
 type RuleSchema struct {
-	class         string
-	patternSchema []AttrSchema
-	actionSchema  ActionSchema
+	Class         string       `json:"class"`
+	PatternSchema []AttrSchema `json:"patternSchema"`
+	ActionSchema  ActionSchema `json:"actionSchema"`
 }
 
+// AttrSchema is encoded in JSON by MarshalJSON() and UnmarshalJSON() in json_format.go
 type AttrSchema struct {
-	name    string
-	valType string
-	vals    map[string]bool
-	valMin  float64
-	valMax  float64
-	lenMin  int
-	lenMax  int
+	Name    string
+	ValType string
+	Vals    map[string]bool
+	ValMin  float64
+	ValMax  float64
+	LenMin  int
+	LenMax  int
+	// For ts and date attributes: the layouts in which entity values may be written, and the time
+	// zone in which values without one are read and dates are taken (see time_layouts.go)
+	Layouts []string
+	TZ      string
+	// For decimal attributes: the currency code of their values, if any, and the largest values
+	// allowed in rule-patterns, as SQL's NUMERIC(Precision, Scale) allows: at most Precision
+	// digits, Scale of them after the point. A Precision of 0 means no limit (see decimal.go).
+	Currency  string
+	Precision int
+	Scale     int
+	// For list attributes: the value-type of their elements, which is enum, str or int (see
+	// list_ops.go). Vals holds the valid elements of an enum list.
+	ElemType string
+	// Whether entities must have the attribute, and the value that an entity without it is given
+	// before it is matched. An attribute with a default cannot be required (see
+	// validate_entity.go).
+	Required bool
+	Default  string
 }
 
 type ActionSchema struct {
-	tasks      []string
-	properties []string
+	Tasks      []string `json:"tasks"`
+	Properties []string `json:"properties"`
+	// The value-types of properties that have them (see action_props.go)
+	PropertySchema []AttrSchema `json:"propertySchema,omitempty"`
 }
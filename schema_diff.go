@@ -0,0 +1,407 @@
+/*
+This file contains DiffSchemas(), which compares two versions of the schema for a class, and
+lists the rules that each change may affect. A change is breaking if rulesets or entities that
+were valid under the old schema may not be valid under the new one:
+
+	breaking    an attribute, task or property is removed; an attribute's value-type or element
+	            type changes; values are removed from the vals of an enum or enum list; the
+	            range (ValMin, ValMax), length (LenMin, LenMax) or precision and scale of an
+	            attribute are narrowed, so that they no longer allow some values that they did; a
+	            decimal's currency changes; layouts are removed from a ts or date attribute, or
+	            its time zone changes; an attribute becomes required; a property is given a
+	            value-type or a different one, or its vals or limits are narrowed
+	compatible  an attribute that is not required, a task or a property is added; values are
+	            added to the vals of an enum or enum list, or an enum property; the range, length
+	            or precision and scale of an attribute or a property are widened; layouts are
+	            added to a ts or date attribute; a property's value-type is removed
+
+A rule refers to an attribute if any term in its pattern does, including terms in groups,
+comparisons with other attributes and expressions. It refers to a task if it collects the task,
+uses it as an attribute, or names it as its nextstep, and to a property if it sets it.
+
+cmd/cruxschemadiff prints the changes between two schema files and the rules they affect.
+*/
+
+package crux
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// The kinds of SchemaChange
+const (
+	ChangeAttrAdded    = "attradded"
+	ChangeAttrRemoved  = "attrremoved"
+	ChangeAttrRetyped  = "attrretyped"
+	ChangeValsNarrowed = "valsnarrowed"
+	ChangeValsWidened  = "valswidened"
+	ChangeCurrency     = "currencychanged"
+	ChangeRequired     = "nowrequired"
+	ChangeTaskAdded    = "taskadded"
+	ChangeTaskRemoved  = "taskremoved"
+	ChangePropAdded    = "propadded"
+	ChangePropRemoved  = "propremoved"
+	ChangePropRetyped  = "propretyped"
+
+	ChangeRangeNarrowed     = "rangenarrowed"
+	ChangeRangeWidened      = "rangewidened"
+	ChangeLengthNarrowed    = "lengthnarrowed"
+	ChangeLengthWidened     = "lengthwidened"
+	ChangePrecisionNarrowed = "precisionnarrowed"
+	ChangePrecisionWidened  = "precisionwidened"
+	ChangeLayoutsRemoved    = "layoutsremoved"
+	ChangeLayoutsAdded      = "layoutsadded"
+	ChangeTZ                = "tzchanged"
+)
+
+// SchemaChange is one difference between two versions of a schema. Name is the attribute, task
+// or property that changed, and Affected lists the rules that refer to it.
+type SchemaChange struct {
+	Kind     string
+	Name     string
+	Breaking bool
+	Detail   string
+	Affected []RuleRef
+}
+
+func (c SchemaChange) String() string {
+	if c.Breaking {
+		return "breaking: " + c.Detail
+	}
+	return "compatible: " + c.Detail
+}
+
+// DiffSchemas returns the changes from oldSchema to newSchema, two versions of the schema for one
+// class: first those to attributes, then to tasks, then to properties, each in the order of the
+// schemas. The rules affected by each change are looked for in those of ruleSets that are of the
+// class, in the order of ruleSets.
+func DiffSchemas(oldSchema RuleSchema, newSchema RuleSchema, ruleSets []RuleSet) ([]SchemaChange, error) {
+	if oldSchema.Class != newSchema.Class {
+		return nil, fmt.Errorf("schemas are for different classes: %v and %v", oldSchema.Class, newSchema.Class)
+	}
+	changes := diffAttrs(oldSchema.PatternSchema, newSchema.PatternSchema)
+	changes = append(changes, diffNames(ChangeTaskRemoved, ChangeTaskAdded, "task",
+		oldSchema.ActionSchema.Tasks, newSchema.ActionSchema.Tasks)...)
+	changes = append(changes, diffNames(ChangePropRemoved, ChangePropAdded, "property",
+		oldSchema.ActionSchema.Properties, newSchema.ActionSchema.Properties)...)
+	changes = append(changes, diffPropTypes(oldSchema.ActionSchema, newSchema.ActionSchema)...)
+
+	for i, c := range changes {
+		var refers func(Rule) bool
+		switch c.Kind {
+		case ChangeTaskAdded, ChangeTaskRemoved:
+			refers = func(r Rule) bool { return ruleUsesTask(r, c.Name) }
+		case ChangePropAdded, ChangePropRemoved, ChangePropRetyped:
+			refers = func(r Rule) bool { return ruleSetsProp(r, c.Name) }
+		default:
+			refers = func(r Rule) bool { return termsUseAttr(r.RulePattern, c.Name) }
+		}
+		for _, rs := range ruleSets {
+			if rs.Class != newSchema.Class {
+				continue
+			}
+			for j, rule := range rs.Rules {
+				if refers(rule) {
+					changes[i].Affected = append(changes[i].Affected, RuleRef{rs.SetName, j})
+				}
+			}
+		}
+	}
+	return changes, nil
+}
+
+// SchemaChanges compares the engine's schema for the class of newSchema with newSchema, as
+// DiffSchemas does, and looks for affected rules in the engine's rulesets, in order of name
+func (e *Engine) SchemaChanges(newSchema RuleSchema) ([]SchemaChange, error) {
+	st := e.registry.load()
+	oldSchema, err := st.getSchema(newSchema.Class)
+	if err != nil {
+		return nil, err
+	}
+	setNames := make([]string, 0, len(st.ruleSets))
+	for setName := range st.ruleSets {
+		setNames = append(setNames, setName)
+	}
+	sort.Strings(setNames)
+	ruleSets := make([]RuleSet, len(setNames))
+	for i, setName := range setNames {
+		ruleSets[i] = st.ruleSets[setName]
+	}
+	return DiffSchemas(oldSchema, newSchema, ruleSets)
+}
+
+// Returns the changes from the attributes oldAttrs to newAttrs
+func diffAttrs(oldAttrs []AttrSchema, newAttrs []AttrSchema) []SchemaChange {
+	var changes []SchemaChange
+	newByName := map[string]AttrSchema{}
+	for _, as := range newAttrs {
+		newByName[as.Name] = as
+	}
+	oldNames := map[string]bool{}
+	for _, old := range oldAttrs {
+		oldNames[old.Name] = true
+		as, ok := newByName[old.Name]
+		if !ok {
+			changes = append(changes, SchemaChange{Kind: ChangeAttrRemoved, Name: old.Name, Breaking: true,
+				Detail: fmt.Sprintf("attribute %v removed", old.Name)})
+			continue
+		}
+		if as.ValType != old.ValType || as.ElemType != old.ElemType {
+			changes = append(changes, SchemaChange{Kind: ChangeAttrRetyped, Name: old.Name, Breaking: true,
+				Detail: fmt.Sprintf("attribute %v changed from %v to %v", old.Name, typeName(old), typeName(as))})
+			continue
+		}
+		changes = append(changes, diffVals("attribute", old, as)...)
+		changes = append(changes, diffLimits("attribute", old, as)...)
+		changes = append(changes, diffTimeFormat(old, as)...)
+		if as.Currency != old.Currency {
+			changes = append(changes, SchemaChange{Kind: ChangeCurrency, Name: old.Name, Breaking: true,
+				Detail: fmt.Sprintf("currency of attribute %v changed from %q to %q", old.Name, old.Currency, as.Currency)})
+		}
+		if as.Required && !old.Required {
+			changes = append(changes, SchemaChange{Kind: ChangeRequired, Name: old.Name, Breaking: true,
+				Detail: fmt.Sprintf("attribute %v is now required", old.Name)})
+		}
+	}
+	for _, as := range newAttrs {
+		if !oldNames[as.Name] {
+			c := SchemaChange{Kind: ChangeAttrAdded, Name: as.Name, Detail: fmt.Sprintf("attribute %v added", as.Name)}
+			// Entities that were valid do not have it
+			if as.Required {
+				c.Breaking, c.Detail = true, c.Detail+" as required"
+			}
+			changes = append(changes, c)
+		}
+	}
+	return changes
+}
+
+// Returns a change for the values removed from, and one for the values added to, the vals of
+// the enum or enum list old in as, which is of the same type. what is "attribute" or "property".
+func diffVals(what string, old AttrSchema, as AttrSchema) []SchemaChange {
+	if old.ValType != TypeEnum && old.ElemType != TypeEnum {
+		return nil
+	}
+	var removed, added []string
+	for val := range old.Vals {
+		if !as.Vals[val] {
+			removed = append(removed, val)
+		}
+	}
+	for val := range as.Vals {
+		if !old.Vals[val] {
+			added = append(added, val)
+		}
+	}
+	sort.Strings(removed)
+	sort.Strings(added)
+	var changes []SchemaChange
+	if len(removed) > 0 {
+		changes = append(changes, SchemaChange{Kind: ChangeValsNarrowed, Name: old.Name, Breaking: true,
+			Detail: fmt.Sprintf("values removed from %v %v: %v", what, old.Name, strings.Join(removed, ", "))})
+	}
+	if len(added) > 0 {
+		changes = append(changes, SchemaChange{Kind: ChangeValsWidened, Name: old.Name,
+			Detail: fmt.Sprintf("values added to %v %v: %v", what, old.Name, strings.Join(added, ", "))})
+	}
+	return changes
+}
+
+// Returns the changes to the range, length, and precision and scale of old in as, which is of
+// the same type. what is "attribute" or "property".
+func diffLimits(what string, old AttrSchema, as AttrSchema) []SchemaChange {
+	var changes []SchemaChange
+	add := func(narrowedKind string, widenedKind string, limit string, narrowed bool, was string, now string) {
+		if was == now {
+			return
+		}
+		c := SchemaChange{Kind: widenedKind, Name: old.Name, Detail: fmt.Sprintf("%v of %v %v widened: was %v, now %v", limit, what, old.Name, was, now)}
+		if narrowed {
+			c.Kind, c.Breaking = narrowedKind, true
+			c.Detail = strings.Replace(c.Detail, "widened", "narrowed", 1)
+		}
+		changes = append(changes, c)
+	}
+	unbounded := func(as AttrSchema) bool { return as.ValMin == 0 && as.ValMax == 0 }
+	add(ChangeRangeNarrowed, ChangeRangeWidened, "range",
+		!unbounded(as) && (unbounded(old) || as.ValMin > old.ValMin || as.ValMax < old.ValMax),
+		rangeText(old), rangeText(as))
+	add(ChangeLengthNarrowed, ChangeLengthWidened, "length",
+		as.LenMin > old.LenMin || as.LenMax != 0 && (old.LenMax == 0 || as.LenMax < old.LenMax),
+		lengthText(old), lengthText(as))
+	add(ChangePrecisionNarrowed, ChangePrecisionWidened, "precision and scale",
+		as.Precision != 0 && (old.Precision == 0 || as.Scale < old.Scale || as.Precision-as.Scale < old.Precision-old.Scale),
+		precisionText(old), precisionText(as))
+	return changes
+}
+
+func rangeText(as AttrSchema) string {
+	if as.ValMin == 0 && as.ValMax == 0 {
+		return "no limit"
+	}
+	return fmt.Sprintf("%v to %v", as.ValMin, as.ValMax)
+}
+
+func lengthText(as AttrSchema) string {
+	switch {
+	case as.LenMin == 0 && as.LenMax == 0:
+		return "no limit"
+	case as.LenMax == 0:
+		return fmt.Sprintf("at least %v", as.LenMin)
+	case as.LenMin == 0:
+		return fmt.Sprintf("at most %v", as.LenMax)
+	}
+	return fmt.Sprintf("%v to %v", as.LenMin, as.LenMax)
+}
+
+func precisionText(as AttrSchema) string {
+	if as.Precision == 0 {
+		return "no limit"
+	}
+	return fmt.Sprintf("(%v, %v)", as.Precision, as.Scale)
+}
+
+// Returns the changes to the layouts and time zone of the ts or date attribute old in as. An
+// attribute without layouts has the default ones, and one without a time zone is in UTC.
+func diffTimeFormat(old AttrSchema, as AttrSchema) []SchemaChange {
+	if old.ValType != TypeTS && old.ValType != TypeDate {
+		return nil
+	}
+	layouts := func(as AttrSchema) []string {
+		if len(as.Layouts) == 0 {
+			return termTimeLayouts
+		}
+		return as.Layouts
+	}
+	var changes []SchemaChange
+	var removed, added []string
+	for _, layout := range layouts(old) {
+		if !isStringInArray(layout, layouts(as)) {
+			removed = append(removed, layout)
+		}
+	}
+	for _, layout := range layouts(as) {
+		if !isStringInArray(layout, layouts(old)) {
+			added = append(added, layout)
+		}
+	}
+	if len(removed) > 0 {
+		changes = append(changes, SchemaChange{Kind: ChangeLayoutsRemoved, Name: old.Name, Breaking: true,
+			Detail: fmt.Sprintf("layouts removed from attribute %v: %v", old.Name, strings.Join(removed, ", "))})
+	}
+	if len(added) > 0 {
+		changes = append(changes, SchemaChange{Kind: ChangeLayoutsAdded, Name: old.Name,
+			Detail: fmt.Sprintf("layouts added to attribute %v: %v", old.Name, strings.Join(added, ", "))})
+	}
+	tz := func(as AttrSchema) string {
+		if as.TZ == "" {
+			return "UTC"
+		}
+		return as.TZ
+	}
+	if tz(as) != tz(old) {
+		changes = append(changes, SchemaChange{Kind: ChangeTZ, Name: old.Name, Breaking: true,
+			Detail: fmt.Sprintf("time zone of attribute %v changed from %v to %v", old.Name, tz(old), tz(as))})
+	}
+	return changes
+}
+
+// Returns the value-type of as, with its element type if it is a list
+func typeName(as AttrSchema) string {
+	if as.ValType == TypeList {
+		return fmt.Sprintf("%v of %v", as.ValType, as.ElemType)
+	}
+	return as.ValType
+}
+
+// Returns the changes from the task or property names oldNames to newNames. what is "task" or
+// "property".
+func diffNames(removedKind string, addedKind string, what string, oldNames []string, newNames []string) []SchemaChange {
+	var changes []SchemaChange
+	for _, name := range oldNames {
+		if !isStringInArray(name, newNames) {
+			changes = append(changes, SchemaChange{Kind: removedKind, Name: name, Breaking: true,
+				Detail: fmt.Sprintf("%v %v removed", what, name)})
+		}
+	}
+	for _, name := range newNames {
+		if !isStringInArray(name, oldNames) {
+			changes = append(changes, SchemaChange{Kind: addedKind, Name: name,
+				Detail: fmt.Sprintf("%v %v added", what, name)})
+		}
+	}
+	return changes
+}
+
+// Returns the changes to the value-types of the properties in both oldAS and newAS. A property
+// without a schema has the type "any".
+func diffPropTypes(oldAS ActionSchema, newAS ActionSchema) []SchemaChange {
+	propSchema := func(as ActionSchema, name string) AttrSchema {
+		for _, ps := range as.PropertySchema {
+			if ps.Name == name {
+				return ps
+			}
+		}
+		return AttrSchema{Name: name, ValType: "any"}
+	}
+	var changes []SchemaChange
+	for _, name := range oldAS.Properties {
+		if !isStringInArray(name, newAS.Properties) {
+			continue
+		}
+		old, ps := propSchema(oldAS, name), propSchema(newAS, name)
+		if ps.ValType != old.ValType {
+			changes = append(changes, SchemaChange{Kind: ChangePropRetyped, Name: name, Breaking: ps.ValType != "any",
+				Detail: fmt.Sprintf("property %v changed from %v to %v", name, old.ValType, ps.ValType)})
+			continue
+		}
+		// Changes to the vals or limits of a property are changes to its type
+		for _, c := range append(diffVals("property", old, ps), diffLimits("property", old, ps)...) {
+			c.Kind = ChangePropRetyped
+			changes = append(changes, c)
+		}
+	}
+	return changes
+}
+
+// Returns whether any of terms, or any term in a group among them, refers to the attribute name
+func termsUseAttr(terms []RulePatternTerm, name string) bool {
+	for _, term := range terms {
+		if groupOps[term.Op] {
+			if group, ok := term.AttrVal.([]RulePatternTerm); ok && termsUseAttr(group, name) {
+				return true
+			}
+			continue
+		}
+		if term.AttrName == name {
+			return true
+		}
+		if ref, ok := term.AttrVal.(AttrRef); ok && ref.Attr == name {
+			return true
+		}
+		if isExprTerm(term) {
+			if left, right, err := exprOperands(term, nil); err == nil && isStringInArray(name, right.attrs(left.attrs(nil))) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Returns whether rule collects the task name, uses it as an attribute, or names it as nextstep
+func ruleUsesTask(rule Rule, name string) bool {
+	return isStringInArray(name, rule.RuleActions.Tasks) || termsUseAttr(rule.RulePattern, name) ||
+		getNextStep(rule.RuleActions.Properties) == name
+}
+
+// Returns whether rule sets the property name
+func ruleSetsProp(rule Rule, name string) bool {
+	for _, p := range rule.RuleActions.Properties {
+		if p.Name == name {
+			return true
+		}
+	}
+	return false
+}
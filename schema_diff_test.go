@@ -0,0 +1,166 @@
+package crux
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestDiffSchemas(t *testing.T) {
+	oldSchema := testTransactionSchema()
+	oldSchema.PatternSchema = append(oldSchema.PatternSchema,
+		AttrSchema{Name: "listprice", ValType: TypeInt},
+		AttrSchema{Name: "amount", ValType: TypeDecimal, Currency: "INR"})
+	oldSchema.ActionSchema.PropertySchema = []AttrSchema{{Name: "discount", ValType: TypeInt}}
+
+	newSchema := testTransactionSchema()
+	newSchema.PatternSchema = []AttrSchema{
+		{Name: "productname", ValType: TypeStr, Required: true},
+		{Name: "price", ValType: TypeFloat},
+		{Name: "paymenttype", ValType: TypeEnum, Vals: map[string]bool{"card": true, "upi": true}},
+		{Name: "ismember", ValType: TypeBool},
+		{Name: "paid", ValType: TypeTS},
+		{Name: "listprice", ValType: TypeInt},
+		{Name: "amount", ValType: TypeDecimal, Currency: "USD"},
+		{Name: "region", ValType: TypeStr},
+	}
+	newSchema.ActionSchema = ActionSchema{
+		Tasks:          []string{"freepen", "freebag", "freehat", "freecap"},
+		Properties:     []string{"discount", "pointsmult", "note"},
+		PropertySchema: []AttrSchema{{Name: "pointsmult", ValType: TypeInt}},
+	}
+
+	ruleSets := []RuleSet{
+		{Ver: 1, Class: transactionClass, SetName: "winter", Rules: []Rule{
+			{[]RulePatternTerm{{"", OpAny, []RulePatternTerm{{"inwintersale", OpEQ, true}, {"paymenttype", OpEQ, "cash"}}}},
+				RuleActions{Tasks: []string{"freemug"}}},
+			{[]RulePatternTerm{{"listprice", OpGT, AttrRef{"price"}}}, RuleActions{Properties: []Property{{"discount", "5"}}}},
+		}},
+		{Ver: 1, Class: transactionClass, SetName: "members", Rules: []Rule{
+			{[]RulePatternTerm{{"", OpGT, Arith{"price * 2", "listprice"}}, {"freemug", OpEQ, true}},
+				RuleActions{Properties: []Property{{"pointsmult", "2"}}}},
+			{[]RulePatternTerm{{"amount", OpGT, "100"}}, RuleActions{Tasks: []string{"freepen"}}},
+		}},
+		{Ver: 1, Class: "otherclass", SetName: "other", Rules: []Rule{
+			{[]RulePatternTerm{{"price", OpGT, 10}}, RuleActions{Tasks: []string{"freemug"}}},
+		}},
+	}
+
+	got, err := DiffSchemas(oldSchema, newSchema, ruleSets)
+	if err != nil {
+		t.Fatalf("DiffSchemas() error = %v", err)
+	}
+	want := []SchemaChange{
+		{ChangeRequired, "productname", true, "attribute productname is now required", nil},
+		{ChangeAttrRetyped, "price", true, "attribute price changed from int to float",
+			[]RuleRef{{"winter", 1}, {"members", 0}}},
+		{ChangeAttrRemoved, "inwintersale", true, "attribute inwintersale removed", []RuleRef{{"winter", 0}}},
+		{ChangeValsNarrowed, "paymenttype", true, "values removed from attribute paymenttype: cash", []RuleRef{{"winter", 0}}},
+		{ChangeValsWidened, "paymenttype", false, "values added to attribute paymenttype: upi", []RuleRef{{"winter", 0}}},
+		{ChangeCurrency, "amount", true, `currency of attribute amount changed from "INR" to "USD"`, []RuleRef{{"members", 1}}},
+		{ChangeAttrAdded, "region", false, "attribute region added", nil},
+		{ChangeTaskRemoved, "freemug", true, "task freemug removed", []RuleRef{{"winter", 0}, {"members", 0}}},
+		{ChangeTaskAdded, "freecap", false, "task freecap added", nil},
+		{ChangePropRetyped, "discount", false, "property discount changed from int to any", []RuleRef{{"winter", 1}}},
+		{ChangePropRetyped, "pointsmult", true, "property pointsmult changed from any to int", []RuleRef{{"members", 0}}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("DiffSchemas() =")
+		for _, c := range got {
+			t.Errorf("  %+v", c)
+		}
+	}
+	if got[0].String() != "breaking: attribute productname is now required" || got[6].String() != "compatible: attribute region added" {
+		t.Errorf("String() = %q, %q", got[0], got[6])
+	}
+
+	if changes, err := DiffSchemas(oldSchema, oldSchema, ruleSets); err != nil || len(changes) != 0 {
+		t.Errorf("DiffSchemas() = %v, %v, want no changes for the same schema", changes, err)
+	}
+	other := newSchema
+	other.Class = "otherclass"
+	if _, err := DiffSchemas(oldSchema, other, ruleSets); err == nil {
+		t.Errorf("DiffSchemas(): expected but did not get error for schemas of different classes")
+	}
+}
+
+// Narrowing the limits or time format of an attribute is breaking, and widening them is not
+func TestDiffSchemaLimits(t *testing.T) {
+	tests := []struct {
+		name string
+		old  AttrSchema
+		new  AttrSchema
+		want []SchemaChange
+	}{
+		{"range narrowed", AttrSchema{ValType: TypeInt, ValMin: 0, ValMax: 365}, AttrSchema{ValType: TypeInt, ValMin: 0, ValMax: 100},
+			[]SchemaChange{{ChangeRangeNarrowed, "a", true, "range of attribute a narrowed: was 0 to 365, now 0 to 100", nil}}},
+		{"range limited", AttrSchema{ValType: TypeFloat}, AttrSchema{ValType: TypeFloat, ValMin: -1.5, ValMax: 1.5},
+			[]SchemaChange{{ChangeRangeNarrowed, "a", true, "range of attribute a narrowed: was no limit, now -1.5 to 1.5", nil}}},
+		{"range shifted", AttrSchema{ValType: TypeInt, ValMin: 0, ValMax: 10}, AttrSchema{ValType: TypeInt, ValMin: 5, ValMax: 20},
+			[]SchemaChange{{ChangeRangeNarrowed, "a", true, "range of attribute a narrowed: was 0 to 10, now 5 to 20", nil}}},
+		{"range widened", AttrSchema{ValType: TypeInt, ValMin: 0, ValMax: 10}, AttrSchema{ValType: TypeInt},
+			[]SchemaChange{{ChangeRangeWidened, "a", false, "range of attribute a widened: was 0 to 10, now no limit", nil}}},
+		{"length narrowed", AttrSchema{ValType: TypeStr, LenMax: 80}, AttrSchema{ValType: TypeStr, LenMin: 1, LenMax: 80},
+			[]SchemaChange{{ChangeLengthNarrowed, "a", true, "length of attribute a narrowed: was at most 80, now 1 to 80", nil}}},
+		{"list length limited", AttrSchema{ValType: TypeList, ElemType: TypeInt}, AttrSchema{ValType: TypeList, ElemType: TypeInt, LenMax: 3},
+			[]SchemaChange{{ChangeLengthNarrowed, "a", true, "length of attribute a narrowed: was no limit, now at most 3", nil}}},
+		{"length widened", AttrSchema{ValType: TypeStr, LenMin: 2, LenMax: 8}, AttrSchema{ValType: TypeStr, LenMin: 1},
+			[]SchemaChange{{ChangeLengthWidened, "a", false, "length of attribute a widened: was 2 to 8, now at least 1", nil}}},
+		{"scale narrowed", AttrSchema{ValType: TypeDecimal, Precision: 10, Scale: 4}, AttrSchema{ValType: TypeDecimal, Precision: 10, Scale: 2},
+			[]SchemaChange{{ChangePrecisionNarrowed, "a", true, "precision and scale of attribute a narrowed: was (10, 4), now (10, 2)", nil}}},
+		{"precision limited", AttrSchema{ValType: TypeDecimal}, AttrSchema{ValType: TypeDecimal, Precision: 12, Scale: 2},
+			[]SchemaChange{{ChangePrecisionNarrowed, "a", true, "precision and scale of attribute a narrowed: was no limit, now (12, 2)", nil}}},
+		{"precision widened", AttrSchema{ValType: TypeDecimal, Precision: 8, Scale: 2}, AttrSchema{ValType: TypeDecimal, Precision: 10, Scale: 2},
+			[]SchemaChange{{ChangePrecisionWidened, "a", false, "precision and scale of attribute a widened: was (8, 2), now (10, 2)", nil}}},
+		{"layouts replaced", AttrSchema{ValType: TypeTS}, AttrSchema{ValType: TypeTS, Layouts: []string{time.RFC3339, "02/01/2006"}}, []SchemaChange{
+			{ChangeLayoutsRemoved, "a", true, "layouts removed from attribute a: 2006-01-02", nil},
+			{ChangeLayoutsAdded, "a", false, "layouts added to attribute a: 02/01/2006", nil},
+		}},
+		{"time zone", AttrSchema{ValType: TypeDate}, AttrSchema{ValType: TypeDate, TZ: "Asia/Kolkata"},
+			[]SchemaChange{{ChangeTZ, "a", true, "time zone of attribute a changed from UTC to Asia/Kolkata", nil}}},
+		{"time zone made explicit", AttrSchema{ValType: TypeTS}, AttrSchema{ValType: TypeTS, TZ: "UTC"}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.old.Name, tt.new.Name = "a", "a"
+			oldSchema := RuleSchema{Class: counterClass, PatternSchema: []AttrSchema{tt.old}}
+			newSchema := RuleSchema{Class: counterClass, PatternSchema: []AttrSchema{tt.new}}
+			if got, err := DiffSchemas(oldSchema, newSchema, nil); err != nil || !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("DiffSchemas() = %+v, %v, want %+v", got, err, tt.want)
+			}
+		})
+	}
+
+	// The limits of a property are part of its type
+	oldSchema := RuleSchema{Class: counterClass, ActionSchema: ActionSchema{Properties: []string{"discount"},
+		PropertySchema: []AttrSchema{{Name: "discount", ValType: TypeInt, ValMin: 0, ValMax: 100}}}}
+	newSchema := oldSchema
+	newSchema.ActionSchema.PropertySchema = []AttrSchema{{Name: "discount", ValType: TypeInt, ValMin: 0, ValMax: 50}}
+	want := []SchemaChange{{ChangePropRetyped, "discount", true, "range of property discount narrowed: was 0 to 100, now 0 to 50", nil}}
+	if got, err := DiffSchemas(oldSchema, newSchema, nil); err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("DiffSchemas() = %+v, %v, want %+v", got, err, want)
+	}
+}
+
+// The engine's schema is compared with the new one, and its rulesets searched in order of name
+func TestSchemaChanges(t *testing.T) {
+	e := NewEngine()
+	e.AddRuleSchema(testTransactionSchema())
+	for _, setName := range []string{"zeta", "alpha"} {
+		e.AddRuleSet(RuleSet{Ver: 1, Class: transactionClass, SetName: setName, Rules: []Rule{
+			{[]RulePatternTerm{{"price", OpGT, 10}}, RuleActions{Tasks: []string{"freepen"}}},
+		}})
+	}
+	newSchema := testTransactionSchema()
+	newSchema.ActionSchema.Tasks = []string{"freemug", "freebag", "freehat"}
+	got, err := e.SchemaChanges(newSchema)
+	want := []SchemaChange{{ChangeTaskRemoved, "freepen", true, "task freepen removed", []RuleRef{{"alpha", 0}, {"zeta", 0}}}}
+	if err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("SchemaChanges() = %+v, %v, want %+v", got, err, want)
+	}
+
+	newSchema.Class = "otherclass"
+	if _, err := e.SchemaChanges(newSchema); err == nil {
+		t.Errorf("SchemaChanges(): expected but did not get error for a class with no schema")
+	}
+}
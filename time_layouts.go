@@ -0,0 +1,86 @@
+/*
+This file contains the conversion of ts and date values. Entity values of a ts attribute are
+parsed with the layouts in the attribute's schema, or by default as RFC 3339 timestamps, with
+any offset and optional fractional seconds, such as "2024-03-15T10:00:00Z" and
+"2024-03-15T15:30:00.250+05:30". A layout without a time zone is read in the attribute's TZ, and
+every ts value is normalised to UTC, so values with different offsets compare by the instant they
+denote.
+
+A date attribute holds a calendar day. Its entity values are parsed in the same way, by default
+as "2006-01-02" or as RFC 3339 timestamps, and are reduced to the day on which they fall in the
+attribute's TZ (by default UTC), so that "2024-01-15T23:30:00-05:00" is 2024-01-16 in UTC but
+2024-01-15 in "America/New_York". Dates compare by day.
+
+	{Name: "received", ValType: TypeTS, Layouts: []string{"02/01/2006 15:04"}, TZ: "Asia/Kolkata"}
+	{Name: "dob", ValType: TypeDate}
+
+ts and date values in rule-patterns are time.Time values, or strings in RFC 3339 or
+"2006-01-02", which are read in UTC.
+*/
+
+package crux
+
+import (
+	"errors"
+	"time"
+)
+
+const dateLayout = "2006-01-02"
+
+// The layouts of ts and date values in rule-patterns, and by default, in entities
+var termTimeLayouts = []string{time.RFC3339, dateLayout}
+
+// Returns the time zone of af, which is UTC for a nil af
+func (af *attrFormat) location() *time.Location {
+	if af == nil {
+		return time.UTC
+	}
+	return af.loc
+}
+
+// Parses the entity value s of a ts or date attribute with the format af, which may be nil
+func parseTime(s string, valType string, af *attrFormat) (time.Time, error) {
+	layouts := termTimeLayouts
+	if af != nil && len(af.layouts) > 0 {
+		layouts = af.layouts
+	}
+	var err error
+	for _, layout := range layouts {
+		var t time.Time
+		if t, err = time.ParseInLocation(layout, s, af.location()); err == nil {
+			return normaliseTime(t, valType, af.location()), nil
+		}
+	}
+	if len(layouts) > 1 {
+		return time.Time{}, errors.New("cannot parse " + s + " as a " + valType)
+	}
+	return time.Time{}, err
+}
+
+// Returns t in UTC, or for a date, midnight UTC on the day on which t falls in loc
+func normaliseTime(t time.Time, valType string, loc *time.Location) time.Time {
+	if valType != TypeDate {
+		return t.UTC()
+	}
+	y, m, d := t.In(loc).Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+}
+
+// Converts val, a ts or date value in a rule-pattern, to a normalised time.Time
+func termTime(val any, valType string) (time.Time, error) {
+	switch v := val.(type) {
+	case time.Time:
+		return normaliseTime(v, valType, v.Location()), nil
+	case string:
+		return parseTime(v, valType, nil)
+	}
+	return time.Time{}, errors.New("not a timestamp")
+}
+
+// Returns t, a normalised ts or date value, as a string that termTime() reads back
+func formatTime(t time.Time) string {
+	if t.Equal(time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)) {
+		return t.Format(dateLayout)
+	}
+	return t.Format(time.RFC3339Nano)
+}
@@ -0,0 +1,225 @@
+/*
+This file contains the operators on ts attributes that compare them with the time of the match
+or with the calendar, rather than with a fixed timestamp:
+
+	within      the value is a duration; the attribute is no earlier than that long before the
+	            time of the match, and no later than it
+	olderthan   the value is a duration; the attribute is earlier than that long before the time
+	            of the match
+	dayofweek   the value is a list of days ("mon", "tue", ... "sun"); the attribute falls on one
+	            of them
+	month       the value is a list of months ("jan", "feb", ... "dec"); the attribute falls in one
+	            of them
+	hourin      the value is a list of two different hours [from, to], from 0 to 24; the
+	            attribute's hour of the day is at least from and less than to, or if from is
+	            greater than to, the window wraps around midnight
+
+For example, "received more than 30 days ago, on a weekend" is:
+
+	RulePatternTerm{"received", OpOlderThan, "30d"}
+	RulePatternTerm{"received", OpDayOfWeek, []string{"sat", "sun"}}
+
+A duration is a time.Duration, or a string of a number of days followed by "d", a duration in
+the format of time.ParseDuration(), or both, such as "30d", "36h" and "1d12h". It must be
+positive. Calendar predicates are evaluated in the attribute's time zone, AttrSchema.TZ, which is
+UTC by default.
+
+The time of a match is read once from the engine's clock, which is time.Now() unless it has been
+replaced with Engine.SetClock(), so every term in a match sees the same time.
+*/
+
+package crux
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	OpWithin    = "within"
+	OpOlderThan = "olderthan"
+	OpDayOfWeek = "dayofweek"
+	OpMonth     = "month"
+	OpHourIn    = "hourin"
+)
+
+// The operators on ts attributes that depend on the time of the match or the calendar
+var timeOps = map[string]bool{OpWithin: true, OpOlderThan: true, OpDayOfWeek: true, OpMonth: true, OpHourIn: true}
+
+// The operators whose value is a list
+var calendarOps = map[string]bool{OpDayOfWeek: true, OpMonth: true, OpHourIn: true}
+
+var weekdayNames = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+var monthNames = map[string]time.Month{
+	"jan": time.January, "feb": time.February, "mar": time.March, "apr": time.April,
+	"may": time.May, "jun": time.June, "jul": time.July, "aug": time.August,
+	"sep": time.September, "oct": time.October, "nov": time.November, "dec": time.December,
+}
+
+// SetClock sets the function that returns the time of each match, for within and olderthan terms.
+// A nil clock restores time.Now(). Tests can set a fixed clock to make matches deterministic.
+func (e *Engine) SetClock(clock func() time.Time) {
+	e.registry.update(func(st *ruleStore) {
+		st.clock = clock
+	})
+}
+
+// Returns the time of the match, reading the clock on first use
+func (m *matcher) now() time.Time {
+	if m.nowVal.IsZero() {
+		if m.store != nil && m.store.clock != nil {
+			m.nowVal = m.store.clock()
+		} else {
+			m.nowVal = time.Now()
+		}
+	}
+	return m.nowVal
+}
+
+// Returns the value-type of the elements of the list in a calendar term, or for within and
+// olderthan, of the duration string
+func timeOpValType(op string) string {
+	if op == OpHourIn {
+		return TypeInt
+	}
+	return TypeStr
+}
+
+// Parses a duration such as "30d", "36h" or "1d12h"
+func parseDuration(s string) (time.Duration, error) {
+	var days time.Duration
+	if i := strings.IndexByte(s, 'd'); i >= 0 {
+		n, err := strconv.Atoi(s[:i])
+		if err != nil || n < 0 || n > math.MaxInt64/int(24*time.Hour) {
+			return 0, fmt.Errorf("invalid number of days in duration %q", s)
+		}
+		days = time.Duration(n) * 24 * time.Hour
+		if s[i+1:] == "" {
+			return days, nil
+		}
+		s = s[i+1:]
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, err
+	}
+	if d < 0 || days > math.MaxInt64-d {
+		return 0, fmt.Errorf("invalid duration %q", s)
+	}
+	return days + d, nil
+}
+
+// Returns d written as parseDuration() reads it
+func formatDuration(d time.Duration) string {
+	days, rest := d/(24*time.Hour), d%(24*time.Hour)
+	switch {
+	case rest == 0:
+		return fmt.Sprintf("%vd", int64(days))
+	case days == 0:
+		return rest.String()
+	}
+	return fmt.Sprintf("%vd%v", int64(days), rest)
+}
+
+// Returns the duration in a within or olderthan term, which must be positive
+func termDuration(val any) (time.Duration, error) {
+	var d time.Duration
+	switch v := val.(type) {
+	case time.Duration:
+		d = v
+	case string:
+		var err error
+		if d, err = parseDuration(v); err != nil {
+			return 0, err
+		}
+	default:
+		return 0, fmt.Errorf("%v is not a duration", val)
+	}
+	if d <= 0 {
+		return 0, fmt.Errorf("duration %v is not positive", val)
+	}
+	return d, nil
+}
+
+// Returns the set of weekdays, months or hours selected by the list in a calendar term with op
+func calendarSet(op string, val any) (valSet, error) {
+	list, ok := termList(val)
+	if !ok || len(list) == 0 {
+		return nil, fmt.Errorf("%v is not a non-empty list", val)
+	}
+	set := valSet{}
+	if op == OpHourIn {
+		if len(list) != 2 {
+			return nil, fmt.Errorf("%v is not a list of two hours", val)
+		}
+		from, fromOK := list[0].(int)
+		to, toOK := list[1].(int)
+		if !fromOK || !toOK || from < 0 || from > 23 || to < 0 || to > 24 || from == to {
+			return nil, fmt.Errorf("%v is not a window of hours, from 0 to 24", val)
+		}
+		for h := 0; h < 24; h++ {
+			if (from < to && h >= from && h < to) || (from > to && (h >= from || h < to)) {
+				set[h] = true
+			}
+		}
+		return set, nil
+	}
+	for _, elem := range list {
+		name, _ := elem.(string)
+		if n, ok := weekdayNames[name]; ok && op == OpDayOfWeek {
+			set[int(n)] = true
+		} else if n, ok := monthNames[name]; ok && op == OpMonth {
+			set[int(n)] = true
+		} else {
+			return nil, fmt.Errorf("%v is not a valid value for %v", elem, op)
+		}
+	}
+	return set, nil
+}
+
+// Converts the value of a term with one of timeOps to the form used by compareTimeVals(): a
+// time.Duration or a valSet
+func compileTimeVal(op string, val any) (any, error) {
+	if calendarOps[op] {
+		return calendarSet(op, val)
+	}
+	return termDuration(val)
+}
+
+// Returns whether the ts value entityAttrValConv satisfies a term with op, one of timeOps, and
+// the value termAttrVal, at the time now. termAttrVal may have been converted by compileTimeVal().
+// Calendar predicates are evaluated in loc, the attribute's time zone.
+func compareTimeVals(entityAttrValConv any, termAttrVal any, op string, now time.Time, loc *time.Location) (bool, error) {
+	t, ok := entityAttrValConv.(time.Time)
+	if !ok {
+		return false, errors.New(op + " applies only to ts attributes")
+	}
+	t = t.In(loc)
+	switch termAttrVal.(type) {
+	case time.Duration, valSet:
+	default:
+		var err error
+		if termAttrVal, err = compileTimeVal(op, termAttrVal); err != nil {
+			return false, err
+		}
+	}
+	switch op {
+	case OpWithin:
+		return !t.Before(now.Add(-termAttrVal.(time.Duration))) && !t.After(now), nil
+	case OpOlderThan:
+		return t.Before(now.Add(-termAttrVal.(time.Duration))), nil
+	case OpDayOfWeek:
+		return termAttrVal.(valSet)[int(t.Weekday())], nil
+	case OpMonth:
+		return termAttrVal.(valSet)[int(t.Month())], nil
+	}
+	return termAttrVal.(valSet)[t.Hour()], nil
+}
@@ -0,0 +1,191 @@
+/*
+This file contains MatchTrace, a record of everything doMatch() did during a match, and the
+functions that doMatch() and matchPattern() call to build it. A trace is only built when the
+match was started by Engine.MatchWithTrace(); otherwise the trace pointers in matcher are nil,
+and the methods below do nothing.
+*/
+
+package crux
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	// Where the entity value in a TermTrace came from
+	valSourceAttr    = "attribute"
+	valSourceTask    = "task"
+	valSourceDefault = "default"
+	valSourceMissing = "missing"
+	valSourceExpr    = "expr"
+
+	viaThenCall = "thencall"
+	viaElseCall = "elsecall"
+)
+
+// MatchTrace is a tree of the rulesets entered during a match, starting with the ruleset that
+// was matched against the entity. It can be encoded as JSON, or as text by String().
+type MatchTrace struct {
+	Root   *RuleSetTrace `json:"root"`
+	Result ActionSet     `json:"result"`
+	Error  string        `json:"error,omitempty"`
+}
+
+type RuleSetTrace struct {
+	SetName string `json:"setName"`
+	// How the ruleset was entered: "thencall", "elsecall", or "" for the first ruleset
+	Via   string      `json:"via,omitempty"`
+	Rules []RuleTrace `json:"rules"`
+}
+
+// RuleTrace records the evaluation of one rule. Terms holds the terms that were evaluated; the
+// first term that did not match ends the evaluation of the pattern.
+type RuleTrace struct {
+	Index   int         `json:"index"`
+	Terms   []TermTrace `json:"terms"`
+	Matched bool        `json:"matched"`
+	// Unknown is true if the rule's pattern depended on a missing attribute, under MissingUnknown
+	Unknown    bool          `json:"unknown,omitempty"`
+	Tasks      []string      `json:"tasks,omitempty"`
+	Properties []Property    `json:"properties,omitempty"`
+	Call       *RuleSetTrace `json:"call,omitempty"`
+	// WillReturn is true if the rule ended the evaluation of its ruleset, and WillExit is true if
+	// the rule, or a ruleset it called, ended the match
+	WillReturn bool `json:"willReturn,omitempty"`
+	WillExit   bool `json:"willExit,omitempty"`
+}
+
+type TermTrace struct {
+	Term RulePatternTerm `json:"term"`
+	// The entity value compared with the term, its value-type, and whether it came from an
+	// entity attribute, a task in the action-set, or neither ("default"), or the attribute is
+	// missing from the entity
+	EntityVal string `json:"entityVal"`
+	ValType   string `json:"valType"`
+	ValSource string `json:"valSource"`
+	// For a term that compares two attributes, the entity's value of the other attribute. For a
+	// term that compares expressions, EntityVal and RefVal are the values of its two sides.
+	RefVal  string `json:"refVal,omitempty"`
+	Matched bool   `json:"matched"`
+	Unknown bool   `json:"unknown,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+// Returns the trace to be filled in by the doMatch() that has just started
+func (m *matcher) startRuleSetTrace(setName string) *RuleSetTrace {
+	st := m.nextSetTrace
+	m.nextSetTrace = nil
+	if st != nil {
+		st.SetName = setName
+	}
+	return st
+}
+
+func (st *RuleSetTrace) addRule(index int) *RuleTrace {
+	if st == nil {
+		return nil
+	}
+	st.Rules = append(st.Rules, RuleTrace{Index: index})
+	return &st.Rules[len(st.Rules)-1]
+}
+
+func (rt *RuleTrace) addTerm(tt TermTrace) {
+	if rt != nil {
+		rt.Terms = append(rt.Terms, tt)
+	}
+}
+
+func (rt *RuleTrace) setMatched(ruleActions RuleActions) {
+	if rt == nil {
+		return
+	}
+	rt.Matched = true
+	rt.Tasks = ruleActions.Tasks
+	rt.Properties = ruleActions.Properties
+}
+
+// Records a call to another ruleset, and returns the trace to be filled in by that ruleset
+func (rt *RuleTrace) addCall(via string) *RuleSetTrace {
+	if rt == nil {
+		return nil
+	}
+	rt.Call = &RuleSetTrace{Via: via}
+	return rt.Call
+}
+
+func (rt *RuleTrace) setUnknown() {
+	if rt != nil {
+		rt.Unknown = true
+	}
+}
+
+func (rt *RuleTrace) setReturn() {
+	if rt != nil {
+		rt.WillReturn = true
+	}
+}
+
+func (rt *RuleTrace) setExit() {
+	if rt != nil {
+		rt.WillExit = true
+	}
+}
+
+// String renders the trace as indented text, one line per ruleset, rule, term and action
+func (t *MatchTrace) String() string {
+	var b strings.Builder
+	writeRuleSetTrace(&b, t.Root, "")
+	if t.Error != "" {
+		fmt.Fprintf(&b, "error: %v\n", t.Error)
+	}
+	fmt.Fprintf(&b, "result: tasks %v, properties %v\n", t.Result.Tasks, t.Result.Properties)
+	return b.String()
+}
+
+func writeRuleSetTrace(b *strings.Builder, st *RuleSetTrace, indent string) {
+	if st == nil {
+		return
+	}
+	fmt.Fprintf(b, "%vruleset %v\n", indent, st.SetName)
+	for _, rt := range st.Rules {
+		outcome := "not matched"
+		if rt.Matched {
+			outcome = "matched"
+		} else if rt.Unknown {
+			outcome = "unknown"
+		}
+		fmt.Fprintf(b, "%v  rule %v: %v\n", indent, rt.Index, outcome)
+		for _, tt := range rt.Terms {
+			result := fmt.Sprint(tt.Matched)
+			if tt.Unknown {
+				result = "unknown"
+			}
+			fmt.Fprintf(b, "%v    %v: %v value %q (%v)", indent, formatDSLTerm(tt.Term), tt.ValSource,
+				tt.EntityVal, tt.ValType)
+			if ref, ok := tt.Term.AttrVal.(AttrRef); ok {
+				fmt.Fprintf(b, ", %v %q", ref.Attr, tt.RefVal)
+			} else if tt.ValSource == valSourceExpr {
+				fmt.Fprintf(b, ", other side %q", tt.RefVal)
+			}
+			fmt.Fprintf(b, " -> %v", result)
+			if tt.Error != "" {
+				fmt.Fprintf(b, ", error: %v", tt.Error)
+			}
+			b.WriteString("\n")
+		}
+		if len(rt.Tasks) > 0 || len(rt.Properties) > 0 {
+			fmt.Fprintf(b, "%v    collect tasks %v, properties %v\n", indent, rt.Tasks, rt.Properties)
+		}
+		if rt.Call != nil {
+			fmt.Fprintf(b, "%v    %v %v\n", indent, rt.Call.Via, rt.Call.SetName)
+			writeRuleSetTrace(b, rt.Call, indent+"      ")
+		}
+		if rt.WillReturn {
+			fmt.Fprintf(b, "%v    return\n", indent)
+		}
+		if rt.WillExit {
+			fmt.Fprintf(b, "%v    exit\n", indent)
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package crux
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestMatchWithTrace(t *testing.T) {
+	testEngine.AddRuleSchema(testTransactionSchema())
+	setupRuleSetMainForTransaction()
+	setupRuleSetWinterDisc()
+	setupRuleSetRegularDisc()
+	setupRuleSetMemberDisc()
+	setupRuleSetNonMemberDisc()
+
+	// A member buying a lamp outside the winter sale: main's first rule fails and calls
+	// regulardisc, which calls memberdisc, whose first rule matches and exits
+	entity := Entity{transactionClass, []Attr{
+		{"productname", "lamp"},
+		{"price", "60"},
+		{"inwintersale", falseStr},
+		{"paymenttype", "card"},
+		{"ismember", trueStr},
+	}}
+	got, trace, err := testEngine.MatchWithTrace(entity, mainRS)
+	if err != nil {
+		t.Fatalf("MatchWithTrace() error = %v", err)
+	}
+	want, _ := testEngine.Match(entity, mainRS)
+	if !reflect.DeepEqual(got, want) || !reflect.DeepEqual(trace.Result, want) {
+		t.Errorf("MatchWithTrace() = %v, trace result %v, want %v", got, trace.Result, want)
+	}
+
+	wantTrace := &RuleSetTrace{SetName: mainRS, Rules: []RuleTrace{{
+		Index: 0,
+		Terms: []TermTrace{
+			{RulePatternTerm{"inwintersale", OpEQ, true}, falseStr, TypeBool, valSourceAttr, "", false, false, ""},
+		},
+		Call: &RuleSetTrace{SetName: "regulardisc", Via: viaElseCall, Rules: []RuleTrace{{
+			Index: 0,
+			Terms: []TermTrace{
+				{RulePatternTerm{"ismember", OpEQ, true}, trueStr, TypeBool, valSourceAttr, "", true, false, ""},
+			},
+			Matched: true,
+			Call: &RuleSetTrace{SetName: "memberdisc", Via: viaThenCall, Rules: []RuleTrace{{
+				Index: 0,
+				Terms: []TermTrace{
+					{RulePatternTerm{"productname", OpEQ, "lamp"}, "lamp", TypeStr, valSourceAttr, "", true, false, ""},
+					{RulePatternTerm{"price", OpGT, 50}, "60", TypeInt, valSourceAttr, "", true, false, ""},
+				},
+				Matched:    true,
+				Properties: []Property{{"discount", "35"}, {"pointsmult", "2"}},
+				WillExit:   true,
+			}}},
+			WillExit: true,
+		}}},
+		WillExit: true,
+	}}}
+	if !reflect.DeepEqual(trace.Root, wantTrace) {
+		t.Errorf("MatchWithTrace() trace =\n%v", trace)
+	}
+
+	// The text rendering shows each step
+	text := trace.String()
+	for _, line := range []string{
+		"ruleset main",
+		"  rule 0: not matched",
+		`    inwintersale eq true: attribute value "false" (bool) -> false`,
+		"    elsecall regulardisc",
+		"            price gt 50: attribute value \"60\" (int) -> true",
+		"            collect tasks [], properties [{discount 35} {pointsmult 2}]",
+		"            exit",
+		"result: tasks [], properties [{discount 35} {pointsmult 2}]",
+	} {
+		if !strings.Contains(text, line+"\n") {
+			t.Errorf("trace text does not contain %q:\n%v", line, text)
+		}
+	}
+
+	// The JSON rendering has the same tree
+	data, err := json.Marshal(trace)
+	if err != nil {
+		t.Fatalf("json.Marshal() error = %v", err)
+	}
+	var decoded struct {
+		Root struct {
+			Rules []struct {
+				Call struct {
+					SetName string `json:"setName"`
+					Via     string `json:"via"`
+				} `json:"call"`
+			} `json:"rules"`
+		} `json:"root"`
+	}
+	if err := json.Unmarshal(data, &decoded); err != nil || decoded.Root.Rules[0].Call.SetName != "regulardisc" ||
+		decoded.Root.Rules[0].Call.Via != viaElseCall {
+		t.Errorf("unexpected JSON trace %s, error %v", data, err)
+	}
+}
+
+func TestMatchWithTraceReturnAndError(t *testing.T) {
+	e := NewEngine()
+	e.AddRuleSchema(testCounterSchema())
+	e.AddRuleSet(RuleSet{Ver: 1, Class: counterClass, SetName: mainRS, Rules: []Rule{
+		{[]RulePatternTerm{{"n", OpGT, 0}}, RuleActions{Tasks: []string{"positive"}, WillReturn: true}},
+		{[]RulePatternTerm{{"n", OpGT, 1}}, RuleActions{Tasks: []string{"big"}}},
+	}})
+	_, trace, err := e.MatchWithTrace(Entity{counterClass, []Attr{{"n", "5"}}}, mainRS)
+	if err != nil {
+		t.Fatalf("MatchWithTrace() error = %v", err)
+	}
+	if len(trace.Root.Rules) != 1 || !trace.Root.Rules[0].WillReturn {
+		t.Errorf("expected evaluation to stop at a return in rule 0:\n%v", trace)
+	}
+
+	// A value that cannot be converted to the attribute's type shows up in the trace
+	_, trace, err = e.MatchWithTrace(Entity{counterClass, []Attr{{"n", "five"}}}, mainRS)
+	if err == nil || trace.Error == "" || trace.Root.Rules[0].Terms[0].Error == "" {
+		t.Errorf("expected an error in the trace, got error %v and trace:\n%v", err, trace)
+	}
+}
@@ -0,0 +1,251 @@
+/*
+This file contains ValidateEntity(), which checks the attributes of an entity against the
+pattern-schema of its class, and the strict mode of an Engine, in which every entity is checked
+this way before it is matched.
+
+Each attribute of the entity must be in the pattern-schema, and its value, unless it is empty,
+must be a valid value of the attribute's value-type. Beyond that:
+
+	enum                 the value must be in AttrSchema.Vals
+	int, float, decimal  the value must be from AttrSchema.ValMin to AttrSchema.ValMax, if either
+	                     of them is not 0, and a decimal must fit in AttrSchema.Precision and
+	                     AttrSchema.Scale
+	str                  the number of characters must be at least AttrSchema.LenMin, if it is not
+	                     0, and at most AttrSchema.LenMax, if it is not 0
+	list                 the number of elements must be within LenMin and LenMax as for str, and
+	                     the elements of an enum list must be in Vals
+
+An empty value is a missing attribute, and is not checked. An attribute that is required
+(AttrSchema.Required) must not be missing. Every problem found is reported, as an AttrError in an
+*EntityError:
+
+	entity of class inventoryitem is invalid: attribute cat: spaceship is not a valid value; attribute ageinstock: -4 is less than 0
+
+Before an entity is matched, each attribute that it is missing is given the default in its
+AttrSchema, if it has one. The match then fails with an *EntityError if the entity is missing
+any required attributes, or in strict mode, if it is not valid in any way.
+*/
+
+package crux
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// The kinds of AttrError
+const (
+	AttrUnknown    = "unknown"
+	AttrInvalid    = "invalid"
+	AttrNotInVals  = "notinvals"
+	AttrOutOfRange = "outofrange"
+	AttrBadLength  = "badlength"
+	AttrMissing    = "missing"
+)
+
+// AttrError is a problem with one attribute of an entity
+type AttrError struct {
+	Attr string `json:"attr"`
+	Val  string `json:"val"`
+	// One of the AttrError kinds
+	Kind string `json:"kind"`
+	Msg  string `json:"msg"`
+}
+
+func (e AttrError) Error() string {
+	return fmt.Sprintf("attribute %v: %v", e.Attr, e.Msg)
+}
+
+// EntityError holds every problem found in an entity, in the order of its attributes, followed by
+// the required attributes that it is missing
+type EntityError struct {
+	Class string
+	Errs  []AttrError
+}
+
+func (e *EntityError) Error() string {
+	msgs := make([]string, len(e.Errs))
+	for i, ae := range e.Errs {
+		msgs[i] = ae.Error()
+	}
+	return fmt.Sprintf("entity of class %v is invalid: %v", e.Class, strings.Join(msgs, "; "))
+}
+
+// ValidateEntity checks entity against the engine's schema for its class. It returns an
+// *EntityError listing the problems found, if any, or an error if there is no such schema.
+func (e *Engine) ValidateEntity(entity Entity) error {
+	return e.registry.load().validateEntity(entity)
+}
+
+// SetStrict turns strict mode on or off. In strict mode, every entity is checked as
+// ValidateEntity does before it is matched, and an invalid entity is not matched: the match
+// fails with the *EntityError.
+func (e *Engine) SetStrict(strict bool) {
+	e.registry.update(func(st *ruleStore) {
+		st.strict = strict
+	})
+}
+
+func (st *ruleStore) validateEntity(entity Entity) error {
+	schema, err := st.getSchema(entity.Class)
+	if err != nil {
+		return err
+	}
+	cs := st.compiledSchemas[entity.Class]
+	var errs []AttrError
+	for _, attr := range entity.Attrs {
+		slot, ok := cs.slots[attr.Name]
+		if !ok {
+			errs = append(errs, AttrError{attr.Name, attr.Val, AttrUnknown, "not in the pattern-schema"})
+			continue
+		}
+		if attr.Val == "" {
+			continue
+		}
+		if kind, msg := validateAttrVal(attr.Val, schema.PatternSchema[slot], cs.formats[slot]); kind != "" {
+			errs = append(errs, AttrError{attr.Name, attr.Val, kind, msg})
+		}
+	}
+	errs = append(errs, missingRequired(cs, entity)...)
+	if len(errs) > 0 {
+		return &EntityError{Class: entity.Class, Errs: errs}
+	}
+	return nil
+}
+
+// Returns entity as it is to be matched: with the defaults of the attributes it is missing, and
+// checked for required attributes, or in strict mode, validated
+func (st *ruleStore) prepareEntity(entity Entity) (Entity, error) {
+	entity = st.withDefaults(entity)
+	if st.strict {
+		return entity, st.validateEntity(entity)
+	}
+	cs, ok := st.compiledSchemas[entity.Class]
+	if !ok {
+		return entity, nil
+	}
+	if errs := missingRequired(cs, entity); len(errs) > 0 {
+		return entity, &EntityError{Class: entity.Class, Errs: errs}
+	}
+	return entity, nil
+}
+
+// Returns entity with the default of each attribute that it is missing, if the attribute has
+// one. entity is returned as it is if it is missing none of them.
+func (st *ruleStore) withDefaults(entity Entity) Entity {
+	cs, ok := st.compiledSchemas[entity.Class]
+	if !ok || len(cs.defaults) == 0 {
+		return entity
+	}
+	var attrs []Attr
+	for _, def := range cs.defaults {
+		i := attrIndex(entity.Attrs, def.Name)
+		if i >= 0 && entity.Attrs[i].Val != "" {
+			continue
+		}
+		if attrs == nil {
+			attrs = append(make([]Attr, 0, len(entity.Attrs)+len(cs.defaults)), entity.Attrs...)
+		}
+		if i >= 0 {
+			attrs[i].Val = def.Val
+		} else {
+			attrs = append(attrs, def)
+		}
+	}
+	if attrs != nil {
+		entity.Attrs = attrs
+	}
+	return entity
+}
+
+// Returns an AttrError for each required attribute of cs that entity is missing
+func missingRequired(cs *compiledSchema, entity Entity) []AttrError {
+	var errs []AttrError
+	for _, name := range cs.required {
+		if i := attrIndex(entity.Attrs, name); i < 0 || entity.Attrs[i].Val == "" {
+			errs = append(errs, AttrError{name, "", AttrMissing, "missing, but required"})
+		}
+	}
+	return errs
+}
+
+// Returns the index of the first attribute in attrs named name, or -1 if there is none
+func attrIndex(attrs []Attr, name string) int {
+	for i, attr := range attrs {
+		if attr.Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// Checks the entity value val of the attribute as, whose format is af. Returns the kind of
+// AttrError and its message, or "" if val is valid.
+func validateAttrVal(val string, as AttrSchema, af *attrFormat) (string, string) {
+	conv, err := convertEntityAttrVal(val, as.ValType, af)
+	if err != nil {
+		return AttrInvalid, fmt.Sprintf("not a valid %v: %v", as.ValType, err)
+	}
+	switch as.ValType {
+	case TypeEnum:
+		if !as.Vals[val] {
+			return AttrNotInVals, fmt.Sprintf("%v is not a valid value", val)
+		}
+	case TypeInt, TypeFloat, TypeDecimal:
+		if d, ok := conv.(Decimal); ok && !d.fits(as.Precision, as.Scale) {
+			return AttrOutOfRange, fmt.Sprintf("%v does not fit in precision %v and scale %v", val, as.Precision, as.Scale)
+		}
+		if as.ValMin == 0 && as.ValMax == 0 {
+			break
+		}
+		if below, above := outOfRange(conv, as.ValMin, as.ValMax); below {
+			return AttrOutOfRange, fmt.Sprintf("%v is less than %v", val, as.ValMin)
+		} else if above {
+			return AttrOutOfRange, fmt.Sprintf("%v is more than %v", val, as.ValMax)
+		}
+	case TypeStr:
+		return checkLength(utf8.RuneCountInString(val), "characters", as)
+	case TypeList:
+		elems := conv.([]any)
+		if as.ElemType == TypeEnum {
+			for _, elem := range elems {
+				if !as.Vals[elem.(string)] {
+					return AttrNotInVals, fmt.Sprintf("%v is not a valid element", elem)
+				}
+			}
+		}
+		return checkLength(len(elems), "elements", as)
+	}
+	return "", ""
+}
+
+// Returns whether conv, an int, float64 or Decimal, is less than min or more than max
+func outOfRange(conv any, min float64, max float64) (bool, bool) {
+	switch v := conv.(type) {
+	case int:
+		return float64(v) < min, float64(v) > max
+	case float64:
+		return v < min, v > max
+	case Decimal:
+		return v.Cmp(floatDecimal(min)) < 0, v.Cmp(floatDecimal(max)) > 0
+	}
+	return false, false
+}
+
+// Returns f as a Decimal, as it would be written in the schema
+func floatDecimal(f float64) Decimal {
+	d, _ := ParseDecimal(strconv.FormatFloat(f, 'f', -1, 64))
+	return d
+}
+
+// Checks n, the length of a value of the attribute as in units, against LenMin and LenMax
+func checkLength(n int, units string, as AttrSchema) (string, string) {
+	if as.LenMin > 0 && n < as.LenMin {
+		return AttrBadLength, fmt.Sprintf("%v %v, less than %v", n, units, as.LenMin)
+	} else if as.LenMax > 0 && n > as.LenMax {
+		return AttrBadLength, fmt.Sprintf("%v %v, more than %v", n, units, as.LenMax)
+	}
+	return "", ""
+}
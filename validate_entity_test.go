@@ -0,0 +1,151 @@
+package crux
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func testValidationEngine(t *testing.T) *Engine {
+	schema, err := ParseRuleSchemaJSON(inventoryItemSchemaJSON)
+	if err != nil {
+		t.Fatalf("ParseRuleSchemaJSON() error = %v", err)
+	}
+	schema.PatternSchema = append(schema.PatternSchema,
+		AttrSchema{Name: "price", ValType: TypeDecimal, Currency: "INR", ValMin: 0.5, ValMax: 1000},
+		AttrSchema{Name: "tags", ValType: TypeList, ElemType: TypeEnum, Vals: map[string]bool{"new": true, "sale": true}, LenMax: 2})
+	e := NewEngine()
+	e.AddRuleSchema(schema)
+	return e
+}
+
+func TestValidateEntity(t *testing.T) {
+	e := testValidationEngine(t)
+	tests := []struct {
+		name  string
+		attrs []Attr
+		want  []AttrError
+	}{
+		{"valid", sampleEntity.Attrs, nil},
+		{"limits", []Attr{{"ageinstock", "365"}, {"fullname", "A"}, {"price", "INR 1000.00"}, {"tags", `["new","sale"]`}}, nil},
+		{"empty values", []Attr{{"cat", ""}, {"fullname", ""}}, nil},
+		{"unknown", []Attr{{"colour", "red"}}, []AttrError{{"colour", "red", AttrUnknown, "not in the pattern-schema"}}},
+		{"enum", []Attr{{"cat", "spaceship"}}, []AttrError{{"cat", "spaceship", AttrNotInVals, "spaceship is not a valid value"}}},
+		{"below", []Attr{{"ageinstock", "-4"}}, []AttrError{{"ageinstock", "-4", AttrOutOfRange, "-4 is less than 0"}}},
+		{"above", []Attr{{"price", "1000.01"}}, []AttrError{{"price", "1000.01", AttrOutOfRange, "1000.01 is more than 1000"}}},
+		{"empty list", []Attr{{"tags", "[]"}}, nil},
+		{"too long", []Attr{{"fullname", string(make([]byte, 81))}}, []AttrError{{"fullname", string(make([]byte, 81)), AttrBadLength, "81 characters, more than 80"}}},
+		{"too many", []Attr{{"tags", `["new","sale","new"]`}}, []AttrError{{"tags", `["new","sale","new"]`, AttrBadLength, "3 elements, more than 2"}}},
+		{"element", []Attr{{"tags", `["old"]`}}, []AttrError{{"tags", `["old"]`, AttrNotInVals, "old is not a valid element"}}},
+		{"unparseable", []Attr{{"mrp", "cheap"}}, []AttrError{{"mrp", "cheap", AttrInvalid,
+			`not a valid float: strconv.ParseFloat: parsing "cheap": invalid syntax`}}},
+		{"all reported", []Attr{{"cat", "spaceship"}, {"bulkorder", "yes"}, {"ageinstock", "400"}}, []AttrError{
+			{"cat", "spaceship", AttrNotInVals, "spaceship is not a valid value"},
+			{"bulkorder", "yes", AttrInvalid, `not a valid bool: strconv.ParseBool: parsing "yes": invalid syntax`},
+			{"ageinstock", "400", AttrOutOfRange, "400 is more than 365"},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := e.ValidateEntity(Entity{inventoryItemClass, tt.attrs})
+			var ee *EntityError
+			if tt.want == nil {
+				if err != nil {
+					t.Errorf("ValidateEntity() error = %v", err)
+				}
+			} else if !errors.As(err, &ee) || ee.Class != inventoryItemClass || !reflect.DeepEqual(ee.Errs, tt.want) {
+				t.Errorf("ValidateEntity() error = %#v, want %v", err, tt.want)
+			}
+		})
+	}
+
+	err := e.ValidateEntity(Entity{"spaceship", nil})
+	var ee *EntityError
+	if err == nil || errors.As(err, &ee) {
+		t.Errorf("ValidateEntity() error = %v, want an error for a class with no schema", err)
+	}
+}
+
+// In strict mode, an invalid entity is not matched by any of the match APIs
+func TestStrictMatch(t *testing.T) {
+	e := testValidationEngine(t)
+	e.AddRuleSet(RuleSet{Ver: 1, Class: inventoryItemClass, SetName: mainRS, Rules: []Rule{{
+		[]RulePatternTerm{{"ageinstock", OpLT, 10}}, RuleActions{Tasks: []string{"dodiscount"}},
+	}}})
+	invalid := Entity{inventoryItemClass, []Attr{{"cat", "spaceship"}, {"ageinstock", "-4"}}}
+	want := ActionSet{Tasks: []string{"dodiscount"}}
+
+	if got, err := e.Match(invalid, mainRS); err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("Match() = %v, %v, want %v without strict mode", got, err, want)
+	}
+
+	e.SetStrict(true)
+	var ee *EntityError
+	if _, err := e.Match(invalid, mainRS); !errors.As(err, &ee) || len(ee.Errs) != 2 {
+		t.Errorf("Match() error = %v, want an EntityError with 2 errors", err)
+	}
+	if _, trace, err := e.MatchWithTrace(invalid, mainRS); err == nil || trace.Error != err.Error() {
+		t.Errorf("MatchWithTrace() error = %v, trace error %q", err, trace.Error)
+	}
+	results := e.MatchBatch([]Entity{invalid, sampleEntity}, mainRS, 2)
+	if !errors.As(results[0].Err, &ee) || results[1].Err != nil || !reflect.DeepEqual(results[1].Result, want) {
+		t.Errorf("MatchBatch() = %+v", results)
+	}
+
+	e.SetStrict(false)
+	if _, err := e.Match(invalid, mainRS); err != nil {
+		t.Errorf("Match() error = %v after strict mode is turned off", err)
+	}
+}
+
+// Before an entity is matched, it is given the defaults of the attributes it is missing, and it
+// must have every required attribute
+func TestDefaultsAndRequired(t *testing.T) {
+	e := NewEngine()
+	schema := testTransactionSchema()
+	for i, as := range schema.PatternSchema {
+		switch as.Name {
+		case "productname":
+			schema.PatternSchema[i].Required = true
+		case "paymenttype":
+			schema.PatternSchema[i].Default = "cash"
+		case "ismember":
+			schema.PatternSchema[i].Default = falseStr
+		}
+	}
+	e.AddRuleSchema(schema)
+	e.AddRuleSet(RuleSet{Ver: 1, Class: transactionClass, SetName: mainRS, Rules: []Rule{
+		{[]RulePatternTerm{{"paymenttype", OpEQ, "cash"}, {"ismember", OpEQ, false}}, RuleActions{Tasks: []string{"freepen"}}},
+		{[]RulePatternTerm{{"paymenttype", OpEQ, "card"}}, RuleActions{Tasks: []string{"freemug"}}},
+	}, MissingAttrs: MissingError})
+
+	attrs := []Attr{{"productname", "jacket"}, {"paymenttype", ""}}
+	entity := Entity{transactionClass, attrs}
+	want := ActionSet{Tasks: []string{"freepen"}}
+	if got, err := e.Match(entity, mainRS); err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("Match() = %v, %v, want %v", got, err, want)
+	}
+	if attrs[1].Val != "" || len(entity.Attrs) != 2 {
+		t.Errorf("Match() modified the entity: %v", entity)
+	}
+	if got, _ := e.Match(Entity{transactionClass, []Attr{{"productname", "jacket"}, {"paymenttype", "card"}}}, mainRS); !reflect.DeepEqual(got, ActionSet{Tasks: []string{"freemug"}}) {
+		t.Errorf("Match() = %v, want the entity's own value to be used", got)
+	}
+	report, err := e.WhyNot(entity, mainRS, WhyNotQuery{Task: "freemug"})
+	if err != nil || len(report.Candidates) != 1 || report.Candidates[0].FailedTerms[0].EntityVal != "cash" {
+		t.Errorf("WhyNot() = %+v, %v, want the default to be explained", report, err)
+	}
+
+	missing := Entity{transactionClass, []Attr{{"productname", ""}, {"paymenttype", "cash"}}}
+	wantErr := "entity of class transaction is invalid: attribute productname: missing, but required"
+	var ee *EntityError
+	if _, err := e.Match(missing, mainRS); !errors.As(err, &ee) || err.Error() != wantErr || ee.Errs[0].Kind != AttrMissing {
+		t.Errorf("Match() error = %v, want %v", err, wantErr)
+	}
+	if _, _, err := e.MatchWithTrace(missing, mainRS); err == nil || err.Error() != wantErr {
+		t.Errorf("MatchWithTrace() error = %v, want %v", err, wantErr)
+	}
+	if err := e.ValidateEntity(missing); err == nil || err.Error() != wantErr {
+		t.Errorf("ValidateEntity() error = %v, want %v", err, wantErr)
+	}
+}
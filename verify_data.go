@@ -3,7 +3,7 @@ This file contains verifyRuleSchema() and verifyRuleSet(), and helper functions
 these two functions.
 */
 
-package main
+package crux
 
 import (
 	"fmt"
@@ -23,18 +23,23 @@ const (
 )
 
 var validTypes = map[string]bool{
-	typeBool: true, typeInt: true, typeFloat: true, typeStr: true, typeEnum: true, typeTS: true,
+	TypeBool: true, TypeInt: true, TypeFloat: true, TypeStr: true, TypeEnum: true, TypeTS: true, TypeDate: true,
+	TypeDecimal: true, TypeList: true,
 }
 
 var validOps = map[string]bool{
-	opEQ: true, opNE: true, opLT: true, opLE: true, opGT: true, opGE: true,
+	OpEQ: true, OpNE: true, OpLT: true, OpLE: true, OpGT: true, OpGE: true, OpIn: true, OpNIn: true,
+	OpContains: true, OpStartsWith: true, OpEndsWith: true, OpMatches: true, OpExists: true, OpNotExists: true,
+	OpWithin: true, OpOlderThan: true, OpDayOfWeek: true, OpMonth: true, OpHourIn: true,
+	OpHasAny: true, OpHasAll: true, OpHasNone: true,
+	OpCountEQ: true, OpCountNE: true, OpCountLT: true, OpCountLE: true, OpCountGT: true, OpCountGE: true,
 }
 
 // Parameters
 // rs RuleSchema: the RuleSchema to be verified
 // isWF bool: true if the RuleSchema applies to a workflow, otherwise false
 func verifyRuleSchema(rs RuleSchema, isWF bool) (bool, error) {
-	if len(rs.class) == 0 {
+	if len(rs.Class) == 0 {
 		return false, fmt.Errorf("schema class is empty string")
 	}
 	if _, err := verifyPatternSchema(rs, isWF); err != nil {
@@ -47,65 +52,150 @@ func verifyRuleSchema(rs RuleSchema, isWF bool) (bool, error) {
 }
 
 func verifyPatternSchema(rs RuleSchema, isWF bool) (bool, error) {
-	if len(rs.patternSchema) == 0 {
-		return false, fmt.Errorf("pattern-schema for %v is empty", rs.class)
+	if len(rs.PatternSchema) == 0 {
+		return false, fmt.Errorf("pattern-schema for %v is empty", rs.Class)
 	}
 	re := regexp.MustCompile(cruxIDRegExp)
 	// Bools needed for workflows only
 	stepFound, stepFailedFound := false, false
 
-	for _, attrSchema := range rs.patternSchema {
-		if !re.MatchString(attrSchema.name) {
-			return false, fmt.Errorf("attribute name %v is not a valid CruxID", attrSchema.name)
-		} else if !validTypes[attrSchema.valType] {
-			return false, fmt.Errorf("%v is not a valid value-type", attrSchema.valType)
-		} else if attrSchema.valType == typeEnum && len(attrSchema.vals) == 0 {
-			return false, fmt.Errorf("no valid values for enum %v", attrSchema.name)
+	for _, attrSchema := range rs.PatternSchema {
+		if !re.MatchString(attrSchema.Name) {
+			return false, fmt.Errorf("attribute name %v is not a valid CruxID", attrSchema.Name)
+		} else if !validTypes[attrSchema.ValType] {
+			return false, fmt.Errorf("%v is not a valid value-type", attrSchema.ValType)
+		} else if attrSchema.ValType != TypeList && attrSchema.ElemType != "" {
+			return false, fmt.Errorf("only list attributes may have an element type: %v", attrSchema.Name)
+		} else if attrSchema.ValType == TypeList && !elemTypes[attrSchema.ElemType] {
+			return false, fmt.Errorf("%q is not a valid element type for list %v", attrSchema.ElemType, attrSchema.Name)
+		} else if (attrSchema.ValType == TypeEnum || attrSchema.ElemType == TypeEnum) && len(attrSchema.Vals) == 0 {
+			return false, fmt.Errorf("no valid values for enum %v", attrSchema.Name)
+		} else if err := verifyTimeFormat(attrSchema); err != nil {
+			return false, err
+		} else if err := verifyDecimalFormat(attrSchema); err != nil {
+			return false, err
+		} else if err := verifyLimits(attrSchema); err != nil {
+			return false, err
+		} else if err := verifyDefault(attrSchema); err != nil {
+			return false, err
 		}
-		for val := range attrSchema.vals {
+		for val := range attrSchema.Vals {
 			if !re.MatchString(val) && val != start {
 				return false, fmt.Errorf("enum value %v is not a valid CruxID", val)
 			}
 		}
 
 		// Workflows only
-		if attrSchema.name == step && attrSchema.valType == typeEnum {
+		if attrSchema.Name == step && attrSchema.ValType == TypeEnum {
 			stepFound = true
 		}
-		if isWF && attrSchema.name == step && !attrSchema.vals[start] {
-			return false, fmt.Errorf("workflow schema for %v doesn't allow step=START", rs.class)
+		if isWF && attrSchema.Name == step && !attrSchema.Vals[start] {
+			return false, fmt.Errorf("workflow schema for %v doesn't allow step=START", rs.Class)
 		}
-		if attrSchema.name == stepFailed && attrSchema.valType == typeBool {
+		if attrSchema.Name == stepFailed && attrSchema.ValType == TypeBool {
 			stepFailedFound = true
 		}
 	}
 
 	// Workflows only
 	if isWF && (!stepFound || !stepFailedFound) {
-		return false, fmt.Errorf("necessary workflow attributes absent in schema for class %v", rs.class)
+		return false, fmt.Errorf("necessary workflow attributes absent in schema for class %v", rs.Class)
 	}
 
 	return true, nil
 }
 
+// Checks that only ts and date attributes have layouts and time zones, and that the time zone
+// can be loaded
+func verifyTimeFormat(as AttrSchema) error {
+	if len(as.Layouts) == 0 && as.TZ == "" {
+		return nil
+	}
+	if as.ValType != TypeTS && as.ValType != TypeDate {
+		return fmt.Errorf("only ts and date attributes may have layouts and time zones: %v", as.Name)
+	}
+	for _, layout := range as.Layouts {
+		if layout == "" {
+			return fmt.Errorf("empty layout for %v", as.Name)
+		}
+	}
+	if _, err := time.LoadLocation(as.TZ); err != nil {
+		return fmt.Errorf("invalid time zone for %v: %w", as.Name, err)
+	}
+	return nil
+}
+
+// Checks that only int, float and decimal attributes have ValMin and ValMax, that only str and
+// list attributes have LenMin and LenMax, and that no minimum is more than its maximum
+func verifyLimits(as AttrSchema) error {
+	if as.ValMin != 0 || as.ValMax != 0 {
+		if as.ValType != TypeInt && as.ValType != TypeFloat && as.ValType != TypeDecimal {
+			return fmt.Errorf("only int, float and decimal attributes may have valMin and valMax: %v", as.Name)
+		} else if as.ValMin > as.ValMax {
+			return fmt.Errorf("valMin is more than valMax for %v", as.Name)
+		}
+	}
+	if as.LenMin != 0 || as.LenMax != 0 {
+		if as.ValType != TypeStr && as.ValType != TypeList {
+			return fmt.Errorf("only str and list attributes may have lenMin and lenMax: %v", as.Name)
+		} else if as.LenMin < 0 || as.LenMax < 0 || as.LenMax > 0 && as.LenMin > as.LenMax {
+			return fmt.Errorf("invalid lenMin and lenMax for %v: %v, %v", as.Name, as.LenMin, as.LenMax)
+		}
+	}
+	return nil
+}
+
+// Checks that the default of as, if it has one, is a valid value of as, and that as is not also
+// required
+func verifyDefault(as AttrSchema) error {
+	if as.Default == "" {
+		return nil
+	}
+	if as.Required {
+		return fmt.Errorf("required attribute %v cannot have a default", as.Name)
+	}
+	if _, msg := validateAttrVal(as.Default, as, newAttrFormat(as)); msg != "" {
+		return fmt.Errorf("invalid default for %v: %v", as.Name, msg)
+	}
+	return nil
+}
+
+// Checks that only decimal attributes have a currency, precision and scale, that the currency is
+// a code of three capital letters, and that the scale is no more than the precision
+func verifyDecimalFormat(as AttrSchema) error {
+	if as.Currency == "" && as.Precision == 0 && as.Scale == 0 {
+		return nil
+	}
+	if as.ValType != TypeDecimal {
+		return fmt.Errorf("only decimal attributes may have a currency, precision and scale: %v", as.Name)
+	}
+	if as.Currency != "" && !currencyCodeRE.MatchString(as.Currency) {
+		return fmt.Errorf("invalid currency code for %v: %v", as.Name, as.Currency)
+	}
+	if as.Precision < 0 || as.Scale < 0 || as.Scale > as.Precision {
+		return fmt.Errorf("invalid precision and scale for %v: %v, %v", as.Name, as.Precision, as.Scale)
+	}
+	return nil
+}
+
 func verifyActionSchema(rs RuleSchema, isWF bool) (bool, error) {
 	re := regexp.MustCompile(cruxIDRegExp)
-	if len(rs.actionSchema.tasks) == 0 && len(rs.actionSchema.properties) == 0 {
-		return false, fmt.Errorf("both tasks and properties are empty in schema for class %v", rs.class)
+	if len(rs.ActionSchema.Tasks) == 0 && len(rs.ActionSchema.Properties) == 0 {
+		return false, fmt.Errorf("both tasks and properties are empty in schema for class %v", rs.Class)
 	}
-	for _, task := range rs.actionSchema.tasks {
+	for _, task := range rs.ActionSchema.Tasks {
 		if !re.MatchString(task) {
 			return false, fmt.Errorf("task %v is not a valid CruxID", task)
 		}
 	}
 
 	// Workflows only
-	if isWF && len(rs.actionSchema.properties) != 2 {
-		return false, fmt.Errorf("action-schema for %v does not contain exactly two properties", rs.class)
+	if isWF && len(rs.ActionSchema.Properties) != 2 {
+		return false, fmt.Errorf("action-schema for %v does not contain exactly two properties", rs.Class)
 	}
 	nextStepFound, doneFound := false, false
 
-	for _, propName := range rs.actionSchema.properties {
+	for _, propName := range rs.ActionSchema.Properties {
 		if !re.MatchString(propName) {
 			return false, fmt.Errorf("property name %v is not a valid CruxID", propName)
 		} else if propName == nextStep {
@@ -115,12 +205,16 @@ func verifyActionSchema(rs RuleSchema, isWF bool) (bool, error) {
 		}
 	}
 
+	if err := verifyPropertySchema(rs); err != nil {
+		return false, err
+	}
+
 	// Workflows only
 	if isWF && (!nextStepFound || !doneFound) {
-		return false, fmt.Errorf("action-schema for %v does not contain both the properties 'nextstep' and 'done'", rs.class)
+		return false, fmt.Errorf("action-schema for %v does not contain both the properties 'nextstep' and 'done'", rs.Class)
 	}
-	if isWF && !reflect.DeepEqual(getTasksMapForWF(rs.actionSchema.tasks), getStepAttrVals(rs)) {
-		return false, fmt.Errorf("action-schema tasks for %v are not the same as valid values for 'step' in pattern-schema", rs.class)
+	if isWF && !reflect.DeepEqual(getTasksMapForWF(rs.ActionSchema.Tasks), getStepAttrVals(rs)) {
+		return false, fmt.Errorf("action-schema tasks for %v are not the same as valid values for 'step' in pattern-schema", rs.Class)
 	}
 	return true, nil
 }
@@ -136,49 +230,59 @@ func getTasksMapForWF(tasks []string) map[string]bool {
 }
 
 func getStepAttrVals(rs RuleSchema) map[string]bool {
-	for _, ps := range rs.patternSchema {
-		if ps.name == step {
-			return ps.vals
+	for _, ps := range rs.PatternSchema {
+		if ps.Name == step {
+			return ps.Vals
 		}
 	}
 	return nil
 }
 
 // Parameters
-// rs RuleSet: the RuleSet to be verified
+// rs RuleSet: the RuleSet to be verified against the schema for its class in st
 // isWF bool: true if the RuleSet is a workflow, otherwise false
-func verifyRuleSet(rs RuleSet, isWF bool) (bool, error) {
-	schema, err := getSchema(rs.Class)
+// Returns the warnings for the terms of rs (see rule_warnings.go) if it is valid.
+func (st *ruleStore) verifyRuleSet(rs RuleSet, isWF bool) ([]RuleWarning, error) {
+	return st.verifyRuleSetWith(rs, isWF, map[string]RuleSet{rs.SetName: rs})
+}
+
+// Verifies rs as verifyRuleSet() does, except that the rulesets it calls are looked up in
+// pending before st
+func (st *ruleStore) verifyRuleSetWith(rs RuleSet, isWF bool, pending map[string]RuleSet) ([]RuleWarning, error) {
+	warnings, err := st.verifyRules(rs, isWF)
 	if err != nil {
-		return false, err
+		return nil, err
+	}
+	if err := st.verifyCalls(rs, pending); err != nil {
+		return nil, err
+	}
+	return warnings, nil
+}
+
+// Verifies the rules of rs against the schema for its class, without following their calls, and
+// returns the warnings for their terms
+func (st *ruleStore) verifyRules(rs RuleSet, isWF bool) ([]RuleWarning, error) {
+	schema, err := st.getSchema(rs.Class)
+	if err != nil {
+		return nil, err
+	}
+	if !missingPolicies[rs.MissingAttrs] {
+		return nil, fmt.Errorf("invalid missing-attribute policy: %v", rs.MissingAttrs)
 	}
 	if _, err = verifyRulePatterns(rs, schema, isWF); err != nil {
-		return false, err
+		return nil, err
 	}
 	if _, err = verifyRuleActions(rs, schema, isWF); err != nil {
-		return false, err
+		return nil, err
 	}
-	return true, nil
+	return ruleSetWarnings(rs, schema), nil
 }
 
 func verifyRulePatterns(ruleSet RuleSet, schema RuleSchema, isWF bool) (bool, error) {
 	for _, rule := range ruleSet.Rules {
 		for _, term := range rule.RulePattern {
-			valType := getType(schema, term.AttrName)
-			if valType == "" {
-				// If the attribute name is not in the pattern-schema, we check if it's a task "tag"
-				// by checking for its presence in the action-schema
-				if !isStringInArray(term.AttrName, schema.actionSchema.tasks) {
-					return false, fmt.Errorf("attribute does not exist in schema: %v", term.AttrName)
-				}
-				// If it is a tag, the value type is set to bool
-				valType = typeBool
-			}
-			if !verifyType(term.AttrVal, valType) {
-				return false, fmt.Errorf("value of this attribute does not match schema type: %v", term.AttrName)
-			}
-			if !validOps[term.Op] {
-				return false, fmt.Errorf("invalid operation in rule: %v", term.Op)
+			if err := verifyTerm(term, schema); err != nil {
+				return false, err
 			}
 		}
 		// Workflows only
@@ -198,24 +302,179 @@ func verifyRulePatterns(ruleSet RuleSet, schema RuleSchema, isWF bool) (bool, er
 	return true, nil
 }
 
-func getSchema(class string) (RuleSchema, error) {
-	for _, s := range ruleSchemas {
-		if class == s.class {
-			return s, nil
+// Verifies a rule-pattern term against schema. The terms in an any, all or not group are
+// verified in turn.
+func verifyTerm(term RulePatternTerm, schema RuleSchema) error {
+	if groupOps[term.Op] {
+		terms, ok := term.AttrVal.([]RulePatternTerm)
+		if !ok || len(terms) == 0 || term.AttrName != "" {
+			return fmt.Errorf("%v group must have no attribute name and at least one term", term.Op)
+		}
+		for _, t := range terms {
+			if err := verifyTerm(t, schema); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if ref, ok := term.AttrVal.(AttrRef); ok {
+		return verifyRefTerm(term, ref, schema)
+	}
+	if isExprTerm(term) {
+		_, err := verifyExprTerm(term, schema)
+		return err
+	}
+	valType := getTermType(schema, term.AttrName)
+	if valType == "" {
+		return fmt.Errorf("attribute does not exist in schema: %v", term.AttrName)
+	}
+	if valType == TypeList || listOps[term.Op] {
+		return verifyListTerm(term, schema)
+	}
+	if !verifyTermVal(term.AttrVal, valType, term.Op) {
+		return fmt.Errorf("value of this attribute does not match schema type: %v", term.AttrName)
+	}
+	if !validOps[term.Op] {
+		return fmt.Errorf("invalid operation in rule: %v", term.Op)
+	}
+	if (term.Op == OpExists || term.Op == OpNotExists) && getType(schema, term.AttrName) == "" {
+		return fmt.Errorf("%v applies only to attributes in the pattern-schema: %v", term.Op, term.AttrName)
+	}
+	if term.Op == OpMatches {
+		if _, err := regexp.Compile(term.AttrVal.(string)); err != nil {
+			return fmt.Errorf("invalid regular expression for %v: %w", term.AttrName, err)
+		}
+	}
+	if valType == TypeDecimal && refOps[term.Op] {
+		if err := verifyDecimalLiteral(term.AttrVal, getAttrSchema(schema, term.AttrName)); err != nil {
+			return err
+		}
+	}
+	if valType == TypeEnum && (setOps[term.Op] || refOps[term.Op]) {
+		vals := getAttrSchema(schema, term.AttrName).Vals
+		list, ok := termList(term.AttrVal)
+		if !ok {
+			list = []any{term.AttrVal}
+		}
+		for _, elem := range list {
+			if !vals[elem.(string)] {
+				return fmt.Errorf("%v is not a valid value for enum %v", elem, term.AttrName)
+			}
+		}
+	}
+	return nil
+}
+
+// Verifies a term that compares the attribute term.AttrName with the attribute ref.Attr
+func verifyRefTerm(term RulePatternTerm, ref AttrRef, schema RuleSchema) error {
+	for _, name := range []string{term.AttrName, ref.Attr} {
+		if getType(schema, name) == "" {
+			return fmt.Errorf("attribute does not exist in pattern-schema: %v", name)
+		}
+	}
+	if !refOps[term.Op] {
+		return fmt.Errorf("%v cannot compare two attributes: %v and %v", term.Op, term.AttrName, ref.Attr)
+	}
+	valType, refType := getType(schema, term.AttrName), getType(schema, ref.Attr)
+	if valType != refType {
+		return fmt.Errorf("%v (%v) cannot be compared with %v (%v)", term.AttrName, valType, ref.Attr, refType)
+	}
+	if orderedOps[term.Op] && !orderedTypes[valType] {
+		return fmt.Errorf("%v is not an ordered type: %v and %v", valType, term.AttrName, ref.Attr)
+	}
+	if valType == TypeList {
+		return fmt.Errorf("list attributes cannot be compared: %v and %v", term.AttrName, ref.Attr)
+	}
+	currency, refCurrency := getAttrSchema(schema, term.AttrName).Currency, getAttrSchema(schema, ref.Attr).Currency
+	if valType == TypeDecimal && currency != refCurrency {
+		return fmt.Errorf("%v (%v) cannot be compared with %v (%v)", term.AttrName, currency, ref.Attr, refCurrency)
+	}
+	return nil
+}
+
+// Verifies a term on a list attribute, or with one of listOps. The attribute must be a list in the
+// pattern-schema, and the term's op exists, notexists or one of listOps. The value of hasany,
+// hasall and hasnone is a non-empty list of valid elements, and of a count op, an int of at
+// least 0.
+func verifyListTerm(term RulePatternTerm, schema RuleSchema) error {
+	as := getAttrSchema(schema, term.AttrName)
+	if as.ValType != TypeList {
+		return fmt.Errorf("%v applies only to list attributes: %v", term.Op, term.AttrName)
+	}
+	switch {
+	case term.Op == OpExists || term.Op == OpNotExists:
+		if term.AttrVal != nil {
+			return fmt.Errorf("value of this attribute does not match schema type: %v", term.AttrName)
+		}
+		return nil
+	case !listOps[term.Op]:
+		return fmt.Errorf("%v does not apply to list attributes: %v", term.Op, term.AttrName)
+	case !hasOps[term.Op]:
+		if n, ok := term.AttrVal.(int); !ok || n < 0 {
+			return fmt.Errorf("%v needs a count of at least 0 for %v", term.Op, term.AttrName)
+		}
+		return nil
+	}
+	list, ok := termList(term.AttrVal)
+	if !ok || len(list) == 0 {
+		return fmt.Errorf("%v needs a non-empty list of elements for %v", term.Op, term.AttrName)
+	}
+	for _, elem := range list {
+		if !verifyType(elem, as.ElemType) {
+			return fmt.Errorf("%v is not a valid element of %v", elem, term.AttrName)
+		} else if s, _ := elem.(string); as.ElemType == TypeEnum && !as.Vals[s] {
+			return fmt.Errorf("%v is not a valid value for enum %v", elem, term.AttrName)
+		}
+	}
+	return nil
+}
+
+// Checks that the value of a term on the decimal attribute as is in its currency, if it has a
+// currency code, and fits in its precision and scale
+func verifyDecimalLiteral(val any, as AttrSchema) error {
+	d, code, _ := termDecimal(val)
+	if code != "" && code != as.Currency {
+		return fmt.Errorf("%v is not in the currency of %v", val, as.Name)
+	}
+	if !d.fits(as.Precision, as.Scale) {
+		return fmt.Errorf("%v does not fit in the precision and scale of %v: %v, %v", d, as.Name, as.Precision, as.Scale)
+	}
+	return nil
+}
+
+func getAttrSchema(rs RuleSchema, name string) AttrSchema {
+	for _, as := range rs.PatternSchema {
+		if as.Name == name {
+			return as
 		}
 	}
-	return RuleSchema{}, fmt.Errorf("no schema found for class %v", class)
+	return AttrSchema{}
 }
 
 func getType(rs RuleSchema, name string) string {
-	for _, as := range rs.patternSchema {
-		if as.name == name {
-			return as.valType
+	for _, as := range rs.PatternSchema {
+		if as.Name == name {
+			return as.ValType
 		}
 	}
 	return ""
 }
 
+// Returns the value-type of the attribute attrName in a rule-pattern term, or "" if schema
+// does not allow attrName in rule-patterns
+func getTermType(schema RuleSchema, attrName string) string {
+	valType := getType(schema, attrName)
+	if valType == "" {
+		// If the attribute name is not in the pattern-schema, we check if it's a task "tag"
+		// by checking for its presence in the action-schema
+		if isStringInArray(attrName, schema.ActionSchema.Tasks) {
+			// If it is a tag, the value type is bool
+			valType = TypeBool
+		}
+	}
+	return valType
+}
+
 func isStringInArray(s string, arr []string) bool {
 	for _, a := range arr {
 		if a == s {
@@ -225,21 +484,57 @@ func isStringInArray(s string, arr []string) bool {
 	return false
 }
 
+// Returns whether or not val is a valid value for a term with op on an attribute of valType. The
+// value of an in or nin term is a non-empty list of values of valType, which must be in setTypes,
+// and exists and notexists terms have no value. A regular expression in a matches term is checked
+// separately.
+func verifyTermVal(val any, valType string, op string) bool {
+	if op == OpExists || op == OpNotExists {
+		return val == nil
+	}
+	if timeOps[op] {
+		// The value of a within, olderthan or calendar term is checked by compileTimeVal(), and
+		// the attribute must be a ts
+		_, err := compileTimeVal(op, val)
+		return valType == TypeTS && err == nil
+	}
+	if stringOps[op] {
+		// The value of a contains, startswith, endswith or matches term is a string, and the
+		// attribute must be a str
+		return valType == TypeStr && verifyType(val, valType)
+	}
+	if !setOps[op] {
+		return verifyType(val, valType)
+	}
+	list, ok := termList(val)
+	if !ok || len(list) == 0 || !setTypes[valType] {
+		return false
+	}
+	for _, elem := range list {
+		if !verifyType(elem, valType) {
+			return false
+		}
+	}
+	return true
+}
+
 // Returns whether or not the type of "val" is the same as "valType"
 func verifyType(val any, valType string) bool {
 	var ok bool
 	switch valType {
-	case typeBool:
+	case TypeBool:
 		_, ok = val.(bool)
-	case typeInt:
+	case TypeInt:
 		_, ok = val.(int)
-	case typeFloat:
+	case TypeFloat:
 		_, ok = val.(float64)
-	case typeStr, typeEnum:
+	case TypeStr, TypeEnum:
 		_, ok = val.(string)
-	case typeTS:
-		s, _ := val.(string)
-		_, err := time.Parse(timeLayout, s)
+	case TypeTS, TypeDate:
+		_, err := termTime(val, valType)
+		ok = (err == nil)
+	case TypeDecimal:
+		_, _, err := termDecimal(val)
 		ok = (err == nil)
 	}
 	return ok
@@ -248,15 +543,18 @@ func verifyType(val any, valType string) bool {
 func verifyRuleActions(ruleSet RuleSet, schema RuleSchema, isWF bool) (bool, error) {
 	for _, rule := range ruleSet.Rules {
 		for _, t := range rule.RuleActions.Tasks {
-			if !isStringInArray(t, schema.actionSchema.tasks) {
+			if !isStringInArray(t, schema.ActionSchema.Tasks) {
 				return false, fmt.Errorf("task %v not found in action-schema", t)
 			}
 		}
 		for _, p := range rule.RuleActions.Properties {
-			if !isStringInArray(p.Name, schema.actionSchema.properties) {
+			if !isStringInArray(p.Name, schema.ActionSchema.Properties) {
 				return false, fmt.Errorf("property name %v not found in action-schema", p.Name)
 			}
 		}
+		if err := verifyPropertyVals(rule.RuleActions, schema); err != nil {
+			return false, err
+		}
 		if rule.RuleActions.WillReturn && rule.RuleActions.WillExit {
 			return false, fmt.Errorf("there is a rule with both the RETURN and EXIT instructions in ruleset %v", ruleSet.SetName)
 		}
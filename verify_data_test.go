@@ -3,10 +3,11 @@ This file contains TestVerifySchema() and TestVerifyRuleSet(). These two functio
 verifyRuleSchema() and verifyRuleSet() respectively.
 */
 
-package main
+package crux
 
 import (
 	"testing"
+	"time"
 )
 
 const (
@@ -19,7 +20,6 @@ type verifySchemaTest struct {
 	name    string
 	rs      RuleSchema
 	isWF    bool
-	want    bool
 	wantErr bool
 }
 
@@ -52,393 +52,373 @@ func TestVerifySchema(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got, err := verifyRuleSchema(tt.rs, tt.isWF)
+			err := testEngine.VerifyRuleSchema(tt.rs, tt.isWF)
 			if (err != nil) != tt.wantErr {
-				t.Errorf("verifyRuleSchema() error = %v, wantErr %v", err, tt.wantErr)
-				return
-			}
-			if got != tt.want {
-				t.Errorf("verifyRuleSchema() = %v, want %v", got, tt.want)
+				t.Errorf("VerifyRuleSchema() error = %v, wantErr %v", err, tt.wantErr)
 			}
 		})
 	}
 }
 
 func testCorrectBRSchema(tests *[]verifySchemaTest) {
-	rs := RuleSchema{class: transactionClass,
-		patternSchema: []AttrSchema{
-			{name: "productname", valType: typeStr},
-			{name: "price", valType: typeInt},
-			{name: "inwintersale", valType: typeBool},
-			{name: "paymenttype", valType: typeEnum, vals: map[string]bool{"cash": true, "card": true}},
-			{name: "ismember", valType: typeBool},
+	rs := RuleSchema{Class: transactionClass,
+		PatternSchema: []AttrSchema{
+			{Name: "productname", ValType: TypeStr},
+			{Name: "price", ValType: TypeInt},
+			{Name: "inwintersale", ValType: TypeBool},
+			{Name: "paymenttype", ValType: TypeEnum, Vals: map[string]bool{"cash": true, "card": true}},
+			{Name: "ismember", ValType: TypeBool},
 		},
-		actionSchema: ActionSchema{
-			tasks:      []string{"freepen", "freemug", "freebag"},
-			properties: []string{"discount", "pointsmult"},
+		ActionSchema: ActionSchema{
+			Tasks:      []string{"freepen", "freemug", "freebag"},
+			Properties: []string{"discount", "pointsmult"},
 		},
 	}
 	*tests = append(*tests, verifySchemaTest{
 		name:    "correct business-rules schema",
 		rs:      rs,
 		isWF:    false,
-		want:    true,
 		wantErr: false,
 	})
 }
 
 func testSchemaEmptyClass(tests *[]verifySchemaTest) {
-	rs := RuleSchema{class: "",
-		patternSchema: []AttrSchema{
-			{name: "productname", valType: typeStr},
-			{name: "price", valType: typeInt},
-			{name: "inwintersale", valType: typeBool},
-			{name: "paymenttype", valType: typeEnum, vals: map[string]bool{"cash": true, "card": true}},
-			{name: "ismember", valType: typeBool},
+	rs := RuleSchema{Class: "",
+		PatternSchema: []AttrSchema{
+			{Name: "productname", ValType: TypeStr},
+			{Name: "price", ValType: TypeInt},
+			{Name: "inwintersale", ValType: TypeBool},
+			{Name: "paymenttype", ValType: TypeEnum, Vals: map[string]bool{"cash": true, "card": true}},
+			{Name: "ismember", ValType: TypeBool},
 		},
-		actionSchema: ActionSchema{
-			tasks:      []string{"freepen", "freemug", "freebag"},
-			properties: []string{"discount", "pointsmult"},
+		ActionSchema: ActionSchema{
+			Tasks:      []string{"freepen", "freemug", "freebag"},
+			Properties: []string{"discount", "pointsmult"},
 		},
 	}
 	*tests = append(*tests, verifySchemaTest{
 		name:    "schema with empty class",
 		rs:      rs,
 		isWF:    false,
-		want:    false,
 		wantErr: true,
 	})
 }
 
 func testEmptyPatternSchema(tests *[]verifySchemaTest) {
-	rs := RuleSchema{class: transactionClass,
-		patternSchema: []AttrSchema{},
-		actionSchema: ActionSchema{
-			tasks:      []string{"freepen", "freemug", "freebag"},
-			properties: []string{"discount", "pointsmult"},
+	rs := RuleSchema{Class: transactionClass,
+		PatternSchema: []AttrSchema{},
+		ActionSchema: ActionSchema{
+			Tasks:      []string{"freepen", "freemug", "freebag"},
+			Properties: []string{"discount", "pointsmult"},
 		},
 	}
 	*tests = append(*tests, verifySchemaTest{
 		name:    "empty pattern schema",
 		rs:      rs,
 		isWF:    false,
-		want:    false,
 		wantErr: true,
 	})
 }
 
 func testAttrNameIsNotCruxID(tests *[]verifySchemaTest) {
-	rs := RuleSchema{class: transactionClass,
-		patternSchema: []AttrSchema{
+	rs := RuleSchema{Class: transactionClass,
+		PatternSchema: []AttrSchema{
 			// 1productname is not a CruxID
-			{name: "1productname", valType: typeStr},
-			{name: "price", valType: typeInt},
-			{name: "inwintersale", valType: typeBool},
-			{name: "paymenttype", valType: typeEnum, vals: map[string]bool{"cash": true, "card": true}},
-			{name: "ismember", valType: typeBool},
+			{Name: "1productname", ValType: TypeStr},
+			{Name: "price", ValType: TypeInt},
+			{Name: "inwintersale", ValType: TypeBool},
+			{Name: "paymenttype", ValType: TypeEnum, Vals: map[string]bool{"cash": true, "card": true}},
+			{Name: "ismember", ValType: TypeBool},
 		},
-		actionSchema: ActionSchema{
-			tasks:      []string{"freepen", "freemug", "freebag"},
-			properties: []string{"discount", "pointsmult"},
+		ActionSchema: ActionSchema{
+			Tasks:      []string{"freepen", "freemug", "freebag"},
+			Properties: []string{"discount", "pointsmult"},
 		},
 	}
 	*tests = append(*tests, verifySchemaTest{
 		name:    "attr name is not CruxID",
 		rs:      rs,
 		isWF:    false,
-		want:    false,
 		wantErr: true,
 	})
 }
 
 func testInvalidValType(tests *[]verifySchemaTest) {
-	rs := RuleSchema{class: transactionClass,
-		patternSchema: []AttrSchema{
-			{name: "productname", valType: typeStr},
-			{name: "price", valType: typeInt},
+	rs := RuleSchema{Class: transactionClass,
+		PatternSchema: []AttrSchema{
+			{Name: "productname", ValType: TypeStr},
+			{Name: "price", ValType: TypeInt},
 			// "abc" is not a valid valType
-			{name: "inwintersale", valType: "abc"},
-			{name: "paymenttype", valType: typeEnum, vals: map[string]bool{"cash": true, "card": true}},
-			{name: "ismember", valType: typeBool},
+			{Name: "inwintersale", ValType: "abc"},
+			{Name: "paymenttype", ValType: TypeEnum, Vals: map[string]bool{"cash": true, "card": true}},
+			{Name: "ismember", ValType: TypeBool},
 		},
-		actionSchema: ActionSchema{
-			tasks:      []string{"freepen", "freemug", "freebag"},
-			properties: []string{"discount", "pointsmult"},
+		ActionSchema: ActionSchema{
+			Tasks:      []string{"freepen", "freemug", "freebag"},
+			Properties: []string{"discount", "pointsmult"},
 		},
 	}
 	*tests = append(*tests, verifySchemaTest{
 		name:    "invalid value type",
 		rs:      rs,
 		isWF:    false,
-		want:    false,
 		wantErr: true,
 	})
 }
 
 func testNoValsForEnum(tests *[]verifySchemaTest) {
-	rs := RuleSchema{class: transactionClass,
-		patternSchema: []AttrSchema{
-			{name: "productname", valType: typeStr},
-			{name: "price", valType: typeInt},
-			{name: "inwintersale", valType: typeBool},
+	rs := RuleSchema{Class: transactionClass,
+		PatternSchema: []AttrSchema{
+			{Name: "productname", ValType: TypeStr},
+			{Name: "price", ValType: TypeInt},
+			{Name: "inwintersale", ValType: TypeBool},
 			// The "vals" "hash-set" below, which is the set of valid values for the
 			// enum "paymenttype", shold not be empty
-			{name: "paymenttype", valType: typeEnum, vals: map[string]bool{}},
-			{name: "ismember", valType: typeBool},
+			{Name: "paymenttype", ValType: TypeEnum, Vals: map[string]bool{}},
+			{Name: "ismember", ValType: TypeBool},
 		},
-		actionSchema: ActionSchema{
-			tasks:      []string{"freepen", "freemug", "freebag"},
-			properties: []string{"discount", "pointsmult"},
+		ActionSchema: ActionSchema{
+			Tasks:      []string{"freepen", "freemug", "freebag"},
+			Properties: []string{"discount", "pointsmult"},
 		},
 	}
 	*tests = append(*tests, verifySchemaTest{
 		name:    "no vals for enum",
 		rs:      rs,
 		isWF:    false,
-		want:    false,
 		wantErr: true,
 	})
 }
 
 func testEnumValIsNotCruxID(tests *[]verifySchemaTest) {
-	rs := RuleSchema{class: transactionClass,
-		patternSchema: []AttrSchema{
-			{name: "productname", valType: typeStr},
-			{name: "price", valType: typeInt},
-			{name: "inwintersale", valType: typeBool},
+	rs := RuleSchema{Class: transactionClass,
+		PatternSchema: []AttrSchema{
+			{Name: "productname", ValType: TypeStr},
+			{Name: "price", ValType: TypeInt},
+			{Name: "inwintersale", ValType: TypeBool},
 			// 1cash is not a CruxID
-			{name: "paymenttype", valType: typeEnum, vals: map[string]bool{"1cash": true, "card": true}},
-			{name: "ismember", valType: typeBool},
+			{Name: "paymenttype", ValType: TypeEnum, Vals: map[string]bool{"1cash": true, "card": true}},
+			{Name: "ismember", ValType: TypeBool},
 		},
-		actionSchema: ActionSchema{
-			tasks:      []string{"freepen", "freemug", "freebag"},
-			properties: []string{"discount", "pointsmult"},
+		ActionSchema: ActionSchema{
+			Tasks:      []string{"freepen", "freemug", "freebag"},
+			Properties: []string{"discount", "pointsmult"},
 		},
 	}
 	*tests = append(*tests, verifySchemaTest{
 		name:    "enum val is not CruxID",
 		rs:      rs,
 		isWF:    false,
-		want:    false,
 		wantErr: true,
 	})
 }
 
 func testBothTasksAndPropsEmpty(tests *[]verifySchemaTest) {
-	rs := RuleSchema{class: transactionClass,
-		patternSchema: []AttrSchema{
-			{name: "productname", valType: typeStr},
-			{name: "price", valType: typeInt},
-			{name: "inwintersale", valType: typeBool},
-			{name: "paymenttype", valType: typeEnum, vals: map[string]bool{"cash": true, "card": true}},
-			{name: "ismember", valType: typeBool},
+	rs := RuleSchema{Class: transactionClass,
+		PatternSchema: []AttrSchema{
+			{Name: "productname", ValType: TypeStr},
+			{Name: "price", ValType: TypeInt},
+			{Name: "inwintersale", ValType: TypeBool},
+			{Name: "paymenttype", ValType: TypeEnum, Vals: map[string]bool{"cash": true, "card": true}},
+			{Name: "ismember", ValType: TypeBool},
 		},
 		// Both tasks and properties should not be empty
-		actionSchema: ActionSchema{
-			tasks:      []string{},
-			properties: []string{},
+		ActionSchema: ActionSchema{
+			Tasks:      []string{},
+			Properties: []string{},
 		},
 	}
 	*tests = append(*tests, verifySchemaTest{
 		name:    "both tasks and properties empty",
 		rs:      rs,
 		isWF:    false,
-		want:    false,
 		wantErr: true,
 	})
 }
 
 func testTaskIsNotCruxID(tests *[]verifySchemaTest) {
-	rs := RuleSchema{class: transactionClass,
-		patternSchema: []AttrSchema{
-			{name: "productname", valType: typeStr},
-			{name: "price", valType: typeInt},
-			{name: "inwintersale", valType: typeBool},
-			{name: "paymenttype", valType: typeEnum, vals: map[string]bool{"cash": true, "card": true}},
-			{name: "ismember", valType: typeBool},
+	rs := RuleSchema{Class: transactionClass,
+		PatternSchema: []AttrSchema{
+			{Name: "productname", ValType: TypeStr},
+			{Name: "price", ValType: TypeInt},
+			{Name: "inwintersale", ValType: TypeBool},
+			{Name: "paymenttype", ValType: TypeEnum, Vals: map[string]bool{"cash": true, "card": true}},
+			{Name: "ismember", ValType: TypeBool},
 		},
-		actionSchema: ActionSchema{
+		ActionSchema: ActionSchema{
 			// free*mug is not a CruxID
-			tasks:      []string{"freepen", "free*mug", "freebag"},
-			properties: []string{"discount", "pointsmult"},
+			Tasks:      []string{"freepen", "free*mug", "freebag"},
+			Properties: []string{"discount", "pointsmult"},
 		},
 	}
 	*tests = append(*tests, verifySchemaTest{
 		name:    "task is not CruxID",
 		rs:      rs,
 		isWF:    false,
-		want:    false,
 		wantErr: true,
 	})
 }
 
 func testPropNameNotCruxID(tests *[]verifySchemaTest) {
-	rs := RuleSchema{class: transactionClass,
-		patternSchema: []AttrSchema{
-			{name: "productname", valType: typeStr},
-			{name: "price", valType: typeInt},
-			{name: "inwintersale", valType: typeBool},
-			{name: "paymenttype", valType: typeEnum, vals: map[string]bool{"cash": true, "card": true}},
-			{name: "ismember", valType: typeBool},
+	rs := RuleSchema{Class: transactionClass,
+		PatternSchema: []AttrSchema{
+			{Name: "productname", ValType: TypeStr},
+			{Name: "price", ValType: TypeInt},
+			{Name: "inwintersale", ValType: TypeBool},
+			{Name: "paymenttype", ValType: TypeEnum, Vals: map[string]bool{"cash": true, "card": true}},
+			{Name: "ismember", ValType: TypeBool},
 		},
-		actionSchema: ActionSchema{
-			tasks: []string{"freepen", "freemug", "freebag"},
+		ActionSchema: ActionSchema{
+			Tasks: []string{"freepen", "freemug", "freebag"},
 			// Discount is not a CruxID
-			properties: []string{"Discount", "pointsmult"},
+			Properties: []string{"Discount", "pointsmult"},
 		},
 	}
 	*tests = append(*tests, verifySchemaTest{
 		name:    "property name is not CruxID",
 		rs:      rs,
 		isWF:    false,
-		want:    false,
 		wantErr: true,
 	})
 }
 
 func testCorrectWFSchema(tests *[]verifySchemaTest) {
 	rs := RuleSchema{
-		class: uccCreationClass,
-		patternSchema: []AttrSchema{
-			{name: step, valType: typeEnum,
-				vals: map[string]bool{start: true, "getcustdetails": true, "aof": true, "sendauthlinktoclient": true},
+		Class: uccCreationClass,
+		PatternSchema: []AttrSchema{
+			{Name: step, ValType: TypeEnum,
+				Vals: map[string]bool{start: true, "getcustdetails": true, "aof": true, "sendauthlinktoclient": true},
 			},
-			{name: stepFailed, valType: typeBool},
-			{name: "mode", valType: typeEnum, vals: map[string]bool{"physical": true, "demat": true}},
+			{Name: stepFailed, ValType: TypeBool},
+			{Name: "mode", ValType: TypeEnum, Vals: map[string]bool{"physical": true, "demat": true}},
 		},
-		actionSchema: ActionSchema{
-			tasks:      []string{"getcustdetails", "aof", "sendauthlinktoclient"},
-			properties: []string{nextStep, done},
+		ActionSchema: ActionSchema{
+			Tasks:      []string{"getcustdetails", "aof", "sendauthlinktoclient"},
+			Properties: []string{nextStep, done},
 		},
 	}
 	*tests = append(*tests, verifySchemaTest{
 		name:    "correct workflow schema",
 		rs:      rs,
 		isWF:    true,
-		want:    true,
 		wantErr: false,
 	})
 }
 
 func testMissingStart(tests *[]verifySchemaTest) {
 	rs := RuleSchema{
-		class: uccCreationClass,
-		patternSchema: []AttrSchema{
+		Class: uccCreationClass,
+		PatternSchema: []AttrSchema{
 			// vals below should also contain '"START": true'
-			{name: step, valType: typeEnum,
-				vals: map[string]bool{"getcustdetails": true, "aof": true, "sendauthlinktoclient": true},
+			{Name: step, ValType: TypeEnum,
+				Vals: map[string]bool{"getcustdetails": true, "aof": true, "sendauthlinktoclient": true},
 			},
-			{name: stepFailed, valType: typeBool},
-			{name: "mode", valType: typeEnum, vals: map[string]bool{"physical": true, "demat": true}},
+			{Name: stepFailed, ValType: TypeBool},
+			{Name: "mode", ValType: TypeEnum, Vals: map[string]bool{"physical": true, "demat": true}},
 		},
-		actionSchema: ActionSchema{
-			tasks:      []string{"getcustdetails", "aof", "sendauthlinktoclient"},
-			properties: []string{nextStep, done},
+		ActionSchema: ActionSchema{
+			Tasks:      []string{"getcustdetails", "aof", "sendauthlinktoclient"},
+			Properties: []string{nextStep, done},
 		},
 	}
 	*tests = append(*tests, verifySchemaTest{
 		name:    "missing START",
 		rs:      rs,
 		isWF:    true,
-		want:    false,
 		wantErr: true,
 	})
 }
 
 func testMissingStep(tests *[]verifySchemaTest) {
 	rs := RuleSchema{
-		class: uccCreationClass,
-		patternSchema: []AttrSchema{
+		Class: uccCreationClass,
+		PatternSchema: []AttrSchema{
 			// there should be a "step" attribute-schema here
-			{name: stepFailed, valType: typeBool},
-			{name: "mode", valType: typeEnum, vals: map[string]bool{"physical": true, "demat": true}},
+			{Name: stepFailed, ValType: TypeBool},
+			{Name: "mode", ValType: TypeEnum, Vals: map[string]bool{"physical": true, "demat": true}},
 		},
-		actionSchema: ActionSchema{
-			tasks:      []string{"getcustdetails", "aof", "sendauthlinktoclient"},
-			properties: []string{nextStep, done},
+		ActionSchema: ActionSchema{
+			Tasks:      []string{"getcustdetails", "aof", "sendauthlinktoclient"},
+			Properties: []string{nextStep, done},
 		},
 	}
 	*tests = append(*tests, verifySchemaTest{
 		name:    "missing step",
 		rs:      rs,
 		isWF:    true,
-		want:    false,
 		wantErr: true,
 	})
 }
 
 func testAdditionalProps(tests *[]verifySchemaTest) {
 	rs := RuleSchema{
-		class: uccCreationClass,
-		patternSchema: []AttrSchema{
-			{name: step, valType: typeEnum,
-				vals: map[string]bool{start: true, "getcustdetails": true, "aof": true, "sendauthlinktoclient": true},
+		Class: uccCreationClass,
+		PatternSchema: []AttrSchema{
+			{Name: step, ValType: TypeEnum,
+				Vals: map[string]bool{start: true, "getcustdetails": true, "aof": true, "sendauthlinktoclient": true},
 			},
-			{name: stepFailed, valType: typeBool},
-			{name: "mode", valType: typeEnum, vals: map[string]bool{"physical": true, "demat": true}},
+			{Name: stepFailed, ValType: TypeBool},
+			{Name: "mode", ValType: TypeEnum, Vals: map[string]bool{"physical": true, "demat": true}},
 		},
-		actionSchema: ActionSchema{
-			tasks: []string{"getcustdetails", "aof", "sendauthlinktoclient"},
+		ActionSchema: ActionSchema{
+			Tasks: []string{"getcustdetails", "aof", "sendauthlinktoclient"},
 			// "abcd" should not be in properties
-			properties: []string{nextStep, done, "abcd"},
+			Properties: []string{nextStep, done, "abcd"},
 		},
 	}
 	*tests = append(*tests, verifySchemaTest{
 		name:    "additional property other than nextstep and done",
 		rs:      rs,
 		isWF:    true,
-		want:    false,
 		wantErr: true,
 	})
 }
 
 func testMissingNextStep(tests *[]verifySchemaTest) {
 	rs := RuleSchema{
-		class: uccCreationClass,
-		patternSchema: []AttrSchema{
-			{name: step, valType: typeEnum,
-				vals: map[string]bool{start: true, "getcustdetails": true, "aof": true, "sendauthlinktoclient": true},
+		Class: uccCreationClass,
+		PatternSchema: []AttrSchema{
+			{Name: step, ValType: TypeEnum,
+				Vals: map[string]bool{start: true, "getcustdetails": true, "aof": true, "sendauthlinktoclient": true},
 			},
-			{name: stepFailed, valType: typeBool},
-			{name: "mode", valType: typeEnum, vals: map[string]bool{"physical": true, "demat": true}},
+			{Name: stepFailed, ValType: TypeBool},
+			{Name: "mode", ValType: TypeEnum, Vals: map[string]bool{"physical": true, "demat": true}},
 		},
-		actionSchema: ActionSchema{
-			tasks: []string{"getcustdetails", "aof", "sendauthlinktoclient"},
+		ActionSchema: ActionSchema{
+			Tasks: []string{"getcustdetails", "aof", "sendauthlinktoclient"},
 			// properties should contain "nextstep" (and should not contain "abcd")
-			properties: []string{done, "abcd"},
+			Properties: []string{done, "abcd"},
 		},
 	}
 	*tests = append(*tests, verifySchemaTest{
 		name:    "missing nextstep",
 		rs:      rs,
 		isWF:    true,
-		want:    false,
 		wantErr: true,
 	})
 }
 
 func testTasksAndStepDiscrepancy(tests *[]verifySchemaTest) {
 	rs := RuleSchema{
-		class: uccCreationClass,
-		patternSchema: []AttrSchema{
-			{name: step, valType: typeEnum,
+		Class: uccCreationClass,
+		PatternSchema: []AttrSchema{
+			{Name: step, ValType: TypeEnum,
 				// "vals" should have exactly the same strings as "tasks" below, except "start" which is only in "vals"
-				vals: map[string]bool{start: true, "getcustdetails": true, "aof": true, "sendauthlinktoclient": true},
+				Vals: map[string]bool{start: true, "getcustdetails": true, "aof": true, "sendauthlinktoclient": true},
 			},
-			{name: stepFailed, valType: typeBool},
-			{name: "mode", valType: typeEnum, vals: map[string]bool{"physical": true, "demat": true}},
+			{Name: stepFailed, ValType: TypeBool},
+			{Name: "mode", ValType: TypeEnum, Vals: map[string]bool{"physical": true, "demat": true}},
 		},
-		actionSchema: ActionSchema{
+		ActionSchema: ActionSchema{
 			// "tasks" should have exactly the same strings as "vals" above, except for "start"
-			tasks:      []string{"getcustinfo", "aof", "sendauthlinktoclient"},
-			properties: []string{nextStep, done},
+			Tasks:      []string{"getcustinfo", "aof", "sendauthlinktoclient"},
+			Properties: []string{nextStep, done},
 		},
 	}
 	*tests = append(*tests, verifySchemaTest{
 		name:    "tasks and steps discrepancy",
 		rs:      rs,
 		isWF:    true,
-		want:    false,
 		wantErr: true,
 	})
 }
@@ -472,8 +452,8 @@ func TestVerifyRuleSet(t *testing.T) {
 }
 
 func testCorrectRS(t *testing.T) {
-	ok, err := verifyRuleSet(ruleSets[mainRS], false)
-	if !ok || err != nil {
+	err := testEngine.VerifyRuleSet(testRuleSet(mainRS), false)
+	if err != nil {
 		t.Errorf(incorrectOutputRSMsg + "no issues")
 	}
 }
@@ -482,60 +462,60 @@ func testCorrectRS(t *testing.T) {
 // After each test, we must reset the rule-pattern to the correct one below before
 // moving on to the next test.
 var correctRP = []RulePatternTerm{
-	{"product", opEQ, "jacket"},
-	{"price", opGT, 50.0},
+	{"product", OpEQ, "jacket"},
+	{"price", OpGT, 50.0},
 }
 
 func testInvalidAttrName(t *testing.T) {
-	ruleSets[mainRS].Rules[1].RulePattern = []RulePatternTerm{
-		{"product", opEQ, "jacket"},
+	testRuleSet(mainRS).Rules[1].RulePattern = []RulePatternTerm{
+		{"product", OpEQ, "jacket"},
 		// priceabc is not in the schema
-		{"priceabc", opGT, 50.0},
+		{"priceabc", OpGT, 50.0},
 	}
-	ok, err := verifyRuleSet(ruleSets[mainRS], false)
-	if ok || err == nil {
+	err := testEngine.VerifyRuleSet(testRuleSet(mainRS), false)
+	if err == nil {
 		t.Errorf(incorrectOutputRSMsg + "invalid attr name")
 	}
-	ruleSets[mainRS].Rules[1].RulePattern = correctRP
+	testRuleSet(mainRS).Rules[1].RulePattern = correctRP
 }
 
 func testTaskAsAttrName(t *testing.T) {
-	ruleSets[mainRS].Rules[1].RulePattern = []RulePatternTerm{
-		{"product", opEQ, "jacket"},
+	testRuleSet(mainRS).Rules[1].RulePattern = []RulePatternTerm{
+		{"product", OpEQ, "jacket"},
 		// freejar is not in the pattern-schema, but it is a task in the action-schema
-		{"freejar", opEQ, true},
+		{"freejar", OpEQ, true},
 	}
-	ok, err := verifyRuleSet(ruleSets[mainRS], false)
-	if !ok || err != nil {
+	err := testEngine.VerifyRuleSet(testRuleSet(mainRS), false)
+	if err != nil {
 		t.Errorf(incorrectOutputRSMsg + "a task 'tag' as an attribute name")
 	}
-	ruleSets[mainRS].Rules[1].RulePattern = correctRP
+	testRuleSet(mainRS).Rules[1].RulePattern = correctRP
 }
 
 func testWrongAttrValType(t *testing.T) {
-	ruleSets[mainRS].Rules[1].RulePattern = []RulePatternTerm{
-		{"product", opEQ, "jacket"},
+	testRuleSet(mainRS).Rules[1].RulePattern = []RulePatternTerm{
+		{"product", OpEQ, "jacket"},
 		// price should be a float, not a string
-		{"price", opGT, "abc"},
+		{"price", OpGT, "abc"},
 	}
-	ok, err := verifyRuleSet(ruleSets[mainRS], false)
-	if ok || err == nil {
+	err := testEngine.VerifyRuleSet(testRuleSet(mainRS), false)
+	if err == nil {
 		t.Errorf(incorrectOutputRSMsg + "wrong attribute value type")
 	}
-	ruleSets[mainRS].Rules[1].RulePattern = correctRP
+	testRuleSet(mainRS).Rules[1].RulePattern = correctRP
 }
 
 func testInvalidOp(t *testing.T) {
-	ruleSets[mainRS].Rules[1].RulePattern = []RulePatternTerm{
-		{"product", opEQ, "jacket"},
-		// it should be "gt" (opGT), not "greater than"
+	testRuleSet(mainRS).Rules[1].RulePattern = []RulePatternTerm{
+		{"product", OpEQ, "jacket"},
+		// it should be "gt" (OpGT), not "greater than"
 		{"price", "greater than", 50.0},
 	}
-	ok, err := verifyRuleSet(ruleSets[mainRS], false)
-	if ok || err == nil {
+	err := testEngine.VerifyRuleSet(testRuleSet(mainRS), false)
+	if err == nil {
 		t.Errorf(incorrectOutputRSMsg + "invalid operation")
 	}
-	ruleSets[mainRS].Rules[1].RulePattern = correctRP
+	testRuleSet(mainRS).Rules[1].RulePattern = correctRP
 }
 
 // In each of the rule-action tests below, a rule-action is modified temporarily.
@@ -547,68 +527,68 @@ var correctRA RuleActions = RuleActions{
 }
 
 func testTaskNotInSchema(t *testing.T) {
-	ruleSets[mainRS].Rules[3].RuleActions = RuleActions{
+	testRuleSet(mainRS).Rules[3].RuleActions = RuleActions{
 		// freeeraser is not in the schema
 		Tasks:      []string{"freemug", "freeeraser"},
 		Properties: []Property{{"discount", "20"}},
 	}
-	ok, err := verifyRuleSet(ruleSets[mainRS], false)
-	if ok || err == nil {
+	err := testEngine.VerifyRuleSet(testRuleSet(mainRS), false)
+	if err == nil {
 		t.Errorf(incorrectOutputRSMsg + "task not in schema")
 	}
-	ruleSets[mainRS].Rules[3].RuleActions = correctRA
+	testRuleSet(mainRS).Rules[3].RuleActions = correctRA
 }
 
 func testPropNameNotInSchema(t *testing.T) {
-	ruleSets[mainRS].Rules[3].RuleActions = RuleActions{
+	testRuleSet(mainRS).Rules[3].RuleActions = RuleActions{
 		Tasks: []string{"freemug", "freejar", "freeplant"},
 		// cashback is not a property in the action-schema
 		Properties: []Property{{"cashback", "5"}},
 	}
-	ok, err := verifyRuleSet(ruleSets[mainRS], false)
-	if ok || err == nil {
+	err := testEngine.VerifyRuleSet(testRuleSet(mainRS), false)
+	if err == nil {
 		t.Errorf(incorrectOutputRSMsg + "property name not in schema")
 	}
-	ruleSets[mainRS].Rules[3].RuleActions = correctRA
+	testRuleSet(mainRS).Rules[3].RuleActions = correctRA
 }
 
 func testBothReturnAndExit(t *testing.T) {
-	ruleSets[mainRS].Rules[3].RuleActions = RuleActions{
+	testRuleSet(mainRS).Rules[3].RuleActions = RuleActions{
 		Tasks:      []string{"freemug", "freejar", "freeplant"},
 		Properties: []Property{{"discount", "20"}},
 		// both WillReturn and WillExit below should not be true
 		WillReturn: true,
 		WillExit:   true,
 	}
-	ok, err := verifyRuleSet(ruleSets[mainRS], false)
-	if ok || err == nil {
+	err := testEngine.VerifyRuleSet(testRuleSet(mainRS), false)
+	if err == nil {
 		t.Errorf(incorrectOutputRSMsg + "both RETURN and EXIT instructions")
 	}
-	ruleSets[mainRS].Rules[3].RuleActions = correctRA
+	testRuleSet(mainRS).Rules[3].RuleActions = correctRA
 }
 
 func testCorrectWF(t *testing.T) {
-	ok, err := verifyRuleSet(ruleSets[uccCreation], true)
-	if !ok || err != nil {
+	err := testEngine.VerifyRuleSet(testRuleSet(uccCreation), true)
+	if err != nil {
 		t.Errorf(incorrectOutputWFMsg + "no issues")
 	}
 }
 
 func testWFRuleMissingStep(t *testing.T) {
-	ruleSets[uccCreation].Rules[1].RulePattern = []RulePatternTerm{
+	testRuleSet(uccCreation).Rules[1].RulePattern = []RulePatternTerm{
 		// there should be a "step" attribute here
-		{stepFailed, opEQ, false},
-		{"mode", opEQ, "physical"},
+		{stepFailed, OpEQ, false},
+		{"mode", OpEQ, "physical"},
 	}
-	ok, err := verifyRuleSet(ruleSets[uccCreation], true)
-	if ok || err == nil {
+	err := testEngine.VerifyRuleSet(testRuleSet(uccCreation), true)
+	if err == nil {
 		t.Errorf(incorrectOutputWFMsg + "a rule missing 'step'")
 	}
 	// Reset to original correct rule-pattern
-	ruleSets[uccCreation].Rules[1].RulePattern = []RulePatternTerm{
-		{step, opEQ, "getcustdetails"},
-		{stepFailed, opEQ, false},
-		{"mode", opEQ, "physical"},
+	testRuleSet(uccCreation).Rules[1].RulePattern = []RulePatternTerm{
+		{step, OpEQ, "getcustdetails"},
+		{stepFailed, OpEQ, false},
+		{"mode", OpEQ, "physical"},
 	}
 }
 
@@ -621,40 +601,381 @@ var correctWorkflowRA RuleActions = RuleActions{
 }
 
 func testWFRuleMissingBothNSAndDone(t *testing.T) {
-	ruleSets[uccCreation].Rules[1].RuleActions = RuleActions{
+	testRuleSet(uccCreation).Rules[1].RuleActions = RuleActions{
 		Tasks: []string{"aof", "kycvalid", "nomauth", "bankaccvalid"},
 		// Properties below should contain at least one of "nextstep" and "done"
 		Properties: []Property{},
 	}
-	ok, err := verifyRuleSet(ruleSets[uccCreation], true)
-	if ok || err == nil {
+	err := testEngine.VerifyRuleSet(testRuleSet(uccCreation), true)
+	if err == nil {
 		t.Errorf(incorrectOutputWFMsg + "a rule missing both 'nextstep' and 'done'")
 	}
-	ruleSets[uccCreation].Rules[1].RuleActions = correctWorkflowRA
+	testRuleSet(uccCreation).Rules[1].RuleActions = correctWorkflowRA
 }
 
 func testWFNoTasksAndNotDone(t *testing.T) {
-	ruleSets[uccCreation].Rules[1].RuleActions = RuleActions{
+	testRuleSet(uccCreation).Rules[1].RuleActions = RuleActions{
 		// Either Tasks below should not be empty, or Properties below should contain {"done", "true"}
 		Tasks:      []string{},
 		Properties: []Property{{nextStep, "abc"}},
 	}
-	ok, err := verifyRuleSet(ruleSets[uccCreation], true)
-	if ok || err == nil {
+	err := testEngine.VerifyRuleSet(testRuleSet(uccCreation), true)
+	if err == nil {
 		t.Errorf(incorrectOutputWFMsg + "a rule with no tasks and no 'done=true'")
 	}
-	ruleSets[uccCreation].Rules[1].RuleActions = correctWorkflowRA
+	testRuleSet(uccCreation).Rules[1].RuleActions = correctWorkflowRA
 }
 
 func testWFNextStepValNotInTasks(t *testing.T) {
-	ruleSets[uccCreation].Rules[1].RuleActions = RuleActions{
+	testRuleSet(uccCreation).Rules[1].RuleActions = RuleActions{
 		Tasks: []string{"aof", "kycvalid", "nomauth", "bankaccvalid"},
 		// "abcd" below is not in "Tasks" above
 		Properties: []Property{{nextStep, "abcd"}},
 	}
-	ok, err := verifyRuleSet(ruleSets[uccCreation], true)
-	if ok || err == nil {
+	err := testEngine.VerifyRuleSet(testRuleSet(uccCreation), true)
+	if err == nil {
 		t.Errorf(incorrectOutputWFMsg + "a 'nextstep' value not in its rule's 'tasks'")
 	}
-	ruleSets[uccCreation].Rules[1].RuleActions = correctWorkflowRA
+	testRuleSet(uccCreation).Rules[1].RuleActions = correctWorkflowRA
+}
+
+type verifyTermTest struct {
+	name    string
+	term    RulePatternTerm
+	wantErr bool
+}
+
+// Verifies a ruleset of one rule whose pattern is each test's term, against the transaction schema
+func runVerifyTermTests(t *testing.T, tests []verifyTermTest) {
+	runVerifyTermTestsWith(t, testTransactionSchema(), tests)
+}
+
+// Verifies a ruleset of one rule whose pattern is each test's term, against schema, which must be
+// for the transaction class
+func runVerifyTermTestsWith(t *testing.T, schema RuleSchema, tests []verifyTermTest) {
+	e := NewEngine()
+	e.AddRuleSchema(schema)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rs := RuleSet{Ver: 1, Class: transactionClass, SetName: "terms", Rules: []Rule{{
+				[]RulePatternTerm{tt.term}, RuleActions{Tasks: []string{"freepen"}},
+			}}}
+			err := e.VerifyRuleSet(rs, false)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("VerifyRuleSet() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestVerifySetOps(t *testing.T) {
+	runVerifyTermTests(t, []verifyTermTest{
+		{"enum in", RulePatternTerm{"paymenttype", OpIn, []string{"cash", "card"}}, false},
+		{"int nin", RulePatternTerm{"price", OpNIn, []any{10, 20}}, false},
+		{"str in", RulePatternTerm{"productname", OpIn, []string{"jacket"}}, false},
+		{"enum value not in vals", RulePatternTerm{"paymenttype", OpIn, []string{"cash", "cheque"}}, true},
+		{"empty list", RulePatternTerm{"price", OpIn, []int{}}, true},
+		{"not a list", RulePatternTerm{"price", OpIn, 10}, true},
+		{"wrong element type", RulePatternTerm{"price", OpIn, []any{10, "20"}}, true},
+		{"bool attribute", RulePatternTerm{"ismember", OpIn, []bool{true}}, true},
+		{"list for eq", RulePatternTerm{"price", OpEQ, []int{10}}, true},
+	})
+}
+
+func TestVerifyEnumVals(t *testing.T) {
+	runVerifyTermTests(t, []verifyTermTest{
+		{"eq", RulePatternTerm{"paymenttype", OpEQ, "cash"}, false},
+		{"ne", RulePatternTerm{"paymenttype", OpNE, "card"}, false},
+		{"eq not in vals", RulePatternTerm{"paymenttype", OpEQ, "cheque"}, true},
+		{"ne not in vals", RulePatternTerm{"paymenttype", OpNE, "Cash"}, true},
+		{"in group", RulePatternTerm{"", OpNot, []RulePatternTerm{{"paymenttype", OpEQ, "upi"}}}, true},
+	})
+}
+
+func TestVerifyStringOps(t *testing.T) {
+	runVerifyTermTests(t, []verifyTermTest{
+		{"contains", RulePatternTerm{"productname", OpContains, "jack"}, false},
+		{"matches", RulePatternTerm{"productname", OpMatches, `^(jacket|coat)s?$`}, false},
+		{"invalid regular expression", RulePatternTerm{"productname", OpMatches, `^(jacket`}, true},
+		{"startswith on int", RulePatternTerm{"price", OpStartsWith, "1"}, true},
+		{"endswith on enum", RulePatternTerm{"paymenttype", OpEndsWith, "sh"}, true},
+		{"non-string value", RulePatternTerm{"productname", OpContains, 5}, true},
+	})
+}
+
+func TestVerifyExistsOps(t *testing.T) {
+	runVerifyTermTests(t, []verifyTermTest{
+		{"exists", RulePatternTerm{"price", OpExists, nil}, false},
+		{"notexists", RulePatternTerm{"productname", OpNotExists, nil}, false},
+		{"exists with a value", RulePatternTerm{"price", OpExists, 5}, true},
+		{"exists on a task", RulePatternTerm{"freepen", OpExists, nil}, true},
+	})
+}
+
+func TestVerifyMissingAttrs(t *testing.T) {
+	e := NewEngine()
+	e.AddRuleSchema(testTransactionSchema())
+	for _, missing := range []string{MissingDefault, MissingUnknown, MissingNoMatch, MissingError, "maybe"} {
+		rs := RuleSet{Ver: 1, Class: transactionClass, SetName: "terms", Rules: []Rule{{
+			[]RulePatternTerm{{"price", OpGT, 5}}, RuleActions{Tasks: []string{"freepen"}},
+		}}, MissingAttrs: missing}
+		if err := e.VerifyRuleSet(rs, false); (err != nil) != (missing == "maybe") {
+			t.Errorf("VerifyRuleSet() with policy %q error = %v", missing, err)
+		}
+	}
+}
+
+func TestVerifyGroups(t *testing.T) {
+	price := RulePatternTerm{"price", OpGT, 5}
+	runVerifyTermTests(t, []verifyTermTest{
+		{"any", RulePatternTerm{Op: OpAny, AttrVal: []RulePatternTerm{price, {"ismember", OpEQ, true}}}, false},
+		{"nested", RulePatternTerm{Op: OpNot, AttrVal: []RulePatternTerm{{Op: OpAll, AttrVal: []RulePatternTerm{price}}}}, false},
+		{"empty group", RulePatternTerm{Op: OpAll, AttrVal: []RulePatternTerm{}}, true},
+		{"values for group", RulePatternTerm{Op: OpAny, AttrVal: []int{5}}, true},
+		{"group with attribute", RulePatternTerm{"price", OpNot, []RulePatternTerm{price}}, true},
+		{"invalid term in group", RulePatternTerm{Op: OpAny, AttrVal: []RulePatternTerm{price, {"price", OpGT, "5"}}}, true},
+	})
+}
+
+func TestVerifyAttrRefs(t *testing.T) {
+	schema := testTransactionSchema()
+	schema.PatternSchema = append(schema.PatternSchema, AttrSchema{Name: "listprice", ValType: TypeInt},
+		AttrSchema{Name: "payback", ValType: TypeEnum, Vals: map[string]bool{"cash": true}})
+	runVerifyTermTestsWith(t, schema, []verifyTermTest{
+		{"int lt int", RulePatternTerm{"price", OpLT, AttrRef{"listprice"}}, false},
+		{"enum ne enum", RulePatternTerm{"paymenttype", OpNE, AttrRef{"payback"}}, false},
+		{"unknown attribute", RulePatternTerm{"price", OpLT, AttrRef{"cost"}}, true},
+		{"task", RulePatternTerm{"freepen", OpEQ, AttrRef{"ismember"}}, true},
+		{"different types", RulePatternTerm{"price", OpEQ, AttrRef{"productname"}}, true},
+		{"ordered op on enums", RulePatternTerm{"paymenttype", OpGT, AttrRef{"payback"}}, true},
+		{"string op", RulePatternTerm{"productname", OpContains, AttrRef{"productname"}}, true},
+	})
+}
+
+func TestVerifyExprs(t *testing.T) {
+	schema := testTransactionSchema()
+	schema.PatternSchema = append(schema.PatternSchema, AttrSchema{Name: "weight", ValType: TypeFloat},
+		AttrSchema{Name: "shipped", ValType: TypeTS})
+	runVerifyTermTestsWith(t, schema, []verifyTermTest{
+		{"int and float", RulePatternTerm{"price", OpGT, Expr{"weight * 2"}}, false},
+		{"two expressions", RulePatternTerm{"", OpGE, Arith{"price * 3", "weight + 1"}}, false},
+		{"ts plus duration", RulePatternTerm{"shipped", OpLT, Expr{"paid + days(2)"}}, false},
+		{"duration", RulePatternTerm{"", OpLE, Arith{"shipped - paid", "hours(price)"}}, false},
+		{"syntax error", RulePatternTerm{"price", OpGT, Expr{"weight *"}}, true},
+		{"unknown attribute", RulePatternTerm{"price", OpGT, Expr{"cost + 1"}}, true},
+		{"str attribute", RulePatternTerm{"price", OpGT, Expr{"productname + 1"}}, true},
+		{"bool term attribute", RulePatternTerm{"ismember", OpEQ, Expr{"price + 1"}}, true},
+		{"task", RulePatternTerm{"price", OpGT, Expr{"freepen + 1"}}, true},
+		{"ts plus number", RulePatternTerm{"shipped", OpLT, Expr{"paid + 2"}}, true},
+		{"ts times number", RulePatternTerm{"", OpLT, Arith{"paid * 2", "shipped"}}, true},
+		{"ts with number", RulePatternTerm{"shipped", OpGT, Expr{"price + 1"}}, true},
+		{"duration with number", RulePatternTerm{"", OpGT, Arith{"shipped - paid", "2"}}, true},
+		{"days of ts", RulePatternTerm{"", OpGT, Arith{"days(paid)", "days(1)"}}, true},
+		{"string op", RulePatternTerm{"price", OpContains, Expr{"weight + 1"}}, true},
+		{"arith with attribute name", RulePatternTerm{"price", OpGT, Arith{"price", "1"}}, true},
+	})
+}
+
+func TestVerifyTimeOps(t *testing.T) {
+	runVerifyTermTests(t, []verifyTermTest{
+		{"within days", RulePatternTerm{"paid", OpWithin, "30d"}, false},
+		{"olderthan days and hours", RulePatternTerm{"paid", OpOlderThan, "1d12h"}, false},
+		{"within time.Duration", RulePatternTerm{"paid", OpWithin, time.Hour}, false},
+		{"dayofweek", RulePatternTerm{"paid", OpDayOfWeek, []string{"sat", "sun"}}, false},
+		{"month", RulePatternTerm{"paid", OpMonth, []any{"dec"}}, false},
+		{"hourin", RulePatternTerm{"paid", OpHourIn, []int{22, 6}}, false},
+		{"bad duration", RulePatternTerm{"paid", OpWithin, "a month"}, true},
+		{"zero duration", RulePatternTerm{"paid", OpWithin, "0d"}, true},
+		{"negative duration", RulePatternTerm{"paid", OpOlderThan, -time.Hour}, true},
+		{"number for duration", RulePatternTerm{"paid", OpOlderThan, 30}, true},
+		{"unknown day", RulePatternTerm{"paid", OpDayOfWeek, []string{"sat", "sunday"}}, true},
+		{"month for day", RulePatternTerm{"paid", OpDayOfWeek, []string{"jan"}}, true},
+		{"empty list", RulePatternTerm{"paid", OpMonth, []string{}}, true},
+		{"one hour", RulePatternTerm{"paid", OpHourIn, []int{9}}, true},
+		{"hour out of range", RulePatternTerm{"paid", OpHourIn, []int{9, 25}}, true},
+		{"empty window", RulePatternTerm{"paid", OpHourIn, []int{9, 9}}, true},
+		{"int attribute", RulePatternTerm{"price", OpWithin, "30d"}, true},
+		{"task", RulePatternTerm{"freepen", OpMonth, []string{"jan"}}, true},
+	})
+}
+
+func TestVerifyTimeFormats(t *testing.T) {
+	schema := testTransactionSchema()
+	schema.PatternSchema = append(schema.PatternSchema, AttrSchema{Name: "dob", ValType: TypeDate})
+	runVerifyTermTestsWith(t, schema, []verifyTermTest{
+		{"ts with offset", RulePatternTerm{"paid", OpGT, "2024-03-15T15:30:00.5+05:30"}, false},
+		{"date for ts", RulePatternTerm{"paid", OpLT, "2024-03-15"}, false},
+		{"date", RulePatternTerm{"dob", OpLT, "2000-01-01"}, false},
+		{"time.Time for date", RulePatternTerm{"dob", OpEQ, time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)}, false},
+		{"dates compared", RulePatternTerm{"dob", OpLT, AttrRef{"dob"}}, false},
+		{"bad ts", RulePatternTerm{"paid", OpGT, "15/03/2024"}, true},
+		{"bad date", RulePatternTerm{"dob", OpEQ, "2000-02-30"}, true},
+		{"ts compared with date", RulePatternTerm{"paid", OpLT, AttrRef{"dob"}}, true},
+	})
+
+	tests := []struct {
+		name    string
+		as      AttrSchema
+		wantErr bool
+	}{
+		{"layouts and tz", AttrSchema{Name: "shipped", ValType: TypeTS, Layouts: []string{"02/01/2006 15:04"}, TZ: "Asia/Kolkata"}, false},
+		{"date in tz", AttrSchema{Name: "shipped", ValType: TypeDate, TZ: "America/New_York"}, false},
+		{"unknown tz", AttrSchema{Name: "shipped", ValType: TypeTS, TZ: "Mars/Olympus_Mons"}, true},
+		{"empty layout", AttrSchema{Name: "shipped", ValType: TypeTS, Layouts: []string{""}}, true},
+		{"layouts on int", AttrSchema{Name: "shipped", ValType: TypeInt, Layouts: []string{time.RFC3339}}, true},
+		{"tz on str", AttrSchema{Name: "shipped", ValType: TypeStr, TZ: "UTC"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			schema := testTransactionSchema()
+			schema.PatternSchema = append(schema.PatternSchema, tt.as)
+			if err := testEngine.VerifyRuleSchema(schema, false); (err != nil) != tt.wantErr {
+				t.Errorf("VerifyRuleSchema() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestVerifyDecimals(t *testing.T) {
+	schema := testTransactionSchema()
+	schema.PatternSchema = append(schema.PatternSchema,
+		AttrSchema{Name: "amount", ValType: TypeDecimal, Currency: "INR", Precision: 10, Scale: 2},
+		AttrSchema{Name: "refund", ValType: TypeDecimal, Currency: "INR"},
+		AttrSchema{Name: "fee", ValType: TypeDecimal, Currency: "USD"},
+		AttrSchema{Name: "rate", ValType: TypeDecimal})
+	amount := func(s string) Decimal {
+		d, _ := ParseDecimal(s)
+		return d
+	}
+	runVerifyTermTestsWith(t, schema, []verifyTermTest{
+		{"decimal", RulePatternTerm{"amount", OpGT, amount("12345678.99")}, false},
+		{"string", RulePatternTerm{"amount", OpLT, "50.80"}, false},
+		{"currency", RulePatternTerm{"amount", OpEQ, "INR 50.80"}, false},
+		{"trailing zeros", RulePatternTerm{"amount", OpEQ, "1.5000"}, false},
+		{"no limits", RulePatternTerm{"rate", OpEQ, "0.000001"}, false},
+		{"same currency", RulePatternTerm{"amount", OpLE, AttrRef{"refund"}}, false},
+		{"exists", RulePatternTerm{"amount", OpExists, nil}, false},
+		{"float", RulePatternTerm{"amount", OpEQ, 50.8}, true},
+		{"bad string", RulePatternTerm{"amount", OpEQ, "fifty"}, true},
+		{"other currency", RulePatternTerm{"amount", OpEQ, "USD 50.80"}, true},
+		{"currency on rate", RulePatternTerm{"rate", OpEQ, "INR 1"}, true},
+		{"too many digits", RulePatternTerm{"amount", OpGT, "123456789.99"}, true},
+		{"too many decimals", RulePatternTerm{"amount", OpGT, "1.005"}, true},
+		{"different currencies", RulePatternTerm{"amount", OpEQ, AttrRef{"fee"}}, true},
+		// Decimals are not in setTypes, on purpose
+		{"in", RulePatternTerm{"amount", OpIn, []string{"1", "2"}}, true},
+		{"nin", RulePatternTerm{"amount", OpNIn, []string{"1", "2"}}, true},
+		{"in with decimals", RulePatternTerm{"amount", OpIn, []Decimal{amount("1"), amount("2")}}, true},
+		{"any of eq", RulePatternTerm{Op: OpAny, AttrVal: []RulePatternTerm{
+			{"amount", OpEQ, "1.00"}, {"amount", OpEQ, "2"},
+		}}, false},
+	})
+
+	tests := []struct {
+		name    string
+		as      AttrSchema
+		wantErr bool
+	}{
+		{"currency", AttrSchema{Name: "amount", ValType: TypeDecimal, Currency: "EUR"}, false},
+		{"precision", AttrSchema{Name: "amount", ValType: TypeDecimal, Precision: 5}, false},
+		{"lower case currency", AttrSchema{Name: "amount", ValType: TypeDecimal, Currency: "eur"}, true},
+		{"long currency", AttrSchema{Name: "amount", ValType: TypeDecimal, Currency: "EURO"}, true},
+		{"scale over precision", AttrSchema{Name: "amount", ValType: TypeDecimal, Precision: 2, Scale: 3}, true},
+		{"scale without precision", AttrSchema{Name: "amount", ValType: TypeDecimal, Scale: 2}, true},
+		{"negative precision", AttrSchema{Name: "amount", ValType: TypeDecimal, Precision: -1}, true},
+		{"currency on float", AttrSchema{Name: "amount", ValType: TypeFloat, Currency: "EUR"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			schema := testTransactionSchema()
+			schema.PatternSchema = append(schema.PatternSchema, tt.as)
+			if err := testEngine.VerifyRuleSchema(schema, false); (err != nil) != tt.wantErr {
+				t.Errorf("VerifyRuleSchema() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestVerifyLists(t *testing.T) {
+	schema := testTransactionSchema()
+	schema.PatternSchema = append(schema.PatternSchema,
+		AttrSchema{Name: "tags", ValType: TypeList, ElemType: TypeEnum, Vals: map[string]bool{"gold": true, "nri": true}},
+		AttrSchema{Name: "accts", ValType: TypeList, ElemType: TypeInt})
+	runVerifyTermTestsWith(t, schema, []verifyTermTest{
+		{"hasany", RulePatternTerm{"tags", OpHasAny, []string{"gold", "nri"}}, false},
+		{"hasall ints", RulePatternTerm{"accts", OpHasAll, []int{101}}, false},
+		{"hasnone", RulePatternTerm{"tags", OpHasNone, []any{"gold"}}, false},
+		{"count", RulePatternTerm{"accts", OpCountGT, 2}, false},
+		{"count zero", RulePatternTerm{"tags", OpCountEQ, 0}, false},
+		{"exists", RulePatternTerm{"tags", OpNotExists, nil}, false},
+		{"invalid enum element", RulePatternTerm{"tags", OpHasAny, []string{"silver"}}, true},
+		{"wrong element type", RulePatternTerm{"accts", OpHasAny, []string{"101"}}, true},
+		{"empty list", RulePatternTerm{"tags", OpHasAll, []string{}}, true},
+		{"scalar", RulePatternTerm{"tags", OpHasAny, "gold"}, true},
+		{"negative count", RulePatternTerm{"tags", OpCountGE, -1}, true},
+		{"float count", RulePatternTerm{"tags", OpCountGE, 1.0}, true},
+		{"eq on list", RulePatternTerm{"tags", OpEQ, "gold"}, true},
+		{"in on list", RulePatternTerm{"tags", OpIn, []string{"gold"}}, true},
+		{"hasany on enum", RulePatternTerm{"paymenttype", OpHasAny, []string{"cash"}}, true},
+		{"count on task", RulePatternTerm{"freepen", OpCountEQ, 1}, true},
+		{"lists compared", RulePatternTerm{"tags", OpEQ, AttrRef{"accts"}}, true},
+		{"list in expression", RulePatternTerm{"price", OpGT, Expr{"accts + 1"}}, true},
+	})
+
+	tests := []struct {
+		name    string
+		as      AttrSchema
+		wantErr bool
+	}{
+		{"str list", AttrSchema{Name: "held", ValType: TypeList, ElemType: TypeStr}, false},
+		{"no element type", AttrSchema{Name: "held", ValType: TypeList}, true},
+		{"float elements", AttrSchema{Name: "held", ValType: TypeList, ElemType: TypeFloat}, true},
+		{"enum list without vals", AttrSchema{Name: "held", ValType: TypeList, ElemType: TypeEnum}, true},
+		{"element type on str", AttrSchema{Name: "held", ValType: TypeStr, ElemType: TypeStr}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			schema := testTransactionSchema()
+			schema.PatternSchema = append(schema.PatternSchema, tt.as)
+			if err := testEngine.VerifyRuleSchema(schema, false); (err != nil) != tt.wantErr {
+				t.Errorf("VerifyRuleSchema() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// The limits of attributes, and their defaults, which must be within them
+func TestVerifyLimits(t *testing.T) {
+	tests := []struct {
+		name    string
+		as      AttrSchema
+		wantErr bool
+	}{
+		{"int range", AttrSchema{Name: "qty", ValType: TypeInt, ValMin: -10, ValMax: 10}, false},
+		{"decimal range", AttrSchema{Name: "qty", ValType: TypeDecimal, ValMin: 0.5, ValMax: 99.5}, false},
+		{"str length", AttrSchema{Name: "code", ValType: TypeStr, LenMin: 2, LenMax: 8}, false},
+		{"minimum length only", AttrSchema{Name: "code", ValType: TypeStr, LenMin: 2}, false},
+		{"list length", AttrSchema{Name: "codes", ValType: TypeList, ElemType: TypeStr, LenMax: 3}, false},
+		{"range on str", AttrSchema{Name: "code", ValType: TypeStr, ValMax: 10}, true},
+		{"length on int", AttrSchema{Name: "qty", ValType: TypeInt, LenMax: 10}, true},
+		{"min over max", AttrSchema{Name: "qty", ValType: TypeFloat, ValMin: 5, ValMax: 1}, true},
+		{"min length over max", AttrSchema{Name: "code", ValType: TypeStr, LenMin: 5, LenMax: 1}, true},
+		{"negative length", AttrSchema{Name: "code", ValType: TypeStr, LenMin: -1}, true},
+		{"required", AttrSchema{Name: "qty", ValType: TypeInt, Required: true}, false},
+		{"default", AttrSchema{Name: "qty", ValType: TypeInt, ValMax: 10, Default: "1"}, false},
+		{"enum default", AttrSchema{Name: "mode", ValType: TypeEnum, Vals: map[string]bool{"demat": true}, Default: "demat"}, false},
+		{"invalid default", AttrSchema{Name: "qty", ValType: TypeInt, Default: "one"}, true},
+		{"default out of range", AttrSchema{Name: "qty", ValType: TypeInt, ValMax: 10, Default: "11"}, true},
+		{"default not in vals", AttrSchema{Name: "mode", ValType: TypeEnum, Vals: map[string]bool{"demat": true}, Default: "paper"}, true},
+		{"required with default", AttrSchema{Name: "qty", ValType: TypeInt, Required: true, Default: "1"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			schema := testTransactionSchema()
+			schema.PatternSchema = append(schema.PatternSchema, tt.as)
+			if err := testEngine.VerifyRuleSchema(schema, false); (err != nil) != tt.wantErr {
+				t.Errorf("VerifyRuleSchema() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
 }
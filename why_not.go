@@ -0,0 +1,438 @@
+/*
+This file contains Engine.WhyNot(), which explains why a match did not produce an expected task
+or property value. It runs the match with a trace, finds every rule reachable from the starting
+ruleset through ThenCall/ElseCall that could have produced the expected action, and uses the
+trace to explain what happened to each of them.
+*/
+
+package crux
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// WhyNotQuery describes the action that a match was expected to produce: either the task Task,
+// or the property Property. If Val is not empty, the property was expected to have that value.
+type WhyNotQuery struct {
+	Task     string
+	Property string
+	Val      string
+}
+
+type WhyNotReport struct {
+	// Produced is true if the match did produce the expected action
+	Produced bool
+	Result   ActionSet
+	// The rules that could have produced the action, nearest first: rules that were reached
+	// before those that were not, then those with fewer failed terms. Among those, candidates
+	// whose failed terms have gaps of a single value-type are ordered by their total gap, within
+	// the places held by candidates with gaps of that value-type; gaps of different value-types
+	// are not compared. Candidates that are otherwise equally near are in the order in which their
+	// rulesets are reached from the starting ruleset, breadth-first.
+	Candidates []RuleExplanation
+}
+
+type RuleRef struct {
+	SetName   string
+	RuleIndex int
+}
+
+// RuleExplanation explains what happened to one rule during a match
+type RuleExplanation struct {
+	Rule RuleRef
+	// Reached is true if the rule's pattern was evaluated, and Matched is true if it matched
+	Reached bool
+	Matched bool
+	// The terms of the rule's pattern that the entity does not satisfy. Tasks used as attributes
+	// are looked up in the action-set in force when the rule was evaluated; for a rule that was
+	// not reached, that in force when its ruleset stopped, or when its caller was evaluated.
+	FailedTerms []TermFailure
+	// If the rule's ruleset was entered but the rule was not reached, StoppedBy is the rule whose
+	// RETURN or EXIT instruction (or whose called ruleset's EXIT) ended the evaluation first
+	StoppedBy *RuleRef
+	// If the rule's ruleset was never entered, Caller explains the rule that would have called
+	// it, and Via is "thencall" or "elsecall"
+	Caller *RuleExplanation
+	Via    string
+	// If this explains the caller of a ruleset called through ElseCall, the terms of its pattern
+	// that the entity satisfies. The ruleset is called only if one of them fails.
+	MatchedTerms []TermTrace
+	// If the rule matched and set the expected property, OverriddenBy is a later rule that set
+	// the property to another value
+	OverriddenBy *RuleRef
+}
+
+// TermFailure describes a term that the entity does not satisfy. For int, float and ts
+// attributes, Gap is the difference between the entity's value and the term's value. Unknown is
+// true if the entity does not have the attribute and the ruleset's missing-attribute policy is
+// MissingUnknown.
+type TermFailure struct {
+	Term      RulePatternTerm
+	EntityVal string
+	ValType   string
+	Gap       string
+	Unknown   bool
+	Error     string
+}
+
+// WhyNot matches entity against the ruleset named setName, and explains why the match did or
+// did not produce the action described by q. If the match fails once it has started, WhyNot
+// returns the error with a report on the rules evaluated until then, in which the term that
+// failed has its Error set. If the match cannot start, as when the entity is invalid or there is
+// no ruleset setName, the report is empty.
+func (e *Engine) WhyNot(entity Entity, setName string, q WhyNotQuery) (WhyNotReport, error) {
+	if (q.Task == "") == (q.Property == "") {
+		return WhyNotReport{}, fmt.Errorf("exactly one of task and property must be given")
+	}
+	st := e.registry.load()
+	// The terms are explained with the values that were matched
+	entity = st.withDefaults(entity)
+	result, trace, err := matchWithTrace(st, entity, setName)
+	if err != nil && trace.Root.SetName == "" {
+		return WhyNotReport{}, err
+	}
+	report := WhyNotReport{Produced: err == nil && q.isIn(result), Result: result}
+
+	w := whyNot{
+		matcher:       matcher{store: st},
+		entity:        entity,
+		setTraces:     map[string]*RuleSetTrace{},
+		callers:       map[string]callerRef{},
+		actionsBefore: map[RuleRef]ActionSet{},
+		actionsAtEnd:  map[string]ActionSet{},
+	}
+	w.indexTrace(trace.Root)
+	for _, ref := range w.findCandidates(setName, q) {
+		report.Candidates = append(report.Candidates, w.explain(ref, q))
+	}
+	sortCandidates(report.Candidates)
+	return report, err
+}
+
+// Orders candidates as described for WhyNotReport
+func sortCandidates(candidates []RuleExplanation) {
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].nearerThan(candidates[j])
+	})
+	for start := 0; start < len(candidates); {
+		end := start + 1
+		for end < len(candidates) && !candidates[start].nearerThan(candidates[end]) {
+			end++
+		}
+		// Within each run of equally near candidates, those with gaps of one value-type are
+		// reordered among their own places
+		places := map[string][]int{}
+		for i := start; i < end; i++ {
+			if valType, _, ok := candidates[i].gapSize(); ok {
+				places[valType] = append(places[valType], i)
+			}
+		}
+		for _, idx := range places {
+			group := make([]RuleExplanation, len(idx))
+			for k, i := range idx {
+				group[k] = candidates[i]
+			}
+			sort.SliceStable(group, func(a, b int) bool {
+				_, gapA, _ := group[a].gapSize()
+				_, gapB, _ := group[b].gapSize()
+				return gapA < gapB
+			})
+			for k, i := range idx {
+				candidates[i] = group[k]
+			}
+		}
+		start = end
+	}
+}
+
+// Returns whether ex is nearer to having produced the action than other, leaving aside the gaps
+// of their failed terms
+func (ex RuleExplanation) nearerThan(other RuleExplanation) bool {
+	if ex.Reached != other.Reached {
+		return ex.Reached
+	}
+	return len(ex.FailedTerms) < len(other.FailedTerms)
+}
+
+// Returns the value-type of the failed terms that have a gap, and the total of their gaps, with
+// those between times in seconds. ok is false if no failed term has a gap, or if they are not all
+// of one value-type, in which case the gaps cannot be compared with those of other candidates.
+func (ex RuleExplanation) gapSize() (valType string, total float64, ok bool) {
+	for _, tf := range ex.FailedTerms {
+		if tf.Gap == "" {
+			continue
+		}
+		if valType != "" && tf.ValType != valType {
+			return "", 0, false
+		}
+		valType = tf.ValType
+		if f, err := strconv.ParseFloat(tf.Gap, 64); err == nil {
+			total += f
+		} else if d, err := time.ParseDuration(tf.Gap); err == nil {
+			total += d.Seconds()
+		}
+	}
+	return valType, total, valType != ""
+}
+
+func (q WhyNotQuery) isIn(actionSet ActionSet) bool {
+	if q.Task != "" {
+		return isStringInArray(q.Task, actionSet.Tasks)
+	}
+	for _, p := range actionSet.Properties {
+		if p.Name == q.Property && (q.Val == "" || p.Val == q.Val) {
+			return true
+		}
+	}
+	return false
+}
+
+func (q WhyNotQuery) isInActions(ra RuleActions) bool {
+	return q.isIn(ActionSet{Tasks: ra.Tasks, Properties: ra.Properties})
+}
+
+type callerRef struct {
+	rule RuleRef
+	via  string
+}
+
+type whyNot struct {
+	matcher
+	entity Entity
+	// The trace of the first visit to each ruleset entered during the match
+	setTraces map[string]*RuleSetTrace
+	// The rule through which each ruleset reachable from the starting ruleset is first reached
+	callers map[string]callerRef
+	// The order in which matched rules were evaluated, to find overriding property values
+	matchedOrder []RuleRef
+	// The action-set in force when each rule was first evaluated, and when the first visit to
+	// each ruleset ended, replayed from the trace; actions is the action-set during the replay
+	actionsBefore map[RuleRef]ActionSet
+	actionsAtEnd  map[string]ActionSet
+	actions       ActionSet
+}
+
+func (w *whyNot) indexTrace(st *RuleSetTrace) {
+	if st == nil {
+		return
+	}
+	if _, ok := w.setTraces[st.SetName]; !ok {
+		w.setTraces[st.SetName] = st
+	}
+	for _, rt := range st.Rules {
+		ref := RuleRef{st.SetName, rt.Index}
+		if _, ok := w.actionsBefore[ref]; !ok {
+			w.actionsBefore[ref] = w.actions
+		}
+		if rt.Matched {
+			w.matchedOrder = append(w.matchedOrder, ref)
+			w.actions = collectActions(w.actions, RuleActions{Tasks: rt.Tasks, Properties: rt.Properties})
+		}
+		w.indexTrace(rt.Call)
+	}
+	if _, ok := w.actionsAtEnd[st.SetName]; !ok {
+		w.actionsAtEnd[st.SetName] = w.actions
+	}
+}
+
+// Returns the action-set in force when the rule ref was evaluated. If it was not reached, that is
+// the action-set when its ruleset stopped, or if its ruleset was never entered, when its caller
+// was evaluated, with the caller's actions if it would have called on a match.
+func (w *whyNot) actionsFor(ref RuleRef) ActionSet {
+	if actions, ok := w.actionsBefore[ref]; ok {
+		return actions
+	}
+	if actions, ok := w.actionsAtEnd[ref.SetName]; ok {
+		return actions
+	}
+	caller, ok := w.callers[ref.SetName]
+	if !ok {
+		return ActionSet{}
+	}
+	actions := w.actionsFor(caller.rule)
+	if caller.via == viaThenCall {
+		rs, _ := w.store.getRuleSet(caller.rule.SetName)
+		actions = collectActions(actions, rs.Rules[caller.rule.RuleIndex].RuleActions)
+	}
+	return actions
+}
+
+// Returns the rules reachable from the ruleset setName whose actions include q, visiting the
+// rulesets in breadth-first order
+func (w *whyNot) findCandidates(setName string, q WhyNotQuery) []RuleRef {
+	var candidates []RuleRef
+	queue := []string{setName}
+	seen := map[string]bool{setName: true}
+	for len(queue) > 0 {
+		rs, ok := w.store.getRuleSet(queue[0])
+		queue = queue[1:]
+		if !ok {
+			continue
+		}
+		for i, rule := range rs.Rules {
+			if q.isInActions(rule.RuleActions) {
+				candidates = append(candidates, RuleRef{rs.SetName, i})
+			}
+			calls := map[string]string{viaThenCall: rule.RuleActions.ThenCall, viaElseCall: rule.RuleActions.ElseCall}
+			for _, via := range []string{viaThenCall, viaElseCall} {
+				target := calls[via]
+				if target != "" && !seen[target] {
+					seen[target] = true
+					w.callers[target] = callerRef{RuleRef{rs.SetName, i}, via}
+					queue = append(queue, target)
+				}
+			}
+		}
+	}
+	return candidates
+}
+
+func (w *whyNot) explain(ref RuleRef, q WhyNotQuery) RuleExplanation {
+	ex := RuleExplanation{Rule: ref}
+	rs, _ := w.store.getRuleSet(ref.SetName)
+	ex.FailedTerms = w.failedTerms(rs.Rules[ref.RuleIndex].RulePattern, w.actionsFor(ref), rs.MissingAttrs)
+
+	st, entered := w.setTraces[ref.SetName]
+	if !entered {
+		if caller, ok := w.callers[ref.SetName]; ok {
+			callerEx := w.explain(caller.rule, q)
+			if caller.via == viaElseCall {
+				callerRS, _ := w.store.getRuleSet(caller.rule.SetName)
+				callerEx.MatchedTerms = w.matchedTerms(callerRS.Rules[caller.rule.RuleIndex].RulePattern,
+					w.actionsFor(caller.rule), callerRS.MissingAttrs)
+			}
+			ex.Caller, ex.Via = &callerEx, caller.via
+		}
+		return ex
+	}
+	for _, rt := range st.Rules {
+		if rt.Index == ref.RuleIndex {
+			ex.Reached, ex.Matched = true, rt.Matched
+		}
+	}
+	if !ex.Reached && len(st.Rules) > 0 {
+		last := st.Rules[len(st.Rules)-1]
+		if last.WillReturn || last.WillExit {
+			stoppedBy := findStoppingRule(st.SetName, last)
+			ex.StoppedBy = &stoppedBy
+		}
+	}
+	if ex.Matched && q.Property != "" && q.Val != "" {
+		ex.OverriddenBy = w.findOverride(ref, q.Property)
+	}
+	return ex
+}
+
+// Returns the rule that ended the evaluation of a ruleset whose last evaluated rule was rt.
+// If rt called a ruleset that exited, that is the ruleset in which the EXIT was found.
+func findStoppingRule(setName string, rt RuleTrace) RuleRef {
+	for rt.Call != nil && len(rt.Call.Rules) > 0 {
+		last := rt.Call.Rules[len(rt.Call.Rules)-1]
+		if !last.WillExit {
+			break
+		}
+		setName, rt = rt.Call.SetName, last
+	}
+	return RuleRef{setName, rt.Index}
+}
+
+// Returns the last rule matched after ref that set property, if it set a different value
+func (w *whyNot) findOverride(ref RuleRef, property string) *RuleRef {
+	var override *RuleRef
+	var refVal string
+	after := false
+	for _, matched := range w.matchedOrder {
+		rs, _ := w.store.getRuleSet(matched.SetName)
+		for _, p := range rs.Rules[matched.RuleIndex].RuleActions.Properties {
+			if p.Name != property {
+				continue
+			}
+			if matched == ref {
+				after, refVal, override = true, p.Val, nil
+			} else if after && p.Val != refVal {
+				m := matched
+				override = &m
+			} else if after {
+				override = nil
+			}
+		}
+	}
+	return override
+}
+
+// Evaluates every term in rulePattern against actionSet under the missing-attribute policy
+// missing, and returns those that the entity does not satisfy
+func (w *whyNot) failedTerms(rulePattern []RulePatternTerm, actionSet ActionSet, missing string) []TermFailure {
+	var failures []TermFailure
+	for _, term := range rulePattern {
+		tt, err := w.matchTerm(w.entity, term, actionSet, missing)
+		if tt.Matched && err == nil {
+			continue
+		}
+		tf := TermFailure{Term: term, EntityVal: tt.EntityVal, ValType: tt.ValType, Unknown: tt.Unknown}
+		if tt.Error != "" {
+			tf.Error = tt.Error
+		} else if tt.ValSource == valSourceAttr {
+			termAttrVal := term.AttrVal
+			if ref, ok := termAttrVal.(AttrRef); ok {
+				termAttrVal, _ = convertEntityAttrVal(tt.RefVal, tt.ValType, w.attrFormat(w.entity.Class, ref.Attr))
+			}
+			tf.Gap = getGap(tt.EntityVal, termAttrVal, tt.ValType, w.attrFormat(w.entity.Class, term.AttrName))
+		}
+		failures = append(failures, tf)
+	}
+	return failures
+}
+
+// Evaluates every term in rulePattern against actionSet under the missing-attribute policy
+// missing, and returns the traces of those that the entity satisfies
+func (w *whyNot) matchedTerms(rulePattern []RulePatternTerm, actionSet ActionSet, missing string) []TermTrace {
+	var matched []TermTrace
+	for _, term := range rulePattern {
+		if tt, err := w.matchTerm(w.entity, term, actionSet, missing); tt.Matched && err == nil {
+			matched = append(matched, tt)
+		}
+	}
+	return matched
+}
+
+// Returns the absolute difference between the entity value and the term value, or "" if the
+// value-type has no notion of distance. af is the format of the attribute, or nil.
+func getGap(entityAttrVal string, termAttrVal any, valType string, af *attrFormat) string {
+	entityAttrValConv, err := convertEntityAttrVal(entityAttrVal, valType, af)
+	if err != nil {
+		return ""
+	}
+	if s, ok := termAttrVal.(string); ok && (valType == TypeTS || valType == TypeDate) {
+		termAttrVal, _ = termTime(s, valType)
+	} else if ok && valType == TypeDecimal {
+		termAttrVal, _, _ = termDecimal(s)
+	}
+	switch v := entityAttrValConv.(type) {
+	case int:
+		if t, ok := termAttrVal.(int); ok {
+			return fmt.Sprint(int(math.Abs(float64(v - t))))
+		}
+	case float64:
+		if t, ok := termAttrVal.(float64); ok {
+			return fmt.Sprint(math.Abs(v - t))
+		}
+	case Decimal:
+		if t, ok := termAttrVal.(Decimal); ok {
+			return v.dist(t).String()
+		}
+	case time.Time:
+		if t, ok := termAttrVal.(time.Time); ok {
+			d := v.Sub(t)
+			if d < 0 {
+				d = -d
+			}
+			return d.String()
+		}
+	}
+	return ""
+}
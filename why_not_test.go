@@ -0,0 +1,326 @@
+package crux
+
+import (
+	"reflect"
+	"testing"
+)
+
+func setupWhyNotEngine() *Engine {
+	testEngine.AddRuleSchema(testTransactionSchema())
+	setupRuleSetMainForTransaction()
+	setupRuleSetWinterDisc()
+	setupRuleSetRegularDisc()
+	setupRuleSetMemberDisc()
+	setupRuleSetNonMemberDisc()
+	return testEngine
+}
+
+func transactionEntity(product, price, inWinterSale, paymentType, isMember string) Entity {
+	return Entity{transactionClass, []Attr{
+		{"productname", product},
+		{"price", price},
+		{"inwintersale", inWinterSale},
+		{"paymenttype", paymentType},
+		{"ismember", isMember},
+	}}
+}
+
+func TestWhyNotFailedTerm(t *testing.T) {
+	e := setupWhyNotEngine()
+	entity := transactionEntity("jacket", "40", trueStr, "cash", falseStr)
+	report, err := e.WhyNot(entity, mainRS, WhyNotQuery{Task: "freehat"})
+	if err != nil {
+		t.Fatalf("WhyNot() error = %v", err)
+	}
+	if report.Produced || len(report.Candidates) != 1 {
+		t.Fatalf("WhyNot() = %+v, want one candidate", report)
+	}
+	want := RuleExplanation{
+		Rule:    RuleRef{"winterdisc", 0},
+		Reached: true,
+		FailedTerms: []TermFailure{
+			{Term: RulePatternTerm{"price", OpGT, 50}, EntityVal: "40", ValType: TypeInt, Gap: "10"},
+		},
+	}
+	if !reflect.DeepEqual(report.Candidates[0], want) {
+		t.Errorf("WhyNot() candidate = %+v, want %+v", report.Candidates[0], want)
+	}
+}
+
+func TestWhyNotRuleSetNotEntered(t *testing.T) {
+	e := setupWhyNotEngine()
+	entity := transactionEntity("kettle", "110", falseStr, "cash", falseStr)
+	report, err := e.WhyNot(entity, mainRS, WhyNotQuery{Property: "discount", Val: "25"})
+	if err != nil {
+		t.Fatalf("WhyNot() error = %v", err)
+	}
+	if report.Produced || len(report.Candidates) != 1 {
+		t.Fatalf("WhyNot() = %+v, want one candidate", report)
+	}
+	c := report.Candidates[0]
+	if c.Rule != (RuleRef{"memberdisc", 2}) || c.Reached || c.Caller == nil || c.Via != viaThenCall {
+		t.Fatalf("WhyNot() candidate = %+v, want memberdisc rule 2 not entered", c)
+	}
+	wantCaller := RuleExplanation{
+		Rule:    RuleRef{"regulardisc", 0},
+		Reached: true,
+		FailedTerms: []TermFailure{
+			{Term: RulePatternTerm{"ismember", OpEQ, true}, EntityVal: falseStr, ValType: TypeBool},
+		},
+	}
+	if !reflect.DeepEqual(*c.Caller, wantCaller) {
+		t.Errorf("WhyNot() caller = %+v, want %+v", *c.Caller, wantCaller)
+	}
+}
+
+// For a ruleset called through ElseCall, the caller's explanation gives the terms that matched
+// and so kept the else-branch from being taken
+func TestWhyNotElseCallCaller(t *testing.T) {
+	e := NewEngine()
+	schema := testCounterSchema()
+	schema.ActionSchema = ActionSchema{Tasks: []string{"neg"}}
+	e.AddRuleSchema(schema)
+	e.AddRuleSet(RuleSet{Ver: 1, Class: counterClass, SetName: mainRS, Rules: []Rule{
+		{[]RulePatternTerm{{"n", OpGT, 0}, {"n", OpLT, 10}}, RuleActions{ElseCall: "negative"}},
+	}})
+	e.AddRuleSet(RuleSet{Ver: 1, Class: counterClass, SetName: "negative", Rules: []Rule{
+		{[]RulePatternTerm{{"n", OpLT, 0}}, RuleActions{Tasks: []string{"neg"}}},
+	}})
+	report, err := e.WhyNot(Entity{counterClass, []Attr{{"n", "3"}}}, mainRS, WhyNotQuery{Task: "neg"})
+	if err != nil {
+		t.Fatalf("WhyNot() error = %v", err)
+	}
+	if report.Produced || len(report.Candidates) != 1 {
+		t.Fatalf("WhyNot() = %+v, want one candidate", report)
+	}
+	c := report.Candidates[0]
+	if c.Rule != (RuleRef{"negative", 0}) || c.Reached || c.Caller == nil || c.Via != viaElseCall {
+		t.Fatalf("WhyNot() candidate = %+v, want negative rule 0 not entered", c)
+	}
+	wantCaller := RuleExplanation{
+		Rule:    RuleRef{mainRS, 0},
+		Reached: true,
+		Matched: true,
+		MatchedTerms: []TermTrace{
+			{Term: RulePatternTerm{"n", OpGT, 0}, EntityVal: "3", ValType: TypeInt, ValSource: valSourceAttr, Matched: true},
+			{Term: RulePatternTerm{"n", OpLT, 10}, EntityVal: "3", ValType: TypeInt, ValSource: valSourceAttr, Matched: true},
+		},
+	}
+	if !reflect.DeepEqual(*c.Caller, wantCaller) {
+		t.Errorf("WhyNot() caller = %+v, want %+v", *c.Caller, wantCaller)
+	}
+}
+
+func TestWhyNotStoppedByExit(t *testing.T) {
+	e := setupWhyNotEngine()
+	entity := transactionEntity("lamp", "60", falseStr, "card", trueStr)
+
+	// memberdisc's first rule exits, so its second rule, which sets discount=20, is never reached
+	report, err := e.WhyNot(entity, mainRS, WhyNotQuery{Property: "discount", Val: "20"})
+	if err != nil {
+		t.Fatalf("WhyNot() error = %v", err)
+	}
+	c := report.Candidates[0]
+	if c.Rule != (RuleRef{"memberdisc", 1}) || c.Reached || c.StoppedBy == nil ||
+		*c.StoppedBy != (RuleRef{"memberdisc", 0}) || len(c.FailedTerms) != 0 {
+		t.Errorf("WhyNot() candidate = %+v, want memberdisc rule 1 stopped by rule 0", c)
+	}
+
+	// The exit in memberdisc also stops the rest of main
+	report, err = e.WhyNot(entity, mainRS, WhyNotQuery{Task: "freemug"})
+	if err != nil {
+		t.Fatalf("WhyNot() error = %v", err)
+	}
+	c = report.Candidates[0]
+	if c.Rule != (RuleRef{mainRS, 2}) || c.Reached || c.StoppedBy == nil || *c.StoppedBy != (RuleRef{"memberdisc", 0}) {
+		t.Errorf("WhyNot() candidate = %+v, want main rule 2 stopped by memberdisc rule 0", c)
+	}
+}
+
+func TestWhyNotOverridden(t *testing.T) {
+	e := setupWhyNotEngine()
+	entity := transactionEntity("kettle", "110", falseStr, "card", falseStr)
+	report, err := e.WhyNot(entity, mainRS, WhyNotQuery{Property: "discount", Val: "10"})
+	if err != nil {
+		t.Fatalf("WhyNot() error = %v", err)
+	}
+	var found bool
+	for _, c := range report.Candidates {
+		if c.Rule == (RuleRef{"nonmemberdisc", 1}) {
+			found = true
+			if !c.Matched || c.OverriddenBy == nil || *c.OverriddenBy != (RuleRef{"nonmemberdisc", 2}) {
+				t.Errorf("WhyNot() candidate = %+v, want nonmemberdisc rule 1 overridden by rule 2", c)
+			}
+		}
+	}
+	if report.Produced || !found {
+		t.Errorf("WhyNot() = %+v, want nonmemberdisc rule 1 as a candidate", report)
+	}
+
+	report, _ = e.WhyNot(entity, mainRS, WhyNotQuery{Property: "discount", Val: "15"})
+	if !report.Produced {
+		t.Errorf("WhyNot() = %+v, want the property to have been produced", report)
+	}
+
+	if _, err = e.WhyNot(entity, mainRS, WhyNotQuery{}); err == nil {
+		t.Errorf("WhyNot(): expected but did not get error for an empty query")
+	}
+}
+
+// Under MissingUnknown, a term on a missing attribute is reported as unknown rather than false
+func TestWhyNotUnknownTerm(t *testing.T) {
+	e := NewEngine()
+	schema := testCounterSchema()
+	schema.ActionSchema = ActionSchema{Tasks: []string{"pos"}}
+	e.AddRuleSchema(schema)
+	e.AddRuleSet(RuleSet{Ver: 1, Class: counterClass, SetName: mainRS, Rules: []Rule{{
+		[]RulePatternTerm{{"n", OpGT, 0}}, RuleActions{Tasks: []string{"pos"}},
+	}}, MissingAttrs: MissingUnknown})
+	report, err := e.WhyNot(Entity{counterClass, nil}, mainRS, WhyNotQuery{Task: "pos"})
+	if err != nil {
+		t.Fatalf("WhyNot() error = %v", err)
+	}
+	want := []TermFailure{{Term: RulePatternTerm{"n", OpGT, 0}, ValType: TypeInt, Unknown: true}}
+	if len(report.Candidates) != 1 || !reflect.DeepEqual(report.Candidates[0].FailedTerms, want) {
+		t.Errorf("WhyNot() = %+v, want failed terms %+v", report, want)
+	}
+}
+
+// The gap for a term that compares two attributes is the difference between their values
+func TestWhyNotAttrRefGap(t *testing.T) {
+	e := NewEngine()
+	schema := testCounterSchema()
+	schema.PatternSchema = append(schema.PatternSchema, AttrSchema{Name: "limit", ValType: TypeInt})
+	schema.ActionSchema = ActionSchema{Tasks: []string{"over"}}
+	e.AddRuleSchema(schema)
+	e.AddRuleSet(RuleSet{Ver: 1, Class: counterClass, SetName: mainRS, Rules: []Rule{{
+		[]RulePatternTerm{{"n", OpGT, AttrRef{"limit"}}}, RuleActions{Tasks: []string{"over"}},
+	}}})
+	report, err := e.WhyNot(Entity{counterClass, []Attr{{"n", "3"}, {"limit", "10"}}}, mainRS, WhyNotQuery{Task: "over"})
+	if err != nil {
+		t.Fatalf("WhyNot() error = %v", err)
+	}
+	want := []TermFailure{{Term: RulePatternTerm{"n", OpGT, AttrRef{"limit"}}, EntityVal: "3", ValType: TypeInt, Gap: "7"}}
+	if len(report.Candidates) != 1 || !reflect.DeepEqual(report.Candidates[0].FailedTerms, want) {
+		t.Errorf("WhyNot() = %+v, want failed terms %+v", report, want)
+	}
+}
+
+// The gap for a decimal term is exact, and ignores the currency codes of the values
+func TestWhyNotDecimalGap(t *testing.T) {
+	e := NewEngine()
+	schema := testCounterSchema()
+	schema.PatternSchema = append(schema.PatternSchema, AttrSchema{Name: "amount", ValType: TypeDecimal, Currency: "INR"})
+	schema.ActionSchema = ActionSchema{Tasks: []string{"over"}}
+	e.AddRuleSchema(schema)
+	e.AddRuleSet(RuleSet{Ver: 1, Class: counterClass, SetName: mainRS, Rules: []Rule{{
+		[]RulePatternTerm{{"amount", OpGT, "INR 1000.10"}}, RuleActions{Tasks: []string{"over"}},
+	}}})
+	report, err := e.WhyNot(Entity{counterClass, []Attr{{"n", "3"}, {"amount", "999.9 INR"}}}, mainRS, WhyNotQuery{Task: "over"})
+	if err != nil {
+		t.Fatalf("WhyNot() error = %v", err)
+	}
+	want := []TermFailure{{Term: RulePatternTerm{"amount", OpGT, "INR 1000.10"}, EntityVal: "999.9 INR", ValType: TypeDecimal, Gap: "0.2"}}
+	if len(report.Candidates) != 1 || !reflect.DeepEqual(report.Candidates[0].FailedTerms, want) {
+		t.Errorf("WhyNot() = %+v, want failed terms %+v", report, want)
+	}
+}
+
+// A task used as an attribute is looked up in the action-set in force when the rule was
+// evaluated, not in the result of the match
+func TestWhyNotTaskCollectedLater(t *testing.T) {
+	e := NewEngine()
+	schema := testCounterSchema()
+	schema.ActionSchema = ActionSchema{Tasks: []string{"pos", "big"}}
+	e.AddRuleSchema(schema)
+	e.AddRuleSet(RuleSet{Ver: 1, Class: counterClass, SetName: mainRS, Rules: []Rule{
+		{[]RulePatternTerm{{"pos", OpEQ, true}}, RuleActions{Tasks: []string{"big"}}},
+		{[]RulePatternTerm{{"n", OpGT, 0}}, RuleActions{Tasks: []string{"pos"}}},
+	}})
+	report, err := e.WhyNot(Entity{counterClass, []Attr{{"n", "3"}}}, mainRS, WhyNotQuery{Task: "big"})
+	if err != nil {
+		t.Fatalf("WhyNot() error = %v", err)
+	}
+	want := []TermFailure{{Term: RulePatternTerm{"pos", OpEQ, true}, EntityVal: falseStr, ValType: TypeBool}}
+	if len(report.Candidates) != 1 || !reflect.DeepEqual(report.Candidates[0].FailedTerms, want) {
+		t.Errorf("WhyNot() = %+v, want failed terms %+v", report, want)
+	}
+}
+
+// Candidates are ordered by nearness: reached first, then by the number of failed terms, then by
+// the total gap
+func TestWhyNotCandidateOrder(t *testing.T) {
+	e := NewEngine()
+	schema := testCounterSchema()
+	schema.ActionSchema = ActionSchema{Tasks: []string{"over"}}
+	e.AddRuleSchema(schema)
+	over := RuleActions{Tasks: []string{"over"}}
+	e.AddRuleSet(RuleSet{Ver: 1, Class: counterClass, SetName: mainRS, Rules: []Rule{
+		{[]RulePatternTerm{{"n", OpGT, 100}}, over},
+		{[]RulePatternTerm{{"n", OpGT, 5}, {"n", OpLT, 2}}, over},
+		{[]RulePatternTerm{{"n", OpGT, 4}}, over},
+		{[]RulePatternTerm{{"n", OpEQ, 3}}, RuleActions{WillReturn: true}},
+		{[]RulePatternTerm{{"n", OpEQ, 3}}, over},
+	}})
+	report, err := e.WhyNot(Entity{counterClass, []Attr{{"n", "3"}}}, mainRS, WhyNotQuery{Task: "over"})
+	if err != nil {
+		t.Fatalf("WhyNot() error = %v", err)
+	}
+	var got []int
+	for _, c := range report.Candidates {
+		got = append(got, c.Rule.RuleIndex)
+	}
+	if want := []int{2, 0, 1, 4}; !reflect.DeepEqual(got, want) {
+		t.Errorf("WhyNot() candidates = %v, want rules %v", got, want)
+	}
+}
+
+// A match that fails part way returns the error with a report whose failed term has the error
+func TestWhyNotMatchError(t *testing.T) {
+	e := NewEngine()
+	schema := testCounterSchema()
+	schema.ActionSchema = ActionSchema{Tasks: []string{"pos"}}
+	e.AddRuleSchema(schema)
+	e.AddRuleSet(testCounterRuleSet("pos"))
+	entity := Entity{counterClass, []Attr{{"n", "x"}}}
+	report, err := e.WhyNot(entity, mainRS, WhyNotQuery{Task: "pos"})
+	if err == nil {
+		t.Fatalf("WhyNot() expected but did not get error")
+	}
+	if report.Produced || len(report.Candidates) != 1 {
+		t.Fatalf("WhyNot() = %+v, want one candidate", report)
+	}
+	c := report.Candidates[0]
+	if !c.Reached || c.Matched || len(c.FailedTerms) != 1 || c.FailedTerms[0].Error == "" {
+		t.Errorf("WhyNot() candidate = %+v, want a reached rule whose term has an error", c)
+	}
+
+	report, err = e.WhyNot(entity, "nosuchset", WhyNotQuery{Task: "pos"})
+	if err == nil || !reflect.DeepEqual(report, WhyNotReport{}) {
+		t.Errorf("WhyNot() with unknown ruleset = %+v, %v, want an empty report and an error", report, err)
+	}
+}
+
+// Gaps are compared only between candidates whose gaps are of the same value-type
+func TestWhyNotGapsOfOneType(t *testing.T) {
+	candidate := func(index int, valType, gap string) RuleExplanation {
+		return RuleExplanation{Rule: RuleRef{mainRS, index}, Reached: true, FailedTerms: []TermFailure{
+			{Term: RulePatternTerm{"n", OpGT, 0}, ValType: valType, Gap: gap},
+		}}
+	}
+	candidates := []RuleExplanation{
+		candidate(0, TypeInt, "5"),
+		candidate(1, TypeTS, "1s"),
+		candidate(2, TypeInt, "2"),
+		candidate(3, TypeBool, ""),
+		candidate(4, TypeTS, "0s"),
+	}
+	sortCandidates(candidates)
+	var got []int
+	for _, c := range candidates {
+		got = append(got, c.Rule.RuleIndex)
+	}
+	if want := []int{2, 4, 0, 3, 1}; !reflect.DeepEqual(got, want) {
+		t.Errorf("sortCandidates() = %v, want rules %v", got, want)
+	}
+}